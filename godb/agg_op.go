@@ -119,13 +119,8 @@ func (a *Aggregator) Iterator(tid TransactionID) (func() (*Tuple, error), error)
 
 		if finalizedIter == nil { // builds the iterator for iterating thru the finalized aggregation results for each group
 			if a.groupByFields == nil {
-				var tup *Tuple
-				for i := 0; i < len(a.newAggState); i++ {
-					newTup := (*aggState[DefaultGroup])[i].Finalize()
-					tup = joinTuples(tup, newTup)
-				}
 				finalizedIter = func() (*Tuple, error) { return nil, nil }
-				return tup, nil
+				return finalizeGroup(nil, *aggState[DefaultGroup])
 			} else {
 				finalizedIter = getFinalizedTuplesIterator(a, groupByList, aggState)
 			}
@@ -156,14 +151,33 @@ func addTupleToGrpAggState(a *Aggregator, t *Tuple, grpAggState *[]AggState) {
 	// TODO: some code goes here
 }
 
+// Return the result of a group: the results of its aggregation states, finalized
+// with [finalizeAggState], joined after group, the tuple that identifies the
+// group (nil without a group-by). Returns the error of the first aggregation
+// state whose result cannot be computed.
+func finalizeGroup(group *Tuple, states []AggState) (*Tuple, error) {
+	tup := group
+	for _, state := range states {
+		t, err := finalizeAggState(state)
+		if err != nil {
+			return nil, err
+		}
+		tup = joinTuples(tup, t)
+	}
+	return tup, nil
+}
+
 // Given that all child tuples have been added, return an iterator that iterates
 // through the finalized aggregate result one group at a time. The returned
 // tuples should be structured according to the TupleDesc returned from the
 // Descriptor() method.
 //
-// HINT: you can call [aggState.Finalize] to get the field for each AggState.
-// Then, you should get the groupByTuple and merge it with each of the AggState
-// tuples using the joinTuples function in tuple.go you wrote in lab 1.
+// HINT: you can call [finalizeGroup] with the groupByTuple of each group and its
+// AggStates: it calls [aggState.Finalize] to get the field for each AggState,
+// and merges them with the groupByTuple using the joinTuples function in
+// tuple.go you wrote in lab 1. It returns the error of an aggregate whose
+// result cannot be computed, such as a SUM of decimals that overflows, which
+// the iterator should return.
 func getFinalizedTuplesIterator(a *Aggregator, groupByList []*Tuple, aggState map[any]*[]AggState) func() (*Tuple, error) {
 	// TODO: some code goes here
 	return func() (*Tuple, error) {
@@ -24,9 +24,32 @@ type AggState interface {
 	GetTupleDesc() *TupleDesc
 }
 
+// An AggState whose result may not be computable, such as a SUM of decimals
+// that overflows; finalizeError returns the error of the last call to
+// Finalize, if any. Use [finalizeAggState] to finalize aggregation states.
+type fallibleAggState interface {
+	AggState
+	finalizeError() error
+}
+
+// Return the result of the aggregation state as [AggState.Finalize] does, or
+// the error of its result if it is a fallibleAggState.
+func finalizeAggState(state AggState) (*Tuple, error) {
+	t := state.Finalize()
+	if f, ok := state.(fallibleAggState); ok {
+		if err := f.finalizeError(); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
 // Implements the aggregation state for COUNT
 // We are supplying the implementation of CountAggState as an example. You need to
 // implement the rest of the aggregation states.
+//
+// COUNT(expr) counts the tuples for which expr is not NULL; COUNT(*) is
+// initialized with a nil expr, and counts every tuple.
 type CountAggState struct {
 	alias string
 	expr  Expr
@@ -45,6 +68,12 @@ func (a *CountAggState) Init(alias string, expr Expr) error {
 }
 
 func (a *CountAggState) AddTuple(t *Tuple) {
+	if a.expr != nil {
+		v, err := a.expr.EvalExpr(t)
+		if err != nil || isNull(v) {
+			return
+		}
+	}
 	a.count++
 }
 
@@ -65,6 +94,10 @@ func (a *CountAggState) GetTupleDesc() *TupleDesc {
 }
 
 // Implements the aggregation state for SUM
+// NULL values are skipped; the sum of no values other than NULLs is NULL. The
+// sum has the type of the values, IntType, FloatType or DecimalType. Sum
+// decimals with a [decimalSum]; if its total overflows, Finalize returns a NULL
+// and finalizeError the NumericOverflowError (see [fallibleAggState]).
 type SumAggState struct {
 	// TODO: some code goes here
 }
@@ -103,9 +136,21 @@ func (a *SumAggState) Finalize() *Tuple {
 	return &Tuple{} // replace me
 }
 
+// Return the error of the last call to Finalize, if any: the
+// NumericOverflowError of a sum of decimals that overflows.
+func (a *SumAggState) finalizeError() error {
+	// TODO: some code goes here
+	return nil // replace me
+}
+
 // Implements the aggregation state for AVG
 // Note that we always AddTuple() at least once before Finalize()
-// so no worries for divide-by-zero
+// so no worries for divide-by-zero; however, NULL values are skipped, and the
+// average of no values other than NULLs is NULL. The average is a FloatType
+// value (a FloatField), whether the values are IntType or FloatType; the
+// average of DecimalType values is the DecimalField [decimalSum.average], and
+// if it overflows, Finalize returns a NULL and finalizeError the
+// NumericOverflowError (see [fallibleAggState]).
 type AvgAggState struct {
 	// TODO: some code goes here
 }
@@ -134,9 +179,17 @@ func (a *AvgAggState) Finalize() *Tuple {
 	return &Tuple{} // replace me
 }
 
+// Return the error of the last call to Finalize, if any: the
+// NumericOverflowError of an average of decimals that overflows.
+func (a *AvgAggState) finalizeError() error {
+	// TODO: some code goes here
+	return nil // replace me
+}
+
 // Implements the aggregation state for MAX
 // Note that we always AddTuple() at least once before Finalize()
-// so no worries for NaN max
+// so no worries for NaN max; however, NULL values are skipped, and the max of
+// no values other than NULLs is NULL.
 type MaxAggState struct {
 	// TODO: some code goes here
 }
@@ -167,7 +220,8 @@ func (a *MaxAggState) Finalize() *Tuple {
 
 // Implements the aggregation state for MIN
 // Note that we always AddTuple() at least once before Finalize()
-// so no worries for NaN min
+// so no worries for NaN min; however, NULL values are skipped, and the min of
+// no values other than NULLs is NULL.
 type MinAggState struct {
 	// TODO: some code goes here
 }
@@ -0,0 +1,987 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+/*
+A B+ tree index (see [BTreeFile]) maps keys, made of the values of one or more
+columns of a table, to the record ids of the tuples of the table's heap file
+that hold them (see [heapRid]). It is stored in a file of its own, whose pages
+are read through the BufferPool and cached like the pages of heap files, but
+are neither locked by the BufferPool nor logged (see below).
+
+Leaves hold entries made of a key and a record id, sorted by key and then by
+record id, so that keys may be duplicated while every entry is unique. Each
+leaf points to the next one, and range scans follow this chain. An internal
+node holds n separator entries and n+1 children: the entries below child i are
+greater than or equal to separator i-1 and smaller than separator i. Keys are
+ordered column by column, with NULLs after every other value (see
+[compareKeys]).
+
+The root is always page 0 of the file. When an insert makes a node too large
+for a page, the upper half of its entries is moved to a new node, and the
+first entry of that half is inserted into the parent as a separator (and
+removed from the node if it is internal). When the root splits, both halves
+are moved to new pages and the root becomes an internal node pointing to
+them. Deletes remove entries from leaves without merging nodes, so, as in
+PostgreSQL, the tree never shrinks; scans skip empty leaves. Keys may be at
+most maxBTreeKeySize bytes long, so that a node that does not fit in a page
+has at least two entries to move to each half.
+
+A page starts with btreePageMagic, a byte that is 1 for leaves, the number of
+entries as a 16 bit integer and the number of the next leaf as a 32 bit
+integer (noBTreePage for internal nodes and the last leaf). Internal nodes
+then hold the number of their first child, and each entry of a node is its
+key, written with [Tuple.writeVarlenTo], followed by the page and slot of its
+record id, and, in internal nodes, by the number of the child that follows
+it, all as 32 bit integers. All integers are little endian. A page of zeros
+is an empty leaf.
+
+Operations on the tree latch the nodes they use, with latches held only for
+the duration of the operation (see [BTreeFile.getNode]), and crab down from
+the root: a node's parent is released once the node is known not to split,
+so that writers only hold the nodes a split may change, and readers hold at
+most a node and its parent. Latches protect the structure of the tree; the
+entries of a leaf are protected like the tuples of a heap page, by locks on
+the leaf that the BufferPool takes as it locks heap pages (see
+[BTreeFile.lockLeaf]), and by the locks (or, under MVCC, the versions) of the
+heap tuples they point to. Several transactions may then have changes on the
+same node, so a transaction's inserts and deletes of entries are undone one at
+a time, by deleting and inserting the entries again, rather than by restoring
+the nodes (see [rowChangeTable]); splits are never undone.
+
+For the same reason, nodes are not logged: transactions write the nodes they
+dirtied when they commit or abort (see [BTreeFile.flushNode]), and, since
+nodes evicted before may hold changes of transactions that did not commit,
+the B+ tree indexes of a database are rebuilt from their heap files after
+recovery rolls back a transaction (see [Catalog.rebuildBTreeIndexes]).
+*/
+
+const (
+	btreePageMagic   uint32 = 0xB7EEB7EE
+	btreeHeaderSize  int    = 11
+	btreeRidSize     int    = 8
+	btreeChildSize   int    = 4
+	btreeRoot        int    = 0
+	noBTreePage      int    = -1
+	maxBTreeKeySize  int    = PageSize/4 - 32 // any four entries fit in a node
+	btreeLeafFlag    byte   = 1
+	btreeMinFillSize int    = PageSize / 2
+)
+
+// A BTreeFile is a B+ tree index. Its tuples are the keys of its entries, in
+// key order, each with the record id of the heap tuple it points to as its
+// Rid; they are inserted and deleted with [BTreeFile.insertTuple] and
+// [BTreeFile.deleteTuple], and read with [BTreeFile.Iterator],
+// [BTreeFile.Lookup] and [BTreeFile.RangeIterator].
+type BTreeFile struct {
+	fileName string
+	keyDesc  *TupleDesc
+	bufPool  *BufferPool
+
+	latches nodeLatches
+	allocMu sync.Mutex // held while a node is appended to the file
+}
+
+// An entry of a node: a key and the record id of the heap tuple it indexes.
+type btreeEntry struct {
+	key  []DBValue
+	rid  heapRid
+	size int // the size of the encoded key in bytes
+}
+
+// A node of a B+ tree, as cached by the BufferPool. Like a [heapPage], it has
+// an LSN and a before image, which are not part of its on-disk format; since
+// nodes are not logged, its LSN stays InvalidLSN.
+type btreePage struct {
+	file     *BTreeFile
+	pageNo   int
+	leaf     bool
+	entries  []btreeEntry
+	children []int // the children of an internal node, one more than its entries
+	next     int   // the next leaf, or noBTreePage
+
+	dirty    bool
+	dirtiers map[TransactionID]bool // transactions may share a node, see [BTreeFile]
+
+	lsn         LSN
+	beforeImage []byte
+}
+
+// A bound of a range scan (see [BTreeFile.RangeIterator]). Key may hold fewer
+// values than the keys of the index, in which case it bounds their prefixes.
+type BTreeBound struct {
+	Key       []DBValue
+	Inclusive bool
+}
+
+// Create a BTreeFile backed by the specified file, which may be empty or a
+// previously created index, for keys with the specified TupleDesc. If the
+// file is empty, an empty root leaf is written to it.
+func NewBTreeFile(fromFile string, keyDesc *TupleDesc, bp *BufferPool) (*BTreeFile, error) {
+	if keyDesc == nil || len(keyDesc.Fields) == 0 {
+		return nil, GoDBError{IllegalOperationError, "an index needs at least one key column"}
+	}
+	file, err := os.OpenFile(fromFile, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	f := &BTreeFile{fileName: fromFile, keyDesc: &TupleDesc{append([]FieldType(nil), keyDesc.Fields...)}, bufPool: bp}
+	if f.NumPages() == 0 {
+		if err := f.flushPage(newBTreePage(f, btreeRoot, true)); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Return the name of the backing file.
+func (f *BTreeFile) BackingFile() string {
+	return f.fileName
+}
+
+// Return the number of pages of the file.
+func (f *BTreeFile) NumPages() int {
+	info, err := os.Stat(f.fileName)
+	if err != nil {
+		return 0
+	}
+	return int(info.Size() / int64(PageSize))
+}
+
+// Return the TupleDesc of the keys of the index.
+func (f *BTreeFile) Descriptor() *TupleDesc {
+	return f.keyDesc
+}
+
+func (f *BTreeFile) pageKey(pgNo int) any {
+	return heapHash{FileName: f.fileName, PageNo: pgNo}
+}
+
+// Read node pageNo from the file. Called by [BufferPool.GetPage].
+func (f *BTreeFile) readPage(pageNo int) (Page, error) {
+	file, err := os.Open(f.fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	buf := make([]byte, PageSize)
+	if _, err := file.ReadAt(buf, int64(pageNo)*int64(PageSize)); err != nil {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("cannot read page %d of %s: %v", pageNo, f.fileName, err)}
+	}
+	p := newBTreePage(f, pageNo, true)
+	if err := p.initFromBuffer(bytes.NewBuffer(buf)); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Write the node to its page of the file.
+func (f *BTreeFile) flushPage(page Page) error {
+	p, ok := page.(*btreePage)
+	if !ok {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("%s can only store B+ tree pages", f.fileName)}
+	}
+	buf, err := p.toBuffer()
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.fileName, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteAt(buf.Bytes(), int64(p.pageNo)*int64(PageSize))
+	return err
+}
+
+// The latches of the nodes of a B+ tree (see [BTreeFile.getNode]). The zero
+// value is ready to use.
+type nodeLatches struct {
+	mu      sync.Mutex
+	latches map[int]*sync.RWMutex
+}
+
+// Return the latch of node pageNo.
+func (nl *nodeLatches) of(pageNo int) *sync.RWMutex {
+	nl.mu.Lock()
+	defer nl.mu.Unlock()
+	if nl.latches == nil {
+		nl.latches = make(map[int]*sync.RWMutex)
+	}
+	l := nl.latches[pageNo]
+	if l == nil {
+		l = new(sync.RWMutex)
+		nl.latches[pageNo] = l
+	}
+	return l
+}
+
+// Latch node pageNo for reading or writing, as specified by perm, waiting for
+// the operations that hold conflicting latches on it to release them.
+func (nl *nodeLatches) latch(pageNo int, perm RWPerm) {
+	if perm == WritePerm {
+		nl.of(pageNo).Lock()
+	} else {
+		nl.of(pageNo).RLock()
+	}
+}
+
+// Latch node pageNo as [nodeLatches.latch] does, but return false rather than
+// waiting if another operation holds a conflicting latch on it.
+func (nl *nodeLatches) tryLatch(pageNo int, perm RWPerm) bool {
+	if perm == WritePerm {
+		return nl.of(pageNo).TryLock()
+	}
+	return nl.of(pageNo).TryRLock()
+}
+
+// Release the latch taken on node pageNo with the specified permission.
+func (nl *nodeLatches) unlatch(pageNo int, perm RWPerm) {
+	if perm == WritePerm {
+		nl.of(pageNo).Unlock()
+	} else {
+		nl.of(pageNo).RUnlock()
+	}
+}
+
+// Return node pageNo of the tree, read on behalf of tid and latched for
+// reading or writing as specified by perm; the caller releases the latch with
+// [BTreeFile.releaseNodes] when it is done with the node. Latches are only
+// held for the duration of an operation on the tree, and are taken from the
+// root down and from left to right along the leaves, so they cannot deadlock;
+// a transaction never waits for a lock while it holds latches (see
+// [BTreeFile.lockLeaf]). [BufferPool.GetPage] does not lock nodes.
+func (f *BTreeFile) getNode(pageNo int, tid TransactionID, perm RWPerm) (*btreePage, error) {
+	f.latches.latch(pageNo, perm)
+	pg, err := f.bufPool.GetPage(f, pageNo, tid, perm)
+	if err != nil {
+		f.latches.unlatch(pageNo, perm)
+		return nil, err
+	}
+	p, ok := pg.(*btreePage)
+	if !ok {
+		f.latches.unlatch(pageNo, perm)
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("page %d of %s is not a B+ tree page", pageNo, f.fileName)}
+	}
+	return p, nil
+}
+
+// Release the latches taken with the specified permission on nodes.
+func (f *BTreeFile) releaseNodes(nodes []*btreePage, perm RWPerm) {
+	for _, p := range nodes {
+		f.latches.unlatch(p.pageNo, perm)
+	}
+}
+
+// Read the nodes from the root down to the leaf where e belongs, or to the
+// first leaf that may hold entries satisfying the lower bound lo if e is nil,
+// on behalf of tid, latching them as specified by perm. Once a node for which
+// safe returns true is latched, the nodes above it are released. Returns the
+// nodes still latched, highest first; the last one is the leaf.
+func (f *BTreeFile) descend(e *btreeEntry, lo *BTreeBound, tid TransactionID, perm RWPerm, safe func(*btreePage) bool) ([]*btreePage, error) {
+	p, err := f.getNode(btreeRoot, tid, perm)
+	if err != nil {
+		return nil, err
+	}
+	path := []*btreePage{p}
+	for !p.leaf {
+		i := p.childForBound(lo)
+		if e != nil {
+			i = p.childFor(*e)
+		}
+		p, err = f.getNode(p.children[i], tid, perm)
+		if err != nil {
+			f.releaseNodes(path, perm)
+			return nil, err
+		}
+		if safe(p) {
+			f.releaseNodes(path, perm)
+			path = path[:0]
+		}
+		path = append(path, p)
+	}
+	return path, nil
+}
+
+// Lock leaf p, which the caller holds latched, on behalf of tid as the
+// BufferPool locks a heap page read or modified with the specified permission
+// (see [BufferPool.lockPage]): readers take the read locks their isolation
+// level requires, so that, e.g., another transaction cannot insert into a
+// range a Serializable transaction has scanned, and writers keep other
+// transactions from reading or changing their uncommitted entries until they
+// end, unless the BufferPool uses row locking, in which case they only take an
+// intention lock and rely on the locks of the heap tuples instead. Returns
+// false without waiting if the lock is held by another transaction: the caller
+// must then release its latches, wait for the lock with [BufferPool.lockPage]
+// and start its operation again.
+func (f *BTreeFile) lockLeaf(p *btreePage, tid TransactionID, perm RWPerm) (bool, error) {
+	err := f.bufPool.lockPage(tid, f.pageKey(p.pageNo), perm, LockNoWait)
+	if isLockNotAvailable(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Append an empty node to the file on behalf of tid, returning it latched for
+// writing.
+func (f *BTreeFile) allocateNode(leaf bool, tid TransactionID) (*btreePage, error) {
+	f.allocMu.Lock()
+	pageNo := f.NumPages()
+	err := f.flushPage(newBTreePage(f, pageNo, true))
+	f.allocMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	p, err := f.getNode(pageNo, tid, WritePerm)
+	if err != nil {
+		return nil, err
+	}
+	p.leaf = leaf
+	return p, nil
+}
+
+// Append a new leaf to the file on behalf of tid, returning it latched for
+// writing and locked like the leaves tid modifies (see [BTreeFile.lockLeaf]),
+// since it receives entries of a leaf tid is modifying. No other transaction
+// can reach the leaf before it is linked into the tree, so the lock is
+// requested without waiting.
+func (f *BTreeFile) allocateLeaf(tid TransactionID) (*btreePage, error) {
+	p, err := f.allocateNode(true, tid)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.bufPool.lockPage(tid, f.pageKey(p.pageNo), WritePerm, LockNoWait); err != nil {
+		f.releaseNodes([]*btreePage{p}, WritePerm)
+		return nil, err
+	}
+	return p, nil
+}
+
+// Write node p to its page of the file and mark it clean, waiting for the
+// operations that hold it latched for writing, so that it is written in a
+// consistent state. [BufferPool.CommitTransaction] and
+// [BufferPool.AbortTransaction] write the nodes a transaction dirtied with
+// this method, whether or not other running transactions have changes on them
+// (see [BTreeFile]).
+func (f *BTreeFile) flushNode(p *btreePage) error {
+	f.latches.latch(p.pageNo, ReadPerm)
+	defer f.latches.unlatch(p.pageNo, ReadPerm)
+	if err := f.flushPage(p); err != nil {
+		return err
+	}
+	p.setDirty(InvalidTID, false)
+	return nil
+}
+
+// Write node p as [BTreeFile.flushNode] does, unless an operation holds it
+// latched for writing, in which case return false without writing it. The
+// BufferPool evicts dirty nodes with this method, since the operation that
+// holds the latch may be the one whose [BufferPool.GetPage] is evicting a
+// page.
+func (f *BTreeFile) tryFlushNode(p *btreePage) (bool, error) {
+	if !f.latches.tryLatch(p.pageNo, ReadPerm) {
+		return false, nil
+	}
+	defer f.latches.unlatch(p.pageNo, ReadPerm)
+	if err := f.flushPage(p); err != nil {
+		return false, err
+	}
+	p.setDirty(InvalidTID, false)
+	return true, nil
+}
+
+// Return the entry for the key t, whose Rid must be a [heapRid], or an error
+// if t does not match the keys of the index or is too large.
+func (f *BTreeFile) entryOf(t *Tuple) (btreeEntry, error) {
+	rid, ok := t.Rid.(heapRid)
+	if !ok {
+		return btreeEntry{}, GoDBError{IllegalOperationError, fmt.Sprintf("index entries must point to heap tuples, not %v", t.Rid)}
+	}
+	if len(t.Fields) != len(f.keyDesc.Fields) {
+		return btreeEntry{}, GoDBError{TypeMismatchError, fmt.Sprintf("index key has %d fields, expected %d", len(t.Fields), len(f.keyDesc.Fields))}
+	}
+	e, err := f.newEntry(t.Fields, rid)
+	if err == nil && e.size > maxBTreeKeySize {
+		err = GoDBError{IllegalOperationError, fmt.Sprintf("index key of %d bytes is larger than the maximum of %d bytes", e.size, maxBTreeKeySize)}
+	}
+	return e, err
+}
+
+// Return the entry for key and rid, computing the size of its key.
+func (f *BTreeFile) newEntry(key []DBValue, rid heapRid) (btreeEntry, error) {
+	var b bytes.Buffer
+	t := Tuple{Desc: *f.keyDesc, Fields: key}
+	if err := t.writeVarlenTo(&b); err != nil {
+		return btreeEntry{}, err
+	}
+	return btreeEntry{key: key, rid: rid, size: b.Len()}, nil
+}
+
+// Insert an entry for the key t, whose Rid is the record id of the heap tuple
+// it indexes, on behalf of tid, and record the insert so that it can be undone
+// (see [rowChangeTable]). Returns an error if the index already has the entry.
+func (f *BTreeFile) insertTuple(t *Tuple, tid TransactionID) error {
+	e, err := f.entryOf(t)
+	if err != nil {
+		return err
+	}
+	if err := f.insertEntry(e, tid, true); err != nil {
+		return err
+	}
+	f.bufPool.changes.add(tid, &rowChange{kind: entryInsert, index: f, entry: e})
+	return nil
+}
+
+// Insert e on behalf of tid, locking the leaf it goes into first if lock is
+// set (see [BTreeFile.lockLeaf]). The nodes that may split are kept latched
+// from the first one that cannot split down to the leaf: a leaf cannot split
+// if e fits into it, and an internal node if any separator does.
+func (f *BTreeFile) insertEntry(e btreeEntry, tid TransactionID, lock bool) error {
+	safe := func(p *btreePage) bool {
+		if p.leaf {
+			return p.size()+p.entrySize(e) <= PageSize
+		}
+		return p.size()+maxBTreeKeySize+btreeRidSize+btreeChildSize <= PageSize
+	}
+	for {
+		path, err := f.descend(&e, nil, tid, WritePerm, safe)
+		if err != nil {
+			return err
+		}
+		leaf := path[len(path)-1]
+		locked := !lock
+		if lock {
+			if locked, err = f.lockLeaf(leaf, tid, WritePerm); err != nil {
+				f.releaseNodes(path, WritePerm)
+				return err
+			}
+		}
+		if locked {
+			err = f.insertInto(path, e, tid)
+			f.releaseNodes(path, WritePerm)
+			return err
+		}
+		f.releaseNodes(path, WritePerm)
+		if err := f.bufPool.lockPage(tid, f.pageKey(leaf.pageNo), WritePerm, LockWait); err != nil {
+			return err
+		}
+	}
+}
+
+// Insert e into the leaf at the end of path, the nodes returned by
+// [BTreeFile.descend], which are latched for writing, and split the nodes that
+// no longer fit in a page from the leaf up. Only the first node of path may
+// not have its parent in path, and it only splits if it is the root. The new
+// node holding the upper half of a split node follows it in the chain of
+// leaves if it is a leaf.
+func (f *BTreeFile) insertInto(path []*btreePage, e btreeEntry, tid TransactionID) error {
+	leaf := path[len(path)-1]
+	i := leaf.find(e)
+	if i < len(leaf.entries) && compareEntries(leaf.entries[i], e) == 0 {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("index %s already has an entry for %v at %v", f.fileName, e.key, e.rid)}
+	}
+	leaf.entries = append(leaf.entries[:i], append([]btreeEntry{e}, leaf.entries[i:]...)...)
+	leaf.setDirty(tid, true)
+	for k := len(path) - 1; path[k].size() > PageSize; k-- {
+		p := path[k]
+		if k == 0 {
+			return f.splitRoot(p, tid)
+		}
+		var right *btreePage
+		var err error
+		if p.leaf {
+			right, err = f.allocateLeaf(tid)
+		} else {
+			right, err = f.allocateNode(false, tid)
+		}
+		if err != nil {
+			return err
+		}
+		sep := p.splitOff(right)
+		right.setDirty(tid, true)
+		f.releaseNodes([]*btreePage{right}, WritePerm)
+		parent := path[k-1]
+		j := parent.childFor(e)
+		parent.entries = append(parent.entries[:j], append([]btreeEntry{sep}, parent.entries[j:]...)...)
+		parent.children = append(parent.children[:j+1], append([]int{right.pageNo}, parent.children[j+1:]...)...)
+		parent.setDirty(tid, true)
+	}
+	return nil
+}
+
+// Split the root, which is latched for writing and no longer fits in a page,
+// on behalf of tid: move both of its halves to new nodes, so that the root
+// stays at page 0, and make it an internal node pointing to them.
+func (f *BTreeFile) splitRoot(root *btreePage, tid TransactionID) error {
+	var left, right *btreePage
+	var err error
+	if root.leaf {
+		left, err = f.allocateLeaf(tid)
+		if err == nil {
+			right, err = f.allocateLeaf(tid)
+		}
+	} else {
+		left, err = f.allocateNode(false, tid)
+		if err == nil {
+			right, err = f.allocateNode(false, tid)
+		}
+	}
+	if err != nil {
+		if left != nil {
+			f.releaseNodes([]*btreePage{left}, WritePerm)
+		}
+		return err
+	}
+	defer f.releaseNodes([]*btreePage{left, right}, WritePerm)
+	left.entries, left.children, left.next = root.entries, root.children, root.next
+	sep := left.splitOff(right)
+	root.leaf = false
+	root.entries = []btreeEntry{sep}
+	root.children = []int{left.pageNo, right.pageNo}
+	root.next = noBTreePage
+	left.setDirty(tid, true)
+	right.setDirty(tid, true)
+	root.setDirty(tid, true)
+	return nil
+}
+
+// Delete the entry for the key t, whose Rid is the record id of the heap
+// tuple it indexes, on behalf of tid, and record the delete so that it can be
+// undone (see [rowChangeTable]). Returns a TupleNotFoundError if the index has
+// no such entry.
+func (f *BTreeFile) deleteTuple(t *Tuple, tid TransactionID) error {
+	e, err := f.entryOf(t)
+	if err != nil {
+		return err
+	}
+	if err := f.deleteEntry(e, tid, true); err != nil {
+		return err
+	}
+	f.bufPool.changes.add(tid, &rowChange{kind: entryDelete, index: f, entry: e})
+	return nil
+}
+
+// Delete e on behalf of tid, locking the leaf that holds it first if lock is
+// set (see [BTreeFile.lockLeaf]). Since deletes never change the structure of
+// the tree, only the leaf is kept latched.
+func (f *BTreeFile) deleteEntry(e btreeEntry, tid TransactionID, lock bool) error {
+	for {
+		path, err := f.descend(&e, nil, tid, WritePerm, func(*btreePage) bool { return true })
+		if err != nil {
+			return err
+		}
+		p := path[len(path)-1]
+		locked := !lock
+		if lock {
+			if locked, err = f.lockLeaf(p, tid, WritePerm); err != nil {
+				f.releaseNodes(path, WritePerm)
+				return err
+			}
+		}
+		if locked {
+			defer f.releaseNodes(path, WritePerm)
+			i := p.find(e)
+			if i == len(p.entries) || compareEntries(p.entries[i], e) != 0 {
+				return GoDBError{TupleNotFoundError, fmt.Sprintf("index %s has no entry for %v at %v", f.fileName, e.key, e.rid)}
+			}
+			p.entries = append(p.entries[:i], p.entries[i+1:]...)
+			p.setDirty(tid, true)
+			return nil
+		}
+		f.releaseNodes(path, WritePerm)
+		if err := f.bufPool.lockPage(tid, f.pageKey(p.pageNo), WritePerm, LockWait); err != nil {
+			return err
+		}
+	}
+}
+
+// Return a function that iterates through all the entries of the index, in
+// key order.
+func (f *BTreeFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return f.RangeIterator(nil, nil, tid)
+}
+
+// Return a function that iterates through the entries whose key is key, which
+// may be a prefix of the keys of the index.
+func (f *BTreeFile) Lookup(key []DBValue, tid TransactionID) (func() (*Tuple, error), error) {
+	bound := &BTreeBound{Key: key, Inclusive: true}
+	return f.RangeIterator(bound, bound, tid)
+}
+
+// Return a function that iterates, in key order, through the entries whose
+// keys are between the bounds lo and hi, which are inclusive or not as
+// specified; a nil bound leaves the range open on its side. Each entry is
+// returned as a tuple holding its key, whose Rid is the record id of the heap
+// tuple it points to. As no predicate is true of NULL, if either bound is not
+// nil, keys with a NULL in the columns the bounds constrain are never
+// returned.
+//
+// The entries of each leaf are copied when the iterator reaches it, and the
+// leaf is then released, so the iterator does not hold latches between calls.
+// Entries only move to the right when a leaf splits, to a leaf before the
+// next one the copy points to, so no entry is skipped; entries inserted into
+// a leaf after it has been copied are not returned.
+func (f *BTreeFile) RangeIterator(lo *BTreeBound, hi *BTreeBound, tid TransactionID) (func() (*Tuple, error), error) {
+	entries, next, err := f.readLeaf(btreeRoot, lo, tid)
+	if err != nil {
+		return nil, err
+	}
+	constrained := 0
+	for _, b := range []*BTreeBound{lo, hi} {
+		if b != nil {
+			constrained = max(constrained, len(b.Key))
+		}
+	}
+	return func() (*Tuple, error) {
+		for {
+			if len(entries) == 0 {
+				if next == noBTreePage {
+					return nil, nil
+				}
+				var err error
+				if entries, next, err = f.readLeaf(next, nil, tid); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			e := entries[0]
+			entries = entries[1:]
+			if !aboveLowerBound(e.key, lo) {
+				continue
+			}
+			if !belowUpperBound(e.key, hi) || hasNullIn(e.key[:min(constrained, len(e.key))]) {
+				// NULLs are sorted last, so no later key is in the range
+				entries, next = nil, noBTreePage
+				return nil, nil
+			}
+			return &Tuple{Desc: *f.keyDesc, Fields: e.key, Rid: e.rid}, nil
+		}
+	}, nil
+}
+
+// Return a copy of the entries of a leaf and the number of the next leaf, read
+// on behalf of tid: the first leaf that may hold entries satisfying the lower
+// bound lo if pageNo is the root, and leaf pageNo otherwise. The leaf is
+// locked for reading (see [BTreeFile.lockLeaf]) before it is read.
+func (f *BTreeFile) readLeaf(pageNo int, lo *BTreeBound, tid TransactionID) ([]btreeEntry, int, error) {
+	defer f.bufPool.endRead(tid, f.bufPool.startRead(tid))
+	for {
+		var path []*btreePage
+		var err error
+		if pageNo == btreeRoot {
+			path, err = f.descend(nil, lo, tid, ReadPerm, func(*btreePage) bool { return true })
+		} else {
+			var p *btreePage
+			p, err = f.getNode(pageNo, tid, ReadPerm)
+			path = []*btreePage{p}
+		}
+		if err != nil {
+			return nil, noBTreePage, err
+		}
+		p := path[len(path)-1]
+		locked, err := f.lockLeaf(p, tid, ReadPerm)
+		if locked {
+			entries, next := append([]btreeEntry(nil), p.entries...), p.next
+			f.releaseNodes(path, ReadPerm)
+			return entries, next, nil
+		}
+		f.releaseNodes(path, ReadPerm)
+		if err == nil {
+			err = f.bufPool.lockPage(tid, f.pageKey(p.pageNo), ReadPerm, LockWait)
+		}
+		if err != nil {
+			return nil, noBTreePage, err
+		}
+	}
+}
+
+// Return true if key satisfies the lower bound b; a nil bound is satisfied by
+// any key.
+func aboveLowerBound(key []DBValue, b *BTreeBound) bool {
+	if b == nil {
+		return true
+	}
+	c := compareKeys(key, b.Key)
+	return c > 0 || c == 0 && b.Inclusive
+}
+
+// Return true if key satisfies the upper bound b; a nil bound is satisfied by
+// any key.
+func belowUpperBound(key []DBValue, b *BTreeBound) bool {
+	if b == nil {
+		return true
+	}
+	c := compareKeys(key, b.Key)
+	return c < 0 || c == 0 && b.Inclusive
+}
+
+// Return true if any of the values is NULL.
+func hasNullIn(values []DBValue) bool {
+	for _, v := range values {
+		if isNull(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compare two values of the same type, returning -1, 0 or 1 if v1 is smaller
+// than, equal to or greater than v2. NULL is greater than any other value.
+func compareKeyValues(v1 DBValue, v2 DBValue) int {
+	order, ok := compareNulls(v1, v2)
+	if !ok {
+		switch {
+		case v1.EvalPred(v2, OpLt):
+			order = OrderedLessThan
+		case v1.EvalPred(v2, OpGt):
+			order = OrderedGreaterThan
+		}
+	}
+	switch order {
+	case OrderedLessThan:
+		return -1
+	case OrderedGreaterThan:
+		return 1
+	}
+	return 0
+}
+
+// Compare two keys column by column, returning -1, 0 or 1 if k1 is smaller
+// than, equal to or greater than k2. Only the columns both keys have are
+// compared, so a key is equal to its prefixes.
+func compareKeys(k1 []DBValue, k2 []DBValue) int {
+	for i := 0; i < len(k1) && i < len(k2); i++ {
+		if c := compareKeyValues(k1[i], k2[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// Compare two record ids by page and then by slot.
+func compareRids(r1 heapRid, r2 heapRid) int {
+	if r1.pageNo != r2.pageNo {
+		return compareInts(r1.pageNo, r2.pageNo)
+	}
+	return compareInts(r1.slot, r2.slot)
+}
+
+func compareInts(a int, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Compare two entries by key and then by record id.
+func compareEntries(e1 btreeEntry, e2 btreeEntry) int {
+	if c := compareKeys(e1.key, e2.key); c != 0 {
+		return c
+	}
+	return compareRids(e1.rid, e2.rid)
+}
+
+// Create an empty node.
+func newBTreePage(f *BTreeFile, pageNo int, leaf bool) *btreePage {
+	p := &btreePage{file: f, pageNo: pageNo, leaf: leaf, next: noBTreePage, lsn: InvalidLSN}
+	if !leaf {
+		p.children = []int{}
+	}
+	return p
+}
+
+// Return the position of the first entry of the node that is greater than or
+// equal to e.
+func (p *btreePage) find(e btreeEntry) int {
+	return sort.Search(len(p.entries), func(i int) bool {
+		return compareEntries(p.entries[i], e) >= 0
+	})
+}
+
+// Return the position of the child of an internal node below which e belongs.
+func (p *btreePage) childFor(e btreeEntry) int {
+	return sort.Search(len(p.entries), func(i int) bool {
+		return compareEntries(p.entries[i], e) > 0
+	})
+}
+
+// Return the position of the first child of an internal node that may hold
+// entries satisfying the lower bound lo.
+func (p *btreePage) childForBound(lo *BTreeBound) int {
+	if lo == nil {
+		return 0
+	}
+	return sort.Search(len(p.entries), func(i int) bool {
+		c := compareKeys(p.entries[i].key, lo.Key)
+		return c > 0 || c == 0 && lo.Inclusive
+	})
+}
+
+// Return the size of the node when written to a page.
+func (p *btreePage) size() int {
+	size := btreeHeaderSize
+	if !p.leaf {
+		size += btreeChildSize
+	}
+	for _, e := range p.entries {
+		size += p.entrySize(e)
+	}
+	return size
+}
+
+// Return the size of an entry of the node when written to a page.
+func (p *btreePage) entrySize(e btreeEntry) int {
+	if p.leaf {
+		return e.size + btreeRidSize
+	}
+	return e.size + btreeRidSize + btreeChildSize
+}
+
+// Move the entries of the node past the first btreeMinFillSize bytes, but at
+// least one, to right, an empty node of the same kind, and return the
+// separator to insert into their parent. The first entry moved is the
+// separator; an internal node moves its children after it to right and keeps
+// the child before it, while a leaf keeps a copy of the separator in right
+// and links right after itself.
+func (p *btreePage) splitOff(right *btreePage) btreeEntry {
+	mid, used := 1, p.entrySize(p.entries[0])
+	for mid < len(p.entries)-1 && used+p.entrySize(p.entries[mid]) <= btreeMinFillSize {
+		used += p.entrySize(p.entries[mid])
+		mid++
+	}
+	sep := p.entries[mid]
+	if p.leaf {
+		right.entries = append([]btreeEntry(nil), p.entries[mid:]...)
+		right.next, p.next = p.next, right.pageNo
+	} else {
+		right.entries = append([]btreeEntry(nil), p.entries[mid+1:]...)
+		right.children = append([]int(nil), p.children[mid+1:]...)
+		p.children = p.children[:mid+1]
+	}
+	p.entries = p.entries[:mid]
+	return sep
+}
+
+// Page method - return whether or not the node is dirty.
+func (p *btreePage) isDirty() bool {
+	return p.dirty
+}
+
+// Page method - mark the node as dirty on behalf of tid, or as clean.
+func (p *btreePage) setDirty(tid TransactionID, dirty bool) {
+	p.dirty = dirty
+	if !dirty {
+		p.dirtiers = nil
+	} else if !p.dirtiers[tid] {
+		if p.dirtiers == nil {
+			p.dirtiers = make(map[TransactionID]bool)
+		}
+		p.dirtiers[tid] = true
+	}
+}
+
+// Page method - return the BTreeFile of the node.
+func (p *btreePage) getFile() DBFile {
+	return p.file
+}
+
+// Write the node to a new buffer of PageSize bytes, in the format described
+// in [BTreeFile].
+func (p *btreePage) toBuffer() (*bytes.Buffer, error) {
+	if p.size() > PageSize {
+		return nil, GoDBError{PageFullError, fmt.Sprintf("node of %d bytes does not fit in a page", p.size())}
+	}
+	var b bytes.Buffer
+	var header [btreeHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[0:], btreePageMagic)
+	if p.leaf {
+		header[4] = btreeLeafFlag
+	}
+	binary.LittleEndian.PutUint16(header[5:], uint16(len(p.entries)))
+	binary.LittleEndian.PutUint32(header[7:], uint32(int32(p.next)))
+	b.Write(header[:])
+	if !p.leaf {
+		binary.Write(&b, binary.LittleEndian, int32(p.children[0]))
+	}
+	for i, e := range p.entries {
+		t := Tuple{Desc: *p.file.keyDesc, Fields: e.key}
+		if err := t.writeVarlenTo(&b); err != nil {
+			return nil, err
+		}
+		binary.Write(&b, binary.LittleEndian, [2]int32{int32(e.rid.pageNo), int32(e.rid.slot)})
+		if !p.leaf {
+			binary.Write(&b, binary.LittleEndian, int32(p.children[i+1]))
+		}
+	}
+	b.Write(make([]byte, PageSize-b.Len()))
+	return &b, nil
+}
+
+// Read the node from buf, and save a copy of buf as its before image.
+func (p *btreePage) initFromBuffer(buf *bytes.Buffer) error {
+	p.beforeImage = append([]byte(nil), buf.Bytes()...)
+	header := buf.Next(btreeHeaderSize)
+	if len(header) < btreeHeaderSize {
+		return GoDBError{MalformedDataError, "truncated B+ tree page"}
+	}
+	p.entries, p.children, p.next = nil, nil, noBTreePage
+	switch binary.LittleEndian.Uint32(header) {
+	case 0:
+		p.leaf = true
+		return nil
+	case btreePageMagic:
+	default:
+		return GoDBError{MalformedDataError, fmt.Sprintf("page %d of %s is not a B+ tree page", p.pageNo, p.file.fileName)}
+	}
+	p.leaf = header[4] == btreeLeafFlag
+	n := int(binary.LittleEndian.Uint16(header[5:]))
+	p.next = int(int32(binary.LittleEndian.Uint32(header[7:])))
+	readInt := func() (int, error) {
+		v := buf.Next(4)
+		if len(v) < 4 {
+			return 0, GoDBError{MalformedDataError, "truncated B+ tree entry"}
+		}
+		return int(int32(binary.LittleEndian.Uint32(v))), nil
+	}
+	if !p.leaf {
+		child, err := readInt()
+		if err != nil {
+			return err
+		}
+		p.children = []int{child}
+	}
+	for i := 0; i < n; i++ {
+		before := buf.Len()
+		key, err := readVarlenTupleFrom(buf, p.file.keyDesc)
+		if err != nil {
+			return err
+		}
+		e := btreeEntry{key: key.Fields, size: before - buf.Len()}
+		if e.rid.pageNo, err = readInt(); err != nil {
+			return err
+		}
+		if e.rid.slot, err = readInt(); err != nil {
+			return err
+		}
+		if !p.leaf {
+			child, err := readInt()
+			if err != nil {
+				return err
+			}
+			p.children = append(p.children, child)
+		}
+		p.entries = append(p.entries, e)
+	}
+	return nil
+}
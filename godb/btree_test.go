@@ -0,0 +1,231 @@
+package godb
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func makeTestBTree(t *testing.T) *BTreeFile {
+	t.Helper()
+	keyDesc := &TupleDesc{[]FieldType{{"name", "", StringType}, {"age", "", IntType}}}
+	f, err := NewBTreeFile(filepath.Join(t.TempDir(), "idx.dat"), keyDesc, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return f
+}
+
+func mustEntry(t *testing.T, f *BTreeFile, name string, age DBValue, page int, slot int) btreeEntry {
+	t.Helper()
+	e, err := f.newEntry([]DBValue{StringField{name}, age}, heapRid{page, slot})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return e
+}
+
+func TestBTreePageSerialization(t *testing.T) {
+	f := makeTestBTree(t)
+	if f.NumPages() != 1 {
+		t.Fatalf("expected a new index to have a root page, got %d pages", f.NumPages())
+	}
+	pg, err := f.readPage(btreeRoot)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if root := pg.(*btreePage); !root.leaf || len(root.entries) != 0 || root.next != noBTreePage {
+		t.Errorf("expected an empty root leaf, got %+v", root)
+	}
+
+	leaf := newBTreePage(f, 1, true)
+	leaf.entries = []btreeEntry{mustEntry(t, f, "joe", IntField{1}, 0, 3), mustEntry(t, f, "sam", NullField{}, 7, 0)}
+	leaf.next = 4
+	inner := newBTreePage(f, 2, false)
+	inner.entries = []btreeEntry{mustEntry(t, f, "bob", IntField{5}, 2, 1)}
+	inner.children = []int{1, 3}
+	for _, p := range []*btreePage{leaf, inner} {
+		if err := f.flushPage(p); err != nil {
+			t.Fatalf(err.Error())
+		}
+		pg, err := f.readPage(p.pageNo)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		p2 := pg.(*btreePage)
+		if p2.leaf != p.leaf || p2.next != p.next || fmt.Sprint(p2.children) != fmt.Sprint(p.children) || len(p2.entries) != len(p.entries) {
+			t.Fatalf("expected %+v, got %+v", p, p2)
+		}
+		for i, e := range p.entries {
+			if compareEntries(e, p2.entries[i]) != 0 || !isNull(e.key[1]) && e.key[1] != p2.entries[i].key[1] || e.size != p2.entries[i].size {
+				t.Errorf("entry %d: expected %+v, got %+v", i, e, p2.entries[i])
+			}
+		}
+		if len(p2.beforeImage) != PageSize {
+			t.Errorf("expected the page to be saved as its before image")
+		}
+	}
+	if f.NumPages() != 3 {
+		t.Errorf("expected 3 pages, got %d", f.NumPages())
+	}
+}
+
+func TestBTreeKeyOrder(t *testing.T) {
+	keys := [][]DBValue{
+		{StringField{"a"}, IntField{2}},
+		{StringField{"a"}, IntField{10}},
+		{StringField{"a"}, NullField{}},
+		{StringField{"b"}, IntField{-1}},
+		{NullField{}, IntField{0}},
+	}
+	for i := range keys {
+		for j := range keys {
+			if c := compareKeys(keys[i], keys[j]); c != compareInts(i, j) {
+				t.Errorf("%v, %v: expected %d, got %d", keys[i], keys[j], compareInts(i, j), c)
+			}
+		}
+	}
+	if compareKeys(keys[1], []DBValue{StringField{"a"}}) != 0 {
+		t.Errorf("expected a key to be equal to its prefix")
+	}
+
+	f := makeTestBTree(t)
+	p := newBTreePage(f, 1, false)
+	p.entries = []btreeEntry{mustEntry(t, f, "b", IntField{1}, 0, 0), mustEntry(t, f, "b", IntField{1}, 0, 5), mustEntry(t, f, "c", IntField{0}, 0, 0)}
+	p.children = []int{2, 3, 4, 5}
+	if i := p.childFor(mustEntry(t, f, "b", IntField{1}, 0, 2)); i != 1 {
+		t.Errorf("expected duplicates between two separators to belong to child 1, got %d", i)
+	}
+	if i := p.childFor(p.entries[2]); i != 3 {
+		t.Errorf("expected a separator to belong to the child after it, got %d", i)
+	}
+	bounds := []struct {
+		bound    BTreeBound
+		expected int
+	}{
+		{BTreeBound{[]DBValue{StringField{"b"}}, true}, 0},
+		{BTreeBound{[]DBValue{StringField{"b"}}, false}, 2},
+		{BTreeBound{[]DBValue{StringField{"bb"}}, true}, 2},
+		{BTreeBound{[]DBValue{StringField{"z"}}, true}, 3},
+	}
+	for _, b := range bounds {
+		if i := p.childForBound(&b.bound); i != b.expected {
+			t.Errorf("%+v: expected child %d, got %d", b.bound, b.expected, i)
+		}
+	}
+	lo, hi := &BTreeBound{[]DBValue{StringField{"b"}}, false}, &BTreeBound{[]DBValue{StringField{"c"}, IntField{3}}, true}
+	for key, expected := range map[string][2]bool{"b": {false, true}, "c": {true, true}, "d": {true, false}} {
+		k := []DBValue{StringField{key}, IntField{3}}
+		if aboveLowerBound(k, lo) != expected[0] || belowUpperBound(k, hi) != expected[1] {
+			t.Errorf("%s: expected %v", key, expected)
+		}
+	}
+}
+
+func TestBTreeSplit(t *testing.T) {
+	f := makeTestBTree(t)
+	leaf := newBTreePage(f, 1, true)
+	leaf.next = 9
+	for i := 0; leaf.size() <= PageSize; i++ {
+		leaf.entries = append(leaf.entries, mustEntry(t, f, strings.Repeat("x", 40), IntField{int64(i)}, i, 0))
+	}
+	n := len(leaf.entries)
+	right := newBTreePage(f, 2, true)
+	sep := leaf.splitOff(right)
+	if len(leaf.entries)+len(right.entries) != n || compareEntries(sep, right.entries[0]) != 0 {
+		t.Errorf("expected a leaf to keep a copy of the separator, got %d + %d entries", len(leaf.entries), len(right.entries))
+	}
+	if leaf.next != 2 || right.next != 9 {
+		t.Errorf("expected the new leaf to be linked after the old one, got %d and %d", leaf.next, right.next)
+	}
+	if leaf.size() > PageSize/2 || right.size() > PageSize || len(leaf.entries) < n/3 {
+		t.Errorf("unbalanced split: %d and %d bytes", leaf.size(), right.size())
+	}
+
+	inner := newBTreePage(f, 3, false)
+	inner.children = []int{100}
+	for i := 0; inner.size() <= PageSize; i++ {
+		inner.entries = append(inner.entries, mustEntry(t, f, "k", IntField{int64(i)}, 0, 0))
+		inner.children = append(inner.children, 101+i)
+	}
+	n = len(inner.entries)
+	right = newBTreePage(f, 4, false)
+	sep = inner.splitOff(right)
+	if len(inner.entries)+len(right.entries) != n-1 || len(inner.children) != len(inner.entries)+1 || len(right.children) != len(right.entries)+1 {
+		t.Errorf("expected an internal node to move its separator up, got %d + %d entries", len(inner.entries), len(right.entries))
+	}
+	if sep.key[1] != (IntField{int64(len(inner.entries))}) || right.children[0] != 100+len(inner.entries)+1 {
+		t.Errorf("unexpected separator %v and first child %d", sep.key, right.children[0])
+	}
+}
+
+func TestBTreeEntries(t *testing.T) {
+	f := makeTestBTree(t)
+	if _, err := f.entryOf(&Tuple{Fields: []DBValue{StringField{"a"}, IntField{1}}, Rid: 3}); err == nil {
+		t.Errorf("expected an entry without a heap record id to be rejected")
+	}
+	if _, err := f.entryOf(&Tuple{Fields: []DBValue{StringField{"a"}}, Rid: heapRid{0, 0}}); err == nil {
+		t.Errorf("expected a key with too few fields to be rejected")
+	}
+	if _, err := f.entryOf(&Tuple{Fields: []DBValue{StringField{strings.Repeat("a", maxBTreeKeySize)}, IntField{1}}, Rid: heapRid{0, 0}}); err == nil {
+		t.Errorf("expected a key larger than maxBTreeKeySize to be rejected")
+	}
+	if _, err := NewBTreeFile(filepath.Join(t.TempDir(), "empty.dat"), &TupleDesc{}, nil); err == nil {
+		t.Errorf("expected an index without key columns to be rejected")
+	}
+
+	hf := &HeapFile{}
+	idx := &tableIndex{name: "by_age", fields: []int{2, 0}, file: f}
+	hf.addIndex(idx)
+	hf.addIndex(&tableIndex{name: "other", fields: []int{0}, file: f})
+	key, err := idx.keyOf(&Tuple{Fields: []DBValue{StringField{"sam"}, IntField{7}, IntField{25}}, Rid: heapRid{3, 4}})
+	if err != nil || key.Fields[0] != (IntField{25}) || key.Fields[1] != (StringField{"sam"}) || key.Rid != (heapRid{3, 4}) {
+		t.Errorf("unexpected key %v (%v)", key, err)
+	}
+	if hf.dropIndex("by_age") != idx || hf.dropIndex("by_age") != nil || len(hf.indexes) != 1 {
+		t.Errorf("expected the index to be dropped once")
+	}
+}
+
+// Node latches are shared between readers and exclusive for writers, and a
+// node latched for writing is not written by an eviction.
+func TestBTreeNodeLatches(t *testing.T) {
+	f := makeTestBTree(t)
+	f.latches.latch(btreeRoot, WritePerm)
+	if f.latches.tryLatch(btreeRoot, ReadPerm) {
+		t.Fatalf("expected a node latched for writing not to be latched for reading")
+	}
+	if !f.latches.tryLatch(1, WritePerm) {
+		t.Fatalf("expected the latch of another node to be free")
+	}
+	f.latches.unlatch(1, WritePerm)
+
+	root := newBTreePage(f, btreeRoot, true)
+	root.entries = []btreeEntry{mustEntry(t, f, "alice", IntField{30}, 0, 0)}
+	root.setDirty(NewTID(), true)
+	if written, err := f.tryFlushNode(root); err != nil || written {
+		t.Fatalf("expected a latched node not to be written, got %v, %v", written, err)
+	}
+	f.latches.unlatch(btreeRoot, WritePerm)
+
+	if !f.latches.tryLatch(btreeRoot, ReadPerm) || !f.latches.tryLatch(btreeRoot, ReadPerm) {
+		t.Fatalf("expected readers to share the latch of a node")
+	}
+	if f.latches.tryLatch(btreeRoot, WritePerm) {
+		t.Fatalf("expected a node latched for reading not to be latched for writing")
+	}
+	if written, err := f.tryFlushNode(root); err != nil || !written || root.isDirty() {
+		t.Fatalf("expected a node latched for reading to be written, got %v, %v", written, err)
+	}
+	f.latches.unlatch(btreeRoot, ReadPerm)
+	f.latches.unlatch(btreeRoot, ReadPerm)
+
+	pg, err := f.readPage(btreeRoot)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if n := len(pg.(*btreePage).entries); n != 1 {
+		t.Errorf("expected the written node to have 1 entry, got %d", n)
+	}
+}
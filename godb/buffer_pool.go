@@ -7,6 +7,8 @@ package godb
 
 import (
 	"fmt"
+	"sync"
+	"time"
 )
 
 // Permissions used to when reading / locking pages
@@ -19,6 +21,71 @@ const (
 
 type BufferPool struct {
 	// TODO: some code goes here
+	// BufferPool should include the fields below; you may want to add
+	// additional fields
+
+	// The write-ahead log for the database, if any (see [BufferPool.setLogFile]).
+	// If nil, the BufferPool does not log its updates.
+	logFile *LogFile
+
+	// Transaction state for MVCC (see [VersionManager]); nil unless the
+	// BufferPool was created with MVCC enabled.
+	versions *VersionManager
+
+	// The page locks held by transactions (see [BufferPool.lockPage]),
+	// created on first use.
+	locks     *LockManager
+	locksOnce sync.Once
+
+	// Settings of transactions, such as their isolation level (see
+	// [IsolationLevel]).
+	settings txnSettingsTable
+
+	// Row locking state (see [BufferPool.lockTuple]); rowLocking is false
+	// unless the BufferPool was created with RowLocking set.
+	rowLocking              bool
+	lockEscalationThreshold int
+	rowLocks                rowLockTable
+
+	// The changes running transactions made to B+ trees, and to heap pages
+	// with row locking (see [rowChangeTable]); commits hold commitMu while they compute and log
+	// the pages they write (see [BufferPool.commitPage]).
+	changes  rowChangeTable
+	commitMu sync.Mutex
+
+	// The short read locks of transactions at ReadCommitted (see
+	// [BufferPool.startRead]).
+	shortLocks shortLockTable
+
+	// The savepoints of running transactions (see [BufferPool.Savepoint]).
+	savepoints savepointTable
+}
+
+// Options for [NewBufferPoolWithOptions]. The zero value gives the same
+// BufferPool as [NewBufferPool].
+type BufferPoolOptions struct {
+	// Use multi-version concurrency control: heap tuples are stored with
+	// version headers and readers do not take read locks (see
+	// [VersionManager]). All heap files of a database must be accessed
+	// with the same setting, since it changes their page format.
+	MVCC bool
+
+	// Lock tuples rather than pages, so that transactions modifying
+	// different tuples of the same page do not conflict (see
+	// [BufferPool.lockTuple]).
+	RowLocking bool
+
+	// Whether deadlocks are detected or prevented (see [DeadlockMode]).
+	DeadlockMode DeadlockMode
+
+	// How the victim of a deadlock is chosen when deadlocks are detected (see
+	// [VictimPolicy]).
+	DeadlockVictim VictimPolicy
+
+	// The number of row locks a transaction can hold in a table before they
+	// are escalated to a table lock; if zero,
+	// DefaultLockEscalationThreshold.
+	LockEscalationThreshold int
 }
 
 // Create a new BufferPool with the specified number of pages
@@ -26,9 +93,47 @@ func NewBufferPool(numPages int) (*BufferPool, error) {
 	return &BufferPool{}, fmt.Errorf("NewBufferPool not implemented")
 }
 
+// Create a new BufferPool with the specified number of pages and options.
+func NewBufferPoolWithOptions(numPages int, opts BufferPoolOptions) (*BufferPool, error) {
+	bp, err := NewBufferPool(numPages)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MVCC {
+		bp.versions = NewVersionManager()
+	}
+	bp.rowLocking = opts.RowLocking
+	bp.lockEscalationThreshold = opts.LockEscalationThreshold
+	bp.SetDeadlockMode(opts.DeadlockMode)
+	bp.SetDeadlockVictimPolicy(opts.DeadlockVictim)
+	return bp, nil
+}
+
+// Set whether deadlocks are detected, or prevented with wait-die or
+// wound-wait. Can be changed while transactions are running; the new mode
+// applies to lock requests made from then on.
+func (bp *BufferPool) SetDeadlockMode(m DeadlockMode) {
+	bp.lockManager().SetDeadlockMode(m)
+}
+
+// Set the policy used to choose which transaction to abort when transactions
+// deadlock.
+func (bp *BufferPool) SetDeadlockVictimPolicy(p VictimPolicy) {
+	bp.lockManager().SetVictimPolicy(p)
+}
+
+// Return the current wait-for graph of the transactions waiting for locks, for
+// diagnostics (see [LockManager]).
+func (bp *BufferPool) WaitForGraph() WaitForGraph {
+	return bp.lockManager().WaitForGraph()
+}
+
 // Testing method -- iterate through all pages in the buffer pool
 // and flush them using [DBFile.flushPage]. Does not need to be thread/transaction safe.
-// Mark pages as not dirty after flushing them.
+// Mark pages as not dirty after flushing them. If the BufferPool has a log file,
+// the log must be forced up to each page's LSN before the page is written, and
+// the contents written become the page's new before image. Pages are not
+// logged by this method.
 func (bp *BufferPool) FlushAllPages() {
 	// TODO: some code goes here
 }
@@ -36,6 +141,34 @@ func (bp *BufferPool) FlushAllPages() {
 // Abort the transaction, releasing locks. Because GoDB is FORCE/NO STEAL, none
 // of the pages tid has dirtied will be on disk so it is sufficient to just
 // release locks to abort. You do not need to implement this for lab 1.
+//
+// If the BufferPool has a log file, it is STEAL/NO FORCE (see
+// [BufferPool.GetPage] and [BufferPool.CommitTransaction]), so before
+// releasing locks:
+//
+//  1. restore every cached page tid has dirtied from its before image (see
+//     [heapPage]), dropping pages whose before image is nil. The before image
+//     is the last committed version of the page, which may not be on disk;
+//  2. call [LogFile.logAbort], which restores the pages tid dirtied that were
+//     evicted before the abort, and drop the pages it returns from the cache.
+//
+// In either case, mark the room of the heap pages that are restored or
+// dropped as unknown with [HeapFile.forgetFreeSpace], since the inserts and
+// deletes of tid no longer apply to them (see [freeSpaceMap]).
+//
+// Other running transactions may have changes on the B+ tree nodes tid
+// dirtied and, if the BufferPool uses row locking, on the heap pages tid
+// dirtied, so first undo the changes of tid with [BufferPool.undoChanges], and
+// write the nodes tid dirtied with [BTreeFile.flushNode]. The steps above then
+// leave the nodes of B+ trees alone, as well as the pages of heap files if the
+// BufferPool uses row locking, and the log holds no records of tid for them
+// (see [rowChangeTable]).
+//
+// If the BufferPool uses MVCC, also mark tid as aborted using
+// [VersionManager.abort] before releasing locks, passing the files of the
+// pages tid dirtied, including those evicted before the abort.
+//
+// Release locks with [BufferPool.releaseLocks].
 func (bp *BufferPool) AbortTransaction(tid TransactionID) {
 	// TODO: some code goes here
 }
@@ -45,29 +178,218 @@ func (bp *BufferPool) AbortTransaction(tid TransactionID) {
 // should iterate through pages and write them to disk.  In GoDB lab3 we assume
 // that the system will not crash while doing this, allowing us to avoid using a
 // WAL. You do not need to implement this for lab 1.
-func (bp *BufferPool) CommitTransaction(tid TransactionID) {
+//
+// If the BufferPool has a log file, the commit instead follows the WAL
+// protocol and does not write any pages (NO FORCE):
+//
+//  1. for each cached page tid dirtied, log an update record with
+//     [LogFile.logUpdate], passing the page's before image (see [heapPage])
+//     and its current contents as the after image, stamp the returned LSN on
+//     the page and make its current contents its new before image. The page
+//     stays dirty, but no longer belongs to tid;
+//  2. log the commit with [LogFile.logCommit], which forces the log.
+//
+// Pages tid dirtied that were evicted before the commit were logged when they
+// were evicted.
+//
+// If the BufferPool uses row locking, the heap pages tid dirtied may also hold
+// changes of other running transactions, so write or log the page
+// [BufferPool.commitPage] returns for each of them instead of its current
+// contents, and make that page's contents its new before image; a page stays
+// dirty while it holds changes of other transactions. Hold bp.commitMu from
+// the first page until the commit is durable, so that commits of transactions
+// changing the same page do not interleave.
+//
+// Nodes of B+ trees are not logged (see [BTreeFile]): write the nodes tid
+// dirtied with [BTreeFile.flushNode] before logging the commit, even if other
+// running transactions have changes on them.
+//
+// If the BufferPool uses MVCC, mark tid as committed using
+// [VersionManager.commit] once the commit is durable and before releasing
+// locks.
+//
+// Release locks with [BufferPool.releaseLocks].
+//
+// Returns an error if the commit could not be made durable, e.g. because the
+// log could not be forced, in which case tid has not committed and should be
+// aborted.
+func (bp *BufferPool) CommitTransaction(tid TransactionID) error {
 	// TODO: some code goes here
+	return nil //replace me
 }
 
 // Begin a new transaction. You do not need to implement this for lab 1.
 //
+// If the BufferPool has a log file, log the start of the transaction using
+// [LogFile.logBegin]. If the BufferPool uses MVCC, take the transaction's
+// snapshot using [VersionManager.begin].
+//
 // Returns an error if the transaction is already running.
 func (bp *BufferPool) BeginTransaction(tid TransactionID) error {
 	// TODO: some code goes here
 	return nil
 }
 
+// Set the write-ahead log used by this BufferPool, closing the log previously
+// in use, if any. Called by [NewCatalogFromFile] once recovery has completed.
+func (bp *BufferPool) setLogFile(lf *LogFile) {
+	if bp.logFile != nil && bp.logFile != lf {
+		bp.logFile.Close()
+	}
+	bp.logFile = lf
+}
+
+// Return the dirty page table used by [BufferPool.Checkpoint]: for every dirty
+// page whose contents have been logged (i.e., whose LSN is not InvalidLSN, see
+// [heapPage]), map the page's heapHash to its LSN. Pages that are dirty but
+// whose contents have not been logged do not need to be included, as their
+// contents will be logged before they are written. Must be safe to call
+// concurrently with transactions.
+func (bp *BufferPool) dirtyPageTable() map[heapHash]LSN {
+	// TODO: some code goes here
+	return nil
+}
+
+// Take a fuzzy checkpoint of the log (see [LogFile.Checkpoint]) and then
+// truncate the part of the log that recovery no longer needs. Returns an error
+// if the BufferPool has no log file.
+func (bp *BufferPool) Checkpoint() error {
+	if bp.logFile == nil {
+		return GoDBError{IllegalOperationError, "cannot checkpoint a database without a log"}
+	}
+	if _, err := bp.logFile.Checkpoint(bp.dirtyPageTable); err != nil {
+		return err
+	}
+	return bp.logFile.Truncate()
+}
+
+// Start a background goroutine that calls [BufferPool.Checkpoint] every
+// interval, so that the time taken by recovery stays bounded as the log grows.
+// Checkpoints are skipped while the BufferPool has no log file. Returns a
+// function that stops the checkpointer.
+func (bp *BufferPool) StartCheckpointer(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if bp.logFile != nil {
+					bp.Checkpoint()
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
 // Retrieve the specified page from the specified DBFile (e.g., a HeapFile), on
 // behalf of the specified transaction. If a page is not cached in the buffer pool,
 // you can read it from disk uing [DBFile.readPage]. If the buffer pool is full (i.e.,
 // already stores numPages pages), a page should be evicted.  Should not evict
 // pages that are dirty, as this would violate NO STEAL. If the buffer pool is
-// full of dirty pages, you should return an error. Before returning the page,
-// attempt to lock it with the specified permission.  If the lock is
-// unavailable, should block until the lock is free. If a deadlock occurs, abort
-// one of the transactions in the deadlock. For lab 1, you do not need to
-// implement locking or deadlock detection. You will likely want to store a list
-// of pages in the BufferPool in a map keyed by the [DBFile.pageKey].
+// full of dirty pages, you should return an error.
+//
+// If the BufferPool has a log file, dirty pages may be evicted when there are
+// no clean pages to evict (STEAL). If the page was dirtied by a running
+// transaction, first log an update record for it on behalf of that
+// transaction, as [BufferPool.CommitTransaction] does, but without changing
+// its before image. Then force the log up to the page's LSN with
+// [LogFile.forceTo] and write the page using [DBFile.flushPage]. In this mode
+// an error is only returned if the log or the page cannot be written.
+//
+// Before returning the page, attempt to lock it with the specified permission
+// using [BufferPool.lockFilePage], which takes read locks as required by the
+// isolation level of tid (see [IsolationLevel]); at ReadCommitted, they are
+// held until the read of the caller ends (see [BufferPool.startRead]), so the
+// caller must copy what it reads out of the page before ending its read and
+// must not use the page afterwards. If the BufferPool uses row locking, this
+// only takes intention locks, and callers lock the tuples they read or write
+// with [BufferPool.lockTuple]; several transactions may then modify the same
+// cached page, so the page must track every transaction that dirtied it, and a
+// heap page holding changes of a running transaction (see [rowChangeTable])
+// is never evicted, even with a log file. If the BufferPool uses MVCC, ReadPerm
+// does not take a lock below Serializable: readers rely on their snapshots
+// instead (see [HeapFile.Iterator]), and only writers conflict.
+// If the lock is unavailable, should block until the lock is free, or until the
+// lock timeout of tid expires (see [LockWaitPolicy]), in which case the
+// LockNotAvailableError from the lock manager is returned. If a deadlock
+// occurs, abort one of the transactions in the deadlock: the lock manager
+// detects deadlocks and makes the lock request of the victim return a
+// DeadlockError, which GetPage should return so that the caller aborts the
+// victim. For lab 1, you do not need to implement locking or deadlock
+// detection. You will likely want to store a list of pages in the BufferPool in
+// a map keyed by the [DBFile.pageKey].
+//
+// The pages of indexes (see [BTreeFile] and [HashFile]) are btreePages and
+// hashPages rather than heapPages, and those of columnar tables (see
+// [ColumnarFile]) are columnarPages. They keep an LSN and a before image like
+// heap pages, and are serialized with [btreePage.toBuffer],
+// [hashPage.toBuffer] and [columnarPage.toBuffer] rather than
+// [heapPage.toBuffer], but are otherwise cached, locked, logged and evicted in
+// the same way, except for the nodes of B+ trees, which GetPage neither locks
+// nor logs: [BTreeFile] latches and locks them itself, and a dirty node is
+// evicted with [BTreeFile.tryFlushNode], which does not write it if it is in
+// use.
 func (bp *BufferPool) GetPage(file DBFile, pageNo int, tid TransactionID, perm RWPerm) (Page, error) {
 	return nil, fmt.Errorf("GetPage not implemented")
 }
+
+// Retrieve the specified page as [BufferPool.GetPage] does, using the
+// specified policy when the page is locked by another transaction (see
+// [BufferPool.lockFilePage]); GetPage behaves like this method with LockWait.
+// With LockNoWait and LockSkipLocked, a LockNotAvailableError is returned
+// rather than waiting for the lock.
+func (bp *BufferPool) getPageWait(file DBFile, pageNo int, tid TransactionID, perm RWPerm, wait LockWaitPolicy) (Page, error) {
+	// TODO: some code goes here
+	return nil, fmt.Errorf("getPageWait not implemented")
+}
+
+// Return what a savepoint of tid needs to roll back to the current contents of
+// the pages tid has dirtied (see [BufferPool.Savepoint]).
+//
+// If the BufferPool has a log file, log an update record for each cached page
+// tid has dirtied, as [BufferPool.CommitTransaction] does: pass the page's
+// before image and its current contents, stamp the returned LSN on the page
+// and make its current contents its new before image. The page stays dirty and
+// still belongs to tid; aborting tid rolls back the logged update. Return nil.
+//
+// Otherwise, return a copy of the current contents of each cached page tid has
+// dirtied (as written by [heapPage.toBuffer]), keyed by the page's heapHash.
+//
+// Skip the nodes of B+ trees, and, if the BufferPool uses row locking, the
+// pages of heap files, whose changes are undone one at a time (see
+// [BufferPool.undoChanges]).
+func (bp *BufferPool) savepointPages(tid TransactionID) (map[heapHash][]byte, error) {
+	// TODO: some code goes here
+	return nil, fmt.Errorf("savepointPages not implemented")
+}
+
+// Restore the cached pages tid has dirtied to the contents they had at a
+// savepoint: a page that has an image in pages is restored from it and stays
+// dirty, belonging to tid. Other pages are restored from their before image
+// (see [heapPage]) and no longer belong to tid; pages whose before image is
+// nil are dropped from the cache. If the BufferPool has a log file, the before
+// image may have been logged by a savepoint without being written, so the
+// restored pages stay dirty; otherwise they are clean. tid keeps its locks.
+// The room of the heap pages restored or dropped is marked as unknown with
+// [HeapFile.forgetFreeSpace]. The nodes of B+ trees, and the pages of heap
+// files if the BufferPool uses row locking, are left alone (see
+// [BufferPool.undoChanges]).
+func (bp *BufferPool) restoreDirtyPages(tid TransactionID, pages map[heapHash][]byte) {
+	// TODO: some code goes here
+}
+
+// Drop the specified pages from the cache, so they are read again from disk,
+// e.g. after they have been restored by [LogFile.rollback]. The room of the
+// heap pages among them is marked as unknown with [HeapFile.forgetFreeSpace],
+// whether or not they were cached.
+func (bp *BufferPool) discardPages(pages []heapHash) {
+	// TODO: some code goes here
+}
@@ -0,0 +1,373 @@
+package godb
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+/*
+CAST(expr AS type) converts the value of expr to the type, as described by
+[castValue]; the type is written as in a CREATE TABLE statement (see
+[parseColumnType]), or as SIGNED, CHAR(n) or DATETIME, as in MySQL. CAST to
+DECIMAL(p, s) rounds to s digits after the decimal point, and CAST to CHAR(n)
+or VARCHAR(n) truncates strings to n bytes.
+
+Besides explicit casts, the planner converts the values of expressions of
+different types that are compared, joined, passed to a function or inserted
+into a column, following this coercion matrix (see [coerceExpr]):
+
+  - INT is widened to DECIMAL and FLOAT, and DECIMAL to FLOAT;
+  - DATE is widened to TIMESTAMP, as midnight of the date;
+  - a string literal is parsed as a value of the other type, so age = '5' is
+    age = 5, and d = '2006-01-02' compares dates.
+
+Any other combination of types, or a string literal that is not a valid value
+of the other type, is a TypeMismatchError when the query is planned, rather
+than a comparison that is never true.
+*/
+
+// The type an expression is cast to.
+type castTarget struct {
+	ftype  DBType
+	column columnDef // the precision of a DECIMAL, or the length of a VARCHAR
+}
+
+func (c castTarget) String() string {
+	return columnTypeName(c.ftype, c.column)
+}
+
+// CastExpr converts the value of expr to another type (see [castValue]).
+type CastExpr struct {
+	expr Expr
+	to   castTarget
+}
+
+func (c *CastExpr) GetExprType() FieldType {
+	ft := c.expr.GetExprType()
+	return FieldType{ft.Fname, ft.TableQualifier, c.to.ftype}
+}
+
+func (c *CastExpr) EvalExpr(t *Tuple) (DBValue, error) {
+	v, err := c.expr.EvalExpr(t)
+	if err != nil {
+		return nil, err
+	}
+	v, err = castValue(v, c.to.ftype)
+	if err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case DecimalField:
+		return c.to.column.decimal.fit(v)
+	case StringField:
+		if l := c.to.column.limit; l > 0 && len(v.Value) > int(l) {
+			return StringField{v.Value[:l]}, nil
+		}
+	}
+	return v, nil
+}
+
+// Convert v to a value of type t:
+//
+//   - any value can be converted to a string, formatted as in query results
+//     (see [formatValue]), and a string can be converted to any type if it is
+//     a valid value of the type (see [parseFieldValue]), or to a date if it is
+//     a timestamp;
+//   - numbers can be converted to other numeric types; conversions to INT
+//     and DECIMAL round half away from zero, and are a NumericOverflowError if
+//     the value does not fit;
+//   - booleans are 1 or 0 as numbers, and numbers are true if they are not 0;
+//   - dates are midnight as timestamps, and timestamps are the day they are in
+//     as dates.
+//
+// NULL converts to NULL. Any other conversion is a TypeMismatchError.
+func castValue(v DBValue, t DBType) (DBValue, error) {
+	from := typeOfValue(v)
+	if from == t || isNull(v) {
+		return v, nil
+	}
+	if t == StringType {
+		return StringField{formatValue(v)}, nil
+	}
+	switch v := v.(type) {
+	case StringField:
+		d, err := parseFieldValue(v.Value, t)
+		if err != nil && t == DateType {
+			// a timestamp is the date it is in
+			if ts, tsErr := parseTimestamp(v.Value); tsErr == nil {
+				return DateField{floorDiv(ts, microsPerDay)}, nil
+			}
+		}
+		return d, err
+	case IntField:
+		switch t {
+		case FloatType:
+			return FloatField{float64(v.Value)}, nil
+		case DecimalType:
+			return newDecimal(big.NewInt(v.Value), 0)
+		case BoolType:
+			return BoolField{v.Value != 0}, nil
+		}
+	case FloatField:
+		switch t {
+		case IntType:
+			r := math.Round(v.Value)
+			if math.IsNaN(r) || r < math.MinInt64 || r >= math.MaxInt64 {
+				return nil, GoDBError{NumericOverflowError, fmt.Sprintf("%s does not fit in an int", formatFloat(v.Value))}
+			}
+			return IntField{int64(r)}, nil
+		case DecimalType:
+			if math.IsNaN(v.Value) || math.IsInf(v.Value, 0) {
+				return nil, GoDBError{NumericOverflowError, fmt.Sprintf("%s does not fit in a decimal", formatFloat(v.Value))}
+			}
+			return parseDecimal(strconv.FormatFloat(v.Value, 'f', -1, 64))
+		case BoolType:
+			return BoolField{v.Value != 0}, nil
+		}
+	case DecimalField:
+		switch t {
+		case IntType:
+			d, err := v.rescale(0)
+			return IntField{d.Value}, err
+		case FloatType:
+			f, _ := strconv.ParseFloat(v.String(), 64)
+			return FloatField{f}, nil
+		case BoolType:
+			return BoolField{v.Value != 0}, nil
+		}
+	case BoolField:
+		var i int64
+		if v.Value {
+			i = 1
+		}
+		switch t {
+		case IntType:
+			return IntField{i}, nil
+		case FloatType:
+			return FloatField{float64(i)}, nil
+		case DecimalType:
+			return DecimalField{i, 0}, nil
+		}
+	case DateField:
+		if t == TimestampType {
+			return TimestampField{v.Value * microsPerDay}, nil
+		}
+	case TimestampField:
+		if t == DateType {
+			return DateField{floorDiv(v.Value, microsPerDay)}, nil
+		}
+	}
+	return nil, GoDBError{TypeMismatchError, fmt.Sprintf("cannot cast %v %s to %v", from, formatValue(v), t)}
+}
+
+// The rank of each numeric type; values are widened to the type of higher
+// rank.
+var numericRank = map[DBType]int{IntType: 1, DecimalType: 2, FloatType: 3}
+
+// Return the type values of types t1 and t2 are both implicitly converted to
+// when they are compared, or false if neither can be converted to the type of
+// the other.
+func commonType(t1 DBType, t2 DBType) (DBType, bool) {
+	switch {
+	case t1 == t2:
+		return t1, true
+	case numericRank[t1] > 0 && numericRank[t2] > 0:
+		if numericRank[t1] > numericRank[t2] {
+			return t1, true
+		}
+		return t2, true
+	case (t1 == DateType && t2 == TimestampType) || (t1 == TimestampType && t2 == DateType):
+		return TimestampType, true
+	}
+	return UnknownType, false
+}
+
+// Return true if values of type from are implicitly converted to type to.
+func canCoerce(from DBType, to DBType) bool {
+	t, ok := commonType(from, to)
+	return ok && t == to
+}
+
+// Return true if e is a string literal, which is implicitly converted to any
+// type by parsing it.
+func isStringLiteral(e Expr) bool {
+	c, ok := e.(*ConstExpr)
+	return ok && c.constType == StringType
+}
+
+// Return e implicitly converted to type t (see [canCoerce] and
+// [isStringLiteral]): e itself if it already has type t, a constant if e is a
+// constant, and otherwise a [CastExpr]. Expressions of UnknownType, such as
+// NULL, are not converted. Returns a TypeMismatchError if e cannot be
+// converted to t.
+func coerceExpr(e Expr, t DBType) (Expr, error) {
+	from := e.GetExprType().Ftype
+	if from == t || from == UnknownType || t == UnknownType {
+		return e, nil
+	}
+	if !canCoerce(from, t) && !isStringLiteral(e) {
+		return nil, GoDBError{TypeMismatchError, fmt.Sprintf("%s of type %v cannot be converted to %v", exprToStr(e), from, t)}
+	}
+	if c, ok := e.(*ConstExpr); ok {
+		v, err := castValue(c.val, t)
+		if err != nil {
+			return nil, err
+		}
+		return &ConstExpr{v, t}, nil
+	}
+	return &CastExpr{e, castTarget{ftype: t}}, nil
+}
+
+// Return left and right implicitly converted to a common type so that they
+// can be compared: a string literal is converted to the type of the other
+// expression, and otherwise both are converted to their [commonType]. Returns
+// a TypeMismatchError if they have no common type.
+func coerceExprs(left Expr, right Expr) (Expr, Expr, error) {
+	lt, rt := left.GetExprType().Ftype, right.GetExprType().Ftype
+	if lt == rt || lt == UnknownType || rt == UnknownType {
+		return left, right, nil
+	}
+	mismatch := func(err error) error {
+		msg := fmt.Sprintf("cannot compare %s of type %v with %s of type %v", exprToStr(left), lt, exprToStr(right), rt)
+		if err != nil {
+			msg += ": " + err.(GoDBError).errString
+		}
+		return GoDBError{TypeMismatchError, msg}
+	}
+	t, ok := commonType(lt, rt)
+	switch {
+	case isStringLiteral(right):
+		t = lt
+	case isStringLiteral(left):
+		t = rt
+	case !ok:
+		return nil, nil, mismatch(nil)
+	}
+	newLeft, err := coerceExpr(left, t)
+	if err != nil {
+		return nil, nil, mismatch(err)
+	}
+	newRight, err := coerceExpr(right, t)
+	if err != nil {
+		return nil, nil, mismatch(err)
+	}
+	return newLeft, newRight, nil
+}
+
+// Return the values of a row of an INSERT statement implicitly converted to
+// the types of the columns of desc (see [coerceExpr]), or a TypeMismatchError
+// naming the column if a value cannot be converted.
+func coerceRow(row []Expr, desc *TupleDesc) ([]Expr, error) {
+	if len(row) != len(desc.Fields) {
+		return nil, GoDBError{TypeMismatchError, fmt.Sprintf("expected %d values, got %d", len(desc.Fields), len(row))}
+	}
+	coerced := make([]Expr, len(row))
+	for i, e := range row {
+		field := desc.Fields[i]
+		var err error
+		coerced[i], err = coerceExpr(e, field.Ftype)
+		if err != nil {
+			return nil, GoDBError{TypeMismatchError, fmt.Sprintf("cannot insert %s into column %s of type %v: %s", exprToStr(e), field.Fname, field.Ftype, err.(GoDBError).errString)}
+		}
+	}
+	return coerced, nil
+}
+
+// Parse the type of a CAST expression, as parsed by sqlparser (see
+// [rewriteCastTypes]).
+func parseCastType(ct *sqlparser.ConvertType) (castTarget, error) {
+	name := strings.ToLower(ct.Type)
+	length := ""
+	if ct.Length != nil {
+		length = string(ct.Length.Val)
+	}
+	if ct.Scale != nil {
+		length += "," + string(ct.Scale.Val)
+	}
+	switch name {
+	case "signed", "unsigned":
+		name = "int"
+	case "char", "nchar", "binary":
+		if t, ok := strings.CutPrefix(ct.Charset, castTypePrefix); ok {
+			name = t
+		} else {
+			name = "varchar"
+		}
+	case "datetime":
+		name, length = "timestamp", ""
+	}
+	t, column, err := parseColumnType(name, length)
+	if err != nil {
+		return castTarget{}, GoDBError{ParseError, fmt.Sprintf("unsupported cast to %s", sqlparser.String(ct))}
+	}
+	return castTarget{t, column}, nil
+}
+
+// The charset names that mark the types rewritten by [rewriteCastTypes].
+const castTypePrefix = "godb_"
+
+// The sqlparser grammar only supports the MySQL types of CAST (SIGNED, CHAR,
+// DATE, DATETIME, DECIMAL, ...), so rewrite the types it does not support in
+// the CAST expressions of query: INT and the other integer types to SIGNED,
+// TEXT and VARCHAR to CHAR, TIMESTAMP to DATETIME, and the other types to CHAR
+// with a charset that names the type, e.g. CHAR godb_float, which
+// [parseCastType] parses back. Quoted text is left alone (see
+// [tokenizeQuery]).
+func rewriteCastTypes(query string) string {
+	tokens := tokenizeQuery(query)
+	var edits []queryEdit
+	for i := 0; i+1 < len(tokens); i++ {
+		if !tokens[i].is("cast") || !tokens[i+1].is("(") {
+			continue
+		}
+		// find the AS that ends the expression being cast
+		depth := 0
+		for j := i + 2; j+1 < len(tokens) && depth >= 0; j++ {
+			switch tok := tokens[j]; {
+			case tok.is("("):
+				depth++
+			case tok.is(")"):
+				depth--
+			case depth == 0 && tok.is("as") && tokens[j+1].isWord():
+				if e, ok := castTypeEdit(query, tokens, j+1); ok {
+					edits = append(edits, e)
+				}
+				depth = -1
+			}
+		}
+	}
+	return applyQueryEdits(query, edits)
+}
+
+// Return the edit rewriting the type of a CAST expression that starts at
+// tokens[i], with its parameters, if sqlparser does not support it (see
+// [rewriteCastTypes]).
+func castTypeEdit(query string, tokens []queryToken, i int) (queryEdit, bool) {
+	end, params := tokens[i].end, ""
+	if i+1 < len(tokens) && tokens[i+1].is("(") {
+		if close := closingParen(tokens, i+1); close >= 0 {
+			end, params = tokens[close].end, query[tokens[i].end:tokens[close].end]
+		}
+	}
+	typeName := strings.ToLower(tokens[i].text)
+	switch typeName {
+	case "int", "integer", "bigint", "smallint":
+		typeName = "signed"
+	case "text", "string":
+		typeName = "char"
+	case "varchar":
+		typeName = "char" + params
+	case "timestamp":
+		typeName = "datetime"
+	case "float", "double", "real", "bool", "boolean":
+		typeName = "char " + castTypePrefix + typeName
+	default:
+		return queryEdit{}, false
+	}
+	return queryEdit{tokens[i].start, end, typeName}, true
+}
@@ -0,0 +1,186 @@
+package godb
+
+import (
+	"testing"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+func TestCastValues(t *testing.T) {
+	cases := []struct {
+		v        DBValue
+		to       DBType
+		expected DBValue
+	}{
+		{IntField{5}, FloatType, FloatField{5}},
+		{IntField{5}, DecimalType, DecimalField{5, 0}},
+		{IntField{0}, BoolType, BoolField{false}},
+		{IntField{-7}, StringType, StringField{"-7"}},
+		{FloatField{2.5}, IntType, IntField{3}},
+		{FloatField{-2.5}, IntType, IntField{-3}},
+		{FloatField{0.1}, DecimalType, DecimalField{1, 1}},
+		{DecimalField{-1250, 3}, IntType, IntField{-1}},
+		{DecimalField{1250, 3}, FloatType, FloatField{1.25}},
+		{DecimalField{1250, 3}, StringType, StringField{"1.250"}},
+		{BoolField{true}, IntType, IntField{1}},
+		{StringField{" 42 "}, IntType, IntField{42}},
+		{StringField{"1.5"}, DecimalType, DecimalField{15, 1}},
+		{StringField{"yes"}, BoolType, BoolField{true}},
+		{StringField{"1970-01-02"}, DateType, DateField{1}},
+		{DateField{1}, TimestampType, TimestampField{microsPerDay}},
+		{TimestampField{-1}, DateType, DateField{-1}},
+		{DateField{0}, StringType, StringField{"1970-01-01"}},
+		{NullField{}, IntType, NullField{}},
+	}
+	for _, c := range cases {
+		if v, err := castValue(c.v, c.to); err != nil || v != c.expected {
+			t.Errorf("cast %v to %v: expected %v, got %v (%v)", c.v, c.to, c.expected, v, err)
+		}
+	}
+
+	errors := []struct {
+		v    DBValue
+		to   DBType
+		code GoDBErrorCode
+	}{
+		{StringField{"abc"}, IntType, TypeMismatchError},
+		{StringField{"2006-02-30"}, DateType, TypeMismatchError},
+		{DateField{0}, IntType, TypeMismatchError},
+		{IntField{1}, DateType, TypeMismatchError},
+		{FloatField{1e300}, IntType, NumericOverflowError},
+		{FloatField{1e30}, DecimalType, NumericOverflowError},
+		{IntField{1 << 62}, DecimalType, NumericOverflowError},
+	}
+	for _, c := range errors {
+		if _, err := castValue(c.v, c.to); err == nil || err.(GoDBError).code != c.code {
+			t.Errorf("cast %v to %v: expected %v, got %v", c.v, c.to, c.code, err)
+		}
+	}
+}
+
+func TestCoercion(t *testing.T) {
+	age := &FieldExpr{FieldType{"age", "t", IntType}}
+	day := &FieldExpr{FieldType{"day", "t", DateType}}
+	name := &FieldExpr{FieldType{"name", "t", StringType}}
+
+	left, right, err := coerceExprs(age, &ConstExpr{StringField{"5"}, StringType})
+	if err != nil || left != Expr(age) || right.(*ConstExpr).val != (IntField{5}) {
+		t.Errorf("expected age = '5' to compare with the int 5, got %v %v (%v)", left, right, err)
+	}
+	_, right, err = coerceExprs(&ConstExpr{StringField{"2006-01-02"}, StringType}, day)
+	if err != nil || right != Expr(day) {
+		t.Errorf("expected a string literal to be converted to a date (%v)", err)
+	}
+	left, _, err = coerceExprs(age, &ConstExpr{DecimalField{55, 1}, DecimalType})
+	if cast, ok := left.(*CastExpr); err != nil || !ok || cast.GetExprType().Ftype != DecimalType || cast.GetExprType().Fname != "age" {
+		t.Errorf("expected age to be cast to decimal, got %v (%v)", left, err)
+	}
+	_, right, err = coerceExprs(&FieldExpr{FieldType{"ts", "t", TimestampType}}, day)
+	if _, ok := right.(*CastExpr); err != nil || !ok || right.GetExprType().Ftype != TimestampType {
+		t.Errorf("expected day to be cast to timestamp, got %v (%v)", right, err)
+	}
+	if _, right, err = coerceExprs(name, &ConstExpr{NullField{}, UnknownType}); err != nil || !isNull(right.(*ConstExpr).val) {
+		t.Errorf("expected NULL not to be converted (%v)", err)
+	}
+
+	mismatches := [][2]Expr{
+		{age, &ConstExpr{StringField{"abc"}, StringType}},
+		{age, day},
+		{name, &ConstExpr{IntField{5}, IntType}},
+	}
+	for _, m := range mismatches {
+		if _, _, err := coerceExprs(m[0], m[1]); err == nil || err.(GoDBError).code != TypeMismatchError {
+			t.Errorf("%s, %s: expected a TypeMismatchError, got %v", exprToStr(m[0]), exprToStr(m[1]), err)
+		}
+	}
+
+	desc := &TupleDesc{[]FieldType{{"age", "", IntType}, {"price", "", DecimalType}, {"name", "", StringType}}}
+	row, err := coerceRow([]Expr{&ConstExpr{StringField{"7"}, StringType}, &ConstExpr{IntField{3}, IntType}, &ConstExpr{StringField{"x"}, StringType}}, desc)
+	if err != nil || row[0].(*ConstExpr).val != (IntField{7}) || row[1].(*ConstExpr).val != (DecimalField{3, 0}) {
+		t.Errorf("unexpected row %v (%v)", row, err)
+	}
+	if _, err := coerceRow([]Expr{&ConstExpr{IntField{1}, IntType}, &ConstExpr{IntField{3}, IntType}, &ConstExpr{IntField{1}, IntType}}, desc); err == nil {
+		t.Errorf("expected an int not to be inserted into a string column")
+	}
+
+	var price, one Expr = &ConstExpr{DecimalField{150, 2}, DecimalType}, &ConstExpr{FloatField{0.5}, FloatType}
+	sum := FuncExpr{"+", []*Expr{&price, &one}}
+	if v, err := sum.EvalExpr(nil); err != nil || v != (FloatField{2}) {
+		t.Errorf("expected 1.50 + 0.5 to be the float 2, got %v (%v)", v, err)
+	}
+	var s Expr = &ConstExpr{StringField{"a"}, StringType}
+	bad := FuncExpr{"+", []*Expr{&s, &one}}
+	if _, err := bad.EvalExpr(nil); err == nil || err.(GoDBError).code != TypeMismatchError {
+		t.Errorf("expected a TypeMismatchError, got %v", err)
+	}
+}
+
+func TestCastParse(t *testing.T) {
+	rewrites := map[string]string{
+		"select cast(f(x, y) as float) as total from t":   "select cast(f(x, y) as char godb_float) as total from t",
+		"select CAST(a AS INT), cast(b as varchar(3))":    "select CAST(a AS signed), cast(b as char(3))",
+		"select cast('a as int' as text)":                 "select cast('a as int' as char)",
+		"select cast(x as decimal(5, 2)) as int_value":    "select cast(x as decimal(5, 2)) as int_value",
+		"select cast(cast(a as int) + 1 as float)":        "select cast(cast(a as signed) + 1 as char godb_float)",
+		"select 'cast(a as int)', cast(\"it's\" as bool)": "select 'cast(a as int)', cast(\"it's\" as char godb_bool)",
+		"select cast('a\\' as int' as text) from t":       "select cast('a\\' as int' as char) from t",
+	}
+	for query, expected := range rewrites {
+		if q := rewriteCastTypes(query); q != expected {
+			t.Errorf("%q: expected %q, got %q", query, expected, q)
+		}
+	}
+
+	casts := map[string]DBValue{
+		"cast('12.345' as decimal(5,2))":          DecimalField{1235, 2},
+		"cast(2.5 as int)":                        IntField{3},
+		"cast('2.5' as double)":                   FloatField{2.5},
+		"cast('yes' as boolean)":                  BoolField{true},
+		"cast('2006-01-02 15:04:05' as date)":     DateField{13150},
+		"cast('1970-01-01' as timestamp)":         TimestampField{0},
+		"cast('abcdef' as varchar(3))":            StringField{"abc"},
+		"cast(42 as char)":                        StringField{"42"},
+		"convert('7', signed)":                    IntField{7},
+		"cast(null as int)":                       NullField{},
+		"cast(cast('1.5' as decimal) * 2 as int)": IntField{3},
+	}
+	for cast, expected := range casts {
+		stmt, err := sqlparser.Parse(rewriteCastTypes("select " + cast + " from t"))
+		if err != nil {
+			t.Fatalf("%s: %v", cast, err)
+		}
+		node, err := parseSelect(nil, stmt.(*sqlparser.Select).SelectExprs[0])
+		if err != nil {
+			t.Fatalf("%s: %v", cast, err)
+		}
+		expr, _, err := node.generateExpr(nil, nil, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", cast, err)
+		}
+		if v, err := expr.EvalExpr(nil); err != nil || v != expected {
+			t.Errorf("%s: expected %v, got %v (%v)", cast, expected, v, err)
+		}
+		if ft := expr.GetExprType().Ftype; !isNull(expected) && ft != typeOfValue(expected) {
+			t.Errorf("%s: expected type %v, got %v", cast, typeOfValue(expected), ft)
+		}
+	}
+
+	stmt, err := sqlparser.Parse(rewriteCastTypes("select cast('abc' as int) from t"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	node, err := parseSelect(nil, stmt.(*sqlparser.Select).SelectExprs[0])
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expr, _, err := node.generateExpr(nil, nil, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := expr.EvalExpr(nil); err == nil || err.(GoDBError).code != TypeMismatchError {
+		t.Errorf("expected a TypeMismatchError, got %v", err)
+	}
+	if _, err := parseCastType(&sqlparser.ConvertType{Type: "time"}); err == nil {
+		t.Errorf("expected a cast to TIME to be rejected")
+	}
+}
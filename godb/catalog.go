@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"regexp"
 	"sort"
 	"strings"
 )
@@ -18,6 +19,21 @@ type Table struct {
 	stats *TableStats
 
 	file DBFile
+
+	// The definitions of the table's columns (see [columnDef]), one per
+	// field; nil if they all have the zero definition.
+	columns []columnDef
+
+	// The indexes of the table, in the order they were created; their files
+	// are kept up to date by file (see [HeapFile.addIndex]).
+	indexes []*tableIndex
+
+	// The cluster key of the table, if it is clustered (see
+	// [HeapFile.setCluster]); nil otherwise.
+	cluster *clusterKey
+
+	// The storage options the table was created with.
+	options tableOptions
 }
 
 type Catalog struct {
@@ -57,6 +73,247 @@ func (c *Catalog) dropTable(tableName string) error {
 	return nil
 }
 
+// The clause at the end of the line of a table in the catalog file that gives
+// its storage options if they do not all have their default value, as in
+// with (format = columnar, compression = flate).
+var catalogOptionsClause = regexp.MustCompile(`\)\s*with\s*\(([^()]*)\)\s*$`)
+
+// The storage options of a table, given by the WITH (option = value, ...)
+// clause of its CREATE TABLE statement: its format, heap or columnar (see
+// [ColumnarFile]), and the codec its pages are compressed with (see
+// [compressPage]).
+type tableOptions struct {
+	format      string
+	compression pageCompression
+}
+
+// Return the options listed in the WITH clause of a table, such as "format =
+// columnar, compression = flate"; options that are not listed have their
+// default value. Names and values are case insensitive. Returns a ParseError
+// if an option or its value is unknown.
+func parseTableOptions(list string) (tableOptions, error) {
+	opts := tableOptions{format: heapFormat}
+	for _, option := range strings.Split(list, ",") {
+		name, value, ok := strings.Cut(option, "=")
+		name, value = strings.ToLower(strings.TrimSpace(name)), strings.ToLower(strings.TrimSpace(value))
+		switch {
+		case !ok:
+			return opts, GoDBError{ParseError, fmt.Sprintf("malformed table option %s", strings.TrimSpace(option))}
+		case name == "format":
+			if value != heapFormat && value != columnarFormat {
+				return opts, GoDBError{ParseError, fmt.Sprintf("unknown table format %s", value)}
+			}
+			opts.format = value
+		case name == "compression":
+			c, err := parsePageCompression(value)
+			if err != nil {
+				return opts, err
+			}
+			opts.compression = c
+		default:
+			return opts, GoDBError{ParseError, fmt.Sprintf("unknown table option %s", name)}
+		}
+	}
+	return opts, nil
+}
+
+// Return the WITH clause of the options, as accepted by [parseTableOptions],
+// or "" if they all have their default value.
+func (o tableOptions) String() string {
+	var options []string
+	if o.format == columnarFormat {
+		options = append(options, "format = "+o.format)
+	}
+	if o.compression != noCompression {
+		options = append(options, "compression = "+o.compression.String())
+	}
+	if len(options) == 0 {
+		return ""
+	}
+	return "with (" + strings.Join(options, ", ") + ")"
+}
+
+// A line of the catalog file that makes a table clustered: cluster table by
+// (col, ...). It follows the line of its table.
+var catalogClusterEntry = regexp.MustCompile(`^\s*cluster\s+(\w+)\s+by\s*\(([^()]*)\)\s*$`)
+
+// Keep the tuples of the table sorted by key, a cluster key of its TupleDesc
+// (see [HeapFile.setCluster]). Must be called before any tuple is inserted
+// into the table.
+func (t *Table) setCluster(key *clusterKey) error {
+	hf, ok := t.file.(*HeapFile)
+	if !ok {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("table %s cannot be clustered", t.name)}
+	}
+	hf.setCluster(key)
+	t.cluster = key
+	return nil
+}
+
+// A line of the catalog file that defines an index: [unique] index name on
+// table(col, ...) [include (col, ...)] [using method]. Index lines follow the
+// line of their table.
+var catalogIndexEntry = regexp.MustCompile(`^\s*(unique\s+)?index\s+(\w+)\s+on\s+(\w+)\s*\(([^()]*)\)(?:\s*include\s*\(([^()]*)\))?(?:\s*using\s+(\w+))?\s*$`)
+
+// Return the table of the index with the specified name, or nil if there is
+// no such index.
+func (c *Catalog) findIndex(indexName string) (*Table, *tableIndex) {
+	for _, t := range c.tableMap {
+		for _, idx := range t.indexes {
+			if idx.name == indexName {
+				return t, idx
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Open the file of a new index of the table on the specified key columns,
+// which also stores the values of the include columns, without adding it to
+// the table. method is the access method of the index, btree (the default if
+// it is empty) or hash; hash indexes cannot have include columns.
+func (c *Catalog) newIndex(t *Table, indexName string, columns []string, include []string, unique bool, method string) (*tableIndex, error) {
+	if len(columns) == 0 {
+		return nil, GoDBError{ParseError, fmt.Sprintf("index %s has no columns", indexName)}
+	}
+	idx := &tableIndex{name: indexName, unique: unique}
+	keyDesc := &TupleDesc{}
+	addColumns := func(columns []string, fields *[]int) error {
+		for _, col := range columns {
+			col = strings.TrimSpace(col)
+			field, err := findFieldInTd(FieldType{col, "", UnknownType}, &t.desc)
+			if err != nil {
+				return err
+			}
+			*fields = append(*fields, field)
+			keyDesc.Fields = append(keyDesc.Fields, FieldType{col, "", t.desc.Fields[field].Ftype})
+		}
+		return nil
+	}
+	if err := addColumns(columns, &idx.fields); err != nil {
+		return nil, err
+	}
+	if err := addColumns(include, &idx.include); err != nil {
+		return nil, err
+	}
+	var err error
+	switch method {
+	case "", "btree":
+		idx.file, err = NewBTreeFile(c.indexNameToFile(indexName), keyDesc, c.bufferPool)
+	case "hash":
+		if len(include) > 0 {
+			return nil, GoDBError{ParseError, fmt.Sprintf("hash index %s cannot have INCLUDE columns", indexName)}
+		}
+		idx.file, err = NewHashFile(c.indexNameToFile(indexName), keyDesc, c.bufferPool)
+	default:
+		return nil, GoDBError{ParseError, fmt.Sprintf("unsupported index method %s", method)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// Add an index whose file holds the entries of the tuples of the table to the
+// table and to the indexes its file keeps up to date.
+func (t *Table) addIndex(idx *tableIndex) error {
+	hf, ok := t.file.(*HeapFile)
+	if !ok {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("table %s cannot be indexed", t.name)}
+	}
+	hf.addIndex(idx)
+	t.indexes = append(t.indexes, idx)
+	return nil
+}
+
+// Open an existing index, as defined by a line of the catalog file.
+func (c *Catalog) openIndex(indexName string, tableName string, columns []string, include []string, unique bool, method string) error {
+	t, err := c.GetTableInfo(tableName)
+	if err != nil {
+		return err
+	}
+	idx, err := c.newIndex(t, indexName, columns, include, unique, method)
+	if err != nil {
+		return err
+	}
+	return t.addIndex(idx)
+}
+
+// Create an index of the table on the specified key and include columns with
+// the specified access method (see [Catalog.newIndex]), holding the entries of
+// the tuples already in the table, and add it to the table. The index is built
+// in a transaction of its own (see [HeapFile.buildIndex]); if unique is set,
+// the index is not created if two live tuples of the table have the same key.
+//
+// Returns an error if an index with the same name already exists, or if the
+// table or one of the columns does not exist.
+func (c *Catalog) createIndex(indexName string, tableName string, columns []string, include []string, unique bool, method string) error {
+	if _, idx := c.findIndex(indexName); idx != nil {
+		return GoDBError{DuplicateTableError, fmt.Sprintf("an index named '%s' already exists", indexName)}
+	}
+	t, err := c.GetTableInfo(tableName)
+	if err != nil {
+		return err
+	}
+	hf, ok := t.file.(*HeapFile)
+	if !ok {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("table %s cannot be indexed", t.name)}
+	}
+	// the file of a dropped index may still exist
+	if err := os.Remove(c.indexNameToFile(indexName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	idx, err := c.newIndex(t, indexName, columns, include, unique, method)
+	if err != nil {
+		return err
+	}
+	tid := NewTID()
+	if err := c.bufferPool.BeginTransaction(tid); err != nil {
+		c.removeIndexFile(idx)
+		return err
+	}
+	if err := hf.buildIndex(idx, tid); err != nil {
+		c.bufferPool.AbortTransaction(tid)
+		c.removeIndexFile(idx)
+		return err
+	}
+	if err := c.bufferPool.CommitTransaction(tid); err != nil {
+		c.bufferPool.AbortTransaction(tid)
+		c.removeIndexFile(idx)
+		return err
+	}
+	return t.addIndex(idx)
+}
+
+// Drop the index with the specified name, which must be an index of tableName
+// unless tableName is empty, and remove its file.
+func (c *Catalog) dropIndex(indexName string, tableName string) error {
+	t, idx := c.findIndex(indexName)
+	if idx == nil || (tableName != "" && t.name != tableName) {
+		return GoDBError{NoSuchTableError, fmt.Sprintf("couldn't find index %s to drop", indexName)}
+	}
+	for i, other := range t.indexes {
+		if other == idx {
+			t.indexes = append(t.indexes[:i:i], t.indexes[i+1:]...)
+			break
+		}
+	}
+	if hf, ok := t.file.(*HeapFile); ok {
+		hf.dropIndex(indexName)
+	}
+	return c.removeIndexFile(idx)
+}
+
+// Drop the pages of the index from the BufferPool and remove its file.
+func (c *Catalog) removeIndexFile(idx *tableIndex) error {
+	pages := make([]heapHash, idx.file.NumPages())
+	for i := range pages {
+		pages[i] = idx.file.pageKey(i).(heapHash)
+	}
+	c.bufferPool.discardPages(pages)
+	return os.Remove(c.indexNameToFile(idx.name))
+}
+
 func ImportCatalogFromCSVs(
 	catalogFile string,
 	bp *BufferPool,
@@ -70,10 +327,26 @@ func ImportCatalogFromCSVs(
 	for _, t := range c.tableMap {
 		fileName := rootPath + "/" + t.name + "." + tableSuffix
 		log.Printf("Loading %s from %s...\n", t.name, fileName)
+		if cf, ok := t.file.(*ColumnarFile); ok {
+			f, err := os.Open(fileName)
+			if err != nil {
+				return err
+			}
+			if err := cf.LoadFromCSV(f, false, separator, true); err != nil {
+				return err
+			}
+			continue
+		}
 		hf, err := NewHeapFile(c.tableNameToFile(t.name), t.desc.copy(), c.bufferPool)
 		if err != nil {
 			return err
 		}
+		hf.setColumns(t.columns)
+		hf.setCluster(t.cluster)
+		hf.setCompression(t.options.compression)
+		for _, idx := range t.indexes {
+			hf.addIndex(idx)
+		}
 		f, err := os.Open(fileName)
 		if err != nil {
 			return err
@@ -96,15 +369,48 @@ func (c *Catalog) parseCatalogFile() error {
 	for scanner.Scan() {
 		// code to read each line
 		line := strings.ToLower(scanner.Text())
-		sep := strings.Split(line, "(")
-		if len(sep) != 2 {
-			return GoDBError{ParseError, fmt.Sprintf("expected one paren in catalog entry, got %d (%s)", len(sep), line)}
+		if m := catalogIndexEntry.FindStringSubmatch(line); m != nil {
+			var include []string
+			if m[5] != "" {
+				include = splitColumnList(m[5])
+			}
+			if err := c.openIndex(m[2], m[3], splitColumnList(m[4]), include, m[1] != "", m[6]); err != nil {
+				return err
+			}
+			continue
+		}
+		if m := catalogClusterEntry.FindStringSubmatch(line); m != nil {
+			t, err := c.GetTableInfo(m[1])
+			if err != nil {
+				return err
+			}
+			key, err := newClusterKey(&t.desc, splitColumnList(m[2]))
+			if err != nil {
+				return err
+			}
+			if err := t.setCluster(key); err != nil {
+				return err
+			}
+			continue
+		}
+		opts := tableOptions{format: heapFormat}
+		if m := catalogOptionsClause.FindStringSubmatchIndex(line); m != nil {
+			var err error
+			if opts, err = parseTableOptions(line[m[2]:m[3]]); err != nil {
+				return GoDBError{ParseError, fmt.Sprintf("%s (line %s)", err.(GoDBError).errString, line)}
+			}
+			line = line[:m[0]+1]
 		}
-		tableName := strings.TrimSpace(sep[0])
-		rest := strings.Trim(sep[1], "()")
-		fields := strings.Split(rest, ",")
+		open, close := strings.Index(line, "("), strings.LastIndex(line, ")")
+		if open < 0 || close < open {
+			return GoDBError{ParseError, fmt.Sprintf("expected parenthesized fields in catalog entry (%s)", line)}
+		}
+		tableName := strings.TrimSpace(line[:open])
+		rest := line[open+1 : close]
+		fields := splitColumnList(rest)
 
 		var fieldArray []FieldType
+		var columns []columnDef
 		for _, f := range fields {
 			f := strings.TrimSpace(f)
 			nameType := strings.Split(f, " ")
@@ -113,25 +419,24 @@ func (c *Catalog) parseCatalogFile() error {
 			}
 
 			name := nameType[0]
-			fieldType := FieldType{name, "", IntType}
-			switch nameType[1] {
-			case "int":
-				fallthrough
-			case "integer":
-				fieldType.Ftype = IntType
-			case "string":
-				fallthrough
-			case "varchar":
-				fallthrough
-			case "text":
-				fieldType.Ftype = StringType
+			ftype, column, err := parseColumnType(nameType[1], "")
+			if err != nil {
+				return GoDBError{ParseError, fmt.Sprintf("%s (line %s)", err.(GoDBError).errString, line)}
+			}
+			var notNull bool
+			switch constraint := strings.Join(nameType[2:], " "); constraint {
+			case "", "null":
+			case "not null":
+				notNull = true
 			default:
-				return GoDBError{ParseError, fmt.Sprintf("unknown type %s (line %s)", nameType[1], line)}
+				return GoDBError{ParseError, fmt.Sprintf("unknown column constraint %s (line %s)", constraint, line)}
 			}
-			fieldArray = append(fieldArray, fieldType)
+			column.nullable = !notNull
+			fieldArray = append(fieldArray, FieldType{name, "", ftype})
+			columns = append(columns, column)
 		}
 
-		_, err := c.addTable(tableName, TupleDesc{fieldArray})
+		_, err := c.addTableWithOptions(tableName, TupleDesc{fieldArray}, columns, opts)
 		if err != nil {
 			return err
 		}
@@ -143,29 +448,127 @@ func NewCatalog(catalogFile string, bp *BufferPool, rootPath string) *Catalog {
 	return &Catalog{make(map[string]*Table), make(map[string][]*Table), bp, rootPath, catalogFile}
 }
 
+// Open the database described by catalogFile in rootPath. The write-ahead log
+// of the database (see [LogFileName]) is recovered before any of its tables are
+// opened, and is then used by bp to log updates. If recovery found
+// transactions that had not ended, the B+ tree indexes of the tables are
+// rebuilt (see [Catalog.rebuildBTreeIndexes]).
 func NewCatalogFromFile(catalogFile string, bp *BufferPool, rootPath string) (*Catalog, error) {
+	lf, err := NewLogFile(rootPath + "/" + LogFileName)
+	if err != nil {
+		return nil, err
+	}
+	if err := lf.Recover(); err != nil {
+		lf.Close()
+		return nil, err
+	}
+	bp.setLogFile(lf)
+
 	c := NewCatalog(catalogFile, bp, rootPath)
 	if err := c.parseCatalogFile(); err != nil {
 		return nil, err
 	}
+	if lf.unfinished {
+		if err := c.rebuildBTreeIndexes(); err != nil {
+			return nil, err
+		}
+	}
 	return c, nil
 }
 
+// Rebuild the B+ tree indexes of the tables from their heap files, each in a
+// transaction of its own. The nodes of B+ trees are not logged, so after a
+// crash they may hold entries of transactions that did not commit, or miss
+// entries of transactions that committed while the nodes were dirtied by
+// transactions that did not (see [BTreeFile]).
+func (c *Catalog) rebuildBTreeIndexes() error {
+	for _, t := range c.tableMap {
+		hf, ok := t.file.(*HeapFile)
+		if !ok {
+			continue
+		}
+		for _, idx := range t.indexes {
+			old, ok := idx.file.(*BTreeFile)
+			if !ok {
+				continue
+			}
+			hf.dropIndex(idx.name)
+			if err := c.removeIndexFile(idx); err != nil {
+				return err
+			}
+			file, err := NewBTreeFile(c.indexNameToFile(idx.name), old.keyDesc, c.bufferPool)
+			if err != nil {
+				return err
+			}
+			idx.file = file
+			tid := NewTID()
+			if err := c.bufferPool.BeginTransaction(tid); err != nil {
+				return err
+			}
+			if err := hf.buildIndex(idx, tid); err != nil {
+				c.bufferPool.AbortTransaction(tid)
+				return err
+			}
+			if err := c.bufferPool.CommitTransaction(tid); err != nil {
+				c.bufferPool.AbortTransaction(tid)
+				return err
+			}
+			hf.addIndex(idx)
+		}
+	}
+	return nil
+}
+
 // Add a new table to the catalog.
 //
 // Returns an error if the table already exists.
 func (c *Catalog) addTable(named string, desc TupleDesc) (DBFile, error) {
+	return c.addTableWithColumns(named, desc, nil)
+}
+
+// Add a new table to the catalog whose columns have the specified definitions,
+// one per field of desc (see [columnDef]).
+//
+// Returns an error if the table already exists.
+func (c *Catalog) addTableWithColumns(named string, desc TupleDesc, columns []columnDef) (DBFile, error) {
+	return c.addTableWithOptions(named, desc, columns, tableOptions{format: heapFormat})
+}
+
+// Add a new table to the catalog whose columns have the specified definitions,
+// stored with the specified options: in a [HeapFile] if its format is heap,
+// or in a [ColumnarFile] if it is columnar, whose pages are compressed with
+// the codec of the options.
+//
+// Returns an error if the table already exists or the format is unknown.
+func (c *Catalog) addTableWithOptions(named string, desc TupleDesc, columns []columnDef, opts tableOptions) (DBFile, error) {
 	f, err := c.GetTable(named)
 	if err == nil {
 		return f, GoDBError{DuplicateTableError, fmt.Sprintf("a table named '%s' already exists", named)}
 	}
 
-	hf, err := NewHeapFile(c.tableNameToFile(named), &desc, c.bufferPool)
-	if err != nil {
-		return nil, err
+	var file DBFile
+	switch opts.format {
+	case heapFormat:
+		heap, err := NewHeapFile(c.tableNameToFile(named), &desc, c.bufferPool)
+		if err != nil {
+			return nil, err
+		}
+		heap.setColumns(columns)
+		heap.setCompression(opts.compression)
+		file = heap
+	case columnarFormat:
+		cf, err := NewColumnarFile(c.tableNameToFile(named), &desc, c.bufferPool)
+		if err != nil {
+			return nil, err
+		}
+		cf.setColumns(columns)
+		cf.setCompression(opts.compression)
+		file = cf
+	default:
+		return nil, GoDBError{ParseError, fmt.Sprintf("unknown table format %s", opts.format)}
 	}
 
-	t := &Table{len(c.tableMap), named, desc, nil, hf}
+	t := &Table{len(c.tableMap), named, desc, nil, file, columns, nil, nil, opts}
 	c.tableMap[named] = t
 	for _, f := range desc.Fields {
 		mapList := c.columnMap[f.Fname]
@@ -175,7 +578,7 @@ func (c *Catalog) addTable(named string, desc TupleDesc) (DBFile, error) {
 		c.columnMap[f.Fname] = append(mapList, t)
 	}
 
-	return hf, nil
+	return file, nil
 }
 
 func (c *Catalog) ComputeTableStats() error {
@@ -187,6 +590,10 @@ func (c *Catalog) tableNameToFile(tableName string) string {
 	return c.rootPath + "/" + tableName + ".dat"
 }
 
+func (c *Catalog) indexNameToFile(indexName string) string {
+	return c.rootPath + "/" + indexName + ".idx"
+}
+
 func (c *Catalog) GetTableInfo(named string) (*Table, error) {
 	t, ok := c.tableMap[named]
 	if !ok {
@@ -250,9 +657,62 @@ func (t *Table) String() string {
 		}
 		buf.WriteString(f.Fname)
 		buf.WriteByte(' ')
-		buf.WriteString(f.Ftype.String())
+		var column columnDef
+		if i < len(t.columns) {
+			column = t.columns[i]
+		}
+		buf.WriteString(columnTypeName(f.Ftype, column))
+		if !column.nullable {
+			buf.WriteString(" not null")
+		}
+	}
+	buf.WriteByte(')')
+	if options := t.options.String(); options != "" {
+		buf.WriteByte(' ')
+		buf.WriteString(options)
+	}
+	buf.WriteByte('\n')
+	if t.cluster != nil {
+		buf.WriteString("cluster ")
+		buf.WriteString(t.name)
+		buf.WriteString(" by (")
+		for i, field := range t.cluster.fields {
+			if i != 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(t.desc.Fields[field].Fname)
+		}
+		buf.WriteString(")\n")
+	}
+	for _, idx := range t.indexes {
+		if idx.unique {
+			buf.WriteString("unique ")
+		}
+		buf.WriteString("index ")
+		buf.WriteString(idx.name)
+		buf.WriteString(" on ")
+		buf.WriteString(t.name)
+		writeColumns := func(fields []int) {
+			buf.WriteByte('(')
+			for i, field := range fields {
+				if i != 0 {
+					buf.WriteString(", ")
+				}
+				buf.WriteString(t.desc.Fields[field].Fname)
+			}
+			buf.WriteByte(')')
+		}
+		writeColumns(idx.fields)
+		if len(idx.include) > 0 {
+			buf.WriteString(" include ")
+			writeColumns(idx.include)
+		}
+		if method := idx.method(); method != "btree" {
+			buf.WriteString(" using ")
+			buf.WriteString(method)
+		}
+		buf.WriteByte('\n')
 	}
-	buf.WriteString(")\n")
 	return buf.String()
 }
 
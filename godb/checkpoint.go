@@ -0,0 +1,204 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+/*
+Checkpoints bound the amount of log that recovery has to read, and the amount
+of log that has to be kept.
+
+GoDB takes fuzzy checkpoints: transactions keep running, and pages keep being
+read and written, while a checkpoint is taken. A checkpoint consists of a
+BeginCheckpointRecord followed (possibly after records of other transactions)
+by an EndCheckpointRecord holding
+
+  - the active transaction table: the first and last LSN of every transaction
+    that had not ended when the end record was written, and
+  - the dirty page table: for every page in the BufferPool whose logged
+    contents may not have reached the disk, the LSN of the update record that
+    logged them (its recLSN), and
+  - the next transaction id, so that ids are not reused after a restart even
+    if the records of earlier transactions have been truncated.
+
+Once the end record is durable, the LSN of the begin record is saved in a
+master record, a small file stored next to the log that is replaced
+atomically. Recovery reads the master record and starts its analysis pass at
+the last checkpoint rather than at the start of the log (see
+[LogFile.Recover]).
+
+No record before the smallest of the begin record's LSN, the recLSNs in the
+dirty page table and the first LSNs in the active transaction table is needed
+by recovery, so [LogFile.Truncate] can discard everything before it.
+*/
+
+// An entry of the active transaction table of a checkpoint.
+type checkpointTxn struct {
+	firstLSN LSN
+	lastLSN  LSN
+}
+
+const logMasterMagic uint64 = 0x474f4442434b5031 // "GODBCKP1"
+
+// Return the path of the master record of the log.
+func (lf *LogFile) masterPath() string {
+	return lf.path + ".master"
+}
+
+// Read the master record, if there is one, into lf.checkpointLSN and
+// lf.truncateLSN.
+func (lf *LogFile) readMasterRecord() error {
+	data, err := os.ReadFile(lf.masterPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var master struct {
+		Magic         uint64
+		CheckpointLSN int64
+		TruncateLSN   int64
+	}
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &master); err != nil || master.Magic != logMasterMagic {
+		return GoDBError{MalformedDataError, fmt.Sprintf("%s is not a GoDB log master record", lf.masterPath())}
+	}
+	if LSN(master.CheckpointLSN) < lf.startLSN || LSN(master.CheckpointLSN) >= lf.nextLSN {
+		// the log was replaced after the checkpoint was taken
+		return nil
+	}
+	lf.checkpointLSN = LSN(master.CheckpointLSN)
+	lf.truncateLSN = LSN(master.TruncateLSN)
+	return nil
+}
+
+// Atomically replace the master record.
+func (lf *LogFile) writeMasterRecord(checkpointLSN LSN, truncateLSN LSN) error {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, logMasterMagic)
+	binary.Write(&b, binary.LittleEndian, int64(checkpointLSN))
+	binary.Write(&b, binary.LittleEndian, int64(truncateLSN))
+	return replaceFile(lf.masterPath(), func(f *os.File) error {
+		_, err := f.Write(b.Bytes())
+		return err
+	})
+}
+
+// Atomically replace the file at path with a file whose contents are written
+// by write, by writing a temporary file and renaming it.
+func replaceFile(path string, write func(f *os.File) error) error {
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Take a fuzzy checkpoint, returning the LSN of its begin record.
+// dirtyPages is called after the begin record has been appended, and must
+// return the dirty page table of the BufferPool (see
+// [BufferPool.dirtyPageTable]). The checkpoint is durable when Checkpoint
+// returns without error.
+func (lf *LogFile) Checkpoint(dirtyPages func() map[heapHash]LSN) (LSN, error) {
+	begin, err := lf.append(&LogRecord{recType: BeginCheckpointRecord})
+	if err != nil {
+		return InvalidLSN, err
+	}
+	dpt := dirtyPages()
+	if dpt == nil {
+		dpt = make(map[heapHash]LSN)
+	}
+
+	lf.mu.Lock()
+	active := make(map[TransactionID]checkpointTxn)
+	truncate := begin
+	for tid, last := range lf.lastLSN {
+		first := lf.firstLSN[tid]
+		active[tid] = checkpointTxn{first, last}
+		if first < truncate {
+			truncate = first
+		}
+	}
+	for _, recLSN := range dpt {
+		if recLSN != InvalidLSN && recLSN < truncate {
+			truncate = recLSN
+		}
+	}
+	_, err = lf.appendLocked(&LogRecord{recType: EndCheckpointRecord, checkpointLSN: begin, nextTID: peekNextTID(),
+		activeTxns: active, dirtyPages: dpt})
+	if err == nil {
+		err = lf.forceLocked()
+	}
+	lf.mu.Unlock()
+	if err != nil {
+		return InvalidLSN, err
+	}
+
+	if err := lf.writeMasterRecord(begin, truncate); err != nil {
+		return InvalidLSN, err
+	}
+	lf.mu.Lock()
+	lf.checkpointLSN = begin
+	lf.truncateLSN = truncate
+	lf.mu.Unlock()
+	return begin, nil
+}
+
+// Return the LSN of the begin record of the last checkpoint, or InvalidLSN if
+// no checkpoint has been taken.
+func (lf *LogFile) lastCheckpoint() LSN {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	return lf.checkpointLSN
+}
+
+// Discard the records before the last checkpoint that recovery no longer
+// needs. LSNs are not changed by truncation. Does nothing if no checkpoint has
+// been taken.
+func (lf *LogFile) Truncate() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	target := lf.truncateLSN
+	if target == InvalidLSN || target <= lf.startLSN {
+		return nil
+	}
+	if err := lf.forceLocked(); err != nil {
+		return err
+	}
+	from := lf.fileOffset(target)
+	to := lf.fileOffset(lf.flushedLSN)
+	err := replaceFile(lf.path, func(f *os.File) error {
+		if _, err := f.Write(encodeLogHeader(target)); err != nil {
+			return err
+		}
+		_, err := io.Copy(f, io.NewSectionReader(lf.file, from, to-from))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(lf.path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	lf.file.Close()
+	lf.file = f
+	lf.startLSN = target
+	return nil
+}
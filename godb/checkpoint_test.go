@@ -0,0 +1,163 @@
+package godb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+// Take a checkpoint as the BufferPool does, using the harness's cache as the
+// dirty page table, and truncate the log.
+func (h *crashHarness) checkpoint() {
+	if !h.step() {
+		return
+	}
+	_, err := h.lf.Checkpoint(func() map[heapHash]LSN {
+		dpt := make(map[heapHash]LSN)
+		for pageNo, p := range h.cache {
+			if p.dirty && p.lsn != InvalidLSN {
+				dpt[heapHash{FileName: h.dataFile, PageNo: pageNo}] = p.lsn
+			}
+		}
+		return dpt
+	})
+	h.check(err)
+	h.check(h.lf.Truncate())
+}
+
+// The recovery workload, with checkpoints taken while transactions are running
+// and pages are dirty.
+func runCheckpointWorkload(h *crashHarness) {
+	t1, t2, t3, t4 := NewTID(), NewTID(), NewTID(), NewTID()
+	h.begin(t1)
+	h.update(t1, 0, 1)
+	h.update(t1, 1, 1)
+	h.commit(t1)
+
+	h.begin(t2)
+	h.update(t2, 2, 2)
+	h.evict(2)
+	h.begin(t3)
+	h.update(t3, 3, 3)
+	h.checkpoint() // t2 and t3 are active, pages 0 and 1 are dirty
+	h.update(t2, 1, 2)
+	h.commit(t2)
+	h.evict(0)
+	h.checkpoint() // t3 is active, page 1 is dirty
+
+	h.begin(t4)
+	h.update(t4, 4, 4)
+	h.evict(4)
+	h.evict(3)
+	h.abort(t3)
+	h.checkpoint() // t4 is active and has stolen a page, page 1 is dirty
+	h.update(t4, 0, 4)
+	h.evict(0)
+}
+
+func TestCheckpointCrashes(t *testing.T) {
+	h := newCrashHarness(t, true, -1, 3)
+	runCheckpointWorkload(h)
+	totalSteps := h.steps
+	h.recoverAndVerify("no crash")
+
+	for crashAfter := 0; crashAfter <= totalSteps; crashAfter++ {
+		h := newCrashHarness(t, true, crashAfter, 3)
+		runCheckpointWorkload(h)
+		h.recoverAndVerify(fmt.Sprintf("crash after %d steps", crashAfter))
+	}
+}
+
+// Recovery should start analysis at the last checkpoint, and records that are
+// no longer needed should be truncated away.
+func TestCheckpointTruncation(t *testing.T) {
+	h := newCrashHarness(t, true, -1, 2)
+	for i := 0; i < 20; i++ {
+		tid := NewTID()
+		h.begin(tid)
+		h.update(tid, i%2, byte(i))
+		h.commit(tid)
+		h.evict(i % 2)
+	}
+	info, err := os.Stat(h.logPath)
+	h.check(err)
+	sizeBefore := info.Size()
+
+	h.checkpoint()
+	info, err = os.Stat(h.logPath)
+	h.check(err)
+	if info.Size() >= sizeBefore/10 {
+		t.Errorf("expected the log to be truncated after a checkpoint, size went from %d to %d", sizeBefore, info.Size())
+	}
+
+	// a transaction that is running at the time of the checkpoint keeps its
+	// records from being truncated
+	tid := NewTID()
+	h.begin(tid)
+	h.update(tid, 0, 50)
+	h.evict(0)
+	h.checkpoint()
+	rec, err := h.lf.readRecord(h.lf.lastLSNOf(tid))
+	h.check(err)
+	if rec.recType != UpdateRecord {
+		t.Errorf("expected the last record of the running transaction to be its update, got %v", rec)
+	}
+	h.recoverAndVerify("after truncation")
+
+	lf, err := NewLogFile(h.logPath)
+	h.check(err)
+	defer lf.Close()
+	if lf.lastCheckpoint() == InvalidLSN {
+		t.Fatalf("expected the checkpoint to be found in the master record")
+	}
+	recs := readLogRecords(t, lf)
+	if recs[0].lsn > lf.lastCheckpoint() || recs[0].tid != tid {
+		t.Errorf("expected the log to start with the running transaction's records, got %v", recs[0])
+	}
+}
+
+func TestCheckpointRecordReadBack(t *testing.T) {
+	lf, path := makeTestLogFile(t)
+	tid := NewTID()
+	lf.logBegin(tid)
+	lsn, _ := lf.logUpdate(tid, "t.dat", 2, nil, testPageImage(1))
+	dpt := map[heapHash]LSN{{FileName: "t.dat", PageNo: 2}: lsn}
+	begin, err := lf.Checkpoint(func() map[heapHash]LSN { return dpt })
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	lf.Close()
+
+	lf, err = NewLogFile(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer lf.Close()
+	if lf.lastCheckpoint() != begin {
+		t.Errorf("expected checkpoint at %d, got %d", begin, lf.lastCheckpoint())
+	}
+	recs := readLogRecords(t, lf)
+	end := recs[len(recs)-1]
+	if end.recType != EndCheckpointRecord || end.checkpointLSN != begin {
+		t.Fatalf("expected an end checkpoint record for %d, got %v", begin, end)
+	}
+	if txn, ok := end.activeTxns[tid]; !ok || txn.firstLSN != recs[0].lsn || txn.lastLSN != lsn {
+		t.Errorf("active transaction table not read back correctly: %v", end.activeTxns)
+	}
+	if len(end.dirtyPages) != 1 || end.dirtyPages[heapHash{FileName: "t.dat", PageNo: 2}] != lsn {
+		t.Errorf("dirty page table not read back correctly: %v", end.dirtyPages)
+	}
+	// checkpoint records are not part of the transaction's prevLSN chain
+	if lf.lastLSNOf(tid) != lsn {
+		t.Errorf("expected last LSN of tid to be %d, got %d", lsn, lf.lastLSNOf(tid))
+	}
+}
+
+func TestCheckpointParse(t *testing.T) {
+	for _, query := range []string{"checkpoint", "CHECKPOINT", " Checkpoint "} {
+		qtype, _, err := Parse(nil, query)
+		if err != nil || qtype != CheckpointQueryType {
+			t.Errorf("%q: expected CheckpointQueryType, got %v (%v)", query, qtype, err)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package godb
+
+import (
+	"fmt"
+	"sort"
+)
+
+/*
+A clustered heap file, created with CREATE TABLE ... CLUSTER BY (col, ...),
+keeps its tuples sorted by the values of some of its columns, its cluster key:
+every tuple of a page has a key smaller than or equal to those of the tuples
+of the later pages of the file. Tuples are not sorted within a page, since
+they keep their slots, but a scan sorts the tuples of each page it reads (see
+[ClusteredScan]), so that the whole file is read in key order.
+
+[HeapFile.insertTuple] inserts a tuple of a clustered file with
+[HeapFile.insertClustered] instead of into the first page with room: into the
+last page whose smallest key is not greater than its own, found with a binary
+search of the pages. If that page is full, the tuples of the page with larger
+keys than the new tuple are moved to the next page to make room for it, and so
+on, as in an insertion sort; a tuple moved out of the last page goes to a new
+page appended to the file. Moving a tuple changes its record id, so its index
+entries are moved along with it, and it is locked for writing if the
+BufferPool uses row locking. Inserting in key order, as when loading a time
+series, only ever appends, whereas inserting keys smaller than those of the
+tuples of full pages moves tuples to make room, so clustered files suit data
+that mostly arrives in key order. Deleting tuples leaves the file sorted.
+
+The planner reads a range of the cluster key that the filters of a query
+constrain, as it would a range of the keys of an index (see [useIndexScan]),
+with a ClusteredScan, which starts at the first page that may hold the range
+and stops at the first tuple past its end, and it drops the ORDER BY of a
+query over a single clustered table that sorts by a prefix of its cluster key
+in ascending order (see [orderedByClusterKey]), reading the table with a
+ClusteredScan instead.
+*/
+
+// The cluster key of a clustered heap file.
+type clusterKey struct {
+	fields []int      // the positions of the key columns in the TupleDesc of the file
+	desc   *TupleDesc // the key columns
+}
+
+// Return the cluster key of a file with the specified TupleDesc made of the
+// specified columns.
+func newClusterKey(desc *TupleDesc, columns []string) (*clusterKey, error) {
+	if len(columns) == 0 {
+		return nil, GoDBError{ParseError, "a cluster key must have columns"}
+	}
+	key := &clusterKey{desc: &TupleDesc{}}
+	for _, col := range columns {
+		field, err := findFieldInTd(FieldType{col, "", UnknownType}, desc)
+		if err != nil {
+			return nil, err
+		}
+		key.fields = append(key.fields, field)
+		key.desc.Fields = append(key.desc.Fields, FieldType{col, "", desc.Fields[field].Ftype})
+	}
+	return key, nil
+}
+
+// Keep the tuples of the file sorted by key, or by nothing if key is nil. Must
+// be called before any tuple is inserted into the file.
+func (f *HeapFile) setCluster(key *clusterKey) {
+	f.cluster = key
+}
+
+// A tuple of a clustered file and its cluster key.
+type clusteredTuple struct {
+	t   *Tuple // the tuple as stored in its page, or to be stored
+	key []DBValue
+
+	// if the tuple is being moved to another page by insertClustered, its
+	// version (if the BufferPool uses MVCC)
+	moved   bool
+	version tupleVersion
+}
+
+// Return the cluster key of t, a tuple of the file as stored in its page,
+// fetching the key values stored out of line on behalf of tid.
+func (f *HeapFile) clusterKeyOf(t *Tuple, tid TransactionID) ([]DBValue, error) {
+	key := make([]DBValue, len(f.cluster.fields))
+	for i, field := range f.cluster.fields {
+		if field >= len(t.Fields) {
+			return nil, GoDBError{MalformedDataError, fmt.Sprintf("tuple has no column %d for its cluster key", field)}
+		}
+		v := t.Fields[field]
+		if p, ok := v.(toastPointer); ok {
+			s, err := f.readOverflowChain(p, tid)
+			if err != nil {
+				return nil, err
+			}
+			v = StringField{s}
+		}
+		key[i] = v
+	}
+	return key, nil
+}
+
+// Return the tuples of hp, a page of the clustered file, sorted by their
+// cluster keys and then by slot, including the versions that are not visible
+// to tid if the BufferPool uses MVCC.
+func (f *HeapFile) clusteredTuples(hp *heapPage, tid TransactionID) ([]clusteredTuple, error) {
+	var tuples []clusteredTuple
+	iter := hp.tupleIter()
+	for t, err := iter(); t != nil || err != nil; t, err = iter() {
+		if err != nil {
+			return nil, err
+		}
+		key, err := f.clusterKeyOf(t, tid)
+		if err != nil {
+			return nil, err
+		}
+		tuples = append(tuples, clusteredTuple{t: t, key: key})
+	}
+	sort.SliceStable(tuples, func(i, j int) bool {
+		return compareKeys(tuples[i].key, tuples[j].key) < 0
+	})
+	return tuples, nil
+}
+
+// Return page pageNo of the clustered file, read on behalf of tid with the
+// specified permission, and its tuples sorted by their cluster keys (see
+// [HeapFile.clusteredTuples]). The tuples are copied out of the page, so the
+// short locks taken at ReadCommitted are released before returning (see
+// [BufferPool.startRead]).
+func (f *HeapFile) readClusteredPage(pageNo int, tid TransactionID, perm RWPerm) (*heapPage, []clusteredTuple, error) {
+	defer f.bufPool.endRead(tid, f.bufPool.startRead(tid))
+	pg, err := f.bufPool.GetPage(f, pageNo, tid, perm)
+	if err != nil {
+		return nil, nil, err
+	}
+	hp := pg.(*heapPage)
+	tuples, err := f.clusteredTuples(hp, tid)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hp, tuples, nil
+}
+
+// Return the number of the last page of the clustered file whose smallest key
+// is smaller than key, or not greater than it if strict is not set, ignoring
+// pages without tuples, or 0 if there is no such page. Pages are read on
+// behalf of tid. key may be a prefix of the cluster key.
+func (f *HeapFile) findClusterPage(key []DBValue, strict bool, tid TransactionID) (int, error) {
+	found := 0
+	lo, hi := 0, f.NumPages()-1
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		// the first page from mid on that has tuples
+		var smallest []DBValue
+		pageNo := mid
+		for ; pageNo <= hi && smallest == nil; pageNo++ {
+			_, tuples, err := f.readClusteredPage(pageNo, tid, ReadPerm)
+			if err != nil {
+				return 0, err
+			}
+			if len(tuples) > 0 {
+				smallest = tuples[0].key
+			}
+		}
+		if smallest == nil {
+			hi = mid - 1
+			continue
+		}
+		pageNo--
+		if c := compareKeys(smallest, key); c < 0 || c == 0 && !strict {
+			found, lo = pageNo, pageNo+1
+		} else {
+			hi = mid - 1
+		}
+	}
+	return found, nil
+}
+
+// Insert t, a tuple returned by [HeapFile.toast], into the clustered file on
+// behalf of tid, keeping the file sorted by cluster key, and set its Rid. The
+// page it goes to is the last one whose smallest key is not greater than its
+// own (see [HeapFile.findClusterPage]); tuples of full pages with larger keys
+// are moved to the next page to make room (see [HeapFile.moveOut]). The version
+// of t is set to have xmin tid if the BufferPool uses MVCC.
+func (f *HeapFile) insertClustered(t *Tuple, tid TransactionID) error {
+	key, err := f.clusterKeyOf(t, tid)
+	if err != nil {
+		return err
+	}
+	pageNo, err := f.findClusterPage(key, false, tid)
+	if err != nil {
+		return err
+	}
+	// the tuples to place on this page or the next ones, sorted by key
+	pending := []clusteredTuple{{t: t, key: key}}
+	for ; len(pending) > 0; pageNo++ {
+		if pageNo == f.NumPages() {
+			if _, err := f.appendEmptyPage(tid); err != nil {
+				return err
+			}
+		}
+		pg, err := f.bufPool.GetPage(f, pageNo, tid, WritePerm)
+		if err != nil {
+			return err
+		}
+		hp := pg.(*heapPage)
+		var tuples []clusteredTuple
+		read := false
+		for len(pending) > 0 {
+			err := f.placeTuple(hp, pending[0], tid)
+			if err == nil {
+				pending = pending[1:]
+				continue
+			}
+			if gerr, ok := err.(GoDBError); !ok || gerr.code != PageFullError {
+				return err
+			}
+			if !read {
+				if tuples, err = f.clusteredTuples(hp, tid); err != nil {
+					return err
+				}
+				read = true
+			}
+			// make room by moving the tuple with the largest key, if it is
+			// larger than that of the tuple to place
+			if len(tuples) == 0 || compareKeys(tuples[len(tuples)-1].key, pending[0].key) <= 0 {
+				break
+			}
+			moved, err := f.moveOut(hp, tuples[len(tuples)-1], tid)
+			if err != nil {
+				return err
+			}
+			tuples = tuples[:len(tuples)-1]
+			i := sort.Search(len(pending), func(i int) bool {
+				return compareKeys(pending[i].key, moved.key) > 0
+			})
+			pending = append(pending[:i], append([]clusteredTuple{moved}, pending[i:]...)...)
+		}
+	}
+	return nil
+}
+
+// Insert c into hp, a page of the clustered file, on behalf of tid, setting
+// the Rid of its tuple and locking it for writing if the BufferPool uses row
+// locking. A moved tuple keeps its version, and its entries are added to the
+// indexes of the file; the version of a new one has xmin tid. Returns a
+// PageFullError if the tuple does not fit in the page.
+func (f *HeapFile) placeTuple(hp *heapPage, c clusteredTuple, tid TransactionID) error {
+	rid, err := f.changeSlot(hp, nil, tid, func() (recordID, error) {
+		rid, err := hp.insertTuple(c.t)
+		if err != nil || f.bufPool.versions == nil {
+			return rid, err
+		}
+		v := tupleVersion{tid, InvalidTID}
+		if c.moved {
+			v = c.version
+		}
+		return rid, hp.setVersion(rid, v)
+	})
+	if err != nil {
+		return err
+	}
+	if err := f.bufPool.lockTuple(f, rid, tid, WritePerm, LockWait); err != nil {
+		return err
+	}
+	if !c.moved {
+		return nil
+	}
+	t, err := f.detoast(c.t, tid)
+	if err != nil {
+		return err
+	}
+	return f.insertIndexEntries(t, tid)
+}
+
+// Remove c, a tuple of hp, from the page on behalf of tid, so that it can be
+// inserted into a later page of the clustered file, and return it. The tuple
+// is locked for writing if the BufferPool uses row locking, and its entries
+// are removed from the indexes of the file; the strings it stores out of line
+// stay where they are.
+func (f *HeapFile) moveOut(hp *heapPage, c clusteredTuple, tid TransactionID) (clusteredTuple, error) {
+	rid := c.t.Rid
+	if err := f.bufPool.lockTuple(f, rid, tid, WritePerm, LockWait); err != nil {
+		return clusteredTuple{}, err
+	}
+	moved := clusteredTuple{t: &Tuple{Desc: c.t.Desc, Fields: c.t.Fields}, key: c.key, moved: true}
+	if f.bufPool.versions != nil {
+		moved.version = hp.versionOf(rid)
+	}
+	if err := f.deleteIndexEntries(c.t, tid); err != nil {
+		return clusteredTuple{}, err
+	}
+	_, err := f.changeSlot(hp, rid, tid, func() (recordID, error) {
+		return rid, hp.deleteTuple(rid)
+	})
+	if err != nil {
+		return clusteredTuple{}, err
+	}
+	return moved, nil
+}
+
+// A scan of the tuples of a clustered heap file whose cluster keys are in a
+// range, in key order.
+type ClusteredScan struct {
+	file *HeapFile
+	keyRange
+}
+
+// Return the TupleDesc of the heap file.
+func (s *ClusteredScan) Descriptor() *TupleDesc {
+	return s.file.Descriptor()
+}
+
+// Return an iterator over the tuples of the file whose keys are in the range
+// of the scan, in key order. The scan starts at the last page whose smallest
+// key is below the range (see [HeapFile.findClusterPage]), and stops at the
+// first tuple above it; as in an index scan, keys with a NULL in the columns
+// the range constrains are never returned, since NULLs are sorted last. Tuples
+// are read as [HeapFile.Iterator] reads them (see [HeapFile.readTuple]),
+// taking a new snapshot first if the isolation level of tid requires it. If
+// the scan reaches the end of the file, it locks the end of the file for
+// reading, as Iterator does.
+func (s *ClusteredScan) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	f := s.file
+	f.bufPool.refreshSnapshot(tid)
+	pageNo := 0
+	if s.lo != nil {
+		var err error
+		if pageNo, err = f.findClusterPage(s.lo.Key, true, tid); err != nil {
+			return nil, err
+		}
+	}
+	constrained := 0
+	for _, b := range []*BTreeBound{s.lo, s.hi} {
+		if b != nil {
+			constrained = max(constrained, len(b.Key))
+		}
+	}
+	var hp *heapPage
+	var tuples []clusteredTuple
+	done := false
+	return func() (*Tuple, error) {
+		for !done {
+			if len(tuples) == 0 {
+				if pageNo >= f.NumPages() {
+					done = true
+					return nil, f.bufPool.lockEndOfFile(f, tid, ReadPerm)
+				}
+				var err error
+				if hp, tuples, err = f.readClusteredPage(pageNo, tid, ReadPerm); err != nil {
+					return nil, err
+				}
+				pageNo++
+				continue
+			}
+			c := tuples[0]
+			tuples = tuples[1:]
+			if !aboveLowerBound(c.key, s.lo) {
+				continue
+			}
+			if !belowUpperBound(c.key, s.hi) || hasNullIn(c.key[:constrained]) {
+				done = true
+				break
+			}
+			t, err := f.readTuple(hp, c.t, tid)
+			if err != nil || t != nil {
+				return t, err
+			}
+		}
+		return nil, nil
+	}, nil
+}
+
+// Return the plan of a single clustered table whose tuples come out of node,
+// a plan returning them in the same order, sorted by the expressions with the
+// ascending flags if possible, as when ORDER BY exprs can be dropped: node is
+// made of projections and filters over a scan of a clustered file, and exprs
+// are the first columns of its cluster key, all ascending. A table scan of the
+// file is replaced with a full [ClusteredScan] of it. Returns false if node is
+// not sorted by exprs.
+func orderedByClusterKey(node *OperatorCard, exprs []Expr, ascs []bool) (*OperatorCard, bool) {
+	exprs = append([]Expr(nil), exprs...)
+	for _, asc := range ascs {
+		if !asc {
+			return nil, false
+		}
+	}
+	scan := node
+	for {
+		var child Operator
+		switch op := scan.Op.(type) {
+		case *Project:
+			// look through the names of the projected expressions
+			for i, e := range exprs {
+				field, ok := e.(*FieldExpr)
+				if !ok {
+					return nil, false
+				}
+				j := -1
+				for k, name := range op.outputNames {
+					if name == field.selectField.Fname {
+						if j >= 0 {
+							return nil, false
+						}
+						j = k
+					}
+				}
+				if j < 0 {
+					return nil, false
+				}
+				exprs[i] = op.selectFields[j]
+			}
+			child = op.child
+		case *Filter:
+			child = op.child
+		case *ClusteredScan:
+			return node, sortedByClusterKey(op.file, exprs)
+		case *HeapFile:
+			if !sortedByClusterKey(op, exprs) {
+				return nil, false
+			}
+			scan.Op = &ClusteredScan{file: op}
+			return node, true
+		default:
+			return nil, false
+		}
+		next, ok := child.(*OperatorCard)
+		if !ok {
+			return nil, false
+		}
+		scan = next
+	}
+}
+
+// Return true if the file is clustered and exprs are fields with the names
+// and types of the first columns of its cluster key.
+func sortedByClusterKey(f *HeapFile, exprs []Expr) bool {
+	if f.cluster == nil || len(exprs) > len(f.cluster.fields) {
+		return false
+	}
+	for i, e := range exprs {
+		field, ok := e.(*FieldExpr)
+		ft := f.cluster.desc.Fields[i]
+		if !ok || field.selectField.Fname != ft.Fname || field.selectField.Ftype != ft.Ftype {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package godb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func makeClusteredFile(t *testing.T) (*HeapFile, *TupleDesc) {
+	t.Helper()
+	desc := &TupleDesc{[]FieldType{{"name", "", StringType}, {"age", "", IntType}, {"ts", "", IntType}}}
+	key, err := newClusterKey(desc, []string{"ts", "age"})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if fmt.Sprint(key.fields) != "[2 1]" || key.desc.Fields[0] != (FieldType{"ts", "", IntType}) {
+		t.Fatalf("unexpected cluster key %+v", key)
+	}
+	hf := &HeapFile{}
+	hf.setCluster(key)
+	return hf, desc
+}
+
+func TestClusteredScanPlan(t *testing.T) {
+	hf, _ := makeClusteredFile(t)
+	ts := &FieldExpr{FieldType{"ts", "t", IntType}}
+	age := &FieldExpr{FieldType{"age", "t", IntType}}
+
+	plan := useIndexScan(filterChain(hf,
+		&Filter{op: OpGe, left: ts, right: &ConstExpr{IntField{100}, IntType}},
+		&Filter{op: OpLt, left: ts, right: &ConstExpr{IntField{200}, IntType}},
+		&Filter{op: OpGt, left: age, right: &ConstExpr{IntField{20}, IntType}},
+	), nil)
+	f, ok := plan.Op.(*Filter)
+	if !ok || f.left != age || plan.Cardinality != 125 {
+		t.Fatalf("expected the filter on age to stay above the scan, got %+v", plan)
+	}
+	scan, ok := f.child.(*OperatorCard).Op.(*ClusteredScan)
+	if !ok || scan.file != hf || !scan.lo.Inclusive || scan.hi.Inclusive || scan.lo.Key[0] != (IntField{100}) || scan.hi.Key[0] != (IntField{200}) {
+		t.Fatalf("expected a clustered scan of ts in [100, 200), got %+v", f.child)
+	}
+	var buf strings.Builder
+	OutputPhysicalPlan(func(format string, a ...any) { fmt.Fprintf(&buf, format, a...) }, f.child, "")
+	if s := buf.String(); !strings.HasPrefix(s, "Clustered Scan ") || !strings.HasSuffix(s, " (t.ts >= 100, t.ts < 200), card:250\n") {
+		t.Errorf("unexpected plan %q", s)
+	}
+
+	// an index only wins if it evaluates more of the filters
+	_, nameIdx, ageIdx := makeIndexedFile(t)
+	hf.addIndex(ageIdx)
+	hf.addIndex(nameIdx)
+	eqTs := &Filter{op: OpEq, left: ts, right: &ConstExpr{IntField{5}, IntType}}
+	plan = useIndexScan(filterChain(hf, eqTs, &Filter{op: OpLe, left: age, right: &ConstExpr{IntField{5}, IntType}}), nil)
+	if _, ok := plan.Op.(*ClusteredScan); !ok {
+		t.Errorf("expected a clustered scan of ts = 5 and age <= 5, got %+v", plan.Op)
+	}
+	leTs := &Filter{op: OpLe, left: ts, right: &ConstExpr{IntField{5}, IntType}}
+	plan = useIndexScan(filterChain(hf, leTs, &Filter{op: OpEq, left: age, right: &ConstExpr{IntField{5}, IntType}}), nil)
+	if f, ok := plan.Op.(*Filter); !ok || f.left != ts {
+		t.Errorf("expected the filter on ts above a lookup of by_age, got %+v", plan.Op)
+	} else if scan, ok := f.child.(*OperatorCard).Op.(*IndexScan); !ok || scan.index != ageIdx {
+		t.Errorf("expected a lookup of by_age, got %+v", f.child)
+	}
+	plan = useIndexScan(filterChain(hf, &Filter{op: OpEq, left: age, right: &ConstExpr{IntField{5}, IntType}}), nil)
+	if _, ok := plan.Op.(*IndexScan); !ok {
+		t.Errorf("expected a filter on the second key column only not to use the cluster key, got %+v", plan.Op)
+	}
+}
+
+func TestOrderedByClusterKey(t *testing.T) {
+	hf, _ := makeClusteredFile(t)
+	ts := &FieldExpr{FieldType{"ts", "t", IntType}}
+	age := &FieldExpr{FieldType{"age", "t", IntType}}
+	name := &FieldExpr{FieldType{"name", "t", StringType}}
+
+	node := filterChain(hf, &Filter{op: OpEq, left: name, right: &ConstExpr{StringField{"joe"}, StringType}})
+	base := node.Op.(*Filter).child.(*OperatorCard)
+	plan, ok := orderedByClusterKey(node, []Expr{ts, age}, []bool{true, true})
+	if !ok || plan != node {
+		t.Fatalf("expected ORDER BY ts, age to be dropped")
+	}
+	if scan, ok := base.Op.(*ClusteredScan); !ok || scan.file != hf || scan.lo != nil || scan.hi != nil {
+		t.Errorf("expected a full clustered scan, got %+v", base.Op)
+	}
+	if _, ok := orderedByClusterKey(node, []Expr{ts}, []bool{true}); !ok {
+		t.Errorf("expected ORDER BY ts on a clustered scan to be dropped")
+	}
+
+	renamed := &FieldExpr{FieldType{"when", "", IntType}}
+	project := NewOperatorCard(&Project{selectFields: []Expr{name, ts}, outputNames: []string{"name", "when"}, child: filterChain(hf)}, 1000)
+	if _, ok := orderedByClusterKey(project, []Expr{renamed}, []bool{true}); !ok {
+		t.Errorf("expected ORDER BY a projection of ts to be dropped")
+	}
+
+	unordered := []struct {
+		node  *OperatorCard
+		exprs []Expr
+		ascs  []bool
+	}{
+		{filterChain(hf), []Expr{ts}, []bool{false}},
+		{filterChain(hf), []Expr{age}, []bool{true}},
+		{filterChain(hf), []Expr{ts, age, name}, []bool{true, true, true}},
+		{filterChain(&HeapFile{}), []Expr{ts}, []bool{true}},
+		{project, []Expr{&FieldExpr{FieldType{"name", "", StringType}}}, []bool{true}},
+		{NewOperatorCard(&OrderBy{}, 10), []Expr{ts}, []bool{true}},
+	}
+	for i, c := range unordered {
+		if _, ok := orderedByClusterKey(c.node, c.exprs, c.ascs); ok {
+			t.Errorf("case %d: expected the ORDER BY to be kept", i)
+		}
+	}
+	node = filterChain(hf)
+	if _, ok := orderedByClusterKey(node, []Expr{age}, []bool{true}); ok || node.Op != Operator(hf) {
+		t.Errorf("expected a table scan to be kept if the ORDER BY is")
+	}
+}
+
+func TestClusterCatalogEntries(t *testing.T) {
+	hf, desc := makeClusteredFile(t)
+	table := &Table{name: "t", desc: *desc, file: &HeapFile{}, columns: []columnDef{{nullable: true}, {nullable: true}, {nullable: true}}}
+	if err := table.setCluster(hf.cluster); err != nil || table.file.(*HeapFile).cluster != hf.cluster {
+		t.Fatalf("expected the file of the table to be clustered, got %v", err)
+	}
+	expected := "t(name string, age int, ts int)\ncluster t by (ts, age)\n"
+	if s := table.String(); s != expected {
+		t.Fatalf("expected %q, got %q", expected, s)
+	}
+	if m := catalogClusterEntry.FindStringSubmatch("cluster t by (ts, age)"); m == nil || m[1] != "t" || m[2] != "ts, age" {
+		t.Errorf("unexpected match %q", m)
+	}
+	if catalogClusterEntry.MatchString("cluster(a int)") {
+		t.Errorf("expected a table named cluster not to be a cluster entry")
+	}
+	if _, err := newClusterKey(desc, []string{"id"}); err == nil {
+		t.Errorf("expected a cluster key on a missing column to be rejected")
+	}
+
+	query, columns := extractClusterBy("CREATE TABLE t (a int, b varchar(10)) CLUSTER BY (a, b);")
+	if query != "CREATE TABLE t (a int, b varchar(10))" || fmt.Sprint(columns) != "[a b]" {
+		t.Errorf("unexpected statement %q and columns %q", query, columns)
+	}
+	for _, q := range []string{"create table t (a int)", "select a from t cluster by (a)"} {
+		if query, columns := extractClusterBy(q); query != q || columns != nil {
+			t.Errorf("%s: expected no CLUSTER BY clause", q)
+		}
+	}
+}
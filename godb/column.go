@@ -0,0 +1,147 @@
+package godb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The definition of a column beyond its name and type (see [FieldType]). The
+// zero value is a NOT NULL column, which is a STRING column if it is a string
+// column, and a DECIMAL column without a precision if it is a decimal column.
+type columnDef struct {
+	// The limit of the values of a string column (see [stringLimit]).
+	limit stringLimit
+
+	// Whether the column is nullable (see [NullField]).
+	nullable bool
+
+	// The precision and scale of a decimal column (see [DecimalField]).
+	decimal decimalSpec
+}
+
+// Return the name of the type of a column of type t with the definition, as
+// written in catalog files, e.g. "varchar(10)" or "decimal(10,2)".
+func columnTypeName(t DBType, def columnDef) string {
+	switch t {
+	case StringType:
+		switch def.limit {
+		case fixedLengthString:
+			return "string"
+		case unboundedString:
+			return "text"
+		}
+		return fmt.Sprintf("varchar(%d)", def.limit)
+	case DecimalType:
+		return def.decimal.String()
+	}
+	return t.String()
+}
+
+// Parse the name of a column type, as written in a catalog file or a CREATE
+// TABLE statement, e.g. "int", "string", "varchar(10)", "text", "float",
+// "bool", "date", "timestamp" or "decimal(10,2)". length is the length of the
+// type if it is not part of name, as parsed by sqlparser, or "". Lengths are
+// ignored except for VARCHAR, DECIMAL, and TINYINT, which is BOOLEAN with
+// length 1 (see [rewriteBooleanColumns]). The returned definition is not
+// nullable.
+func parseColumnType(name string, length string) (DBType, columnDef, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if open := strings.Index(name, "("); open >= 0 && strings.HasSuffix(name, ")") {
+		name, length = name[:open], name[open+1:len(name)-1]
+	}
+	switch name {
+	case "int", "integer", "bigint", "smallint":
+		return IntType, columnDef{}, nil
+	case "tinyint":
+		if strings.TrimSpace(length) == "1" {
+			return BoolType, columnDef{}, nil
+		}
+		return IntType, columnDef{}, nil
+	case "float", "double", "real":
+		return FloatType, columnDef{}, nil
+	case "bool", "boolean":
+		return BoolType, columnDef{}, nil
+	case "date":
+		return DateType, columnDef{}, nil
+	case "timestamp", "datetime":
+		return TimestampType, columnDef{}, nil
+	case "decimal", "numeric", "dec":
+		spec, err := parseDecimalSpec(length)
+		if err != nil {
+			return UnknownType, columnDef{}, err
+		}
+		return DecimalType, columnDef{decimal: spec}, nil
+	case "string":
+		return StringType, columnDef{limit: fixedLengthString}, nil
+	case "text":
+		return StringType, columnDef{limit: unboundedString}, nil
+	case "varchar":
+		if length == "" {
+			return StringType, columnDef{limit: unboundedString}, nil
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(length))
+		if err != nil || n <= 0 {
+			return UnknownType, columnDef{}, GoDBError{ParseError, fmt.Sprintf("invalid varchar length %s", length)}
+		}
+		return StringType, columnDef{limit: stringLimit(n)}, nil
+	}
+	return UnknownType, columnDef{}, GoDBError{ParseError, fmt.Sprintf("unknown type %s", name)}
+}
+
+// Split the column list of a catalog entry at the commas that are not inside
+// parentheses, such as the comma of "decimal(10,2)".
+func splitColumnList(s string) []string {
+	var columns []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				columns = append(columns, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(columns, s[start:])
+}
+
+// Set the definitions of the columns of the file, one per field of its
+// TupleDesc; nil means all columns have the zero definition.
+func (f *HeapFile) setColumns(columns []columnDef) {
+	f.columns = columns
+}
+
+// Return the definition of the field at position i of the file's TupleDesc.
+func (f *HeapFile) column(i int) columnDef {
+	if i < len(f.columns) {
+		return f.columns[i]
+	}
+	return columnDef{}
+}
+
+// Return the limit of the field at position i of the file's TupleDesc.
+func (f *HeapFile) stringLimit(i int) stringLimit {
+	return f.column(i).limit
+}
+
+// Return true if the field at position i of the file's TupleDesc is nullable.
+func (f *HeapFile) isNullable(i int) bool {
+	return f.column(i).nullable
+}
+
+// Return true if the file stores its tuples with the variable-length encoding,
+// in slotted pages: if it has variable-length columns, or nullable columns
+// (whose NULLs are recorded in the null bitmap of the encoding).
+func (f *HeapFile) isVarlen() bool {
+	for _, c := range f.columns {
+		if c.limit.varlen() || c.nullable {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,749 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/*
+A columnar table, created with CREATE TABLE ... WITH (format = columnar), is
+stored in a ColumnarFile rather than in a heap file. Instead of storing whole
+rows in each page, it stores the values of each column in pages of their own,
+so that a query that reads a few columns of a wide table only reads the pages
+of those columns (see [ColumnarScan]).
+
+The rows of the file are divided into row groups. The pages of row group g
+are the len(columns)+1 pages starting at page g*(len(columns)+1): a header
+page, followed by one segment per column, a page holding the values of the
+column for the rows of the group, in the same order. A segment also records
+the smallest and largest of its values, so that a scan can skip a row group
+when a predicate of a filter of the query on the column cannot be true of
+any value between them, without reading the segments of the other columns.
+Rows are appended to the last row group until one of its pages is full, and
+then to a new row group appended to the file. A deleted row is only marked as
+deleted in the header of its group, so the file never shrinks.
+
+A header page starts with columnarPageMagic, columnarHeaderFlag and the number
+of rows of the group as a 16 bit integer, followed by a bitmap of its deleted
+rows, in which bit i%8 of byte i/8 is set if row i was deleted. A segment
+starts with columnarPageMagic, a zero byte and the number of its values as a
+16 bit integer, followed by its smallest and largest values, written as a
+tuple of two fields with [Tuple.writeVarlenTo] (both NULL if it has no values
+other than NULLs), and by its values, written as a tuple of one field per row.
+All integers are little endian. A page of zeros is empty.
+
+Values are always written with the variable-length encoding, so strings are
+not padded, but they are never stored out of line (see [toastPointer]): a row
+must fit in the pages of an empty row group.
+
+If the file is compressed (see [ColumnarFile.setCompression]), its segments
+are laid out in compressedPageSize bytes and compressed into PageSize bytes
+with [compressPage], so a value is only appended to a segment if the segment
+still compresses into PageSize bytes. Header pages are never compressed: a
+bitmap of deleted rows may not compress, but always fits in PageSize bytes.
+
+Transactions lock the pages they read for reading and the pages they modify
+for writing until they end, as they lock the pages of indexes (see
+[HashFile.getHashPage]), whether or not the BufferPool uses row locking or
+MVCC, and lock the end of the file to append a row group. A scan reads the
+header of every row group, so inserts into the last one wait for
+transactions that have scanned the file to end.
+*/
+
+const (
+	columnarPageMagic  uint32 = 0xC01DA7A5
+	columnarHeaderFlag byte   = 1
+	columnarHeaderSize int    = 7
+	maxColumnarRows    int    = 1<<16 - 1
+)
+
+// The storage formats of tables (see [Catalog.addTableWithOptions]).
+const (
+	heapFormat     = "heap"
+	columnarFormat = "columnar"
+)
+
+// A ColumnarFile stores the tuples of a table column by column, in row groups
+// (see above). Its tuples have a [columnarRid] as their Rid.
+type ColumnarFile struct {
+	fileName string
+	desc     *TupleDesc
+	bufPool  *BufferPool
+
+	// The definitions of the columns of the file (see
+	// [ColumnarFile.setColumns]); nil if they all have the zero definition.
+	columns []columnDef
+
+	// The codec the segments of the file are compressed with (see
+	// [ColumnarFile.setCompression]).
+	compression pageCompression
+}
+
+// The record id of a tuple of a ColumnarFile: its row in its row group.
+type columnarRid struct {
+	group int
+	row   int
+}
+
+// The smallest and largest values of a segment that a predicate can be true
+// of, both NULL if it has none, and the size of its values when written to
+// the file, not counting the null bitmap.
+type segmentStats struct {
+	min, max DBValue
+	size     int
+}
+
+// A page of a ColumnarFile, as cached by the BufferPool: the header page or a
+// segment of a row group. Like a [heapPage], it keeps the LSN of the last
+// update record logged for it and its before image, which are not part of its
+// on-disk format.
+type columnarPage struct {
+	file   *ColumnarFile
+	pageNo int
+
+	deleted []bool    // of a header page: whether each row of the group was deleted
+	values  []DBValue // of a segment: the values of its column, one per row
+	segmentStats
+
+	dirty   bool
+	dirtier TransactionID // writers lock pages exclusively, so there is at most one
+
+	lsn         LSN
+	beforeImage []byte
+}
+
+// Create a ColumnarFile backed by the specified file, which may be empty or a
+// previously created columnar file, for tuples with the specified TupleDesc.
+func NewColumnarFile(fromFile string, desc *TupleDesc, bp *BufferPool) (*ColumnarFile, error) {
+	if desc == nil || len(desc.Fields) == 0 {
+		return nil, GoDBError{IllegalOperationError, "a columnar table needs at least one column"}
+	}
+	file, err := os.OpenFile(fromFile, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	return &ColumnarFile{fileName: fromFile, desc: &TupleDesc{append([]FieldType(nil), desc.Fields...)}, bufPool: bp}, nil
+}
+
+// Set the definitions of the columns of the file, one per field of its
+// TupleDesc; nil means all columns have the zero definition.
+func (f *ColumnarFile) setColumns(columns []columnDef) {
+	f.columns = columns
+}
+
+// Return the definition of the field at position i of the file's TupleDesc.
+func (f *ColumnarFile) column(i int) columnDef {
+	if i < len(f.columns) {
+		return f.columns[i]
+	}
+	return columnDef{}
+}
+
+// Return a HeapFile with the definitions of the columns of the file, whose
+// checks of the values of tuples (see [HeapFile.checkNotNull]) and CSV parsing
+// (see [HeapFile.loadCSV]) only depend on them.
+func (f *ColumnarFile) columnChecks() *HeapFile {
+	return &HeapFile{bufPool: f.bufPool, columns: f.columns}
+}
+
+// Load the contents of the file from a CSV file, as [HeapFile.LoadFromCSV]
+// does.
+func (f *ColumnarFile) LoadFromCSV(file *os.File, hasHeader bool, sep string, skipLastField bool) error {
+	return f.columnChecks().loadCSV(file, hasHeader, sep, skipLastField, f)
+}
+
+// Return the name of the backing file.
+func (f *ColumnarFile) BackingFile() string {
+	return f.fileName
+}
+
+// Return the number of pages of the file.
+func (f *ColumnarFile) NumPages() int {
+	info, err := os.Stat(f.fileName)
+	if err != nil {
+		return 0
+	}
+	return int(info.Size() / int64(PageSize))
+}
+
+// Return the number of pages of a row group.
+func (f *ColumnarFile) groupSize() int {
+	return len(f.desc.Fields) + 1
+}
+
+// Return the number of row groups of the file. The pages of a row group that
+// was being appended when the database crashed are ignored, and overwritten by
+// the next row group appended.
+func (f *ColumnarFile) numGroups() int {
+	return f.NumPages() / f.groupSize()
+}
+
+// Return the TupleDesc of the tuples of the file.
+func (f *ColumnarFile) Descriptor() *TupleDesc {
+	return f.desc
+}
+
+// Return the TupleDesc of the specified columns of the file, in that order.
+func (f *ColumnarFile) projection(columns []int) *TupleDesc {
+	desc := &TupleDesc{make([]FieldType, len(columns))}
+	for i, column := range columns {
+		desc.Fields[i] = f.desc.Fields[column]
+	}
+	return desc
+}
+
+func (f *ColumnarFile) pageKey(pgNo int) any {
+	return heapHash{FileName: f.fileName, PageNo: pgNo}
+}
+
+// Read page pageNo from the file. Called by [BufferPool.GetPage].
+func (f *ColumnarFile) readPage(pageNo int) (Page, error) {
+	file, err := os.Open(f.fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	buf := make([]byte, PageSize)
+	if _, err := file.ReadAt(buf, int64(pageNo)*int64(PageSize)); err != nil {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("cannot read page %d of %s: %v", pageNo, f.fileName, err)}
+	}
+	p := newColumnarPage(f, pageNo)
+	if err := p.initFromBuffer(bytes.NewBuffer(buf)); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Write the page to the file.
+func (f *ColumnarFile) flushPage(page Page) error {
+	p, ok := page.(*columnarPage)
+	if !ok {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("%s can only store columnar pages", f.fileName)}
+	}
+	buf, err := p.toBuffer()
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.fileName, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteAt(buf.Bytes(), int64(p.pageNo)*int64(PageSize))
+	return err
+}
+
+// Return page pageNo of the file, read on behalf of tid with the specified
+// permission, locking it like [HashFile.getHashPage] locks pages.
+func (f *ColumnarFile) getColumnarPage(pageNo int, tid TransactionID, perm RWPerm) (*columnarPage, error) {
+	bp := f.bufPool
+	mode := SharedLock
+	if perm == WritePerm {
+		mode = ExclusiveLock
+	}
+	if err := bp.lockManager().acquireWithin(tid, f.pageKey(pageNo), mode, bp.lockWaitTimeout(tid, LockWait)); err != nil {
+		return nil, err
+	}
+	pg, err := bp.GetPage(f, pageNo, tid, perm)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := pg.(*columnarPage)
+	if !ok {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("page %d of %s is not a columnar page", pageNo, f.fileName)}
+	}
+	return p, nil
+}
+
+// Return the segment of the specified column of a row group, read on behalf
+// of tid with the specified permission.
+func (f *ColumnarFile) getSegment(group int, column int, tid TransactionID, perm RWPerm) (*columnarPage, error) {
+	return f.getColumnarPage(group*f.groupSize()+1+column, tid, perm)
+}
+
+// Insert the tuple into the last row group of the file on behalf of tid, or
+// into a new row group appended to the file if the pages of the last one do
+// not all have room for it, and set its Rid. The values of the tuple are
+// checked and rounded like those of the tuples of a heap file (see
+// [HeapFile.insertTuple]), and strings of STRING columns are truncated to
+// StringLength bytes. Returns a PageFullError if the tuple does not fit in an
+// empty row group.
+func (f *ColumnarFile) insertTuple(t *Tuple, tid TransactionID) error {
+	if len(t.Fields) != len(f.desc.Fields) {
+		return GoDBError{TypeMismatchError, fmt.Sprintf("tuple has %d fields, expected %d", len(t.Fields), len(f.desc.Fields))}
+	}
+	checks := f.columnChecks()
+	if err := checks.checkNotNull(t); err != nil {
+		return err
+	}
+	if err := checks.roundDecimals(t); err != nil {
+		return err
+	}
+	if err := checks.checkStringLengths(t); err != nil {
+		return err
+	}
+	for i, v := range t.Fields {
+		if s, ok := v.(StringField); ok && !f.column(i).limit.varlen() && len(s.Value) > StringLength {
+			t.Fields[i] = StringField{s.Value[:StringLength]}
+		}
+	}
+	if groups := f.numGroups(); groups > 0 {
+		if ok, err := f.appendRow(groups-1, t, tid); ok || err != nil {
+			return err
+		}
+	}
+	if err := f.bufPool.lockEndOfFile(f, tid, WritePerm); err != nil {
+		return err
+	}
+	group := f.numGroups()
+	for i := 0; i < f.groupSize(); i++ {
+		if err := f.flushPage(newColumnarPage(f, group*f.groupSize()+i)); err != nil {
+			return err
+		}
+	}
+	ok, err := f.appendRow(group, t, tid)
+	if err == nil && !ok {
+		err = GoDBError{PageFullError, fmt.Sprintf("tuple does not fit in a row group of %s", f.fileName)}
+	}
+	return err
+}
+
+// Append the tuple to a row group on behalf of tid, locking its pages for
+// writing, and set its Rid. Returns false, leaving the pages unchanged, if one
+// of them does not have room for it.
+func (f *ColumnarFile) appendRow(group int, t *Tuple, tid TransactionID) (bool, error) {
+	header, err := f.getColumnarPage(group*f.groupSize(), tid, WritePerm)
+	if err != nil {
+		return false, err
+	}
+	rows := len(header.deleted)
+	if rows == maxColumnarRows || columnarHeaderSize+(rows+8)/8 > PageSize {
+		return false, nil
+	}
+	segments := make([]*columnarPage, len(t.Fields))
+	stats := make([]segmentStats, len(t.Fields))
+	for i, v := range t.Fields {
+		seg, err := f.getSegment(group, i, tid, WritePerm)
+		if err != nil {
+			return false, err
+		}
+		if len(seg.values) != rows {
+			return false, GoDBError{MalformedDataError, fmt.Sprintf("segment %d of row group %d of %s has %d values, expected %d", i, group, f.fileName, len(seg.values), rows)}
+		}
+		s, ok, err := seg.statsWith(v)
+		if err == nil && ok && f.compression != noCompression {
+			ok, err = seg.compressesWith(v, s)
+		}
+		if err != nil || !ok {
+			return false, err
+		}
+		segments[i], stats[i] = seg, s
+	}
+	header.deleted = append(header.deleted, false)
+	header.setDirty(tid, true)
+	for i, seg := range segments {
+		seg.values = append(seg.values, t.Fields[i])
+		seg.segmentStats = stats[i]
+		seg.setDirty(tid, true)
+	}
+	t.Rid = columnarRid{group, rows}
+	return true, nil
+}
+
+// Mark the row of the file whose record id is t.Rid as deleted, on behalf of
+// tid. Returns a TupleNotFoundError if the file has no such row, or if it was
+// already deleted.
+func (f *ColumnarFile) deleteTuple(t *Tuple, tid TransactionID) error {
+	rid, ok := t.Rid.(columnarRid)
+	if !ok || rid.group >= f.numGroups() {
+		return GoDBError{TupleNotFoundError, fmt.Sprintf("%s has no tuple at %v", f.fileName, t.Rid)}
+	}
+	header, err := f.getColumnarPage(rid.group*f.groupSize(), tid, WritePerm)
+	if err != nil {
+		return err
+	}
+	if rid.row >= len(header.deleted) || header.deleted[rid.row] {
+		return GoDBError{TupleNotFoundError, fmt.Sprintf("%s has no tuple at %v", f.fileName, t.Rid)}
+	}
+	header.deleted[rid.row] = true
+	header.setDirty(tid, true)
+	return nil
+}
+
+// Return a function that iterates through the tuples of the file, row group
+// by row group.
+func (f *ColumnarFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	columns := make([]int, len(f.desc.Fields))
+	for i := range columns {
+		columns[i] = i
+	}
+	return f.scan(columns, nil, tid), nil
+}
+
+// Return a function that iterates through the rows of the file that are not
+// deleted, row group by row group, materializing only the values of the
+// specified columns: the tuples have the fields of those columns, in that
+// order, as their TupleDesc (see [ColumnarFile.projection]). Row groups with
+// a segment whose values cannot satisfy one of the predicates are skipped
+// without reading their other segments (see [ColumnarFile.readGroup]). Once
+// the last row group has been read, the end of the file is locked for
+// reading (see [BufferPool.lockEndOfFile]).
+func (f *ColumnarFile) scan(columns []int, preds []segmentPredicate, tid TransactionID) func() (*Tuple, error) {
+	desc := f.projection(columns)
+	next := 0 // the next row group to read
+	var header *columnarPage
+	var segments []*columnarPage
+	row := 0
+	return func() (*Tuple, error) {
+		for {
+			if header != nil && row < len(header.deleted) {
+				r := row
+				row++
+				if header.deleted[r] {
+					continue
+				}
+				fields := make([]DBValue, len(segments))
+				for i, seg := range segments {
+					fields[i] = seg.values[r]
+				}
+				return &Tuple{Desc: *desc, Fields: fields, Rid: columnarRid{next - 1, r}}, nil
+			}
+			if next >= f.numGroups() {
+				return nil, f.bufPool.lockEndOfFile(f, tid, ReadPerm)
+			}
+			var err error
+			if header, segments, err = f.readGroup(next, columns, preds, tid); err != nil {
+				return nil, err
+			}
+			next++
+			row = 0
+		}
+	}
+}
+
+// Return the header of a row group and its segments of the specified
+// columns, read on behalf of tid. Returns a nil header, without reading the
+// segments of the columns, if the segment of the column of one of the
+// predicates cannot satisfy it (see [columnarPage.mayMatch]).
+func (f *ColumnarFile) readGroup(group int, columns []int, preds []segmentPredicate, tid TransactionID) (*columnarPage, []*columnarPage, error) {
+	header, err := f.getColumnarPage(group*f.groupSize(), tid, ReadPerm)
+	if err != nil {
+		return nil, nil, err
+	}
+	read := make(map[int]*columnarPage)
+	segment := func(column int) (*columnarPage, error) {
+		if seg, ok := read[column]; ok {
+			return seg, nil
+		}
+		seg, err := f.getSegment(group, column, tid, ReadPerm)
+		if err != nil {
+			return nil, err
+		}
+		if len(seg.values) != len(header.deleted) {
+			return nil, GoDBError{MalformedDataError, fmt.Sprintf("segment %d of row group %d of %s has %d values, expected %d", column, group, f.fileName, len(seg.values), len(header.deleted))}
+		}
+		read[column] = seg
+		return seg, nil
+	}
+	for _, p := range preds {
+		seg, err := segment(p.column)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !seg.mayMatch(p.op, p.value.val) {
+			return nil, nil, nil
+		}
+	}
+	segments := make([]*columnarPage, len(columns))
+	for i, column := range columns {
+		if segments[i], err = segment(column); err != nil {
+			return nil, nil, err
+		}
+	}
+	return header, segments, nil
+}
+
+// Create an empty page of the file: a header page if pageNo is the first page
+// of a row group, and a segment otherwise.
+func newColumnarPage(f *ColumnarFile, pageNo int) *columnarPage {
+	return &columnarPage{file: f, pageNo: pageNo, segmentStats: segmentStats{NullField{}, NullField{}, 0}, lsn: InvalidLSN}
+}
+
+// Return the position in the TupleDesc of the file of the column of a
+// segment, or -1 for a header page.
+func (p *columnarPage) column() int {
+	return p.pageNo%p.file.groupSize() - 1
+}
+
+// Return the TupleDesc of n values of the column of a segment.
+func (p *columnarPage) valuesDesc(n int) *TupleDesc {
+	desc := &TupleDesc{make([]FieldType, n)}
+	for i := range desc.Fields {
+		desc.Fields[i] = p.file.desc.Fields[p.column()]
+	}
+	return desc
+}
+
+// Return the statistics of a segment once v is appended to it, and whether the
+// page then still fits in [ColumnarFile.pageSize] bytes. Values that no
+// predicate is true of, NULL and NaN, which are not equal to themselves, are
+// not counted among the smallest and largest values.
+func (p *columnarPage) statsWith(v DBValue) (segmentStats, bool, error) {
+	s := p.segmentStats
+	if !isNull(v) {
+		n, err := varlenSize(&Tuple{Desc: *p.valuesDesc(1), Fields: []DBValue{v}})
+		if err != nil {
+			return s, false, err
+		}
+		s.size += n - 1 // the null bitmap is that of the whole segment
+	}
+	if v.EvalPred(v, OpEq) {
+		if isNull(s.min) || compareKeyValues(v, s.min) < 0 {
+			s.min = v
+		}
+		if isNull(s.max) || compareKeyValues(v, s.max) > 0 {
+			s.max = v
+		}
+	}
+	statsSize, err := varlenSize(&Tuple{Desc: *p.valuesDesc(2), Fields: []DBValue{s.min, s.max}})
+	if err != nil {
+		return s, false, err
+	}
+	rows := len(p.values) + 1
+	return s, columnarHeaderSize+statsSize+(rows+7)/8+s.size <= p.file.pageSize(), nil
+}
+
+// Return whether a segment of a compressed file still compresses into
+// PageSize bytes once v is appended to it, s being its statistics returned by
+// [columnarPage.statsWith]. The segment is left unchanged.
+func (p *columnarPage) compressesWith(v DBValue, s segmentStats) (bool, error) {
+	values, stats := p.values, p.segmentStats
+	p.values, p.segmentStats = append(p.values, v), s
+	_, err := p.toBuffer()
+	p.values, p.segmentStats = values, stats
+	if gerr, ok := err.(GoDBError); ok && gerr.code == PageFullError {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Return false if no value of a segment can satisfy the predicate "value op
+// v", where v is not NULL, since v is not between its smallest and largest
+// values in the way op requires; returns true otherwise.
+func (p *columnarPage) mayMatch(op BoolOp, v DBValue) bool {
+	if isNull(p.min) {
+		// no predicate of op is true of the values of the segment
+		return false
+	}
+	switch op {
+	case OpEq:
+		return compareKeyValues(p.min, v) <= 0 && compareKeyValues(p.max, v) >= 0
+	case OpLt:
+		return compareKeyValues(p.min, v) < 0
+	case OpLe:
+		return compareKeyValues(p.min, v) <= 0
+	case OpGt:
+		return compareKeyValues(p.max, v) > 0
+	case OpGe:
+		return compareKeyValues(p.max, v) >= 0
+	}
+	return true
+}
+
+// Page method - return whether or not the page is dirty.
+func (p *columnarPage) isDirty() bool {
+	return p.dirty
+}
+
+// Page method - mark the page as dirty on behalf of tid, or as clean.
+func (p *columnarPage) setDirty(tid TransactionID, dirty bool) {
+	p.dirty = dirty
+	if dirty {
+		p.dirtier = tid
+	}
+}
+
+// Page method - return the ColumnarFile of the page.
+func (p *columnarPage) getFile() DBFile {
+	return p.file
+}
+
+// Write the page to a new buffer of PageSize bytes, in the format described
+// in [ColumnarFile]. A segment of a compressed file is laid out in
+// compressedPageSize bytes and compressed with [compressPage], which returns
+// a PageFullError if it does not compress into PageSize bytes.
+func (p *columnarPage) toBuffer() (*bytes.Buffer, error) {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, columnarPageMagic)
+	if p.column() < 0 {
+		b.WriteByte(columnarHeaderFlag)
+		binary.Write(&b, binary.LittleEndian, uint16(len(p.deleted)))
+		bitmap := make([]byte, (len(p.deleted)+7)/8)
+		for i, deleted := range p.deleted {
+			if deleted {
+				bitmap[i/8] |= 1 << (i % 8)
+			}
+		}
+		b.Write(bitmap)
+	} else {
+		b.WriteByte(0)
+		binary.Write(&b, binary.LittleEndian, uint16(len(p.values)))
+		stats := Tuple{Desc: *p.valuesDesc(2), Fields: []DBValue{p.min, p.max}}
+		if err := stats.writeVarlenTo(&b); err != nil {
+			return nil, err
+		}
+		values := Tuple{Desc: *p.valuesDesc(len(p.values)), Fields: p.values}
+		if err := values.writeVarlenTo(&b); err != nil {
+			return nil, err
+		}
+	}
+	size := PageSize
+	if p.column() >= 0 {
+		size = p.file.pageSize()
+	}
+	if b.Len() > size {
+		return nil, GoDBError{PageFullError, fmt.Sprintf("columnar page of %d bytes does not fit in a page", b.Len())}
+	}
+	b.Write(make([]byte, size-b.Len()))
+	if size != PageSize {
+		return compressPage(b.Bytes())
+	}
+	return &b, nil
+}
+
+// Read the page from buf, decompressing it with [decompressPage] if it is
+// compressed, and save a copy of buf as its before image.
+func (p *columnarPage) initFromBuffer(buf *bytes.Buffer) error {
+	p.beforeImage = append([]byte(nil), buf.Bytes()...)
+	p.deleted, p.values, p.segmentStats = nil, nil, segmentStats{NullField{}, NullField{}, 0}
+	page, err := decompressPage(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	buf = bytes.NewBuffer(page)
+	header := buf.Next(columnarHeaderSize)
+	if len(header) < columnarHeaderSize {
+		return GoDBError{MalformedDataError, "truncated columnar page"}
+	}
+	switch binary.LittleEndian.Uint32(header) {
+	case 0:
+		return nil
+	case columnarPageMagic:
+	default:
+		return GoDBError{MalformedDataError, fmt.Sprintf("page %d of %s is not a columnar page", p.pageNo, p.file.fileName)}
+	}
+	if (header[4] == columnarHeaderFlag) != (p.column() < 0) {
+		kind := "segment"
+		if p.column() < 0 {
+			kind = "header page"
+		}
+		return GoDBError{MalformedDataError, fmt.Sprintf("page %d of %s is not a %s", p.pageNo, p.file.fileName, kind)}
+	}
+	count := int(binary.LittleEndian.Uint16(header[5:]))
+	if p.column() < 0 {
+		bitmap := buf.Next((count + 7) / 8)
+		if len(bitmap) < (count+7)/8 {
+			return GoDBError{MalformedDataError, "truncated columnar header page"}
+		}
+		p.deleted = make([]bool, count)
+		for i := range p.deleted {
+			p.deleted[i] = bitmap[i/8]&(1<<(i%8)) != 0
+		}
+		return nil
+	}
+	stats, err := readVarlenTupleFrom(buf, p.valuesDesc(2))
+	if err != nil {
+		return err
+	}
+	before := buf.Len()
+	values, err := readVarlenTupleFrom(buf, p.valuesDesc(count))
+	if err != nil {
+		return err
+	}
+	p.values = values.Fields
+	p.segmentStats = segmentStats{stats.Fields[0], stats.Fields[1], before - buf.Len() - (count+7)/8}
+	return nil
+}
+
+// A predicate "column op value" of a filter over a [ColumnarScan], which the
+// scan uses to skip the row groups whose segment of the column has no value
+// that satisfies it.
+type segmentPredicate struct {
+	column int // the position of the column in the TupleDesc of the file
+	indexPredicate
+}
+
+// A scan of a ColumnarFile that materializes only some of its columns and
+// skips the row groups that cannot satisfy some of the filters above it.
+type ColumnarScan struct {
+	file    *ColumnarFile
+	columns []int // the positions of the columns read in the TupleDesc of the file
+	preds   []segmentPredicate
+}
+
+// Return the TupleDesc of the columns the scan reads.
+func (s *ColumnarScan) Descriptor() *TupleDesc {
+	return s.file.projection(s.columns)
+}
+
+// Return an iterator over the values of the columns the scan reads of the rows
+// of the file, skipping the row groups that cannot satisfy its predicates
+// (see [ColumnarFile.scan]).
+func (s *ColumnarScan) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return s.file.scan(s.columns, s.preds, tid), nil
+}
+
+// Return a description of the predicates of the scan, such as "t.a = 1, t.b >
+// 2".
+func (s *ColumnarScan) predicateString() string {
+	preds := make([]string, len(s.preds))
+	for i, p := range s.preds {
+		preds[i] = fmt.Sprintf("%s %s %s", exprToStr(p.field), opToStr(p.op), exprToStr(p.value))
+	}
+	return strings.Join(preds, ", ")
+}
+
+// Replace the scan of a ColumnarFile below the filters of node, the plan of a
+// table, with a [ColumnarScan] that reads only the columns the query reads,
+// columns (all of them if it is nil; see [LogicalPlan.columnsRead]), and
+// skips the row groups that cannot satisfy the filters that compare a column
+// with a constant (see [indexablePredicate]). The filters stay above the
+// scan, since the rows of the groups it reads may not satisfy them. Returns
+// node.
+func useColumnarScan(node *OperatorCard, columns map[string]bool) *OperatorCard {
+	var filters []*Filter
+	scan := node
+	for {
+		f, ok := scan.Op.(*Filter)
+		if !ok {
+			break
+		}
+		child, ok := f.child.(*OperatorCard)
+		if !ok {
+			return node
+		}
+		filters = append(filters, f)
+		scan = child
+	}
+	file, ok := scan.Op.(*ColumnarFile)
+	if !ok {
+		return node
+	}
+	s := &ColumnarScan{file: file, columns: []int{}}
+	for i, ft := range file.desc.Fields {
+		if columns == nil || columns[ft.Fname] {
+			s.columns = append(s.columns, i)
+		}
+		for _, f := range filters {
+			if p, ok := indexablePredicate(f, ft); ok {
+				s.preds = append(s.preds, segmentPredicate{i, p})
+			}
+		}
+	}
+	scan.Op = s
+	return node
+}
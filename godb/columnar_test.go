@@ -0,0 +1,191 @@
+package godb
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func makeColumnarFile(t *testing.T) *ColumnarFile {
+	t.Helper()
+	desc := &TupleDesc{[]FieldType{{"name", "", StringType}, {"age", "", IntType}, {"score", "", FloatType}}}
+	f, err := NewColumnarFile(filepath.Join(t.TempDir(), "t.dat"), desc, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return f
+}
+
+func TestColumnarPages(t *testing.T) {
+	f := makeColumnarFile(t)
+	if f.groupSize() != 4 || f.numGroups() != 0 {
+		t.Fatalf("expected an empty file with row groups of 4 pages")
+	}
+
+	seg := newColumnarPage(f, 7) // the segment of score of the second row group
+	for _, v := range []DBValue{FloatField{2.5}, NullField{}, FloatField{math.NaN()}, FloatField{-1}, FloatField{7}} {
+		s, ok, err := seg.statsWith(v)
+		if err != nil || !ok {
+			t.Fatalf("expected %v to fit in the segment, got %v", v, err)
+		}
+		seg.values = append(seg.values, v)
+		seg.segmentStats = s
+	}
+	if seg.column() != 2 || seg.min != (FloatField{-1}) || seg.max != (FloatField{7}) || seg.size != 32 {
+		t.Fatalf("unexpected segment stats %+v", seg.segmentStats)
+	}
+	buf, err := seg.toBuffer()
+	if err != nil || buf.Len() != PageSize {
+		t.Fatalf("expected a page of %d bytes, got %v", PageSize, err)
+	}
+	read := newColumnarPage(f, 7)
+	if err := read.initFromBuffer(bytes.NewBuffer(buf.Bytes())); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if read.segmentStats != seg.segmentStats || len(read.values) != 5 || !isNull(read.values[1]) || read.values[4] != (FloatField{7}) {
+		t.Errorf("expected the segment to be read back, got %+v", read)
+	}
+
+	header := newColumnarPage(f, 4)
+	header.deleted = []bool{false, true, false, false, false, false, false, false, true}
+	buf, err = header.toBuffer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	read = newColumnarPage(f, 4)
+	if err := read.initFromBuffer(bytes.NewBuffer(buf.Bytes())); err != nil || fmt.Sprint(read.deleted) != fmt.Sprint(header.deleted) {
+		t.Errorf("expected the header page to be read back, got %v (%v)", read.deleted, err)
+	}
+	if err := newColumnarPage(f, 5).initFromBuffer(bytes.NewBuffer(buf.Bytes())); err == nil {
+		t.Errorf("expected a header page not to be read as a segment")
+	}
+	empty := newColumnarPage(f, 1)
+	if err := empty.initFromBuffer(bytes.NewBuffer(make([]byte, PageSize))); err != nil || len(empty.values) != 0 || !isNull(empty.min) {
+		t.Errorf("expected a page of zeros to be an empty segment, got %+v (%v)", empty, err)
+	}
+
+	full := newColumnarPage(f, 2)
+	for {
+		s, ok, err := full.statsWith(IntField{int64(len(full.values))})
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if !ok {
+			break
+		}
+		full.values = append(full.values, IntField{int64(len(full.values))})
+		full.segmentStats = s
+	}
+	if n := len(full.values); n < 400 || n > 512 {
+		t.Errorf("unexpected number of ints in a segment %d", n)
+	}
+	if _, err := full.toBuffer(); err != nil {
+		t.Errorf("expected a full segment to fit in a page, got %v", err)
+	}
+}
+
+func TestSegmentMayMatch(t *testing.T) {
+	f := makeColumnarFile(t)
+	seg := newColumnarPage(f, 2)
+	if seg.mayMatch(OpLt, IntField{100}) {
+		t.Errorf("expected an empty segment to match nothing")
+	}
+	seg.min, seg.max = IntField{10}, IntField{20}
+	cases := []struct {
+		op       BoolOp
+		v        int64
+		expected bool
+	}{
+		{OpEq, 9, false}, {OpEq, 10, true}, {OpEq, 20, true}, {OpEq, 21, false},
+		{OpLt, 10, false}, {OpLt, 11, true}, {OpLe, 10, true}, {OpLe, 9, false},
+		{OpGt, 20, false}, {OpGt, 19, true}, {OpGe, 20, true}, {OpGe, 21, false},
+		{OpNeq, 15, true},
+	}
+	for _, c := range cases {
+		if seg.mayMatch(c.op, IntField{c.v}) != c.expected {
+			t.Errorf("%s %d: expected %v", opToStr(c.op), c.v, c.expected)
+		}
+	}
+}
+
+func TestColumnarScanPlan(t *testing.T) {
+	f := makeColumnarFile(t)
+	age := &FieldExpr{FieldType{"age", "t", IntType}}
+	name := &FieldExpr{FieldType{"name", "t", StringType}}
+	gt := &Filter{op: OpGt, left: age, right: &ConstExpr{IntField{30}, IntType}}
+	like := &Filter{op: OpLike, left: name, right: &ConstExpr{StringField{"j%"}, StringType}}
+
+	node := filterChain(f, gt, like)
+	if plan := useColumnarScan(node, map[string]bool{"age": true, "name": true}); plan != node {
+		t.Fatalf("expected the filters to stay")
+	}
+	base := node.Op.(*Filter).child.(*OperatorCard).Op.(*Filter).child.(*OperatorCard)
+	scan, ok := base.Op.(*ColumnarScan)
+	if !ok || fmt.Sprint(scan.columns) != "[0 1]" || len(scan.preds) != 1 || scan.preds[0].column != 1 || scan.preds[0].op != OpGt {
+		t.Fatalf("expected a scan of name and age skipping row groups by age, got %+v", base.Op)
+	}
+	if desc := scan.Descriptor(); len(desc.Fields) != 2 || desc.Fields[1].Fname != "age" {
+		t.Errorf("unexpected TupleDesc %v", desc)
+	}
+	var buf strings.Builder
+	OutputPhysicalPlan(func(format string, a ...any) { fmt.Fprintf(&buf, format, a...) }, base, "")
+	if s := buf.String(); !strings.HasPrefix(s, "Columnar Scan ") || !strings.HasSuffix(s, "t.dat [name, age] (t.age > 30), card:1000\n") {
+		t.Errorf("unexpected plan %q", s)
+	}
+
+	node = filterChain(f)
+	useColumnarScan(node, nil)
+	if scan, ok := node.Op.(*ColumnarScan); !ok || len(scan.columns) != 3 || scan.preds != nil {
+		t.Errorf("expected a scan of every column, got %+v", node.Op)
+	}
+	node = filterChain(f)
+	useColumnarScan(node, map[string]bool{})
+	if scan, ok := node.Op.(*ColumnarScan); !ok || len(scan.columns) != 0 || len(scan.Descriptor().Fields) != 0 {
+		t.Errorf("expected a scan of no column, as in SELECT COUNT(*), got %+v", node.Op)
+	}
+	hf := &HeapFile{}
+	node = filterChain(hf, gt)
+	if useColumnarScan(node, nil); gt.child.(*OperatorCard).Op != Operator(hf) {
+		t.Errorf("expected a heap scan to be kept")
+	}
+}
+
+func TestColumnarCatalogEntries(t *testing.T) {
+	c := NewCatalog("catalog.txt", nil, t.TempDir())
+	if qtype, _, err := Parse(c, "CREATE TABLE t (name varchar(10), age int not null) WITH (format = COLUMNAR);"); err != nil || qtype != CreateTableQueryType {
+		t.Fatalf("expected a columnar table to be created, got %v", err)
+	}
+	table, err := c.GetTableInfo("t")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cf, ok := table.file.(*ColumnarFile)
+	if !ok || cf.column(1).nullable || !cf.column(0).nullable {
+		t.Fatalf("expected a columnar file with the columns of the table, got %+v", table.file)
+	}
+	expected := "t(name varchar(10), age int not null) with (format = columnar)\n"
+	if s := table.String(); s != expected {
+		t.Errorf("expected %q, got %q", expected, s)
+	}
+	if m := catalogOptionsClause.FindStringSubmatchIndex(strings.TrimSpace(expected)); m == nil || expected[m[2]:m[3]] != "format = columnar" || expected[:m[0]+1] != "t(name varchar(10), age int not null)" {
+		t.Errorf("unexpected match %v", m)
+	}
+
+	if _, _, err := Parse(c, "create table u (a int) with (format = parquet)"); err == nil {
+		t.Errorf("expected an unknown format to be rejected")
+	}
+	if _, _, err := Parse(c, "create table u (a int) cluster by (a) with (format = columnar)"); err == nil {
+		t.Errorf("expected a clustered columnar table to be rejected")
+	}
+	if err := c.createIndex("by_age", "t", []string{"age"}, nil, false, ""); err == nil {
+		t.Errorf("expected a columnar table not to be indexed")
+	}
+	for _, q := range []string{"create table t (a int)", "select a from t with (format = columnar)"} {
+		if query, opts, err := extractTableOptions(q); query != q || opts.format != heapFormat || err != nil {
+			t.Errorf("%s: expected no WITH clause", q)
+		}
+	}
+}
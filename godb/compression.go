@@ -0,0 +1,153 @@
+package godb
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+/*
+The pages of a table created with CREATE TABLE ... WITH (compression = flate)
+are compressed when they are written to its file and decompressed when they
+are read, so the BufferPool caches them uncompressed and the rest of GoDB
+never sees the compressed bytes. Such a page is compressedPageSize bytes long
+in memory, but must compress into PageSize bytes on disk, so the file still
+has a page every PageSize bytes; a page holds as many tuples as it can
+without its compressed form growing larger than PageSize (see
+[compressPage]). STRING values, which are padded to StringLength bytes, and
+the repeated values of a column compress well, so a page usually holds
+several times as many tuples as it would uncompressed.
+
+A compressed page starts with compressedPageMagic, the codec it was
+compressed with as a byte, and the length of the compressed bytes as a 16 bit
+little endian integer, followed by the compressed bytes and zeros up to
+PageSize bytes. Pages that do not start with compressedPageMagic, such as
+pages of zeros and overflow pages (see [HeapFile.toast]), are stored
+uncompressed, so a page that is never larger than PageSize need not be
+compressed.
+*/
+
+// The codec the pages of a file are compressed with.
+type pageCompression byte
+
+const (
+	noCompression    pageCompression = 0
+	flateCompression pageCompression = 1 // compress/flate, at BestSpeed
+)
+
+const (
+	compressedPageMagic      uint32 = 0xF5C0F5C0
+	compressedPageHeaderSize int    = 7
+	compressedPageSize       int    = 4 * PageSize // largest page compressed into PageSize bytes
+)
+
+// Return the codec named by the compression option of a table: none or
+// flate. Returns a ParseError for an unknown codec.
+func parsePageCompression(name string) (pageCompression, error) {
+	switch name {
+	case "none":
+		return noCompression, nil
+	case "flate":
+		return flateCompression, nil
+	}
+	return noCompression, GoDBError{ParseError, fmt.Sprintf("unknown compression %s", name)}
+}
+
+// Return the name of the codec, as accepted by [parsePageCompression].
+func (c pageCompression) String() string {
+	if c == flateCompression {
+		return "flate"
+	}
+	return "none"
+}
+
+// flate writers are large, so they are reused across pages.
+var flateWriters = sync.Pool{New: func() any {
+	w, _ := flate.NewWriter(nil, flate.BestSpeed)
+	return w
+}}
+
+// Compress page, the bytes of a page of at most compressedPageSize bytes,
+// into a new buffer of PageSize bytes, in the format described above. Returns
+// a PageFullError if it does not compress into PageSize bytes.
+func compressPage(page []byte) (*bytes.Buffer, error) {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, compressedPageMagic)
+	b.WriteByte(byte(flateCompression))
+	binary.Write(&b, binary.LittleEndian, uint16(0))
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(&b)
+	if _, err := w.Write(page); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if b.Len() > PageSize {
+		return nil, GoDBError{PageFullError, fmt.Sprintf("page of %d bytes compresses into %d bytes", len(page), b.Len()-compressedPageHeaderSize)}
+	}
+	binary.LittleEndian.PutUint16(b.Bytes()[5:], uint16(b.Len()-compressedPageHeaderSize))
+	b.Write(make([]byte, PageSize-b.Len()))
+	return &b, nil
+}
+
+// Return the bytes of the page compressed into buf by [compressPage], or buf
+// itself if it does not hold a compressed page. Returns a MalformedDataError
+// if buf holds a compressed page that cannot be decompressed.
+func decompressPage(buf []byte) ([]byte, error) {
+	if len(buf) < compressedPageHeaderSize || binary.LittleEndian.Uint32(buf) != compressedPageMagic {
+		return buf, nil
+	}
+	if pageCompression(buf[4]) != flateCompression {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("unknown page compression %d", buf[4])}
+	}
+	n := int(binary.LittleEndian.Uint16(buf[5:]))
+	if compressedPageHeaderSize+n > len(buf) {
+		return nil, GoDBError{MalformedDataError, "truncated compressed page"}
+	}
+	r := flate.NewReader(bytes.NewReader(buf[compressedPageHeaderSize : compressedPageHeaderSize+n]))
+	defer r.Close()
+	page, err := io.ReadAll(io.LimitReader(r, int64(compressedPageSize)+1))
+	if err != nil {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("corrupt compressed page: %v", err)}
+	}
+	if len(page) > compressedPageSize {
+		return nil, GoDBError{MalformedDataError, "compressed page is too large"}
+	}
+	return page, nil
+}
+
+// Set the codec the pages of the file are compressed with. Must be called
+// before the file is read or written, as [Catalog.addTableWithOptions] does.
+func (f *HeapFile) setCompression(c pageCompression) {
+	f.compression = c
+}
+
+// Return the size of the pages of the file in memory: compressedPageSize if
+// it is compressed, and PageSize otherwise. Overflow pages are always
+// PageSize bytes.
+func (f *HeapFile) pageSize() int {
+	if f.compression != noCompression {
+		return compressedPageSize
+	}
+	return PageSize
+}
+
+// Set the codec the segments of the file are compressed with. Must be called
+// before the file is read or written, as [Catalog.addTableWithOptions] does.
+func (f *ColumnarFile) setCompression(c pageCompression) {
+	f.compression = c
+}
+
+// Return the size of the segments of the file in memory, as
+// [HeapFile.pageSize] does. Header pages are always PageSize bytes.
+func (f *ColumnarFile) pageSize() int {
+	if f.compression != noCompression {
+		return compressedPageSize
+	}
+	return PageSize
+}
@@ -0,0 +1,169 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestCompressPage(t *testing.T) {
+	page := make([]byte, compressedPageSize)
+	for i := range page {
+		page[i] = byte(i / 100)
+	}
+	buf, err := compressPage(page)
+	if err != nil || buf.Len() != PageSize {
+		t.Fatalf("expected a page of %d bytes, got %v", PageSize, err)
+	}
+	read, err := decompressPage(buf.Bytes())
+	if err != nil || !bytes.Equal(read, page) {
+		t.Fatalf("expected the page to be decompressed, got %v", err)
+	}
+
+	zeros := make([]byte, PageSize)
+	if read, err := decompressPage(zeros); err != nil || len(read) != PageSize {
+		t.Errorf("expected a page of zeros to be returned unchanged, got %v", err)
+	}
+	random := make([]byte, PageSize)
+	rand.New(rand.NewSource(1)).Read(random)
+	if _, err := compressPage(random); err == nil || err.(GoDBError).code != PageFullError {
+		t.Errorf("expected random bytes not to compress into a page, got %v", err)
+	}
+
+	corrupt := append([]byte(nil), buf.Bytes()...)
+	corrupt[4] = 9
+	if _, err := decompressPage(corrupt); err == nil {
+		t.Errorf("expected an unknown codec to be rejected")
+	}
+	corrupt = append([]byte(nil), buf.Bytes()...)
+	corrupt[5], corrupt[6] = 0xff, 0xff
+	if _, err := decompressPage(corrupt); err == nil {
+		t.Errorf("expected a truncated page to be rejected")
+	}
+}
+
+func TestCompressedSlottedPage(t *testing.T) {
+	p := newSlottedPageOfSize(compressedPageSize)
+	rec := []byte(fmt.Sprintf("%-32s", "joe"))
+	for {
+		if _, err := p.insert(rec); err != nil {
+			break
+		}
+	}
+	if n := p.numRecords(); n <= PageSize/len(rec) {
+		t.Fatalf("expected more records than fit in %d bytes, got %d", PageSize, n)
+	}
+	var b bytes.Buffer
+	if err := p.writeTo(&b); err != nil || b.Len() != compressedPageSize {
+		t.Fatalf("expected a page of %d bytes, got %v", compressedPageSize, err)
+	}
+	buf, err := compressPage(b.Bytes())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	page, err := decompressPage(buf.Bytes())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	read, err := readSlottedPageOfSize(page, len(page))
+	if err != nil || read.numRecords() != p.numRecords() || read.freeSpace() != p.freeSpace() {
+		t.Fatalf("expected the slotted page to be read back, got %v", err)
+	}
+	if rec, err := read.get(read.numSlots() - 1); err != nil || strings.TrimSpace(string(rec)) != "joe" {
+		t.Errorf("unexpected last record %q (%v)", rec, err)
+	}
+	if _, err := readSlottedPage(page); err == nil {
+		t.Errorf("expected a page of %d bytes not to be read as a page of PageSize bytes", compressedPageSize)
+	}
+}
+
+func TestCompressedColumnarSegment(t *testing.T) {
+	f := makeColumnarFile(t)
+	fill := func(seg *columnarPage) {
+		for i := 0; ; i++ {
+			v := StringField{fmt.Sprintf("name %d", i%10)}
+			s, ok, err := seg.statsWith(v)
+			if err == nil && ok && seg.file.compression != noCompression {
+				ok, err = seg.compressesWith(v, s)
+			}
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if !ok {
+				return
+			}
+			seg.values = append(seg.values, v)
+			seg.segmentStats = s
+		}
+	}
+	plain := newColumnarPage(f, 1)
+	fill(plain)
+	f.setCompression(flateCompression)
+	seg := newColumnarPage(f, 1)
+	fill(seg)
+	if len(seg.values) <= 2*len(plain.values) {
+		t.Fatalf("expected a compressed segment to hold more values than %d, got %d", len(plain.values), len(seg.values))
+	}
+	buf, err := seg.toBuffer()
+	if err != nil || buf.Len() != PageSize {
+		t.Fatalf("expected a page of %d bytes, got %v", PageSize, err)
+	}
+	read := newColumnarPage(f, 1)
+	if err := read.initFromBuffer(bytes.NewBuffer(buf.Bytes())); err != nil || len(read.values) != len(seg.values) || read.segmentStats != seg.segmentStats {
+		t.Fatalf("expected the segment to be read back, got %v", err)
+	}
+	if !bytes.Equal(read.beforeImage, buf.Bytes()) {
+		t.Errorf("expected the before image to be the compressed page")
+	}
+
+	// header pages are never compressed
+	header := newColumnarPage(f, 0)
+	header.deleted = make([]bool, 100)
+	if buf, err := header.toBuffer(); err != nil || binary.LittleEndian.Uint32(buf.Bytes()) != columnarPageMagic {
+		t.Errorf("expected an uncompressed header page, got %v", err)
+	}
+}
+
+func TestTableOptions(t *testing.T) {
+	opts, err := parseTableOptions(" Compression = FLATE,format=columnar ")
+	if err != nil || opts.format != columnarFormat || opts.compression != flateCompression {
+		t.Fatalf("unexpected options %+v (%v)", opts, err)
+	}
+	if s := opts.String(); s != "with (format = columnar, compression = flate)" {
+		t.Errorf("unexpected clause %q", s)
+	}
+	if opts, err := parseTableOptions("compression = none"); err != nil || opts.format != heapFormat || opts.String() != "" {
+		t.Errorf("expected default options, got %+v (%v)", opts, err)
+	}
+	for _, list := range []string{"compression = zstd", "fillfactor = 50", "format", ""} {
+		if _, err := parseTableOptions(list); err == nil {
+			t.Errorf("%q: expected an error", list)
+		}
+	}
+
+	c := NewCatalog("catalog.txt", nil, t.TempDir())
+	if _, _, err := Parse(c, "create table t (name varchar(10), age int) with (compression = flate, format = columnar);"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	table, err := c.GetTableInfo("t")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if cf, ok := table.file.(*ColumnarFile); !ok || cf.compression != flateCompression {
+		t.Fatalf("expected a compressed columnar file, got %+v", table.file)
+	}
+	expected := "t(name varchar(10), age int) with (format = columnar, compression = flate)\n"
+	if s := table.String(); s != expected {
+		t.Errorf("expected %q, got %q", expected, s)
+	}
+	if _, _, err := Parse(c, "create table u (a int) with (compression = lz4)"); err == nil {
+		t.Errorf("expected an unknown codec to be rejected")
+	}
+	query, opts, err := extractTableOptions("CREATE TABLE u (a int) CLUSTER BY (a) WITH (compression = flate)")
+	if err != nil || query != "CREATE TABLE u (a int) CLUSTER BY (a)" || opts.compression != flateCompression || opts.format != heapFormat {
+		t.Errorf("unexpected statement %q and options %+v (%v)", query, opts, err)
+	}
+}
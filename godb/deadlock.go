@@ -0,0 +1,293 @@
+package godb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+The LockManager detects deadlocks with a wait-for graph, which has an edge from
+transaction t1 to transaction t2 when a request of t1 is waiting for t2: t2
+holds the lock in a mode incompatible with the request, or t2 has an earlier
+request for the same lock that is still waiting (requests are granted in
+order). Every time a request has to wait, the LockManager looks for a cycle in
+the graph through the waiting transaction; if there is one, it breaks the
+cycle by refusing the request of one of the transactions in it (the victim),
+which then gets a DeadlockError and should abort.
+
+Alternatively, the LockManager can prevent deadlocks instead of detecting them
+(see [DeadlockMode]), using transaction ids as timestamps: ids are assigned in
+increasing order by [NewTID], so a transaction with a lower id is older. When
+a request would make a transaction wait for others,
+
+  - with wait-die, an older requester waits, and a younger one dies: its
+    request fails with a DeadlockError;
+  - with wound-wait, an older requester wounds the younger transactions it
+    would wait for and then waits, and a younger requester waits. A wounded
+    transaction that is waiting for a lock gets a DeadlockError right away;
+    one that is running gets a DeadlockError on its next lock request.
+
+Either way, transactions only ever wait for younger (wait-die) or older
+(wound-wait) transactions, so no cycle can form. Some transactions may be
+aborted although there would have been no deadlock.
+*/
+
+// How the LockManager deals with deadlocks.
+type DeadlockMode int
+
+const (
+	// Detect deadlocks with the wait-for graph and abort a victim.
+	DeadlockDetection DeadlockMode = iota
+	// Prevent deadlocks by aborting younger transactions that would wait.
+	WaitDie DeadlockMode = iota
+	// Prevent deadlocks by aborting younger transactions older ones would
+	// wait for.
+	WoundWait DeadlockMode = iota
+)
+
+func (m DeadlockMode) String() string {
+	switch m {
+	case DeadlockDetection:
+		return "detection"
+	case WaitDie:
+		return "wait-die"
+	case WoundWait:
+		return "wound-wait"
+	}
+	return "unknown"
+}
+
+// How the victim of a deadlock is chosen among the transactions in a cycle of
+// the wait-for graph. Ties are broken by choosing the youngest transaction.
+type VictimPolicy int
+
+const (
+	// Abort the youngest transaction, i.e., the one with the highest id (ids
+	// are assigned in increasing order by [NewTID]).
+	VictimYoungest VictimPolicy = iota
+	// Abort the transaction holding the fewest locks.
+	VictimFewestLocks VictimPolicy = iota
+	// Abort the transaction that has done the least work, measured as the
+	// number of locks it has been granted, including locks it has since
+	// released.
+	VictimLeastWork VictimPolicy = iota
+)
+
+func (p VictimPolicy) String() string {
+	switch p {
+	case VictimYoungest:
+		return "youngest"
+	case VictimFewestLocks:
+		return "fewest locks"
+	case VictimLeastWork:
+		return "least work"
+	}
+	return "unknown"
+}
+
+// A wait-for graph, mapping each waiting transaction to the transactions it is
+// waiting for.
+type WaitForGraph map[TransactionID][]TransactionID
+
+// Return the graph as one line per waiting transaction, e.g. "3 -> 1, 2", in
+// order of transaction id.
+func (g WaitForGraph) String() string {
+	tids := make([]TransactionID, 0, len(g))
+	for tid := range g {
+		tids = append(tids, tid)
+	}
+	sort.Slice(tids, func(i, j int) bool { return tids[i] < tids[j] })
+	var b strings.Builder
+	for _, tid := range tids {
+		targets := make([]string, len(g[tid]))
+		for i, t := range g[tid] {
+			targets[i] = fmt.Sprint(t)
+		}
+		fmt.Fprintf(&b, "%d -> %s\n", tid, strings.Join(targets, ", "))
+	}
+	return b.String()
+}
+
+// Return a cycle of the graph through tid, as the list of transactions in it
+// starting with tid, or nil if there is none.
+func (g WaitForGraph) cycleThrough(tid TransactionID) []TransactionID {
+	visited := make(map[TransactionID]bool)
+	var path []TransactionID
+	var visit func(t TransactionID) bool
+	visit = func(t TransactionID) bool {
+		path = append(path, t)
+		for _, next := range g[t] {
+			if next == tid {
+				return true
+			}
+			if !visited[next] {
+				visited[next] = true
+				if visit(next) {
+					return true
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	visited[tid] = true
+	if visit(tid) {
+		return path
+	}
+	return nil
+}
+
+// Return the transactions the request at position i of the queue waits for:
+// those holding the lock in a mode incompatible with the request, and those
+// with earlier requests in the queue. Must be called with lm.mu held.
+func (st *lockState) blockers(i int) []TransactionID {
+	w := st.queue[i]
+	seen := make(map[TransactionID]bool)
+	var blockers []TransactionID
+	add := func(t TransactionID) {
+		if t != w.tid && !seen[t] {
+			seen[t] = true
+			blockers = append(blockers, t)
+		}
+	}
+	for holder, held := range st.holders {
+		if !held.compatible(w.mode) {
+			add(holder)
+		}
+	}
+	for _, earlier := range st.queue[:i] {
+		add(earlier.tid)
+	}
+	sort.Slice(blockers, func(i, j int) bool { return blockers[i] < blockers[j] })
+	return blockers
+}
+
+// Build the wait-for graph from the current lock state. Must be called with
+// lm.mu held.
+func (lm *LockManager) waitForGraphLocked() WaitForGraph {
+	g := make(WaitForGraph)
+	for _, st := range lm.locks {
+		for i, w := range st.queue {
+			for _, t := range st.blockers(i) {
+				if !containsTID(g[w.tid], t) {
+					g[w.tid] = append(g[w.tid], t)
+				}
+			}
+		}
+	}
+	for _, targets := range g {
+		sort.Slice(targets, func(i, j int) bool { return targets[i] < targets[j] })
+	}
+	return g
+}
+
+func containsTID(tids []TransactionID, tid TransactionID) bool {
+	for _, t := range tids {
+		if t == tid {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the current wait-for graph, for diagnostics.
+func (lm *LockManager) WaitForGraph() WaitForGraph {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return lm.waitForGraphLocked()
+}
+
+// Set how deadlocks are dealt with. Changing the mode affects requests made
+// from now on.
+func (lm *LockManager) SetDeadlockMode(m DeadlockMode) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	lm.mode = m
+}
+
+// Set the policy used to choose the victim of a deadlock when deadlocks are
+// detected.
+func (lm *LockManager) SetVictimPolicy(p VictimPolicy) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	lm.policy = p
+}
+
+// Choose the victim among the transactions of a cycle according to the
+// policy. Must be called with lm.mu held.
+func (lm *LockManager) chooseVictimLocked(cycle []TransactionID) TransactionID {
+	cost := func(tid TransactionID) int {
+		switch lm.policy {
+		case VictimFewestLocks:
+			return len(lm.held[tid])
+		case VictimLeastWork:
+			return lm.work[tid]
+		}
+		return 0
+	}
+	victim := cycle[0]
+	for _, tid := range cycle[1:] {
+		if c, vc := cost(tid), cost(victim); c < vc || (c == vc && tid > victim) {
+			victim = tid
+		}
+	}
+	return victim
+}
+
+// Break every deadlock that tid, which has just started waiting, is part of.
+// Returns a DeadlockError if tid is chosen as a victim; the requests of other
+// victims are refused. Must be called with lm.mu held.
+func (lm *LockManager) detectDeadlockLocked(tid TransactionID) error {
+	for {
+		cycle := lm.waitForGraphLocked().cycleThrough(tid)
+		if cycle == nil {
+			return nil
+		}
+		victim := lm.chooseVictimLocked(cycle)
+		err := GoDBError{DeadlockError, fmt.Sprintf("transaction %d aborted to break deadlock %v (victim policy: %v)", victim, cycle, lm.policy)}
+		if victim == tid {
+			return err
+		}
+		for _, w := range append([]*lockWaiter{}, lm.waiting[victim]...) {
+			lm.cancelWaiterLocked(w, err)
+		}
+	}
+}
+
+// Deal with the deadlocks that w, a request that has just started waiting,
+// may cause, according to the deadlock mode. Returns a DeadlockError if the
+// request must not wait. Must be called with lm.mu held.
+func (lm *LockManager) handleWaitLocked(w *lockWaiter) error {
+	switch lm.mode {
+	case WaitDie, WoundWait:
+		st := lm.locks[w.key]
+		var blockers []TransactionID
+		for i, w2 := range st.queue {
+			if w2 == w {
+				blockers = st.blockers(i)
+			}
+		}
+		for _, b := range blockers {
+			if lm.mode == WaitDie && b < w.tid {
+				return GoDBError{DeadlockError, fmt.Sprintf("transaction %d aborted rather than wait for older transaction %d (wait-die)", w.tid, b)}
+			}
+			if lm.mode == WoundWait && b > w.tid {
+				lm.woundLocked(b, w.tid)
+			}
+		}
+		return nil
+	}
+	return lm.detectDeadlockLocked(w.tid)
+}
+
+// Wound tid, which an older transaction would wait for: refuse the requests it
+// is waiting for, and any request it makes until it ends. Must be called with
+// lm.mu held.
+func (lm *LockManager) woundLocked(tid TransactionID, by TransactionID) {
+	err := GoDBError{DeadlockError, fmt.Sprintf("transaction %d aborted as older transaction %d waits for it (wound-wait)", tid, by)}
+	lm.wounded[tid] = err
+	for _, w := range append([]*lockWaiter{}, lm.waiting[tid]...) {
+		lm.cancelWaiterLocked(w, err)
+	}
+}
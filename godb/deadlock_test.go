@@ -165,3 +165,193 @@ func TestDeadlockUpgradeWrite(t *testing.T) {
 		fmt.Println("should not be nil")
 	}
 }
+
+// Return the DeadlockError of the request, failing if it was granted or did not
+// complete.
+func expectDeadlock(t *testing.T, done chan error) {
+	t.Helper()
+	select {
+	case err := <-done:
+		if gerr, ok := err.(GoDBError); !ok || gerr.code != DeadlockError {
+			t.Fatalf("expected a DeadlockError, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the request to fail with a DeadlockError")
+	}
+}
+
+// Set up a deadlock in which the older transaction holds fewer locks but has
+// done more work than the younger one, and check that each victim policy
+// chooses the expected transaction.
+func TestDeadlockVictimPolicies(t *testing.T) {
+	for _, policy := range []VictimPolicy{VictimYoungest, VictimFewestLocks, VictimLeastWork} {
+		lm := NewLockManager()
+		lm.SetVictimPolicy(policy)
+		older, younger := NewTID(), NewTID()
+		for i := 0; i < 5; i++ {
+			lm.acquire(older, i, SharedLock)
+			lm.release(older, i)
+		}
+		lm.acquire(older, "a", SharedLock)
+		lm.acquire(younger, "b", SharedLock)
+		lm.acquire(younger, "c", SharedLock)
+
+		first := acquireAsync(lm, older, "b", ExclusiveLock)
+		if granted(t, first) {
+			t.Fatalf("%v: the first request should wait", policy)
+		}
+		second := acquireAsync(lm, younger, "a", ExclusiveLock)
+
+		victim, survivor := older, younger
+		victimReq, survivorReq := first, second
+		if policy == VictimYoungest || policy == VictimLeastWork {
+			victim, survivor = younger, older
+			victimReq, survivorReq = second, first
+		}
+		expectDeadlock(t, victimReq)
+		if g := lm.WaitForGraph(); g.cycleThrough(survivor) != nil {
+			t.Errorf("%v: expected the deadlock to be broken, wait-for graph is\n%s", policy, g)
+		}
+		lm.releaseAll(victim)
+		if !granted(t, survivorReq) {
+			t.Errorf("%v: the survivor's request should be granted once the victim aborts", policy)
+		}
+		lm.releaseAll(survivor)
+	}
+}
+
+func TestDeadlockThreeWayCycle(t *testing.T) {
+	lm := NewLockManager()
+	t1, t2, t3 := NewTID(), NewTID(), NewTID()
+	lm.acquire(t1, "a", ExclusiveLock)
+	lm.acquire(t2, "b", ExclusiveLock)
+	lm.acquire(t3, "c", ExclusiveLock)
+	r1 := acquireAsync(lm, t1, "b", SharedLock)
+	r2 := acquireAsync(lm, t2, "c", SharedLock)
+	if granted(t, r1) || granted(t, r2) {
+		t.Fatalf("requests should wait")
+	}
+	if g := lm.WaitForGraph(); g.String() != fmt.Sprintf("%d -> %d\n%d -> %d\n", t1, t2, t2, t3) {
+		t.Errorf("unexpected wait-for graph\n%s", g)
+	}
+	r3 := acquireAsync(lm, t3, "a", SharedLock)
+	expectDeadlock(t, r3)
+	lm.releaseAll(t3)
+	if !granted(t, r2) {
+		t.Fatalf("t2 should proceed once t3 aborts")
+	}
+	lm.releaseAll(t2)
+	if !granted(t, r1) {
+		t.Fatalf("t1 should proceed once t2 commits")
+	}
+	lm.releaseAll(t1)
+}
+
+// Requests waiting behind another request for the same lock wait for it too.
+func TestDeadlockWaitForGraphQueue(t *testing.T) {
+	lm := NewLockManager()
+	t1, t2, t3 := NewTID(), NewTID(), NewTID()
+	lm.acquire(t1, "a", SharedLock)
+	r2 := acquireAsync(lm, t2, "a", ExclusiveLock)
+	granted(t, r2)
+	r3 := acquireAsync(lm, t3, "a", SharedLock)
+	if granted(t, r3) {
+		t.Fatalf("a shared request should wait behind an exclusive one")
+	}
+	g := lm.WaitForGraph()
+	if len(g[t2]) != 1 || g[t2][0] != t1 || len(g[t3]) != 1 || g[t3][0] != t2 {
+		t.Errorf("unexpected wait-for graph\n%s", g)
+	}
+
+	// t1 now waiting for t3 closes a cycle through the queue; t3 is the
+	// youngest, so its request is refused
+	lm.acquire(t3, "b", SharedLock)
+	r1 := acquireAsync(lm, t1, "b", ExclusiveLock)
+	expectDeadlock(t, r3)
+	lm.releaseAll(t3)
+	if !granted(t, r1) {
+		t.Fatalf("t1 should proceed once t3 aborts")
+	}
+	lm.releaseAll(t1)
+	if !granted(t, r2) {
+		t.Fatalf("t2 should proceed once t1 commits")
+	}
+	lm.releaseAll(t2)
+}
+
+func TestDeadlockWaitDie(t *testing.T) {
+	lm := NewLockManager()
+	lm.SetDeadlockMode(WaitDie)
+	older, younger := NewTID(), NewTID()
+	lm.acquire(older, "a", ExclusiveLock)
+	lm.acquire(younger, "b", ExclusiveLock)
+
+	expectDeadlock(t, acquireAsync(lm, younger, "a", SharedLock))
+	wait := acquireAsync(lm, older, "b", SharedLock)
+	if granted(t, wait) {
+		t.Fatalf("an older transaction should wait for a younger one")
+	}
+	lm.releaseAll(younger)
+	if !granted(t, wait) {
+		t.Fatalf("the older transaction should proceed once the younger one aborts")
+	}
+	lm.releaseAll(older)
+}
+
+func TestDeadlockWoundWait(t *testing.T) {
+	lm := NewLockManager()
+	lm.SetDeadlockMode(WoundWait)
+	oldest, older, younger := NewTID(), NewTID(), NewTID()
+	lm.acquire(oldest, "a", ExclusiveLock)
+	lm.acquire(younger, "b", ExclusiveLock)
+
+	// a younger transaction waits for an older one
+	wait := acquireAsync(lm, younger, "a", SharedLock)
+	if granted(t, wait) {
+		t.Fatalf("a younger transaction should wait for an older one")
+	}
+	// an older transaction wounds a younger one; as it is waiting, its request
+	// is refused
+	wound := acquireAsync(lm, older, "b", SharedLock)
+	expectDeadlock(t, wait)
+	if granted(t, wound) {
+		t.Fatalf("the older transaction should wait for the wounded one to abort")
+	}
+	// a wounded transaction's later requests are refused too
+	expectDeadlock(t, acquireAsync(lm, younger, "c", SharedLock))
+	lm.releaseAll(younger)
+	if !granted(t, wound) {
+		t.Fatalf("the older transaction should proceed once the wounded one aborts")
+	}
+	lm.releaseAll(older)
+	lm.releaseAll(oldest)
+
+	// the wound is forgotten once the transaction ends
+	if !granted(t, acquireAsync(lm, younger, "c", SharedLock)) {
+		t.Errorf("expected the wound to be forgotten after the transaction ended")
+	}
+	lm.releaseAll(younger)
+}
+
+// In each mode, the younger of two transactions that would deadlock gets a
+// DeadlockError, and the older proceeds once it aborts. The mode can be changed
+// between transactions.
+func TestDeadlockModes(t *testing.T) {
+	bp := &BufferPool{}
+	for _, mode := range []DeadlockMode{DeadlockDetection, WaitDie, WoundWait} {
+		bp.SetDeadlockMode(mode)
+		t1, t2 := NewTID(), NewTID()
+		bp.lockPage(t1, "p0", ReadPerm, LockWait)
+		bp.lockPage(t2, "p1", ReadPerm, LockWait)
+		w1 := lockPageAsync(bp, t1, "p1", WritePerm)
+		if granted(t, w1) {
+			t.Fatalf("%v: t1 should wait for t2", mode)
+		}
+		expectDeadlock(t, lockPageAsync(bp, t2, "p0", WritePerm))
+		bp.releaseLocks(t2)
+		if !granted(t, w1) {
+			t.Fatalf("%v: t1 should proceed once t2 aborts", mode)
+		}
+		bp.releaseLocks(t1)
+	}
+}
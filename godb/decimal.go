@@ -0,0 +1,310 @@
+package godb
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+/*
+DECIMAL(p, s) (or NUMERIC(p, s)) columns hold exact decimal numbers of at most
+p digits, s of which are after the decimal point, as [DecimalField] values.
+DECIMAL(p) is DECIMAL(p, 0), and DECIMAL without a precision holds values of
+any scale. A DecimalField is an unscaled integer and a scale, so 12.50 is
+1250 with scale 2; it never has more than maxDecimalPrecision digits, so that
+it fits in an int64, nor more than maxDecimalPrecision digits after the
+decimal point.
+
+Values stored in a DECIMAL(p, s) column are rounded to s digits after the
+decimal point, and a value that then has more than p digits is a
+NumericOverflowError. Rounding is always half away from zero, e.g. 2.5 rounds
+to 3 and -2.5 to -3.
+
+Arithmetic is exact where possible, and any result with more than
+maxDecimalPrecision digits is a NumericOverflowError:
+
+  - the scale of a sum or difference is the larger scale of its operands;
+  - the scale of a product is the sum of the scales of its operands, reduced
+    (by rounding) down to the larger of the two if the exact product does
+    not fit;
+  - the scale of a quotient is the scale of the dividend plus
+    decimalDivisionScale, reduced down to the scale of the dividend if the
+    quotient does not fit; dividing by zero is a DivisionByZeroError.
+
+Numeric literals with a decimal point, e.g. 12.50, are DECIMAL values;
+literals with an exponent, e.g. 1.25e1, are FLOAT values.
+*/
+
+const (
+	maxDecimalPrecision  = 18
+	decimalDivisionScale = 4
+)
+
+// The precision and scale of a DECIMAL column; the zero value means the
+// column has no precision, and holds values of any scale.
+type decimalSpec struct {
+	precision int
+	scale     int
+}
+
+var decimalLimit = new(big.Int).Exp(big.NewInt(10), big.NewInt(maxDecimalPrecision), nil)
+
+// Return 10^n, for n >= 0. Scales are never larger than maxDecimalPrecision,
+// so a negative n is a bug in the caller, which would otherwise get 1.
+func pow10(n int) *big.Int {
+	if n < 0 {
+		panic(fmt.Sprintf("negative power of ten 10^%d", n))
+	}
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// Return n / d rounded half away from zero.
+func roundQuo(n *big.Int, d *big.Int) *big.Int {
+	q, r := new(big.Int).QuoRem(n, d, new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(r), big.NewInt(2)).Cmp(new(big.Int).Abs(d)) >= 0 {
+		if (n.Sign() < 0) != (d.Sign() < 0) {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
+
+// Return the decimal v / 10^scale, or a NumericOverflowError if v has more
+// than maxDecimalPrecision digits.
+func newDecimal(v *big.Int, scale int) (DecimalField, error) {
+	if new(big.Int).Abs(v).Cmp(decimalLimit) >= 0 {
+		return DecimalField{}, GoDBError{NumericOverflowError, fmt.Sprintf("%s has more than %d digits", DecimalField{0, scale}.format(v), maxDecimalPrecision)}
+	}
+	return DecimalField{v.Int64(), scale}, nil
+}
+
+func (d DecimalField) big() *big.Int {
+	return big.NewInt(d.Value)
+}
+
+// Return d with the specified scale, rounding it if the scale is smaller.
+func (d DecimalField) rescale(scale int) (DecimalField, error) {
+	switch {
+	case scale > d.Scale:
+		return newDecimal(new(big.Int).Mul(d.big(), pow10(scale-d.Scale)), scale)
+	case scale < d.Scale:
+		return newDecimal(roundQuo(d.big(), pow10(d.Scale-scale)), scale)
+	}
+	return d, nil
+}
+
+// Return the decimal v / 10^scale, rounded to fewer digits after the decimal
+// point, but no fewer than minScale, if it does not fit, and to at most
+// maxDecimalPrecision digits after the decimal point. minScale must not be
+// larger than maxDecimalPrecision.
+func fitDecimal(v *big.Int, scale int, minScale int) (DecimalField, error) {
+	if scale > maxDecimalPrecision {
+		v = roundQuo(v, pow10(scale-maxDecimalPrecision))
+		scale = maxDecimalPrecision
+	}
+	d, err := newDecimal(v, scale)
+	for err != nil && scale > minScale {
+		scale--
+		v = roundQuo(v, big.NewInt(10))
+		d, err = newDecimal(v, scale)
+	}
+	return d, err
+}
+
+// Return -1, 0 or 1 if d is less than, equal to or greater than e.
+func (d DecimalField) compare(e DecimalField) int {
+	x, y := d.big(), e.big()
+	if d.Scale < e.Scale {
+		x.Mul(x, pow10(e.Scale-d.Scale))
+	} else {
+		y.Mul(y, pow10(d.Scale-e.Scale))
+	}
+	return x.Cmp(y)
+}
+
+func (d DecimalField) String() string {
+	return d.format(d.big())
+}
+
+// Return the decimal v / 10^d.Scale as a string, e.g. "-0.05".
+func (d DecimalField) format(v *big.Int) string {
+	s := new(big.Int).Abs(v).String()
+	if d.Scale > 0 {
+		if len(s) <= d.Scale {
+			s = strings.Repeat("0", d.Scale-len(s)+1) + s
+		}
+		s = s[:len(s)-d.Scale] + "." + s[len(s)-d.Scale:]
+	}
+	if v.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// Parse a decimal number such as "12.50", "-.5" or "+3", keeping its digits
+// after the decimal point as its scale, unless it has more than
+// maxDecimalPrecision digits, in which case it is rounded to fewer digits after
+// the decimal point. Returns a NumericOverflowError if it has more than
+// maxDecimalPrecision digits after the decimal point.
+func parseDecimal(s string) (DecimalField, error) {
+	s = strings.TrimSpace(s)
+	invalid := GoDBError{TypeMismatchError, fmt.Sprintf("invalid decimal %q", s)}
+	digits := strings.TrimLeft(s, "+-")
+	if len(s)-len(digits) > 1 {
+		return DecimalField{}, invalid
+	}
+	intPart, fracPart, _ := strings.Cut(digits, ".")
+	if intPart == "" && fracPart == "" {
+		return DecimalField{}, invalid
+	}
+	for _, c := range intPart + fracPart {
+		if c < '0' || c > '9' {
+			return DecimalField{}, invalid
+		}
+	}
+	if len(fracPart) > maxDecimalPrecision {
+		return DecimalField{}, GoDBError{NumericOverflowError, fmt.Sprintf("%s has more than %d digits after the decimal point", s, maxDecimalPrecision)}
+	}
+	v, _ := new(big.Int).SetString("0"+intPart+fracPart, 10)
+	if strings.HasPrefix(s, "-") {
+		v.Neg(v)
+	}
+	return fitDecimal(v, len(fracPart), 0)
+}
+
+// Return d + e.
+func decimalAdd(d DecimalField, e DecimalField) (DecimalField, error) {
+	scale := max(d.Scale, e.Scale)
+	x, err := d.rescale(scale)
+	if err != nil {
+		return DecimalField{}, err
+	}
+	y, err := e.rescale(scale)
+	if err != nil {
+		return DecimalField{}, err
+	}
+	return newDecimal(new(big.Int).Add(x.big(), y.big()), scale)
+}
+
+// Return d - e.
+func decimalSub(d DecimalField, e DecimalField) (DecimalField, error) {
+	return decimalAdd(d, DecimalField{-e.Value, e.Scale})
+}
+
+// Return d * e.
+func decimalMul(d DecimalField, e DecimalField) (DecimalField, error) {
+	return fitDecimal(new(big.Int).Mul(d.big(), e.big()), d.Scale+e.Scale, max(d.Scale, e.Scale))
+}
+
+// Return d / e, or a DivisionByZeroError if e is zero.
+func decimalDiv(d DecimalField, e DecimalField) (DecimalField, error) {
+	if e.Value == 0 {
+		return DecimalField{}, GoDBError{DivisionByZeroError, fmt.Sprintf("%v / %v", d, e)}
+	}
+	// d / e with scale s is (d.Value * 10^(s - d.Scale + e.Scale)) / e.Value
+	scale := min(d.Scale+decimalDivisionScale, maxDecimalPrecision)
+	n := new(big.Int).Mul(d.big(), pow10(scale-d.Scale+e.Scale))
+	return fitDecimal(roundQuo(n, e.big()), scale, d.Scale)
+}
+
+// Parse the precision and scale of a DECIMAL column, written as "p" or "p,s",
+// or "" if the column has no precision.
+func parseDecimalSpec(length string) (decimalSpec, error) {
+	if strings.TrimSpace(length) == "" {
+		return decimalSpec{}, nil
+	}
+	p, s, hasScale := strings.Cut(length, ",")
+	precision, err := strconv.Atoi(strings.TrimSpace(p))
+	scale := 0
+	if err == nil && hasScale {
+		scale, err = strconv.Atoi(strings.TrimSpace(s))
+	}
+	if err != nil || precision < 1 || precision > maxDecimalPrecision || scale < 0 || scale > precision {
+		return decimalSpec{}, GoDBError{ParseError, fmt.Sprintf("invalid decimal precision and scale %s (precision must be at most %d)", length, maxDecimalPrecision)}
+	}
+	return decimalSpec{precision, scale}, nil
+}
+
+func (s decimalSpec) String() string {
+	if s.precision == 0 {
+		return "decimal"
+	}
+	return fmt.Sprintf("decimal(%d,%d)", s.precision, s.scale)
+}
+
+// Return d rounded to the scale of a column with the spec, or a
+// NumericOverflowError if it has too many digits for the column.
+func (s decimalSpec) fit(d DecimalField) (DecimalField, error) {
+	if s.precision == 0 {
+		return d, nil
+	}
+	r, err := d.rescale(s.scale)
+	if err == nil && new(big.Int).Abs(r.big()).Cmp(pow10(s.precision)) >= 0 {
+		err = GoDBError{NumericOverflowError, fmt.Sprintf("%v does not fit in %v", d, s)}
+	}
+	return r, err
+}
+
+// Round the decimal fields of t to the scales of their columns (see
+// [decimalSpec.fit]), returning a NumericOverflowError if one does not fit.
+func (f *HeapFile) roundDecimals(t *Tuple) error {
+	for i, field := range t.Fields {
+		d, ok := field.(DecimalField)
+		if !ok {
+			continue
+		}
+		r, err := f.column(i).decimal.fit(d)
+		if err != nil {
+			return err
+		}
+		t.Fields[i] = r
+	}
+	return nil
+}
+
+// The running sum of the decimals aggregated by SUM or AVG, which is exact
+// however many values are added; SUM fails with a NumericOverflowError if the
+// sum has more than maxDecimalPrecision digits (see [decimalSum.total]).
+type decimalSum struct {
+	sum   *big.Int
+	scale int
+	count int
+}
+
+// Add d to the sum, whose scale is the largest scale of the values added.
+func (s *decimalSum) add(d DecimalField) {
+	if s.sum == nil {
+		s.sum = new(big.Int)
+	}
+	if d.Scale > s.scale {
+		s.sum.Mul(s.sum, pow10(d.Scale-s.scale))
+		s.scale = d.Scale
+	}
+	s.sum.Add(s.sum, new(big.Int).Mul(d.big(), pow10(s.scale-d.Scale)))
+	s.count++
+}
+
+// Return the sum as a DecimalField, or NULL if no values were added. Returns a
+// NumericOverflowError if the sum overflows.
+func (s *decimalSum) total() (DBValue, error) {
+	if s.count == 0 {
+		return NullField{}, nil
+	}
+	return newDecimal(s.sum, s.scale)
+}
+
+// Return the average of the values as a DecimalField with decimalDivisionScale
+// more digits after the decimal point than the sum (or fewer, but no fewer than
+// the sum, if it does not fit), or NULL if no values were added. Returns a
+// NumericOverflowError if the average overflows.
+func (s *decimalSum) average() (DBValue, error) {
+	if s.count == 0 {
+		return NullField{}, nil
+	}
+	scale := min(s.scale+decimalDivisionScale, maxDecimalPrecision)
+	n := new(big.Int).Mul(s.sum, pow10(scale-s.scale))
+	return fitDecimal(roundQuo(n, big.NewInt(int64(s.count))), scale, s.scale)
+}
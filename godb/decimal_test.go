@@ -0,0 +1,339 @@
+package godb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func mustDecimal(t *testing.T, s string) DecimalField {
+	t.Helper()
+	d, err := parseDecimal(s)
+	if err != nil {
+		t.Fatalf("%s: %v", s, err)
+	}
+	return d
+}
+
+func TestDecimalParse(t *testing.T) {
+	values := map[string]DecimalField{
+		"12.50": {1250, 2},
+		"-0.05": {-5, 2},
+		"+3":    {3, 0},
+		".5":    {5, 1},
+		"7.":    {7, 0},
+		" 1.0 ": {10, 1},
+	}
+	for s, expected := range values {
+		if d := mustDecimal(t, s); d != expected {
+			t.Errorf("%q: expected %+v, got %+v", s, expected, d)
+		}
+	}
+	formatted := map[DecimalField]string{{1250, 2}: "12.50", {-5, 2}: "-0.05", {3, 0}: "3", {-123, 3}: "-0.123"}
+	for d, expected := range formatted {
+		if s := d.String(); s != expected {
+			t.Errorf("%+v: expected %s, got %s", d, expected, s)
+		}
+	}
+	for _, s := range []string{"", ".", "1.2.3", "--1", "1e5", "abc"} {
+		if _, err := parseDecimal(s); err == nil {
+			t.Errorf("%q: expected an invalid decimal to be rejected", s)
+		}
+	}
+	_, err := parseDecimal("1234567890123456789")
+	if err == nil || err.(GoDBError).code != NumericOverflowError {
+		t.Errorf("expected a decimal with 19 digits to overflow, got %v", err)
+	}
+	if d := mustDecimal(t, "0.000000000000000001"); d != (DecimalField{1, maxDecimalPrecision}) {
+		t.Errorf("expected a decimal with %d digits after the point, got %+v", maxDecimalPrecision, d)
+	}
+	for _, s := range []string{"0.0000000000000000001", "." + strings.Repeat("0", 100000) + "1"} {
+		if _, err := parseDecimal(s); err == nil || err.(GoDBError).code != NumericOverflowError {
+			t.Errorf("expected a decimal with more than %d digits after the point to be rejected, got %v", maxDecimalPrecision, err)
+		}
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a negative power of ten to panic")
+		}
+	}()
+	pow10(-1)
+}
+
+func TestDecimalRounding(t *testing.T) {
+	cases := []struct {
+		in       string
+		scale    int
+		expected string
+	}{
+		{"2.5", 0, "3"},
+		{"-2.5", 0, "-3"},
+		{"2.49", 1, "2.5"},
+		{"1.005", 2, "1.01"},
+		{"-1.004", 2, "-1.00"},
+		{"1.5", 3, "1.500"},
+	}
+	for _, c := range cases {
+		d, err := mustDecimal(t, c.in).rescale(c.scale)
+		if err != nil || d.String() != c.expected {
+			t.Errorf("%s to scale %d: expected %s, got %v (%v)", c.in, c.scale, c.expected, d, err)
+		}
+	}
+
+	spec, err := parseDecimalSpec("5,2")
+	if err != nil || spec != (decimalSpec{5, 2}) {
+		t.Fatalf("unexpected spec %+v (%v)", spec, err)
+	}
+	if d, err := spec.fit(mustDecimal(t, "999.994")); err != nil || d.String() != "999.99" {
+		t.Errorf("expected 999.99, got %v (%v)", d, err)
+	}
+	for _, s := range []string{"999.995", "1000", "-1000.1"} {
+		if _, err := spec.fit(mustDecimal(t, s)); err == nil || err.(GoDBError).code != NumericOverflowError {
+			t.Errorf("%s: expected an overflow in decimal(5,2), got %v", s, err)
+		}
+	}
+	if d, err := (decimalSpec{}).fit(mustDecimal(t, "1.23456")); err != nil || d.String() != "1.23456" {
+		t.Errorf("expected a decimal without precision to be unchanged, got %v (%v)", d, err)
+	}
+}
+
+func TestDecimalArithmetic(t *testing.T) {
+	cases := []struct {
+		op       func(DecimalField, DecimalField) (DecimalField, error)
+		x, y     string
+		expected string
+	}{
+		{decimalAdd, "1.5", "2.25", "3.75"},
+		{decimalSub, "1.5", "2.25", "-0.75"},
+		{decimalMul, "1.5", "2.25", "3.375"},
+		{decimalDiv, "1", "3", "0.3333"},
+		{decimalDiv, "2.00", "3", "0.666667"},
+		{decimalDiv, "-1", "8", "-0.1250"},
+		// the exact product, 1.000000002000000001, has 19 digits, so it is
+		// rounded to scale 17
+		{decimalMul, "1.000000001", "1.000000001", "1.00000000200000000"},
+		// the exact product has scale 21, more than a decimal can have
+		{decimalMul, "0.000000001", "0.000000000005", "0.000000000000000000"},
+		{decimalMul, "0.000000001", "0.000000000500", "0.000000000000000001"},
+		{decimalDiv, "0.000000000000000005", "2", "0.000000000000000003"},
+	}
+	for _, c := range cases {
+		d, err := c.op(mustDecimal(t, c.x), mustDecimal(t, c.y))
+		if err != nil || d.String() != c.expected {
+			t.Errorf("%s, %s: expected %s, got %v (%v)", c.x, c.y, c.expected, d, err)
+		}
+	}
+	big := mustDecimal(t, "999999999999999999")
+	if _, err := decimalAdd(big, DecimalField{1, 0}); err == nil || err.(GoDBError).code != NumericOverflowError {
+		t.Errorf("expected an overflow, got %v", err)
+	}
+	if _, err := decimalMul(big, DecimalField{2, 0}); err == nil || err.(GoDBError).code != NumericOverflowError {
+		t.Errorf("expected an overflow, got %v", err)
+	}
+	if _, err := decimalDiv(big, DecimalField{0, 2}); err == nil || err.(GoDBError).code != DivisionByZeroError {
+		t.Errorf("expected a division by zero, got %v", err)
+	}
+
+	var x, y Expr = &ConstExpr{mustDecimal(t, "1.10"), DecimalType}, &ConstExpr{mustDecimal(t, "2.2"), DecimalType}
+	sum := FuncExpr{"+", []*Expr{&x, &y}}
+	if v, err := sum.EvalExpr(nil); err != nil || v != (DecimalField{330, 2}) || sum.GetExprType().Ftype != DecimalType {
+		t.Errorf("expected 1.10 + 2.2 to be 3.30, got %v (%v)", v, err)
+	}
+	var zero Expr = &ConstExpr{DecimalField{0, 0}, DecimalType}
+	div := FuncExpr{"/", []*Expr{&x, &zero}}
+	if _, err := div.EvalExpr(nil); err == nil {
+		t.Errorf("expected division by zero to fail")
+	}
+	var one Expr = &ConstExpr{IntField{1}, IntType}
+	mixed := FuncExpr{"+", []*Expr{&x, &one}}
+	if v, err := mixed.EvalExpr(nil); err != nil || v != (DecimalField{210, 2}) {
+		t.Errorf("expected 1.10 + 1 to be 2.10, got %v (%v)", v, err)
+	}
+}
+
+func TestDecimalPredicates(t *testing.T) {
+	if !mustDecimal(t, "1.50").EvalPred(mustDecimal(t, "1.5"), OpEq) {
+		t.Errorf("expected decimals with different scales to compare by value")
+	}
+	if !mustDecimal(t, "-0.01").EvalPred(DecimalField{0, 0}, OpLt) || !mustDecimal(t, "10").EvalPred(mustDecimal(t, "9.999"), OpGt) {
+		t.Errorf("unexpected decimal comparison")
+	}
+	if (DecimalField{1, 0}).EvalPred(IntField{1}, OpEq) {
+		t.Errorf("expected values of different types not to compare")
+	}
+}
+
+func TestDecimalAggregates(t *testing.T) {
+	var s decimalSum
+	total, err := s.total()
+	avg, err2 := s.average()
+	if !isNull(total) || !isNull(avg) || err != nil || err2 != nil {
+		t.Errorf("expected the sum and average of no values to be NULL")
+	}
+	for _, v := range []string{"1.5", "2.25", "3"} {
+		s.add(mustDecimal(t, v))
+	}
+	if v, err := s.total(); err != nil || v != (DecimalField{675, 2}) {
+		t.Errorf("expected a sum of 6.75, got %v (%v)", v, err)
+	}
+	if v, err := s.average(); err != nil || v != (DecimalField{2250000, 6}) {
+		t.Errorf("expected an average of 2.250000, got %v (%v)", v, err)
+	}
+
+	var overflow decimalSum
+	overflow.add(mustDecimal(t, "999999999999999999"))
+	overflow.add(DecimalField{1, 0})
+	if _, err := overflow.total(); err == nil || err.(GoDBError).code != NumericOverflowError {
+		t.Errorf("expected a sum that overflows to be an error, got %v", err)
+	}
+	if v, err := overflow.average(); err != nil || v != (DecimalField{500000000000000000, 0}) {
+		t.Errorf("expected the average to be rounded to fit, got %v (%v)", v, err)
+	}
+}
+
+// A SUM of the decimals in the first field of the tuples, summed with a
+// decimalSum as SumAggState sums them.
+type decimalSumAggState struct {
+	sum decimalSum
+	err error
+}
+
+func (a *decimalSumAggState) Init(alias string, expr Expr) error {
+	return nil
+}
+
+func (a *decimalSumAggState) Copy() AggState {
+	return &decimalSumAggState{}
+}
+
+func (a *decimalSumAggState) AddTuple(t *Tuple) {
+	a.sum.add(t.Fields[0].(DecimalField))
+}
+
+func (a *decimalSumAggState) Finalize() *Tuple {
+	v, err := a.sum.total()
+	if a.err = err; err != nil {
+		v = NullField{}
+	}
+	return &Tuple{Desc: *a.GetTupleDesc(), Fields: []DBValue{v}}
+}
+
+func (a *decimalSumAggState) GetTupleDesc() *TupleDesc {
+	return &TupleDesc{[]FieldType{{"sum", "", DecimalType}}}
+}
+
+func (a *decimalSumAggState) finalizeError() error {
+	return a.err
+}
+
+// An operator returning the tuples of a list.
+type tupleList struct {
+	desc   TupleDesc
+	tuples []*Tuple
+}
+
+func (l *tupleList) Descriptor() *TupleDesc {
+	return &l.desc
+}
+
+func (l *tupleList) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	i := 0
+	return func() (*Tuple, error) {
+		if i == len(l.tuples) {
+			return nil, nil
+		}
+		i++
+		return l.tuples[i-1], nil
+	}, nil
+}
+
+// A SUM of decimals that overflows fails the aggregation, with or without a
+// group-by.
+func TestDecimalSumOverflowAggregation(t *testing.T) {
+	desc := TupleDesc{[]FieldType{{"amount", "", DecimalType}}}
+	big := &Tuple{Desc: desc, Fields: []DBValue{mustDecimal(t, "999999999999999999")}}
+	one := &Tuple{Desc: desc, Fields: []DBValue{DecimalField{1, 0}}}
+
+	agg := NewAggregator([]AggState{&decimalSumAggState{}}, &tupleList{desc, []*Tuple{big, one}})
+	iter, err := agg.Iterator(NewTID())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := iter(); err == nil || err.(GoDBError).code != NumericOverflowError {
+		t.Errorf("expected the aggregation to fail with a NumericOverflowError, got %v", err)
+	}
+
+	// a group whose sum overflows
+	state := &decimalSumAggState{}
+	state.AddTuple(big)
+	state.AddTuple(big)
+	group := &Tuple{Desc: TupleDesc{[]FieldType{{"region", "", StringType}}}, Fields: []DBValue{StringField{"north"}}}
+	if _, err := finalizeGroup(group, []AggState{state}); err == nil || err.(GoDBError).code != NumericOverflowError {
+		t.Errorf("expected the group to fail with a NumericOverflowError, got %v", err)
+	}
+	if _, err := finalizeAggState(state); err == nil {
+		t.Errorf("expected the sum to overflow")
+	}
+	state = &decimalSumAggState{}
+	state.AddTuple(big)
+	if tup, err := finalizeAggState(state); err != nil || tup.Fields[0] != DBValue(mustDecimal(t, "999999999999999999")) {
+		t.Errorf("expected a sum that fits to be returned, got %v (%v)", tup, err)
+	}
+}
+
+func TestDecimalColumns(t *testing.T) {
+	specs := map[string]decimalSpec{
+		"decimal":       {},
+		"DECIMAL(10,2)": {10, 2},
+		"numeric(5)":    {5, 0},
+		"dec(18, 18)":   {18, 18},
+	}
+	for name, expected := range specs {
+		ftype, c, err := parseColumnType(name, "")
+		if err != nil || ftype != DecimalType || c.decimal != expected {
+			t.Errorf("%s: expected a decimal %+v, got %v %+v (%v)", name, expected, ftype, c.decimal, err)
+		}
+		if _, reparsed, _ := parseColumnType(columnTypeName(ftype, c), ""); reparsed != c {
+			t.Errorf("%s: type name %s does not parse", name, columnTypeName(ftype, c))
+		}
+	}
+	if _, c, _ := parseColumnType("decimal", "8,3"); c.decimal != (decimalSpec{8, 3}) {
+		t.Errorf("expected a separate precision and scale to be used, got %+v", c.decimal)
+	}
+	for _, name := range []string{"decimal(0)", "decimal(19,2)", "decimal(4,5)", "decimal(x)"} {
+		if _, _, err := parseColumnType(name, ""); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if cols := splitColumnList("id int, price decimal(10,2) not null, name text"); len(cols) != 3 || cols[1] != " price decimal(10,2) not null" {
+		t.Errorf("unexpected columns %q", cols)
+	}
+
+	td := TupleDesc{[]FieldType{{"id", "", IntType}, {"price", "", DecimalType}}}
+	hf := &HeapFile{}
+	hf.setColumns([]columnDef{{}, {decimal: decimalSpec{6, 2}}})
+	tup := Tuple{Desc: td, Fields: []DBValue{IntField{1}, mustDecimal(t, "12.345")}}
+	if err := hf.roundDecimals(&tup); err != nil || tup.Fields[1] != (DecimalField{1235, 2}) {
+		t.Errorf("expected 12.345 to be stored as 12.35, got %v (%v)", tup.Fields[1], err)
+	}
+	tup.Fields[1] = mustDecimal(t, "12345")
+	if err := hf.roundDecimals(&tup); err == nil {
+		t.Errorf("expected 12345 not to fit in decimal(6,2)")
+	}
+
+	tup.Fields[1] = DecimalField{-1234567, 5}
+	var b bytes.Buffer
+	if err := tup.writeVarlenTo(&b); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if expected := 1 + 8 + DecimalType.fixedSize(); b.Len() != expected {
+		t.Errorf("expected %d bytes, got %d", expected, b.Len())
+	}
+	t2, err := readVarlenTupleFrom(&b, &td)
+	if err != nil || t2.Fields[1] != tup.Fields[1] {
+		t.Errorf("expected %v, got %v (%v)", tup.Fields[1], t2, err)
+	}
+	if s := tup.PrettyPrintString(false); s != "1,-12.34567" {
+		t.Errorf("unexpected output %s", s)
+	}
+}
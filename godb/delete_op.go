@@ -26,7 +26,10 @@ func (i *DeleteOp) Descriptor() *TupleDesc {
 // Return an iterator that deletes all of the tuples from the child iterator
 // from the DBFile passed to the constructor and then returns a one-field tuple
 // with a "count" field indicating the number of tuples that were deleted.
-// Tuples should be deleted using the [DBFile.deleteTuple] method.
+// Tuples should be deleted using the [DBFile.deleteTuple] method, which locks
+// each deleted tuple for writing.
+//
+// Before reading the child, lock the DBFile for writing with [lockDBFile].
 func (dop *DeleteOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 	// TODO: some code goes here
 	return nil, fmt.Errorf("DeleteOp.Iterator not implemented") // replace me
@@ -3,6 +3,7 @@ package godb
 import (
 	"fmt"
 	"math/rand"
+	"strings"
 	"time"
 )
 
@@ -13,7 +14,7 @@ import (
 //other values from tuples.
 
 type Expr interface {
-	EvalExpr(t *Tuple) (DBValue, error) //DBValue is either IntField or StringField
+	EvalExpr(t *Tuple) (DBValue, error) //DBValue is either IntField, StringField or NullField
 	GetExprType() FieldType             //Return the type of the Expression
 }
 
@@ -54,9 +55,9 @@ type FuncExpr struct {
 }
 
 func (f *FuncExpr) GetExprType() FieldType {
-	fType, exists := funcs[f.op]
+	fType, err := f.resolve()
 	//todo return err
-	if !exists {
+	if err != nil {
 		return FieldType{f.op, "", IntType}
 	}
 	ft := FieldType{f.op, "", IntType}
@@ -70,53 +71,116 @@ func (f *FuncExpr) GetExprType() FieldType {
 
 }
 
+// Return the overload of the function whose argument types match the types
+// of the arguments of f; a NULL constant, of UnknownType, matches any type.
+// If no overload matches exactly, return the first one the arguments can be
+// implicitly converted to (see [canCoerce]), e.g. the DECIMAL overload of + for
+// a DECIMAL and an INT. If there is none, return a TypeMismatchError
+// describing the first overload.
+func (f *FuncExpr) resolve() (FuncType, error) {
+	overloads, exists := funcs[f.op]
+	if !exists {
+		return FuncType{}, GoDBError{ParseError, fmt.Sprintf("unknown function %s", f.op)}
+	}
+	for _, coerce := range []bool{false, true} {
+		for _, fType := range overloads {
+			if len(f.args) != len(fType.argTypes) {
+				continue
+			}
+			matches := true
+			for i, argType := range fType.argTypes {
+				ft := (*f.args[i]).GetExprType().Ftype
+				if ft != argType && ft != UnknownType && !(coerce && canCoerce(ft, argType)) {
+					matches = false
+				}
+			}
+			if matches {
+				return fType, nil
+			}
+		}
+	}
+	fType := overloads[0]
+	if len(f.args) != len(fType.argTypes) {
+		return FuncType{}, GoDBError{ParseError, fmt.Sprintf("function %s expected %d args", f.op, len(fType.argTypes))}
+	}
+	argTypes := make([]string, len(f.args))
+	for i, arg := range f.args {
+		argTypes[i] = (*arg).GetExprType().Ftype.String()
+	}
+	return FuncType{}, GoDBError{TypeMismatchError, fmt.Sprintf("function %s expected args of types %s, got (%s)", f.op, fType.argList(), strings.Join(argTypes, ","))}
+}
+
+// The type of a function. Arguments of type IntType and StringType are passed
+// to f as int64 and string values, and arguments of other types as DBValues;
+// f returns a value of the same kind for outType, or an error.
 type FuncType struct {
 	argTypes []DBType
 	outType  DBType
 	f        func([]any) any
 }
 
-var funcs = map[string]FuncType{
+// Return the argument types of the function, e.g. "(int,int)".
+func (f FuncType) argList() string {
+	args := "("
+	hasArg := false
+	for _, a := range f.argTypes {
+		if hasArg {
+			args = args + ","
+		}
+		args = args + a.String()
+		hasArg = true
+	}
+	return args + ")"
+}
+
+// The overloads of each function (see [FuncExpr.resolve])
+var funcs = map[string][]FuncType{
 	//note should all be lower case
-	"+":                     {[]DBType{IntType, IntType}, IntType, addFunc},
-	"-":                     {[]DBType{IntType, IntType}, IntType, minusFunc},
-	"*":                     {[]DBType{IntType, IntType}, IntType, timesFunc},
-	"/":                     {[]DBType{IntType, IntType}, IntType, divFunc},
-	"mod":                   {[]DBType{IntType, IntType}, IntType, modFunc},
-	"rand":                  {[]DBType{}, IntType, randIntFunc},
-	"sq":                    {[]DBType{IntType}, IntType, sqFunc},
-	"getsubstr":             {[]DBType{StringType, IntType, IntType}, StringType, subStrFunc},
-	"epoch":                 {[]DBType{}, IntType, epoch},
-	"datetimestringtoepoch": {[]DBType{StringType}, IntType, dateTimeToEpoch},
-	"datestringtoepoch":     {[]DBType{StringType}, IntType, dateToEpoch},
-	"epochtodatetimestring": {[]DBType{IntType}, StringType, dateString},
-	"imin":                  {[]DBType{IntType, IntType}, IntType, minFunc},
-	"imax":                  {[]DBType{IntType, IntType}, IntType, maxFunc},
+	"+": {{[]DBType{IntType, IntType}, IntType, addFunc},
+		{[]DBType{DecimalType, DecimalType}, DecimalType, decimalFunc(decimalAdd)},
+		{[]DBType{FloatType, FloatType}, FloatType, floatAddFunc}},
+	"-": {{[]DBType{IntType, IntType}, IntType, minusFunc},
+		{[]DBType{DecimalType, DecimalType}, DecimalType, decimalFunc(decimalSub)},
+		{[]DBType{FloatType, FloatType}, FloatType, floatMinusFunc}},
+	"*": {{[]DBType{IntType, IntType}, IntType, timesFunc},
+		{[]DBType{DecimalType, DecimalType}, DecimalType, decimalFunc(decimalMul)},
+		{[]DBType{FloatType, FloatType}, FloatType, floatTimesFunc}},
+	"/": {{[]DBType{IntType, IntType}, IntType, divFunc},
+		{[]DBType{DecimalType, DecimalType}, DecimalType, decimalFunc(decimalDiv)},
+		{[]DBType{FloatType, FloatType}, FloatType, floatDivFunc}},
+	"mod":                   {{[]DBType{IntType, IntType}, IntType, modFunc}},
+	"rand":                  {{[]DBType{}, IntType, randIntFunc}},
+	"sq":                    {{[]DBType{IntType}, IntType, sqFunc}},
+	"getsubstr":             {{[]DBType{StringType, IntType, IntType}, StringType, subStrFunc}},
+	"epoch":                 {{[]DBType{}, IntType, epoch}},
+	"datetimestringtoepoch": {{[]DBType{StringType}, IntType, dateTimeToEpoch}},
+	"datestringtoepoch":     {{[]DBType{StringType}, IntType, dateToEpoch}},
+	"epochtodatetimestring": {{[]DBType{IntType}, StringType, dateString}},
+	"imin":                  {{[]DBType{IntType, IntType}, IntType, minFunc}},
+	"imax":                  {{[]DBType{IntType, IntType}, IntType, maxFunc}},
 }
 
 func ListOfFunctions() string {
 	fList := ""
-	for name, f := range funcs {
-		args := "("
-		argList := f.argTypes
-		hasArg := false
-		for _, a := range argList {
-			if hasArg {
-				args = args + ","
-			}
-			switch a {
-			case IntType:
-				args = args + "int"
-			case StringType:
-				args = args + "string"
-			}
-			hasArg = true
+	for name, overloads := range funcs {
+		for _, f := range overloads {
+			fList = fList + "\t" + name + f.argList() + "\n"
 		}
-		args = args + ")"
-		fList = fList + "\t" + name + args + "\n"
 	}
 	return fList
 }
+
+// Return a function of two decimal arguments computing op, which returns
+// either a DecimalField or an error.
+func decimalFunc(op func(DecimalField, DecimalField) (DecimalField, error)) func([]any) any {
+	return func(args []any) any {
+		d, err := op(args[0].(DecimalField), args[1].(DecimalField))
+		if err != nil {
+			return err
+		}
+		return d
+	}
+}
 func minFunc(args []any) any {
 	first := args[0].(int64)
 	second := args[1].(int64)
@@ -189,6 +253,22 @@ func addFunc(args []any) any {
 	return args[0].(int64) + args[1].(int64)
 }
 
+func floatAddFunc(args []any) any {
+	return FloatField{args[0].(FloatField).Value + args[1].(FloatField).Value}
+}
+
+func floatMinusFunc(args []any) any {
+	return FloatField{args[0].(FloatField).Value - args[1].(FloatField).Value}
+}
+
+func floatTimesFunc(args []any) any {
+	return FloatField{args[0].(FloatField).Value * args[1].(FloatField).Value}
+}
+
+func floatDivFunc(args []any) any {
+	return FloatField{args[0].(FloatField).Value / args[1].(FloatField).Value}
+}
+
 func sqFunc(args []any) any {
 	return args[0].(int64) * args[0].(int64)
 }
@@ -211,41 +291,54 @@ func subStrFunc(args []any) any {
 }
 
 func (f *FuncExpr) EvalExpr(t *Tuple) (DBValue, error) {
-	fType, exists := funcs[f.op]
-	if !exists {
-		return nil, GoDBError{ParseError, fmt.Sprintf("unknown function %s", f.op)}
-	}
-	if len(f.args) != len(fType.argTypes) {
-		return nil, GoDBError{ParseError, fmt.Sprintf("function %s expected %d args", f.op, len(fType.argTypes))}
+	fType, err := f.resolve()
+	if err != nil {
+		return nil, err
 	}
 	argvals := make([]any, len(fType.argTypes))
 	for i, argType := range fType.argTypes {
 		arg := *f.args[i]
-		if arg.GetExprType().Ftype != argType {
-			typeName := "string"
-			switch argType {
-			case IntType:
-				typeName = "int"
-			}
-			return nil, GoDBError{ParseError, fmt.Sprintf("function %s expected arg of type %s", f.op, typeName)}
-		}
 		val, err := arg.EvalExpr(t)
 		if err != nil {
 			return nil, err
 		}
+		if isNull(val) {
+			return NullField{}, nil
+		}
+		if val, err = castValue(val, argType); err != nil {
+			return nil, err
+		}
+		var ok bool
 		switch argType {
 		case IntType:
-			argvals[i] = val.(IntField).Value
+			var v IntField
+			v, ok = val.(IntField)
+			argvals[i] = v.Value
 		case StringType:
-			argvals[i] = val.(StringField).Value
+			var v StringField
+			v, ok = val.(StringField)
+			argvals[i] = v.Value
+		default:
+			ok = typeOfValue(val) == argType
+			argvals[i] = val
+		}
+		if !ok {
+			return nil, GoDBError{TypeMismatchError, fmt.Sprintf("function %s got argument %v of the wrong type", f.op, val)}
 		}
 	}
 	result := fType.f(argvals)
+	if err, ok := result.(error); ok {
+		return nil, err
+	}
 	switch fType.outType {
 	case IntType:
 		return IntField{result.(int64)}, nil
 	case StringType:
 		return StringField{result.(string)}, nil
+	case DecimalType:
+		return result.(DecimalField), nil
+	case FloatType:
+		return result.(FloatField), nil
 	}
 	return nil, GoDBError{ParseError, "unknown result type in function"}
 }
@@ -24,8 +24,10 @@ func (f *Filter) Descriptor() *TupleDesc {
 
 // Filter operator implementation. This function should iterate over the results
 // of the child iterator and return a tuple if it satisfies the predicate.
+// Predicates use three-valued logic: a tuple is returned only if the predicate
+// is true, not if it is unknown because a value is NULL.
 //
-// HINT: you can use [types.evalPred] to compare two values.
+// HINT: you can use [evalPred] to compare two values.
 func (f *Filter) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 	// TODO: some code goes here
 	return nil, fmt.Errorf("Filter.Iterator not implemented") // replace me
@@ -0,0 +1,172 @@
+package godb
+
+import (
+	"encoding/binary"
+	"os"
+	"sync"
+)
+
+/*
+Each HeapFile keeps a free space map, which records the room each of its pages
+has for new tuples, so that [HeapFile.insertTuple] goes straight to a page
+with room instead of reading every page of the file. The room of a page is
+its number of free slots if the file stores tuples of fixed length, and the
+free space of its slotted page (see [slottedPage.freeSpace]) if it is
+variable length; overflow pages have no room (see [heapPage.freeRoom]).
+
+The map is a hint: a page it says has room may turn out not to have enough,
+e.g. because the page is compressed, in which case the insert records what it
+found and moves on to the next page. The room of a page may also be unknown,
+in which case an insert reads the page to find out. This is the case of the
+pages the map has no entry for, and of the pages whose contents are restored
+when a transaction aborts or rolls back to a savepoint, since the inserts and
+deletes that were undone no longer apply to them.
+
+The map is kept in memory, and persisted in a file next to the heap file,
+named after it with freeSpaceMapSuffix, holding the room of page i as a 16
+bit little endian integer at offset 2*i, or unknownFreeSpaceEntry if it is
+unknown. [HeapFile.flushPage] persists the room of each page it writes, so
+the map on disk describes the pages on disk; recovery, which writes page
+images without going through the files of the pages, marks their entries
+as unknown instead (see [writePageImage]).
+*/
+
+const (
+	freeSpaceMapSuffix           = ".fsm"
+	unknownFreeSpace             = -1
+	unknownFreeSpaceEntry uint16 = 0xFFFF
+)
+
+// The free space map of a HeapFile (see above).
+type freeSpaceMap struct {
+	mu       sync.Mutex
+	fileName string // the file the map is persisted in
+	room     []int  // the room of each page, or unknownFreeSpace
+	first    int    // no page before first has room for any tuple
+}
+
+// Open the free space map of the heap file with the specified name, loading
+// its entries from its file if it exists.
+func openFreeSpaceMap(heapFile string) (*freeSpaceMap, error) {
+	m := &freeSpaceMap{fileName: heapFile + freeSpaceMapSuffix}
+	buf, err := os.ReadFile(m.fileName)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	m.room = make([]int, len(buf)/2)
+	for i := range m.room {
+		m.room[i] = int(binary.LittleEndian.Uint16(buf[2*i:]))
+		if m.room[i] == int(unknownFreeSpaceEntry) {
+			m.room[i] = unknownFreeSpace
+		}
+	}
+	return m, nil
+}
+
+// Return the first page from page from on, among the first numPages pages of
+// the file, that may have at least need room for a tuple: a page whose room is
+// unknown, or at least need. Returns -1 if there is none, in which case the
+// tuple goes to a new page.
+func (m *freeSpaceMap) findPage(need int, from int, numPages int) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for m.first < len(m.room) && m.room[m.first] == 0 {
+		m.first++
+	}
+	for pageNo := max(from, m.first); pageNo < numPages; pageNo++ {
+		if pageNo >= len(m.room) || m.room[pageNo] == unknownFreeSpace || m.room[pageNo] >= need {
+			return pageNo
+		}
+	}
+	return -1
+}
+
+// Record the room of a page in memory; room may be unknownFreeSpace.
+func (m *freeSpaceMap) record(pageNo int, room int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for len(m.room) <= pageNo {
+		m.room = append(m.room, unknownFreeSpace)
+	}
+	m.room[pageNo] = room
+	if room != 0 && pageNo < m.first {
+		m.first = pageNo
+	}
+}
+
+// Write the entry of a page to the file of the map, creating it if needed,
+// without changing the map in memory.
+func (m *freeSpaceMap) persist(pageNo int, room int) error {
+	f, err := os.OpenFile(m.fileName, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writeFreeSpaceEntry(f, pageNo, room)
+}
+
+// Write the entry of a page to the file of a free space map.
+func writeFreeSpaceEntry(f *os.File, pageNo int, room int) error {
+	entry := unknownFreeSpaceEntry
+	if room != unknownFreeSpace {
+		entry = uint16(min(room, int(unknownFreeSpaceEntry)-1))
+	}
+	var buf [2]byte
+	binary.LittleEndian.PutUint16(buf[:], entry)
+	_, err := f.WriteAt(buf[:], 2*int64(pageNo))
+	return err
+}
+
+// Mark the room of page pageNo of the specified file as unknown in its free
+// space map on disk, if it has one and the map has an entry for the page.
+// Used by recovery, which writes pages without their files being open.
+func forgetPersistedFreeSpace(fileName string, pageNo int) error {
+	f, err := os.OpenFile(fileName+freeSpaceMapSuffix, os.O_WRONLY, 0644)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.Size() < 2*int64(pageNo+1) {
+		return err
+	}
+	return writeFreeSpaceEntry(f, pageNo, unknownFreeSpace)
+}
+
+// Return the room t needs in a page of the file: a slot if the file stores
+// tuples of fixed length, and the size of its record (see [heapPage])
+// otherwise.
+func (f *HeapFile) roomNeeded(t *Tuple) (int, error) {
+	if !f.isVarlen() {
+		return 1, nil
+	}
+	n, err := varlenSize(t)
+	if err != nil {
+		return 0, err
+	}
+	if f.bufPool.versions != nil {
+		n += tupleVersionSize
+	}
+	return n, nil
+}
+
+// Record the room of hp, page pageNo of the file, in its free space map.
+func (f *HeapFile) noteFreeSpace(pageNo int, hp *heapPage) {
+	f.freeSpace.record(pageNo, hp.freeRoom())
+}
+
+// Record that hp, page pageNo of the file, turned out not to have room for a
+// tuple that needed the specified room, even if [heapPage.freeRoom] says
+// otherwise, as it may for a compressed page.
+func (f *HeapFile) notePageFull(pageNo int, hp *heapPage, need int) {
+	f.freeSpace.record(pageNo, min(hp.freeRoom(), need-1))
+}
+
+// Mark the room of page pageNo of the file as unknown in its free space map,
+// e.g. because its contents were restored by an abort.
+func (f *HeapFile) forgetFreeSpace(pageNo int) {
+	f.freeSpace.record(pageNo, unknownFreeSpace)
+}
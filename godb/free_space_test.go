@@ -0,0 +1,108 @@
+package godb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFreeSpaceMapFindPage(t *testing.T) {
+	m, err := openFreeSpaceMap(filepath.Join(t.TempDir(), "t.dat"))
+	if err != nil || len(m.room) != 0 {
+		t.Fatalf("expected an empty map, got %v (%v)", m.room, err)
+	}
+	if p := m.findPage(1, 0, 0); p != -1 {
+		t.Errorf("expected an empty file to have no room, got page %d", p)
+	}
+	if p := m.findPage(1, 0, 2); p != 0 {
+		t.Errorf("expected a page without an entry to be tried, got page %d", p)
+	}
+	for pageNo, room := range []int{0, 0, 10, 0, 100, unknownFreeSpace} {
+		m.record(pageNo, room)
+	}
+	cases := []struct{ need, from, expected int }{
+		{1, 0, 2}, {10, 0, 2}, {11, 0, 4}, {11, 5, 5}, {101, 0, 5}, {1, 3, 4},
+	}
+	for _, c := range cases {
+		if p := m.findPage(c.need, c.from, 6); p != c.expected {
+			t.Errorf("need %d from %d: expected page %d, got %d", c.need, c.from, c.expected, p)
+		}
+	}
+	if m.first != 2 {
+		t.Errorf("expected the first pages to be skipped, first is %d", m.first)
+	}
+	if p := m.findPage(101, 0, 5); p != -1 {
+		t.Errorf("expected no page with room for 101, got page %d", p)
+	}
+
+	// a deletion or an abort makes room before the first page with room
+	m.record(1, unknownFreeSpace)
+	if p := m.findPage(1, 0, 6); p != 1 {
+		t.Errorf("expected page 1 to be tried, got page %d", p)
+	}
+	m.record(1, 0)
+	m.record(2, 0)
+	m.record(8, 5)
+	if p := m.findPage(1, 0, 9); p != 4 || len(m.room) != 9 || m.room[7] != unknownFreeSpace {
+		t.Errorf("expected page 4, got page %d with entries %v", p, m.room)
+	}
+}
+
+func TestFreeSpaceMapPersist(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "t.dat")
+	m, err := openFreeSpaceMap(name)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for pageNo, room := range []int{3, 0, unknownFreeSpace, 1 << 20} {
+		if err := m.persist(pageNo, room); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if len(m.room) != 0 {
+		t.Errorf("expected persist not to change the map in memory, got %v", m.room)
+	}
+	if m, err = openFreeSpaceMap(name); err != nil || fmt.Sprint(m.room) != "[3 0 -1 65534]" {
+		t.Fatalf("unexpected entries %v (%v)", m.room, err)
+	}
+
+	// recovery forgets the entries of the pages it writes
+	if err := writePageImage(name, 1, nil); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := writePageImage(name, 7, nil); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if m, err = openFreeSpaceMap(name); err != nil || fmt.Sprint(m.room) != "[3 -1 -1 65534]" {
+		t.Errorf("unexpected entries after recovery %v (%v)", m.room, err)
+	}
+	index := filepath.Join(t.TempDir(), "t.idx")
+	if err := writePageImage(index, 0, nil); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := os.Stat(index + freeSpaceMapSuffix); !os.IsNotExist(err) {
+		t.Errorf("expected a file without a free space map not to get one, got %v", err)
+	}
+}
+
+func TestRoomNeeded(t *testing.T) {
+	desc := &TupleDesc{[]FieldType{{"name", "", StringType}, {"age", "", IntType}}}
+	tup := &Tuple{Desc: *desc, Fields: []DBValue{StringField{"joe"}, IntField{30}}}
+	hf := &HeapFile{bufPool: &BufferPool{}}
+	if n, err := hf.roomNeeded(tup); err != nil || n != 1 {
+		t.Errorf("expected a tuple of fixed length to need a slot, got %d (%v)", n, err)
+	}
+	hf.setColumns([]columnDef{{limit: 10, nullable: true}, {nullable: true}})
+	size, err := varlenSize(tup)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if n, err := hf.roomNeeded(tup); err != nil || n != size {
+		t.Errorf("expected a record of %d bytes, got %d (%v)", size, n, err)
+	}
+	hf.bufPool.versions = NewVersionManager()
+	if n, _ := hf.roomNeeded(tup); n != size+tupleVersionSize {
+		t.Errorf("expected the version header to be counted, got %d", n)
+	}
+}
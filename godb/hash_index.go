@@ -0,0 +1,671 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"os"
+)
+
+/*
+A hash index (see [HashFile]) maps keys to the record ids of the heap tuples
+that hold them, like a B+ tree (see [BTreeFile]), but can only find the
+entries whose key is equal to a given key. It uses extendible hashing: page 0
+of its file is a directory of 1 << depth buckets, and the entries whose keys
+have a hash whose depth lowest bits are i are in bucket i. Several
+consecutive entries of the directory may point to the same bucket page, which
+has a local depth: the number of low bits its keys have in common.
+
+When an insert finds its bucket full, the bucket is split: its entries whose
+hash has a 1 in the bit after its local depth move to a new bucket, both
+buckets get a local depth one greater, and the directory entries of the new
+bucket are updated, doubling the directory first if the local depth was its
+depth. The directory has at most 1 << maxHashDepth entries, so that it fits in
+a page; once a bucket has that local depth, or if its entries all have the
+hash of the new entry, as duplicate keys do, the bucket grows a chain of
+overflow pages instead. Deletes remove entries without merging buckets, so
+the index never shrinks.
+
+The directory page starts with hashPageMagic, hashDirectoryFlag and the
+depth, followed by the page numbers of its buckets. A bucket page starts with
+hashPageMagic, a zero byte and its local depth, the number of its entries as
+a 16 bit integer and the number of its next overflow page (noHashPage for the
+last page of a chain) as a 32 bit integer. Its entries are written like the
+entries of B+ tree leaves. All integers are little endian. A page of zeros is
+an empty bucket.
+
+Transactions lock the pages they read for reading and the pages they modify
+for writing, until they end (see [HashFile.getHashPage]). Writers lock the
+directory for writing, so transactions that modify an index are serialized.
+*/
+
+const (
+	hashPageMagic      uint32 = 0x4A54AB1E
+	hashHeaderSize     int    = 11
+	hashDirectory      int    = 0
+	hashDirectoryFlag  byte   = 1
+	hashDirHeaderSize  int    = 6
+	noHashPage         int    = -1
+	maxHashDepth       int    = 9 // hashDirHeaderSize + 4 << maxHashDepth <= PageSize
+	hashPageNumberSize int    = 4
+)
+
+// A HashFile is a hash index. Its tuples are the keys of its entries, each
+// with the record id of the heap tuple it points to as its Rid; they are
+// inserted and deleted with [HashFile.insertTuple] and [HashFile.deleteTuple],
+// and read with [HashFile.Iterator], in no particular order, and
+// [HashFile.Lookup].
+type HashFile struct {
+	fileName string
+	keyDesc  *TupleDesc
+	bufPool  *BufferPool
+}
+
+// A page of a hash index, as cached by the BufferPool: the directory or a
+// bucket page. Like a [heapPage], it keeps the LSN of the last update record
+// logged for it and its before image, which are not part of its on-disk
+// format.
+type hashPage struct {
+	file      *HashFile
+	pageNo    int
+	directory bool
+	depth     int // the depth of the directory, or the local depth of a bucket
+
+	buckets []int        // the directory's bucket pages, 1 << depth of them
+	entries []btreeEntry // the entries of a bucket page, in no particular order
+	next    int          // the next overflow page of a bucket, or noHashPage
+
+	dirty   bool
+	dirtier TransactionID // writers lock pages exclusively, so there is at most one
+
+	lsn         LSN
+	beforeImage []byte
+}
+
+// Create a HashFile backed by the specified file, which may be empty or a
+// previously created index, for keys with the specified TupleDesc. If the
+// file is empty, a directory pointing to a single empty bucket is written to
+// it.
+func NewHashFile(fromFile string, keyDesc *TupleDesc, bp *BufferPool) (*HashFile, error) {
+	if keyDesc == nil || len(keyDesc.Fields) == 0 {
+		return nil, GoDBError{IllegalOperationError, "an index needs at least one key column"}
+	}
+	file, err := os.OpenFile(fromFile, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	f := &HashFile{fileName: fromFile, keyDesc: &TupleDesc{append([]FieldType(nil), keyDesc.Fields...)}, bufPool: bp}
+	if f.NumPages() == 0 {
+		dir := newHashPage(f, hashDirectory)
+		dir.directory = true
+		dir.buckets = []int{1}
+		if err := f.flushPage(dir); err != nil {
+			return nil, err
+		}
+		if err := f.flushPage(newHashPage(f, 1)); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Return the name of the backing file.
+func (f *HashFile) BackingFile() string {
+	return f.fileName
+}
+
+// Return the number of pages of the file.
+func (f *HashFile) NumPages() int {
+	info, err := os.Stat(f.fileName)
+	if err != nil {
+		return 0
+	}
+	return int(info.Size() / int64(PageSize))
+}
+
+// Return the TupleDesc of the keys of the index.
+func (f *HashFile) Descriptor() *TupleDesc {
+	return f.keyDesc
+}
+
+func (f *HashFile) pageKey(pgNo int) any {
+	return heapHash{FileName: f.fileName, PageNo: pgNo}
+}
+
+// Read page pageNo from the file. Called by [BufferPool.GetPage].
+func (f *HashFile) readPage(pageNo int) (Page, error) {
+	file, err := os.Open(f.fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	buf := make([]byte, PageSize)
+	if _, err := file.ReadAt(buf, int64(pageNo)*int64(PageSize)); err != nil {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("cannot read page %d of %s: %v", pageNo, f.fileName, err)}
+	}
+	p := newHashPage(f, pageNo)
+	if err := p.initFromBuffer(bytes.NewBuffer(buf)); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Write the page to the file.
+func (f *HashFile) flushPage(page Page) error {
+	p, ok := page.(*hashPage)
+	if !ok {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("%s can only store hash index pages", f.fileName)}
+	}
+	buf, err := p.toBuffer()
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.fileName, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteAt(buf.Bytes(), int64(p.pageNo)*int64(PageSize))
+	return err
+}
+
+// Return page pageNo of the index, read on behalf of tid with the specified
+// permission. Pages are locked with shared or exclusive locks held until tid
+// ends, before the page is retrieved with [BufferPool.GetPage], whatever the
+// isolation level of tid and even if the BufferPool uses row locking or MVCC,
+// since a transaction must not read a page another one is modifying.
+func (f *HashFile) getHashPage(pageNo int, tid TransactionID, perm RWPerm) (*hashPage, error) {
+	bp := f.bufPool
+	mode := SharedLock
+	if perm == WritePerm {
+		mode = ExclusiveLock
+	}
+	if err := bp.lockManager().acquireWithin(tid, f.pageKey(pageNo), mode, bp.lockWaitTimeout(tid, LockWait)); err != nil {
+		return nil, err
+	}
+	pg, err := bp.GetPage(f, pageNo, tid, perm)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := pg.(*hashPage)
+	if !ok {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("page %d of %s is not a hash index page", pageNo, f.fileName)}
+	}
+	return p, nil
+}
+
+// Append an empty bucket page with the specified local depth to the file on
+// behalf of tid, returning it locked for writing.
+func (f *HashFile) allocateBucket(depth int, tid TransactionID) (*hashPage, error) {
+	if err := f.bufPool.lockEndOfFile(f, tid, WritePerm); err != nil {
+		return nil, err
+	}
+	pageNo := f.NumPages()
+	if err := f.flushPage(newHashPage(f, pageNo)); err != nil {
+		return nil, err
+	}
+	p, err := f.getHashPage(pageNo, tid, WritePerm)
+	if err != nil {
+		return nil, err
+	}
+	p.depth = depth
+	return p, nil
+}
+
+// Return the pages of the bucket starting at page pageNo, its overflow pages
+// following it, read on behalf of tid with the specified permission.
+func (f *HashFile) bucketChain(pageNo int, tid TransactionID, perm RWPerm) ([]*hashPage, error) {
+	var chain []*hashPage
+	for pageNo != noHashPage {
+		p, err := f.getHashPage(pageNo, tid, perm)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, p)
+		pageNo = p.next
+	}
+	return chain, nil
+}
+
+// Return the entry for the key t, whose Rid must be a [heapRid], or an error
+// if t does not match the keys of the index or is larger than
+// maxBTreeKeySize.
+func (f *HashFile) entryOf(t *Tuple) (btreeEntry, error) {
+	rid, ok := t.Rid.(heapRid)
+	if !ok {
+		return btreeEntry{}, GoDBError{IllegalOperationError, fmt.Sprintf("index entries must point to heap tuples, not %v", t.Rid)}
+	}
+	if len(t.Fields) != len(f.keyDesc.Fields) {
+		return btreeEntry{}, GoDBError{TypeMismatchError, fmt.Sprintf("index key has %d fields, expected %d", len(t.Fields), len(f.keyDesc.Fields))}
+	}
+	var b bytes.Buffer
+	key := Tuple{Desc: *f.keyDesc, Fields: t.Fields}
+	if err := key.writeVarlenTo(&b); err != nil {
+		return btreeEntry{}, err
+	}
+	if b.Len() > maxBTreeKeySize {
+		return btreeEntry{}, GoDBError{IllegalOperationError, fmt.Sprintf("index key of %d bytes is larger than the maximum of %d bytes", b.Len(), maxBTreeKeySize)}
+	}
+	return btreeEntry{key: t.Fields, rid: rid, size: b.Len()}, nil
+}
+
+// Return the hash of a key of the index. Equal keys have the same hash: a
+// negative zero is hashed as zero, the values of each column being otherwise
+// encoded the same way when they are equal.
+func (f *HashFile) hashKey(key []DBValue) (uint32, error) {
+	normalized := make([]DBValue, len(key))
+	for i, v := range key {
+		if fv, ok := v.(FloatField); ok && fv.Value == 0 {
+			v = FloatField{0}
+		}
+		normalized[i] = v
+	}
+	var b bytes.Buffer
+	t := Tuple{Desc: *f.keyDesc, Fields: normalized}
+	if err := t.writeVarlenTo(&b); err != nil {
+		return 0, err
+	}
+	h := fnv.New32a()
+	h.Write(b.Bytes())
+	return h.Sum32(), nil
+}
+
+// Return the page of the bucket of keys with the specified hash.
+func (dir *hashPage) bucketFor(hash uint32) int {
+	return dir.buckets[hash&(1<<dir.depth-1)]
+}
+
+// Insert an entry for the key t, whose Rid is the record id of the heap tuple
+// it indexes, on behalf of tid, splitting its bucket or adding an overflow page
+// to it if it is full. Returns an error if the index already has the entry.
+func (f *HashFile) insertTuple(t *Tuple, tid TransactionID) error {
+	e, err := f.entryOf(t)
+	if err != nil {
+		return err
+	}
+	hash, err := f.hashKey(e.key)
+	if err != nil {
+		return err
+	}
+	dir, err := f.getHashPage(hashDirectory, tid, WritePerm)
+	if err != nil {
+		return err
+	}
+	for {
+		chain, err := f.bucketChain(dir.bucketFor(hash), tid, WritePerm)
+		if err != nil {
+			return err
+		}
+		for _, p := range chain {
+			if p.find(e) >= 0 {
+				return GoDBError{IllegalOperationError, fmt.Sprintf("index %s already has an entry for %v at %v", f.fileName, e.key, e.rid)}
+			}
+		}
+		for _, p := range chain {
+			if p.size()+p.entrySize(e) <= PageSize {
+				p.entries = append(p.entries, e)
+				p.setDirty(tid, true)
+				return nil
+			}
+		}
+		splittable := false
+		for _, p := range chain {
+			for _, other := range p.entries {
+				h, err := f.hashKey(other.key)
+				if err != nil {
+					return err
+				}
+				splittable = splittable || h != hash
+			}
+		}
+		if chain[0].depth == maxHashDepth || !splittable {
+			last := chain[len(chain)-1]
+			overflow, err := f.allocateBucket(last.depth, tid)
+			if err != nil {
+				return err
+			}
+			overflow.entries = []btreeEntry{e}
+			overflow.setDirty(tid, true)
+			last.next = overflow.pageNo
+			last.setDirty(tid, true)
+			return nil
+		}
+		// split the bucket and try again: it is split again if all its
+		// entries stay together
+		if err := f.splitBucket(dir, chain, tid); err != nil {
+			return err
+		}
+	}
+}
+
+// Split the bucket whose pages are chain, moving its entries whose hash has a
+// 1 in the bit after its local depth to a new bucket, on behalf of tid, which
+// has locked dir and chain for writing. Doubles the directory if the bucket's
+// local depth is its depth.
+func (f *HashFile) splitBucket(dir *hashPage, chain []*hashPage, tid TransactionID) error {
+	depth := chain[0].depth
+	if depth == dir.depth {
+		dir.buckets = append(dir.buckets, dir.buckets...)
+		dir.depth++
+	}
+	moved, err := f.allocateBucket(depth+1, tid)
+	if err != nil {
+		return err
+	}
+	var stay, move []btreeEntry
+	for _, p := range chain {
+		for _, e := range p.entries {
+			hash, err := f.hashKey(e.key)
+			if err != nil {
+				return err
+			}
+			if hash&(1<<depth) == 0 {
+				stay = append(stay, e)
+			} else {
+				move = append(move, e)
+			}
+		}
+	}
+	for _, p := range chain {
+		p.depth = depth + 1
+		p.entries = nil
+		p.setDirty(tid, true)
+	}
+	if err := f.fillChain(chain, stay, tid); err != nil {
+		return err
+	}
+	if err := f.fillChain([]*hashPage{moved}, move, tid); err != nil {
+		return err
+	}
+	for i, pageNo := range dir.buckets {
+		if pageNo == chain[0].pageNo && i&(1<<depth) != 0 {
+			dir.buckets[i] = moved.pageNo
+		}
+	}
+	dir.setDirty(tid, true)
+	return nil
+}
+
+// Add the entries to the pages of a bucket, in order, allocating overflow
+// pages if they do not fit; the pages, locked for writing by tid, must be
+// empty.
+func (f *HashFile) fillChain(chain []*hashPage, entries []btreeEntry, tid TransactionID) error {
+	p := chain[0]
+	for _, e := range entries {
+		if len(p.entries) > 0 && p.size()+p.entrySize(e) > PageSize {
+			if next := indexOfHashPage(chain, p) + 1; next < len(chain) {
+				p = chain[next]
+			} else {
+				overflow, err := f.allocateBucket(p.depth, tid)
+				if err != nil {
+					return err
+				}
+				overflow.next, p.next = p.next, overflow.pageNo
+				chain = append(chain, overflow)
+				p = overflow
+			}
+			p.setDirty(tid, true)
+		}
+		p.entries = append(p.entries, e)
+	}
+	return nil
+}
+
+// Return the position of p in chain.
+func indexOfHashPage(chain []*hashPage, p *hashPage) int {
+	for i, q := range chain {
+		if q == p {
+			return i
+		}
+	}
+	return -1
+}
+
+// Delete the entry for the key t, whose Rid is the record id of the heap
+// tuple it indexes, on behalf of tid. Returns a TupleNotFoundError if the
+// index has no such entry.
+func (f *HashFile) deleteTuple(t *Tuple, tid TransactionID) error {
+	e, err := f.entryOf(t)
+	if err != nil {
+		return err
+	}
+	hash, err := f.hashKey(e.key)
+	if err != nil {
+		return err
+	}
+	dir, err := f.getHashPage(hashDirectory, tid, WritePerm)
+	if err != nil {
+		return err
+	}
+	chain, err := f.bucketChain(dir.bucketFor(hash), tid, WritePerm)
+	if err != nil {
+		return err
+	}
+	for _, p := range chain {
+		if i := p.find(e); i >= 0 {
+			p.entries = append(p.entries[:i], p.entries[i+1:]...)
+			p.setDirty(tid, true)
+			return nil
+		}
+	}
+	return GoDBError{TupleNotFoundError, fmt.Sprintf("index %s has no entry for %v at %v", f.fileName, e.key, e.rid)}
+}
+
+// Return a function that iterates through all the entries of the index,
+// bucket by bucket.
+func (f *HashFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	dir, err := f.getHashPage(hashDirectory, tid, ReadPerm)
+	if err != nil {
+		return nil, err
+	}
+	var buckets []int
+	seen := make(map[int]bool)
+	for _, pageNo := range dir.buckets {
+		if !seen[pageNo] {
+			seen[pageNo] = true
+			buckets = append(buckets, pageNo)
+		}
+	}
+	return f.chainIterator(buckets, nil, tid), nil
+}
+
+// Return a function that iterates through the entries whose key is key, which
+// must have a value for every key column. As no predicate is true of NULL,
+// there are none if key has a NULL.
+func (f *HashFile) Lookup(key []DBValue, tid TransactionID) (func() (*Tuple, error), error) {
+	if len(key) != len(f.keyDesc.Fields) {
+		return nil, GoDBError{IllegalOperationError, fmt.Sprintf("hash index %s can only look up keys of %d columns", f.fileName, len(f.keyDesc.Fields))}
+	}
+	if hasNullIn(key) {
+		return func() (*Tuple, error) { return nil, nil }, nil
+	}
+	hash, err := f.hashKey(key)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := f.getHashPage(hashDirectory, tid, ReadPerm)
+	if err != nil {
+		return nil, err
+	}
+	return f.chainIterator([]int{dir.bucketFor(hash)}, key, tid), nil
+}
+
+// Return a function that iterates through the entries of the buckets starting
+// at the specified pages whose key is key, or all of them if key is nil.
+func (f *HashFile) chainIterator(buckets []int, key []DBValue, tid TransactionID) func() (*Tuple, error) {
+	var p *hashPage
+	i := 0
+	return func() (*Tuple, error) {
+		for {
+			if p == nil || i == len(p.entries) {
+				next := noHashPage
+				if p != nil {
+					next = p.next
+				}
+				if next == noHashPage {
+					if len(buckets) == 0 {
+						return nil, nil
+					}
+					next, buckets = buckets[0], buckets[1:]
+				}
+				var err error
+				if p, err = f.getHashPage(next, tid, ReadPerm); err != nil {
+					return nil, err
+				}
+				i = 0
+				continue
+			}
+			e := p.entries[i]
+			i++
+			if key == nil || compareKeys(e.key, key) == 0 {
+				return &Tuple{Desc: *f.keyDesc, Fields: e.key, Rid: e.rid}, nil
+			}
+		}
+	}
+}
+
+// Create an empty bucket page.
+func newHashPage(f *HashFile, pageNo int) *hashPage {
+	return &hashPage{file: f, pageNo: pageNo, next: noHashPage, lsn: InvalidLSN}
+}
+
+// Return the position of the entry e in a bucket page, or -1 if it does not
+// hold it.
+func (p *hashPage) find(e btreeEntry) int {
+	for i, other := range p.entries {
+		if compareEntries(other, e) == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// Return the size of the page when written to the file.
+func (p *hashPage) size() int {
+	if p.directory {
+		return hashDirHeaderSize + hashPageNumberSize*len(p.buckets)
+	}
+	size := hashHeaderSize
+	for _, e := range p.entries {
+		size += p.entrySize(e)
+	}
+	return size
+}
+
+// Return the size of an entry of a bucket page when written to the file.
+func (p *hashPage) entrySize(e btreeEntry) int {
+	return e.size + btreeRidSize
+}
+
+// Page method - return whether or not the page is dirty.
+func (p *hashPage) isDirty() bool {
+	return p.dirty
+}
+
+// Page method - mark the page as dirty on behalf of tid, or as clean.
+func (p *hashPage) setDirty(tid TransactionID, dirty bool) {
+	p.dirty = dirty
+	if dirty {
+		p.dirtier = tid
+	}
+}
+
+// Page method - return the HashFile of the page.
+func (p *hashPage) getFile() DBFile {
+	return p.file
+}
+
+// Write the page to a new buffer of PageSize bytes, in the format described in
+// [HashFile].
+func (p *hashPage) toBuffer() (*bytes.Buffer, error) {
+	if p.size() > PageSize {
+		return nil, GoDBError{PageFullError, fmt.Sprintf("hash index page of %d bytes does not fit in a page", p.size())}
+	}
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, hashPageMagic)
+	if p.directory {
+		b.Write([]byte{hashDirectoryFlag, byte(p.depth)})
+		for _, pageNo := range p.buckets {
+			binary.Write(&b, binary.LittleEndian, int32(pageNo))
+		}
+	} else {
+		b.Write([]byte{0, byte(p.depth)})
+		binary.Write(&b, binary.LittleEndian, uint16(len(p.entries)))
+		binary.Write(&b, binary.LittleEndian, int32(p.next))
+		for _, e := range p.entries {
+			t := Tuple{Desc: *p.file.keyDesc, Fields: e.key}
+			if err := t.writeVarlenTo(&b); err != nil {
+				return nil, err
+			}
+			binary.Write(&b, binary.LittleEndian, [2]int32{int32(e.rid.pageNo), int32(e.rid.slot)})
+		}
+	}
+	b.Write(make([]byte, PageSize-b.Len()))
+	return &b, nil
+}
+
+// Read the page from buf, and save a copy of buf as its before image.
+func (p *hashPage) initFromBuffer(buf *bytes.Buffer) error {
+	p.beforeImage = append([]byte(nil), buf.Bytes()...)
+	header := buf.Next(hashDirHeaderSize)
+	if len(header) < hashDirHeaderSize {
+		return GoDBError{MalformedDataError, "truncated hash index page"}
+	}
+	p.directory, p.depth, p.buckets, p.entries, p.next = false, 0, nil, nil, noHashPage
+	switch binary.LittleEndian.Uint32(header) {
+	case 0:
+		return nil
+	case hashPageMagic:
+	default:
+		return GoDBError{MalformedDataError, fmt.Sprintf("page %d of %s is not a hash index page", p.pageNo, p.file.fileName)}
+	}
+	p.directory = header[4] == hashDirectoryFlag
+	p.depth = int(header[5])
+	readInt := func() (int, error) {
+		v := buf.Next(4)
+		if len(v) < 4 {
+			return 0, GoDBError{MalformedDataError, "truncated hash index page"}
+		}
+		return int(int32(binary.LittleEndian.Uint32(v))), nil
+	}
+	if p.directory {
+		if p.depth > maxHashDepth {
+			return GoDBError{MalformedDataError, fmt.Sprintf("hash index directory of depth %d", p.depth)}
+		}
+		p.buckets = make([]int, 1<<p.depth)
+		for i := range p.buckets {
+			var err error
+			if p.buckets[i], err = readInt(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	count := buf.Next(2)
+	if len(count) < 2 {
+		return GoDBError{MalformedDataError, "truncated hash index page"}
+	}
+	next, err := readInt()
+	if err != nil {
+		return err
+	}
+	p.next = next
+	for i := 0; i < int(binary.LittleEndian.Uint16(count)); i++ {
+		before := buf.Len()
+		key, err := readVarlenTupleFrom(buf, p.file.keyDesc)
+		if err != nil {
+			return err
+		}
+		e := btreeEntry{key: key.Fields, size: before - buf.Len()}
+		if e.rid.pageNo, err = readInt(); err != nil {
+			return err
+		}
+		if e.rid.slot, err = readInt(); err != nil {
+			return err
+		}
+		p.entries = append(p.entries, e)
+	}
+	return nil
+}
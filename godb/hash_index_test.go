@@ -0,0 +1,133 @@
+package godb
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func makeTestHashFile(t *testing.T) *HashFile {
+	t.Helper()
+	keyDesc := &TupleDesc{[]FieldType{{"name", "", StringType}, {"score", "", FloatType}}}
+	f, err := NewHashFile(filepath.Join(t.TempDir(), "idx.hash"), keyDesc, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return f
+}
+
+func TestHashPageSerialization(t *testing.T) {
+	f := makeTestHashFile(t)
+	if f.NumPages() != 2 {
+		t.Fatalf("expected a new index to have a directory and a bucket, got %d pages", f.NumPages())
+	}
+	pg, err := f.readPage(hashDirectory)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if dir := pg.(*hashPage); !dir.directory || dir.depth != 0 || fmt.Sprint(dir.buckets) != "[1]" {
+		t.Errorf("expected a directory with one bucket, got %+v", dir)
+	}
+
+	dir := newHashPage(f, hashDirectory)
+	dir.directory = true
+	dir.depth = maxHashDepth
+	dir.buckets = make([]int, 1<<maxHashDepth)
+	for i := range dir.buckets {
+		dir.buckets[i] = i + 1
+	}
+	bucket := newHashPage(f, 3)
+	bucket.depth = 2
+	bucket.next = 7
+	for i, key := range [][]DBValue{{StringField{"joe"}, FloatField{1.5}}, {NullField{}, FloatField{-2}}} {
+		e, err := f.entryOf(&Tuple{Fields: key, Rid: heapRid{i, 2 * i}})
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		bucket.entries = append(bucket.entries, e)
+	}
+	for _, p := range []*hashPage{dir, bucket} {
+		if err := f.flushPage(p); err != nil {
+			t.Fatalf(err.Error())
+		}
+		pg, err := f.readPage(p.pageNo)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		p2 := pg.(*hashPage)
+		if p2.directory != p.directory || p2.depth != p.depth || p2.next != p.next || fmt.Sprint(p2.buckets) != fmt.Sprint(p.buckets) || len(p2.entries) != len(p.entries) {
+			t.Fatalf("expected %+v, got %+v", p, p2)
+		}
+		for i, e := range p.entries {
+			if compareEntries(e, p2.entries[i]) != 0 || e.size != p2.entries[i].size {
+				t.Errorf("entry %d: expected %+v, got %+v", i, e, p2.entries[i])
+			}
+		}
+	}
+	dir.buckets = append(dir.buckets, dir.buckets...)
+	if _, err := dir.toBuffer(); err == nil {
+		t.Errorf("expected a directory deeper than maxHashDepth not to fit in a page")
+	}
+}
+
+func TestHashKeys(t *testing.T) {
+	f := makeTestHashFile(t)
+	hash := func(key ...DBValue) uint32 {
+		h, err := f.hashKey(key)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		return h
+	}
+	if hash(StringField{"a"}, FloatField{0}) != hash(StringField{"a"}, FloatField{math.Copysign(0, -1)}) {
+		t.Errorf("expected 0 and -0 to have the same hash")
+	}
+	if hash(StringField{"a"}, FloatField{1}) == hash(StringField{"b"}, FloatField{1}) {
+		t.Errorf("expected different keys to have different hashes")
+	}
+
+	dir := &hashPage{directory: true, depth: 2, buckets: []int{1, 2, 1, 3}}
+	for h, expected := range map[uint32]int{0: 1, 5: 2, 6: 1, 0xFF: 3} {
+		if b := dir.bucketFor(h); b != expected {
+			t.Errorf("hash %x: expected bucket %d, got %d", h, expected, b)
+		}
+	}
+
+	it, err := f.Lookup([]DBValue{NullField{}, FloatField{1}}, 0)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if e, err := it(); e != nil || err != nil {
+		t.Errorf("expected a key with a NULL to match no entry")
+	}
+	if _, err := f.Lookup([]DBValue{StringField{"a"}}, 0); err == nil {
+		t.Errorf("expected a lookup of a prefix to be rejected")
+	}
+}
+
+func TestHashIndexScanPlan(t *testing.T) {
+	f := makeTestHashFile(t)
+	hf := &HeapFile{}
+	idx := &tableIndex{name: "h", fields: []int{0, 1}, file: f}
+	hf.addIndex(idx)
+	name := &FieldExpr{FieldType{"name", "t", StringType}}
+	score := &FieldExpr{FieldType{"score", "t", FloatType}}
+	eqName := &Filter{op: OpEq, left: name, right: &ConstExpr{StringField{"joe"}, StringType}}
+
+	if node := filterChain(hf, eqName); useIndexScan(node, nil) != node {
+		t.Errorf("expected a hash index not to be used for a prefix of its key")
+	}
+	node := filterChain(hf, &Filter{op: OpGt, left: score, right: &ConstExpr{FloatField{1}, FloatType}}, eqName)
+	if useIndexScan(node, nil) != node {
+		t.Errorf("expected a hash index not to be used for a range")
+	}
+	plan := useIndexScan(filterChain(hf, eqName, &Filter{op: OpEq, left: score, right: &ConstExpr{FloatField{2}, FloatType}}), nil)
+	scan, ok := plan.Op.(*IndexScan)
+	if !ok || scan.index != idx || idx.method() != "hash" || idx.supportsRanges() {
+		t.Fatalf("expected a scan of the hash index, got %+v", plan.Op)
+	}
+	if _, err := idx.scan(&BTreeBound{scan.lo.Key, false}, scan.hi, 0); err == nil {
+		t.Errorf("expected a hash index to reject an exclusive bound")
+	}
+}
@@ -12,11 +12,38 @@ import (
 //
 // HeapFile is a public class because external callers may wish to instantiate
 // database tables using the method [LoadFromCSV]
+//
+// The methods that read and write its tuples follow the rules of the features
+// of the file and of its BufferPool, which are stated where those features are
+// implemented: row locking ([BufferPool.lockTuple] and [rowChangeTable]), MVCC
+// ([Snapshot]), short read locks at ReadCommitted ([BufferPool.startRead]),
+// phantoms ([BufferPool.lockEndOfFile]), the free space map ([freeSpaceMap]),
+// strings stored out of line ([HeapFile.toast]), clustering
+// ([HeapFile.setCluster]) and indexes ([HeapFile.addIndex]).
 type HeapFile struct {
 	// TODO: some code goes here
 	// HeapFile should include the fields below;  you may want to add
 	// additional fields
 	bufPool *BufferPool
+
+	// The definitions of the columns of the file (see [HeapFile.setColumns]);
+	// nil if they all have the zero definition.
+	columns []columnDef
+
+	// The indexes kept up to date by insertTuple and deleteTuple (see
+	// [HeapFile.addIndex]).
+	indexes []*tableIndex
+
+	// The key the tuples of the file are sorted by, if it is clustered (see
+	// [HeapFile.setCluster]); nil otherwise.
+	cluster *clusterKey
+
+	// The codec the pages of the file are compressed with (see
+	// [HeapFile.setCompression]).
+	compression pageCompression
+
+	// The room of each page for new tuples (see [freeSpaceMap]).
+	freeSpace *freeSpaceMap
 }
 
 // Create a HeapFile.
@@ -25,6 +52,8 @@ type HeapFile struct {
 // - td: the TupleDesc for the HeapFile.
 // - bp: the BufferPool that is used to store pages read from the HeapFile
 // May return an error if the file cannot be opened or created.
+// Load the free space map of the file with [openFreeSpaceMap], returning its
+// error.
 func NewHeapFile(fromFile string, td *TupleDesc, bp *BufferPool) (*HeapFile, error) {
 	// TODO: some code goes here
 	return &HeapFile{}, fmt.Errorf("NewHeapFile not implemented") //replace me
@@ -42,15 +71,68 @@ func (f *HeapFile) NumPages() int {
 	return 0 //replace me
 }
 
+// The longest line [HeapFile.LoadFromCSV] accepts.
+const maxCSVLineLength = 16 << 20
+
 // Load the contents of a heap file from a specified CSV file.  Parameters are as follows:
 // - hasHeader:  whether or not the CSV file has a header
 // - sep: the character to use to separate fields
 // - skipLastField: if true, the final field is skipped (some TPC datasets include a trailing separator on each line)
 // Returns an error if the field cannot be opened or if a line is malformed
+// Values of STRING columns are truncated to StringLength bytes; a value too
+// long for a VARCHAR(n) column is an error (see [stringLimit]). In nullable
+// columns, \N is NULL, and so is an empty value unless the column is a string
+// column. Values of DECIMAL(p,s) columns are rounded to s digits after the
+// decimal point, and a value with too many digits is an error (see
+// [HeapFile.roundDecimals]). Lines may be up to maxCSVLineLength bytes long,
+// since TEXT values can be larger than a page.
 // We provide the implementation of this method, but it won't work until
 // [HeapFile.insertTuple] and some other utility functions are implemented
 func (f *HeapFile) LoadFromCSV(file *os.File, hasHeader bool, sep string, skipLastField bool) error {
+	return f.loadCSV(file, hasHeader, sep, skipLastField, f)
+}
+
+// Load the contents of dest, a file whose columns have the definitions of the
+// columns of f, from a CSV file, as [HeapFile.LoadFromCSV] does.
+//
+// If the BufferPool has a log file, pages written without being logged could
+// be overwritten by older images during recovery, so the whole file is loaded
+// in a single transaction, which STEAL allows to dirty more pages than the
+// BufferPool holds, unless the BufferPool uses row locking (see
+// [rowChangeTable]). The transaction is aborted if a line cannot be loaded.
+func (f *HeapFile) loadCSV(file *os.File, hasHeader bool, sep string, skipLastField bool, dest DBFile) error {
+	bp := f.bufPool
+	if bp.logFile == nil {
+		return f.scanCSV(file, hasHeader, sep, skipLastField, dest, func(t *Tuple) error {
+			tid := NewTID()
+			dest.insertTuple(t, tid)
+
+			// Force dirty pages to disk. CommitTransaction may not be implemented
+			// yet if this is called in lab 1 or 2.
+			bp.FlushAllPages()
+			return nil
+		})
+	}
+	tid := NewTID()
+	if err := bp.BeginTransaction(tid); err != nil {
+		return err
+	}
+	err := f.scanCSV(file, hasHeader, sep, skipLastField, dest, func(t *Tuple) error {
+		return dest.insertTuple(t, tid)
+	})
+	if err != nil {
+		bp.AbortTransaction(tid)
+		return err
+	}
+	return bp.CommitTransaction(tid)
+}
+
+// Parse the lines of a CSV file into tuples of dest, as described in
+// [HeapFile.LoadFromCSV], and pass each of them to insert, returning the first
+// error.
+func (f *HeapFile) scanCSV(file *os.File, hasHeader bool, sep string, skipLastField bool, dest DBFile, insert func(t *Tuple) error) error {
 	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxCSVLineLength)
 	cnt := 0
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -60,19 +142,23 @@ func (f *HeapFile) LoadFromCSV(file *os.File, hasHeader bool, sep string, skipLa
 		}
 		numFields := len(fields)
 		cnt++
-		desc := f.Descriptor()
+		desc := dest.Descriptor()
 		if desc == nil || desc.Fields == nil {
 			return GoDBError{MalformedDataError, "Descriptor was nil"}
 		}
 		if numFields != len(desc.Fields) {
-			return GoDBError{MalformedDataError, fmt.Sprintf("LoadFromCSV:  line %d (%s) does not have expected number of fields (expected %d, got %d)", cnt, line, len(f.Descriptor().Fields), numFields)}
+			return GoDBError{MalformedDataError, fmt.Sprintf("LoadFromCSV:  line %d (%s) does not have expected number of fields (expected %d, got %d)", cnt, line, len(dest.Descriptor().Fields), numFields)}
 		}
 		if cnt == 1 && hasHeader {
 			continue
 		}
 		var newFields []DBValue
 		for fno, field := range fields {
-			switch f.Descriptor().Fields[fno].Ftype {
+			if f.isNullable(fno) && isNullCSVValue(field, dest.Descriptor().Fields[fno].Ftype) {
+				newFields = append(newFields, NullField{})
+				continue
+			}
+			switch dest.Descriptor().Fields[fno].Ftype {
 			case IntType:
 				field = strings.TrimSpace(field)
 				floatVal, err := strconv.ParseFloat(field, 64)
@@ -82,23 +168,31 @@ func (f *HeapFile) LoadFromCSV(file *os.File, hasHeader bool, sep string, skipLa
 				intValue := int(floatVal)
 				newFields = append(newFields, IntField{int64(intValue)})
 			case StringType:
-				if len(field) > StringLength {
+				limit := f.stringLimit(fno)
+				if !limit.varlen() && len(field) > StringLength {
 					field = field[0:StringLength]
 				}
+				if limit.exceededBy(len(field)) {
+					return GoDBError{TypeMismatchError, fmt.Sprintf("LoadFromCSV: value of %d bytes too long for %s column %s, tuple %d", len(field), columnTypeName(StringType, f.column(fno)), dest.Descriptor().Fields[fno].Fname, cnt)}
+				}
 				newFields = append(newFields, StringField{field})
+			default:
+				value, err := parseFieldValue(field, dest.Descriptor().Fields[fno].Ftype)
+				if err != nil {
+					return GoDBError{TypeMismatchError, fmt.Sprintf("LoadFromCSV: %s, tuple %d", err.(GoDBError).errString, cnt)}
+				}
+				newFields = append(newFields, value)
 			}
 		}
-		newT := Tuple{*f.Descriptor(), newFields, nil}
-		tid := NewTID()
-		bp := f.bufPool
-		f.insertTuple(&newT, tid)
-
-		// Force dirty pages to disk. CommitTransaction may not be implemented
-		// yet if this is called in lab 1 or 2.
-		bp.FlushAllPages()
-
+		newT := Tuple{*dest.Descriptor(), newFields, nil}
+		if err := f.roundDecimals(&newT); err != nil {
+			return GoDBError{NumericOverflowError, fmt.Sprintf("LoadFromCSV: %s, tuple %d", err.(GoDBError).errString, cnt)}
+		}
+		if err := insert(&newT); err != nil {
+			return err
+		}
 	}
-	return nil
+	return scanner.Err()
 }
 
 // Read the specified page number from the HeapFile on disk. This method is
@@ -113,20 +207,30 @@ func (f *HeapFile) readPage(pageNo int) (Page, error) {
 	return nil, fmt.Errorf("readPage not implemented")
 }
 
-// Add the tuple to the HeapFile. This method should search through pages in the
-// heap file, looking for empty slots and adding the tuple in the first empty
-// slot if finds.
+// Add the tuple to the HeapFile, setting t.Rid to where it is stored. This
+// method should not read every page of the heap file looking for room: it
+// should ask the free space map of the file for a page that may have room (see
+// [freeSpaceMap]), and record what it finds there with [HeapFile.notePageFull]
+// and [HeapFile.noteFreeSpace].
 //
-// If none are found, it should create a new [heapPage] and insert the tuple
+// If no page has room, it should create a new [heapPage] and insert the tuple
 // there, and write the heapPage to the end of the HeapFile (e.g., using the
-// [flushPage] method.)
+// [flushPage] method), once it has locked the end of the file for writing with
+// [BufferPool.lockEndOfFile].
 //
 // To iterate through pages, it should use the [BufferPool.GetPage method]
 // rather than directly reading pages itself. For lab 1, you do not need to
 // worry about concurrent transactions modifying the Page or HeapFile. We will
 // add support for concurrent modifications in lab 3.
 //
-// The page the tuple is inserted into should be marked as dirty.
+// The tuple is first checked with [HeapFile.checkNotNull],
+// [HeapFile.roundDecimals], [HeapFile.checkStringLengths] (for variable-length
+// files, see [HeapFile.isVarlen]) and [HeapFile.checkUniqueKeys], returning
+// their errors. The tuple [HeapFile.toast] returns is then inserted with
+// [HeapFile.changeSlot], or [HeapFile.insertClustered] if the file is
+// clustered; it is locked for writing with [BufferPool.lockTuple], and its
+// version set to have xmin tid with [heapPage.setVersion]. Finally its entries
+// are added to the indexes of the file with [HeapFile.insertIndexEntries].
 func (f *HeapFile) insertTuple(t *Tuple, tid TransactionID) error {
 	// TODO: some code goes here
 	return fmt.Errorf("insertTuple not implemented") //replace me
@@ -140,7 +244,13 @@ func (f *HeapFile) insertTuple(t *Tuple, tid TransactionID) error {
 // empty interface, so you can supply any object you wish. You will likely want
 // to identify the heap page and slot within the page that the tuple came from.
 //
-// The page the tuple is deleted from should be marked as dirty.
+// The tuple is deleted from its page with [HeapFile.changeSlot], once t.Rid is
+// locked for writing with [BufferPool.lockTuple]. Its index entries and
+// overflow chains are removed with [HeapFile.deleteIndexEntries] and
+// [HeapFile.freeToastedValues], and the room it leaves is recorded with
+// [HeapFile.noteFreeSpace]. If the BufferPool uses MVCC, the tuple is instead
+// only marked deleted by tid, once [Snapshot.checkDelete] allows it, and
+// [HeapFile.Vacuum] removes it later.
 func (f *HeapFile) deleteTuple(t *Tuple, tid TransactionID) error {
 	// TODO: some code goes here
 	return fmt.Errorf("deleteTuple not implemented") //replace me
@@ -150,7 +260,9 @@ func (f *HeapFile) deleteTuple(t *Tuple, tid TransactionID) error {
 // appropriate location. This will be called by BufferPool when it wants to
 // evict a page. The Page object should store information about its offset on
 // disk (e.g., that it is the ith page in the heap file), so you can determine
-// where to write it back.
+// where to write it back. Once the page is written, persist its room (see
+// [heapPage.freeRoom]) in the free space map of the file with
+// [freeSpaceMap.persist], so that the map on disk describes the pages on disk.
 func (f *HeapFile) flushPage(p Page) error {
 	// TODO: some code goes here
 	return fmt.Errorf("flushPage not implemented") //replace me
@@ -174,6 +286,13 @@ func (f *HeapFile) Descriptor() *TupleDesc {
 // set appropriate so that [deleteTuple] will work (see additional comments there).
 // Make sure to set the returned tuple's TupleDescriptor to the TupleDescriptor of
 // the HeapFile. This allows it to correctly capture the table qualifier.
+//
+// Each tuple is returned as [HeapFile.readTuple] returns it. A page must not be
+// left open across calls to the iterator: copy its tuples out of it between
+// [BufferPool.startRead] and endRead, as [HeapFile.readClusteredPage] does.
+// Call [BufferPool.refreshSnapshot] before reading the first page, and lock the
+// end of the file for reading with [BufferPool.lockEndOfFile] once the last
+// page has been read.
 func (f *HeapFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 	// TODO: some code goes here
 	return func() (*Tuple, error) {
@@ -181,6 +300,19 @@ func (f *HeapFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 	}, nil
 }
 
+// Return a function that iterates through the records in the heap file as
+// [HeapFile.Iterator] does, locking them as specified by locking (see
+// [scanLocking]); Iterator behaves like this method with defaultScanLocking.
+//
+// Pages are read with [BufferPool.getPageWait], with ReadPerm if the BufferPool
+// uses row locking, and tuples are locked with [BufferPool.lockTuple]; with
+// LockSkipLocked, the tuples that cannot be locked without waiting are
+// skipped.
+func (f *HeapFile) iteratorWithLocking(tid TransactionID, locking scanLocking) (func() (*Tuple, error), error) {
+	// TODO: some code goes here
+	return nil, fmt.Errorf("heap_file.iteratorWithLocking not implemented") //replace me
+}
+
 // internal strucuture to use as key for a heap page
 type heapHash struct {
 	FileName string
@@ -23,7 +23,8 @@ Each tuple occupies the same number of bytes.  You can use the go function
 unsafe.Sizeof() to determine the size in bytes of an object.  So, a GoDB integer
 (represented as an int64) requires unsafe.Sizeof(int64(0)) bytes.  For strings,
 we encode them as byte arrays of StringLength, so they are size
-((int)(unsafe.Sizeof(byte('a')))) * StringLength bytes.  The size in bytes  of a
+((int)(unsafe.Sizeof(byte('a')))) * StringLength bytes.  The sizes of fields of
+the other types are given by [DBType.fixedSize].  The size in bytes  of a
 tuple is just the sum of the size in bytes of its fields.
 
 Once you have figured out how big a record is, you can determine the number of
@@ -44,31 +45,106 @@ Note that to process deletions you will likely delete tuples at a specific
 position (slot) in the heap page.  This means that after a page is read from
 disk, tuples should retain the same slot number. Because GoDB will never evict a
 dirty page, it's OK if tuples are renumbered when they are written back to disk.
+(This is not the case once the BufferPool has a log file: dirty pages may then
+be evicted and read again by the transaction that dirtied them, so tuples must
+keep their slots on disk too.)
+
+To support the write-ahead log (see [LogFile]), each heap page also keeps the
+LSN of the last update record logged for it and a before image: the bytes of
+the page as they were last read from disk, or as of the last commit that logged
+the page. [heapPage.initFromBuffer] should save a copy of the buffer it reads
+as the before image. A page created by [newHeapPage] has a nil before image, as
+it does not exist on disk yet, and its LSN is InvalidLSN. Neither is part of
+the on-disk format of the page.
+
+If the BufferPool uses MVCC (see [VersionManager]), each tuple is stored
+preceded by its version header, which is tupleVersionSize bytes long (see
+[tupleVersion.writeTo] and [readTupleVersion]); this reduces the number of
+slots that fit on a page. The version of each used slot is kept in memory and
+accessed with [heapPage.versionOf] and [heapPage.setVersion].
+
+Pages of files with variable-length or nullable columns (see
+[HeapFile.isVarlen]) are instead stored as slotted pages (see [slottedPage]):
+each tuple is a record of the slotted page, holding the tuple serialized with
+[Tuple.writeVarlenTo] (preceded by its version header if the BufferPool uses
+MVCC), and the slot of a record is its slot number in the heap page. The
+number of slots of such a page is the number of slots of its directory, and a
+tuple fits on the page if its record fits in the free space of the slotted
+page (see [slottedPage.freeSpace]). [heapPage.initFromBuffer] should check the
+format of the page with [isSlottedPage], so that pages written in the
+fixed-length format before a file had such columns can still be read; pages
+of such files are always written as slotted pages.
+
+A slotted page may be an overflow page, holding a chunk of a string stored out
+of line rather than tuples (see [HeapFile.toast]); such a page has no tuples,
+and no room for any.
+
+If the BufferPool uses row locking, several running transactions may change
+the same page, each the slots of the tuples it has locked (see
+[rowChangeTable]), so the methods of a heapPage must be safe to call
+concurrently, e.g. by guarding the page with a mutex.
+
+If the file is compressed (see [HeapFile.setCompression]), its pages other
+than overflow pages are laid out in [HeapFile.pageSize] bytes rather than
+PageSize bytes, in either format, and compressed into PageSize bytes with
+[compressPage] when they are written. A page then has room for a tuple only
+if it still compresses into PageSize bytes once the tuple is inserted.
 
 */
 
 type heapPage struct {
 	// TODO: some code goes here
+	// heapPage should include the fields below; you may want to add
+	// additional fields
+
+	lsn         LSN    // LSN of the last update record logged for this page
+	beforeImage []byte // page contents as of the last read from disk or commit
+
+	versions []tupleVersion // version of each slot; nil unless using MVCC
+
+	slots *slottedPage // records of the page; nil unless the file is variable length
 }
 
-// Construct a new heap page
+// Construct a new heap page. Its number of slots, or the size of its slotted
+// page (see [newSlottedPageOfSize]), is computed from [HeapFile.pageSize]
+// rather than PageSize.
 func newHeapPage(desc *TupleDesc, pageNo int, f *HeapFile) (*heapPage, error) {
 	// TODO: some code goes here
 	return &heapPage{}, fmt.Errorf("newHeapPage is not implemented") //replace me
 }
 
+// Return the number of slots of the page. For a slotted page, this is the
+// number of slots of its directory, which changes as tuples are inserted and
+// deleted.
 func (h *heapPage) getNumSlots() int {
 	// TODO: some code goes here
 	return 0 //replace me
 }
 
-// Insert the tuple into a free slot on the page, or return an error if there are
-// no free slots.  Set the tuples rid and return it.
+// Insert the tuple into a free slot on the page, or return a PageFullError if
+// there are no free slots.  Set the tuples rid to its [heapRid] and return it.
+// For a slotted page, insert its record with [slottedPage.insert], which
+// returns a PageFullError if the page does not have enough free space. Never
+// insert into an overflow page, which is always full. If the file is
+// compressed, check that the page still compresses into PageSize bytes with
+// [heapPage.toBuffer]; if it returns a PageFullError, remove the tuple again
+// and return that error. If the BufferPool uses row locking, do not use the
+// slots other running transactions have changed, and leave room for the
+// records they need to store back into them (see [rowChangeTable.reserved]).
 func (h *heapPage) insertTuple(t *Tuple) (recordID, error) {
 	// TODO: some code goes here
 	return 0, fmt.Errorf("insertTuple not implemented") //replace me
 }
 
+// Return the room the page has for new tuples, as recorded in the free space
+// map of its file (see [freeSpaceMap]): its number of free slots, or the free
+// space of its slotted page (see [slottedPage.freeSpace]). An overflow page
+// has no room.
+func (h *heapPage) freeRoom() int {
+	// TODO: some code goes here
+	return 0 //replace me
+}
+
 // Delete the tuple at the specified record ID, or return an error if the ID is
 // invalid.
 func (h *heapPage) deleteTuple(rid recordID) error {
@@ -76,6 +152,37 @@ func (h *heapPage) deleteTuple(rid recordID) error {
 	return fmt.Errorf("deleteTuple not implemented") //replace me
 }
 
+// Return a copy of the record stored in the slot with the specified record ID,
+// as written to the page (including its version header if the BufferPool uses
+// MVCC), or nil if the slot is free. Used to undo changes with row locking
+// (see [rowChangeTable]).
+func (h *heapPage) recordAt(rid recordID) []byte {
+	// TODO: some code goes here
+	return nil //replace me
+}
+
+// Store rec, a record returned by [heapPage.recordAt], in the slot with the
+// specified record ID, replacing the tuple stored there, or free the slot if
+// rec is nil. Returns a PageFullError if rec does not fit on the page.
+func (h *heapPage) setRecord(rid recordID, rec []byte) error {
+	// TODO: some code goes here
+	return fmt.Errorf("setRecord not implemented") //replace me
+}
+
+// Return the version of the tuple at the specified record ID. Only used if the
+// BufferPool uses MVCC.
+func (h *heapPage) versionOf(rid recordID) tupleVersion {
+	// TODO: some code goes here
+	return tupleVersion{InvalidTID, InvalidTID} //replace me
+}
+
+// Set the version of the tuple at the specified record ID, or return an error
+// if the ID is invalid. Only used if the BufferPool uses MVCC.
+func (h *heapPage) setVersion(rid recordID, v tupleVersion) error {
+	// TODO: some code goes here
+	return fmt.Errorf("setVersion not implemented") //replace me
+}
+
 // Page method - return whether or not the page is dirty
 func (h *heapPage) isDirty() bool {
 	// TODO: some code goes here
@@ -98,21 +205,33 @@ func (p *heapPage) getFile() DBFile {
 // if the write to the the buffer fails. You will likely want to call this from
 // your [HeapFile.flushPage] method.  You should write the page header, using
 // the binary.Write method in LittleEndian order, followed by the tuples of the
-// page, written using the Tuple.writeTo method.
+// page, written using the Tuple.writeTo method. Pages of files with
+// variable-length columns are written with [slottedPage.writeTo] instead. If
+// the file is compressed, lay the page out in [HeapFile.pageSize] bytes and
+// return the buffer [compressPage] returns for them, except for overflow
+// pages, which are written uncompressed.
 func (h *heapPage) toBuffer() (*bytes.Buffer, error) {
 	// TODO: some code goes here
 	return nil, fmt.Errorf("heap_page.toBuffer not implemented") //replace me
 }
 
-// Read the contents of the HeapPage from the supplied buffer.
+// Read the contents of the HeapPage from the supplied buffer. First
+// decompress it with [decompressPage], which returns pages that are not
+// compressed unchanged. If the page then holds a slotted page (see
+// [isSlottedPage]), read it with [readSlottedPageOfSize], passing the length
+// of the page, and its tuples with [readVarlenTupleFrom]; otherwise read the
+// fixed-length format, even if the file is variable length. The before image
+// is a copy of the buffer as read, compressed or not.
 func (h *heapPage) initFromBuffer(buf *bytes.Buffer) error {
 	// TODO: some code goes here
 	return fmt.Errorf("initFromBuffer not implemented") //replace me
 }
 
 // Return a function that iterates through the tuples of the heap page.  Be sure
-// to set the rid of the tuple to the rid struct of your choosing beforing
-// return it. Return nil, nil when the last tuple is reached.
+// to set the rid of the tuple to its [heapRid] before returning it. Return nil,
+// nil when the last tuple is reached. An overflow page has no tuples. Strings
+// stored out of line are returned as [toastPointer] values, as read by
+// [readVarlenTupleFrom].
 func (p *heapPage) tupleIter() func() (*Tuple, error) {
 	// TODO: some code goes here
 	return func() (*Tuple, error) {
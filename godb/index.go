@@ -0,0 +1,309 @@
+package godb
+
+import "fmt"
+
+/*
+An index of a heap file maps the values of some of its columns, the key
+columns, to the record ids of the tuples that hold them, so that the tuples
+matching a predicate on the key columns can be found without scanning the
+file. Indexes are files of their own, such as B+ trees (see [BTreeFile]) and
+hash indexes (see [HashFile]), whose tuples are keys: a key tuple holds the values of the key columns of a
+heap tuple, and its Rid is the record id of that tuple.
+
+The indexes of a heap file are kept up to date by [HeapFile.insertTuple] and
+[HeapFile.deleteTuple], which add and remove the entries of the tuples they
+insert and delete (see [HeapFile.insertIndexEntries] and
+[HeapFile.deleteIndexEntries]). Since indexes are modified on behalf of the
+same transaction as the heap pages, aborting the transaction rolls back both:
+the pages of hash indexes are restored like heap pages, and the entries of B+
+trees are deleted or inserted again (see [rowChangeTable]). If the BufferPool uses MVCC, a deleted tuple
+keeps its entries until [HeapFile.Vacuum] removes it, so readers must check
+the visibility of the tuples they find through an index.
+
+A unique index rejects a tuple whose key is that of another tuple of the file
+(see [HeapFile.checkUniqueKeys]); keys that have a NULL never conflict, as in
+SQL, where NULL is not equal to anything. Under MVCC, the key of a tuple
+another transaction deleted conflicts until that transaction commits, and the
+key of a tuple inserted by a transaction that is still running conflicts too,
+so that two transactions cannot both commit the same key. Since index nodes
+are not locked until the transactions that read them end (see [BTreeFile]),
+transactions also lock the keys they insert into and delete from unique
+indexes (see [uniqueKeyLockKey]).
+
+An index may also store the values of other columns of the tuples, its
+INCLUDE columns, after the key columns of its entries. They are not part of
+the key, so they cannot be used to look tuples up and are ignored by unique
+indexes, but they let queries that only need the columns of an index read
+them from the index without fetching the tuples (see [IndexOnlyScan]).
+*/
+
+// The record id of a heap tuple: the page and slot it is stored in. Tuples of
+// heap files must have heapRid record ids (see [heapPage.insertTuple]), since
+// indexes store them.
+type heapRid struct {
+	pageNo int
+	slot   int
+}
+
+// The lock key of a key of a unique index. A transaction locks the keys it
+// inserts into or deletes from a unique index for writing until it ends, so
+// that no other transaction inserts a key between the check that the key is
+// free and the insert, or reuses a key whose delete may still be undone.
+type uniqueKeyLockKey struct {
+	index DBFile
+	key   string
+}
+
+// An index of a heap file.
+type tableIndex struct {
+	name    string
+	fields  []int  // the positions of the key columns in the TupleDesc of the heap file
+	include []int  // the positions of the INCLUDE columns
+	file    DBFile // the index, whose TupleDesc is that of the key columns followed by the INCLUDE columns
+	unique  bool
+}
+
+// Return the entry of the heap tuple t in the index: the values of its key
+// columns followed by those of its INCLUDE columns.
+func (idx *tableIndex) keyOf(t *Tuple) (*Tuple, error) {
+	columns := append(idx.fields[:len(idx.fields):len(idx.fields)], idx.include...)
+	key := &Tuple{Desc: *idx.file.Descriptor(), Fields: make([]DBValue, len(columns)), Rid: t.Rid}
+	for i, field := range columns {
+		if field >= len(t.Fields) {
+			return nil, GoDBError{MalformedDataError, fmt.Sprintf("tuple has no column %d for index %s", field, idx.name)}
+		}
+		key.Fields[i] = t.Fields[field]
+	}
+	return key, nil
+}
+
+// Return the access method of the index, as named in CREATE INDEX ... USING
+// method.
+func (idx *tableIndex) method() string {
+	if _, ok := idx.file.(*HashFile); ok {
+		return "hash"
+	}
+	return "btree"
+}
+
+// Return true if the index can scan ranges of keys, rather than only look up
+// the entries of a key.
+func (idx *tableIndex) supportsRanges() bool {
+	_, ok := idx.file.(*BTreeFile)
+	return ok
+}
+
+// Return an iterator over the key tuples of the index between lo and hi, on
+// behalf of tid (see [BTreeFile.RangeIterator]), or over all of them if both
+// are nil. If the index does not support ranges, lo and hi must otherwise
+// both be inclusive bounds of the same key, with a value for every key column.
+func (idx *tableIndex) scan(lo, hi *BTreeBound, tid TransactionID) (func() (*Tuple, error), error) {
+	if lo == nil && hi == nil {
+		return idx.file.Iterator(tid)
+	}
+	switch file := idx.file.(type) {
+	case *BTreeFile:
+		return file.RangeIterator(lo, hi, tid)
+	case *HashFile:
+		if lo != nil && hi != nil && lo.Inclusive && hi.Inclusive && len(lo.Key) == len(hi.Key) && compareKeys(lo.Key, hi.Key) == 0 {
+			return file.Lookup(lo.Key, tid)
+		}
+	}
+	return nil, GoDBError{IllegalOperationError, fmt.Sprintf("index %s does not support this scan", idx.name)}
+}
+
+// Add the index to the indexes the file keeps up to date, replacing the
+// index with the same name, if any. The index must already hold the entries of
+// the tuples of the file.
+func (f *HeapFile) addIndex(idx *tableIndex) {
+	f.dropIndex(idx.name)
+	f.indexes = append(f.indexes, idx)
+}
+
+// Remove the index with the specified name from the indexes of the file,
+// returning it, or nil if the file has no such index.
+func (f *HeapFile) dropIndex(name string) *tableIndex {
+	for i, idx := range f.indexes {
+		if idx.name == name {
+			f.indexes = append(f.indexes[:i:i], f.indexes[i+1:]...)
+			return idx
+		}
+	}
+	return nil
+}
+
+// Add the entries of t, which has just been inserted into the file, to the
+// indexes of the file on behalf of tid. t must hold the values inserted, and
+// its Rid must be the record id of the tuple.
+func (f *HeapFile) insertIndexEntries(t *Tuple, tid TransactionID) error {
+	for _, idx := range f.indexes {
+		key, err := idx.keyOf(t)
+		if err != nil {
+			return err
+		}
+		if err := idx.file.insertTuple(key, tid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove the entries of t, which is being removed from its page, from the
+// indexes of the file on behalf of tid. t is the tuple as read from its page:
+// strings it stores out of line are fetched, so this must be called before
+// their overflow chains are freed (see [HeapFile.freeToastedValues]).
+func (f *HeapFile) deleteIndexEntries(t *Tuple, tid TransactionID) error {
+	if len(f.indexes) == 0 {
+		return nil
+	}
+	t, err := f.detoast(t, tid)
+	if err != nil {
+		return err
+	}
+	for _, idx := range f.indexes {
+		if idx.unique {
+			if err := f.lockUniqueKey(idx, t, tid); err != nil {
+				return err
+			}
+		}
+		key, err := idx.keyOf(t)
+		if err != nil {
+			return err
+		}
+		if err := idx.file.deleteTuple(key, tid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return a UniqueViolationError if a unique index of the file already has an
+// entry with the key of t, which is about to be inserted into the file on
+// behalf of tid, for a tuple that may still be live (see
+// [VersionManager.mayBeLive]) if the BufferPool uses MVCC. Keys with a NULL
+// are not checked. The keys of t are locked before they are checked (see
+// [HeapFile.lockUniqueKey]).
+func (f *HeapFile) checkUniqueKeys(t *Tuple, tid TransactionID) error {
+	for _, idx := range f.indexes {
+		if idx.unique {
+			if err := f.lockUniqueKey(idx, t, tid); err != nil {
+				return err
+			}
+			if err := f.checkUniqueKey(idx, t, tid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Return a UniqueViolationError if idx, an index of the file, has an entry
+// with the key of t for a tuple that may be live, unless the key has a NULL.
+// The INCLUDE columns of idx are not part of the key.
+func (f *HeapFile) checkUniqueKey(idx *tableIndex, t *Tuple, tid TransactionID) error {
+	entry, err := idx.keyOf(t)
+	if err != nil {
+		return err
+	}
+	key := entry.Fields[:len(idx.fields)]
+	if hasNullIn(key) {
+		return nil
+	}
+	bound := &BTreeBound{key, true}
+	iter, err := idx.scan(bound, bound, tid)
+	if err != nil {
+		return err
+	}
+	for e, err := iter(); e != nil || err != nil; e, err = iter() {
+		if err != nil {
+			return err
+		}
+		live, err := f.mayBeLive(e.Rid.(heapRid), tid)
+		if err != nil {
+			return err
+		}
+		if live {
+			return GoDBError{UniqueViolationError, fmt.Sprintf("duplicate key %v in unique index %s", key, idx.name)}
+		}
+	}
+	return nil
+}
+
+// Lock the key of t, a tuple of the file, in idx, a unique index of the file,
+// for writing on behalf of tid until tid ends (see [uniqueKeyLockKey]). Keys
+// with a NULL, which never conflict, are not locked.
+func (f *HeapFile) lockUniqueKey(idx *tableIndex, t *Tuple, tid TransactionID) error {
+	entry, err := idx.keyOf(t)
+	if err != nil {
+		return err
+	}
+	key := entry.Fields[:len(idx.fields)]
+	if hasNullIn(key) {
+		return nil
+	}
+	return f.bufPool.lockKey(tid, uniqueKeyLockKey{idx.file, fmt.Sprint(key)}, ExclusiveLock, LockWait)
+}
+
+// Return true if the tuple of the file with the specified record id may be
+// live (see [VersionManager.mayBeLive]), reading its page on behalf of tid.
+// Every tuple that has index entries is live if the BufferPool does not use
+// MVCC.
+func (f *HeapFile) mayBeLive(rid heapRid, tid TransactionID) (bool, error) {
+	vm := f.bufPool.versions
+	if vm == nil {
+		return true, nil
+	}
+	defer f.bufPool.endRead(tid, f.bufPool.startRead(tid))
+	pg, err := f.bufPool.GetPage(f, rid.pageNo, tid, ReadPerm)
+	if err != nil {
+		return false, err
+	}
+	return vm.mayBeLive(pg.(*heapPage).versionOf(rid), tid), nil
+}
+
+// Add the entries of every tuple of the file to idx, which is not yet one of
+// the indexes of the file, on behalf of tid. Every tuple version has an entry,
+// including those that are not visible to tid, since [HeapFile.Vacuum]
+// removes the entries of dead versions. If idx is unique, return a
+// UniqueViolationError if it gets two entries with the same key for tuples
+// that may be live (see [HeapFile.checkUniqueKey]).
+func (f *HeapFile) buildIndex(idx *tableIndex, tid TransactionID) error {
+	for pageNo := 0; pageNo < f.NumPages(); pageNo++ {
+		read := f.bufPool.startRead(tid)
+		pg, err := f.bufPool.GetPage(f, pageNo, tid, ReadPerm)
+		if err != nil {
+			return err
+		}
+		hp := pg.(*heapPage)
+		iter := hp.tupleIter()
+		for t, err := iter(); t != nil || err != nil; t, err = iter() {
+			if err != nil {
+				return err
+			}
+			t, err = f.detoast(t, tid)
+			if err != nil {
+				return err
+			}
+			if idx.unique {
+				live, err := f.mayBeLive(t.Rid.(heapRid), tid)
+				if err != nil {
+					return err
+				}
+				if live {
+					if err := f.checkUniqueKey(idx, t, tid); err != nil {
+						return err
+					}
+				}
+			}
+			key, err := idx.keyOf(t)
+			if err != nil {
+				return err
+			}
+			if err := idx.file.insertTuple(key, tid); err != nil {
+				return err
+			}
+		}
+		f.bufPool.endRead(tid, read)
+	}
+	return nil
+}
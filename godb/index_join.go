@@ -0,0 +1,183 @@
+package godb
+
+import "fmt"
+
+/*
+An index nested-loop join (see [IndexJoin]) joins an outer operator with a
+table by looking up, for each outer tuple, the tuples of the table whose join
+column has the outer tuple's join value in an index of the table, rather than
+scanning the table. It is much faster than an [EqualityJoin] when the outer
+side is small, as in joins that fetch a few rows by key.
+
+The planner uses it instead of an EqualityJoin when one side of the join is a
+table whose join column is the first key column of one of its indexes (the
+only one for a hash index), compared with an expression of the same type,
+and the other side is small enough: looking up each of its tuples, which
+costs about indexProbeCost tuples read, must read fewer tuples than scanning
+the table (see [planIndexJoin]). The filters of the table are applied to the
+output of the join. Tables read with an index scan (see [IndexScan]) are not
+joined with an index nested-loop join, since their scan is already cheap.
+*/
+
+// Whether the planner may use index nested-loop joins.
+var EnableIndexJoins = true
+
+// The cost of looking up a key in an index, in tuples read by a scan.
+const indexProbeCost = 4
+
+// An index nested-loop join of outer with the tuples of inner, found through
+// index, whose first key column is innerField.
+type IndexJoin struct {
+	outer      Operator
+	outerField Expr // the join value of outer tuples
+	inner      *HeapFile
+	index      *tableIndex
+	innerField *FieldExpr
+
+	// whether inner is the left side of the join, whose fields come first in
+	// the tuples the join returns
+	innerIsLeft bool
+}
+
+// Return the TupleDesc of the tuples of the join: the fields of its left side
+// followed by those of its right side.
+func (j *IndexJoin) Descriptor() *TupleDesc {
+	if j.innerIsLeft {
+		return j.inner.Descriptor().merge(j.outer.Descriptor())
+	}
+	return j.outer.Descriptor().merge(j.inner.Descriptor())
+}
+
+// Return an iterator over the join of each outer tuple with the inner tuples
+// whose join value is equal to its own, found with an index lookup; the inner
+// tuples are read as an [IndexScan] reads them. Outer tuples whose join value
+// is NULL join no tuple.
+func (j *IndexJoin) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	outer, err := j.outer.Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	j.inner.bufPool.refreshSnapshot(tid)
+	var current *Tuple
+	var matches func() (*Tuple, error)
+	return func() (*Tuple, error) {
+		for {
+			if matches == nil {
+				current, err = outer()
+				if err != nil || current == nil {
+					return nil, err
+				}
+				v, err := j.outerField.EvalExpr(current)
+				if err != nil {
+					return nil, err
+				}
+				if isNull(v) {
+					continue
+				}
+				bound := &BTreeBound{[]DBValue{v}, true}
+				if matches, err = j.index.scan(bound, bound, tid); err != nil {
+					return nil, err
+				}
+			}
+			e, err := matches()
+			if err != nil {
+				return nil, err
+			}
+			if e == nil {
+				matches = nil
+				continue
+			}
+			t, err := j.inner.fetchTuple(e.Rid.(heapRid), tid)
+			if err != nil {
+				return nil, err
+			}
+			if t == nil {
+				continue
+			}
+			if j.innerIsLeft {
+				return joinTuples(t, current), nil
+			}
+			return joinTuples(current, t), nil
+		}
+	}, nil
+}
+
+// Return a description of the join, such as "t.a == u.b using btree index i".
+func (j *IndexJoin) String() string {
+	return fmt.Sprintf("%s == %s using %s index %s", exprToStr(j.outerField), exprToStr(j.innerField), j.index.method(), j.index.name)
+}
+
+// Return the scan at the bottom of node, the plan of a single table, and the
+// filters applied to it, top down, if node is a scan of a heap file with
+// filters; returns nil otherwise.
+func baseTableOf(node *OperatorCard) (*OperatorCard, []*Filter) {
+	var filters []*Filter
+	for {
+		switch op := node.Op.(type) {
+		case *Filter:
+			child, ok := op.child.(*OperatorCard)
+			if !ok {
+				return nil, nil
+			}
+			filters = append(filters, op)
+			node = child
+		case *HeapFile:
+			return node, filters
+		default:
+			return nil, nil
+		}
+	}
+}
+
+// Return an index nested-loop join of outer and inner, the plans of the two
+// sides of a join on outerField = innerField, with the filters of inner above
+// it, if inner is a table with an index whose first key column is innerField
+// and outer is small enough (see [IndexJoin]). The join and the filters have
+// card, the estimated cardinality of the join, as their cardinality. Returns
+// nil otherwise.
+func planIndexJoin(outer *OperatorCard, outerField Expr, inner *OperatorCard, innerField Expr, innerIsLeft bool, card int) *OperatorCard {
+	field, ok := innerField.(*FieldExpr)
+	if !ok || outerField.GetExprType().Ftype != field.selectField.Ftype {
+		return nil
+	}
+	base, filters := baseTableOf(inner)
+	if base == nil || outer.Cardinality < 0 || outer.Cardinality*indexProbeCost >= base.Cardinality {
+		return nil
+	}
+	file := base.Op.(*HeapFile)
+	var index *tableIndex
+	for _, idx := range file.indexes {
+		ft := idx.file.Descriptor().Fields[0]
+		if ft.Fname == field.selectField.Fname && ft.Ftype == field.selectField.Ftype && (idx.supportsRanges() || len(idx.fields) == 1) {
+			index = idx
+			break
+		}
+	}
+	if index == nil {
+		return nil
+	}
+	join := &IndexJoin{outer, outerField, file, index, field, innerIsLeft}
+	result := NewOperatorCard(join, card)
+	for i := len(filters) - 1; i >= 0; i-- {
+		f := filters[i]
+		result = NewOperatorCard(&Filter{f.op, f.left, f.right, result}, card)
+	}
+	return result
+}
+
+// Return an index nested-loop join of left and right, the plans of the two
+// sides of a join on leftField = rightField, with card as its estimated
+// cardinality, if either side can be looked up in an index (see
+// [planIndexJoin]), preferring the join whose outer side is smaller. Returns
+// nil if neither can, or if index joins are disabled.
+func planIndexJoins(left *OperatorCard, leftField Expr, right *OperatorCard, rightField Expr, card int) *OperatorCard {
+	if !EnableIndexJoins {
+		return nil
+	}
+	probeRight := planIndexJoin(left, leftField, right, rightField, false, card)
+	probeLeft := planIndexJoin(right, rightField, left, leftField, true, card)
+	if probeLeft != nil && (probeRight == nil || right.Cardinality < left.Cardinality) {
+		return probeLeft
+	}
+	return probeRight
+}
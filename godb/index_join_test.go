@@ -0,0 +1,66 @@
+package godb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestIndexJoinPlan(t *testing.T) {
+	hf, nameIdx, ageIdx := makeIndexedFile(t)
+	age := &FieldExpr{FieldType{"age", "t", IntType}}
+	name := &FieldExpr{FieldType{"name", "t", StringType}}
+	outerAge := &FieldExpr{FieldType{"age", "u", IntType}}
+	outerName := &FieldExpr{FieldType{"name", "u", StringType}}
+	outer := NewOperatorCard(&HeapFile{}, 10)
+
+	inner := filterChain(hf, &Filter{op: OpNeq, left: name, right: &ConstExpr{StringField{"joe"}, StringType}})
+	plan := planIndexJoins(outer, outerAge, inner, age, 7)
+	f, ok := plan.Op.(*Filter)
+	if !ok || f.op != OpNeq || plan.Cardinality != 7 {
+		t.Fatalf("expected the filter of the inner table above the join, got %+v", plan.Op)
+	}
+	join, ok := f.child.(*OperatorCard).Op.(*IndexJoin)
+	if !ok || join.index != ageIdx || join.innerIsLeft || join.outer != Operator(outer) || join.inner != hf {
+		t.Fatalf("expected a lookup of by_age for each outer tuple, got %+v", f.child)
+	}
+	if plan := planIndexJoins(inner, name, outer, outerName, 7); plan == nil || plan.Op.(*Filter).child.(*OperatorCard).Op.(*IndexJoin).index != nameIdx {
+		t.Errorf("expected by_name to be used on the left side of the join")
+	} else if !plan.Op.(*Filter).child.(*OperatorCard).Op.(*IndexJoin).innerIsLeft {
+		t.Errorf("expected the inner table to stay on the left side of the join")
+	}
+
+	var buf strings.Builder
+	OutputPhysicalPlan(func(format string, a ...any) { fmt.Fprintf(&buf, format, a...) }, plan.Op.(*Filter).child, "")
+	if !strings.HasPrefix(buf.String(), "Index Join, u.age == t.age using btree index by_age, card:7\n") {
+		t.Errorf("unexpected plan %q", buf.String())
+	}
+
+	noIndexJoin := []struct {
+		outer      *OperatorCard
+		outerField Expr
+		inner      *OperatorCard
+		innerField Expr
+	}{
+		{NewOperatorCard(&HeapFile{}, 500), outerAge, inner, age},
+		{NewOperatorCard(&HeapFile{}, -1), outerAge, inner, age},
+		{outer, outerAge, inner, &FieldExpr{FieldType{"id", "t", IntType}}},
+		{outer, &FieldExpr{FieldType{"age", "u", FloatType}}, inner, age},
+		{outer, outerAge, NewOperatorCard(&OrderBy{}, 1000), age},
+	}
+	for i, c := range noIndexJoin {
+		if plan := planIndexJoin(c.outer, c.outerField, c.inner, c.innerField, false, 7); plan != nil {
+			t.Errorf("case %d: expected no index join, got %+v", i, plan.Op)
+		}
+	}
+	EnableIndexJoins = false
+	if plan := planIndexJoins(outer, outerAge, inner, age, 7); plan != nil {
+		t.Errorf("expected no index join when they are disabled")
+	}
+	EnableIndexJoins = true
+
+	lookup := useIndexScan(filterChain(hf, &Filter{op: OpEq, left: age, right: &ConstExpr{IntField{3}, IntType}}), nil)
+	if _, ok := lookup.Op.(*IndexScan); !ok || lookup.Cardinality != 1 {
+		t.Errorf("expected a lookup of a unique key to return one tuple, got %+v", lookup)
+	}
+}
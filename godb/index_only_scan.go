@@ -0,0 +1,186 @@
+package godb
+
+/*
+An index-only scan reads the columns a query needs from the entries of an
+index that stores all of them, its key columns and INCLUDE columns, instead of
+fetching the tuples of the heap file the entries point to (see
+[IndexOnlyScan]). Since index entries are much smaller than the tuples of a
+wide table, this reads far fewer pages.
+
+The planner uses an index-only scan instead of an index scan when the index
+covers every column of the table the query reads (see [LogicalPlan.columnsRead]
+and [useIndexScan]). When no index can evaluate the filters of the table, it
+also replaces the scan of the heap file with a scan of all the entries of the
+narrowest covering index, if there is one.
+
+Without MVCC, the entries of an index are those of the tuples of the file, and
+the locks a transaction holds on the leaves of the index keep other
+transactions from changing them (see [BTreeFile.lockLeaf]), so the heap file
+is not read at all, unless the BufferPool uses row locking: leaves then only
+take intention locks, so the scan locks the tuple of each entry, which may
+have been inserted by a transaction that has not committed, and reads its
+page to check that the tuple still exists. Under
+MVCC, entries of tuple versions that are not visible to the transaction remain
+in the index until they are vacuumed, so the scan still reads the header of the
+page of each tuple to check its visibility, though not the tuple itself.
+*/
+
+// A scan of the entries of one of the indexes of a heap file whose keys are
+// between lo and hi, which returns the values the entries store rather than
+// the tuples they point to.
+type IndexOnlyScan struct {
+	IndexScan
+}
+
+// Return the TupleDesc of the tuples of the scan: the fields of the heap file
+// stored by the index, its key columns followed by its INCLUDE columns.
+func (s *IndexOnlyScan) Descriptor() *TupleDesc {
+	fields := s.file.Descriptor().Fields
+	columns := append(s.index.fields[:len(s.index.fields):len(s.index.fields)], s.index.include...)
+	desc := &TupleDesc{make([]FieldType, len(columns))}
+	for i, field := range columns {
+		desc.Fields[i] = fields[field]
+	}
+	return desc
+}
+
+// Return an iterator over the values stored in the entries of the index in
+// the range of the scan, in the order of their keys, whose tuples are visible
+// to tid if the BufferPool uses MVCC (see [HeapFile.isVisible]), taking a new
+// snapshot first if the isolation level of tid requires it. The Rid of each
+// tuple is the record id of the heap tuple its values are from.
+func (s *IndexOnlyScan) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	s.file.bufPool.refreshSnapshot(tid)
+	entries, err := s.index.scan(s.lo, s.hi, tid)
+	if err != nil {
+		return nil, err
+	}
+	desc := s.Descriptor()
+	return func() (*Tuple, error) {
+		for {
+			e, err := entries()
+			if err != nil || e == nil {
+				return nil, err
+			}
+			visible, err := s.file.isVisible(e.Rid.(heapRid), tid)
+			if err != nil {
+				return nil, err
+			}
+			if visible {
+				return &Tuple{Desc: *desc, Fields: e.Fields, Rid: e.Rid}, nil
+			}
+		}
+	}, nil
+}
+
+// Return true unless the BufferPool uses MVCC and the version of the tuple of
+// the file with the specified record id is not visible to the snapshot of tid,
+// reading the page of the tuple on behalf of tid only in that case. Without
+// MVCC, if the BufferPool uses row locking, the tuple is locked for reading
+// instead, and false is returned if it no longer exists (see
+// [HeapFile.fetchTuple]).
+func (f *HeapFile) isVisible(rid heapRid, tid TransactionID) (bool, error) {
+	vm := f.bufPool.versions
+	if vm == nil && f.bufPool.rowLocking {
+		t, err := f.fetchTuple(rid, tid)
+		return t != nil, err
+	}
+	if vm == nil {
+		return true, nil
+	}
+	s := vm.snapshot(tid)
+	if s == nil {
+		return true, nil
+	}
+	defer f.bufPool.endRead(tid, f.bufPool.startRead(tid))
+	pg, err := f.bufPool.GetPage(f, rid.pageNo, tid, ReadPerm)
+	if err != nil {
+		return false, err
+	}
+	return s.isVisible(pg.(*heapPage).versionOf(rid)), nil
+}
+
+// Return true if the entries of the index store every one of the columns,
+// which are the names of columns of its table; returns false if columns is
+// nil, which stands for an unknown set of columns.
+func (idx *tableIndex) covers(columns map[string]bool) bool {
+	if columns == nil {
+		return false
+	}
+	stored := make(map[string]bool)
+	for _, ft := range idx.file.Descriptor().Fields {
+		stored[ft.Fname] = true
+	}
+	for col := range columns {
+		if !stored[col] {
+			return false
+		}
+	}
+	return true
+}
+
+// Return the columns of each table of the plan that the query reads, by name
+// or alias of the table, or nil if some expression reads all the columns of a
+// table (as SELECT * does) or the columns of an expression cannot be
+// resolved. Columns of the subqueries of the plan are not included, since
+// they are planned separately.
+func (p *LogicalPlan) columnsRead(c *Catalog) map[string]map[string]bool {
+	aliases := make(map[string]bool) // the names of the select expressions
+	for _, s := range append(p.selects[:len(p.selects):len(p.selects)], p.aggs...) {
+		if s.alias != "" {
+			aliases[s.alias] = true
+		}
+	}
+	columns := make(map[string]map[string]bool)
+	var add func(s *LogicalSelectNode) bool
+	add = func(s *LogicalSelectNode) bool {
+		switch s.exprType {
+		case ExprConst:
+			return true
+		case ExprStar:
+			return false
+		case ExprFunc, ExprAggr:
+			for _, arg := range s.args {
+				if !add(arg) {
+					return false
+				}
+			}
+			return true
+		}
+		if s.field == "*" {
+			return true // COUNT(*) reads no column
+		}
+		table, err := checkNameInTablesOrSubqueries(s.table, s.field, c, p.subqueries, p.tables)
+		if err != nil {
+			return false
+		}
+		if table == "" {
+			// the name of a select expression, as in ORDER BY
+			return aliases[s.field]
+		}
+		if columns[table] == nil {
+			columns[table] = make(map[string]bool)
+		}
+		columns[table][s.field] = true
+		return true
+	}
+	exprs := append(p.selects[:len(p.selects):len(p.selects)], p.aggs...)
+	for _, f := range p.filters {
+		exprs = append(exprs, &f.fieldExpr, &f.constExpr)
+	}
+	for _, j := range p.joins {
+		exprs = append(exprs, j.left, j.right)
+	}
+	for _, g := range p.groupByFields {
+		exprs = append(exprs, g.expr)
+	}
+	for _, o := range p.orderByFields {
+		exprs = append(exprs, o.expr)
+	}
+	for _, s := range exprs {
+		if !add(s) {
+			return nil
+		}
+	}
+	return columns
+}
@@ -0,0 +1,143 @@
+package godb
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIndexOnlyScanPlan(t *testing.T) {
+	hf, nameIdx, ageIdx := makeIndexedFile(t)
+	age := &FieldExpr{FieldType{"age", "t", IntType}}
+	cols := func(names ...string) map[string]bool {
+		m := make(map[string]bool)
+		for _, name := range names {
+			m[name] = true
+		}
+		return m
+	}
+
+	plan := useIndexScan(filterChain(hf, &Filter{op: OpEq, left: age, right: &ConstExpr{IntField{3}, IntType}}), cols("age"))
+	if scan, ok := plan.Op.(*IndexOnlyScan); !ok || scan.index != ageIdx || plan.Cardinality != 1 {
+		t.Errorf("expected an index-only lookup of by_age, got %+v", plan.Op)
+	}
+	plan = useIndexScan(filterChain(hf, &Filter{op: OpLt, left: age, right: &ConstExpr{IntField{3}, IntType}}), cols("name"))
+	if scan, ok := plan.Op.(*IndexScan); !ok || scan.index != ageIdx {
+		t.Errorf("expected a range scan of by_age rather than a scan of by_name, got %+v", plan.Op)
+	}
+
+	full := []struct {
+		columns  map[string]bool
+		expected *tableIndex
+	}{
+		{cols("name", "age"), nameIdx},
+		{cols("age"), ageIdx},
+		{cols(), ageIdx}, // as in SELECT COUNT(*)
+	}
+	for _, c := range full {
+		plan := useIndexScan(filterChain(hf), c.columns)
+		scan, ok := plan.Op.(*IndexOnlyScan)
+		if !ok || scan.index != c.expected || scan.lo != nil || scan.hi != nil || plan.Cardinality != 1000 {
+			t.Errorf("columns %v: expected a full scan of %s, got %+v", c.columns, c.expected.name, plan.Op)
+		}
+	}
+	var buf strings.Builder
+	OutputPhysicalPlan(func(format string, a ...any) { fmt.Fprintf(&buf, format, a...) }, useIndexScan(filterChain(hf), cols("age")), "")
+	if s := buf.String(); !strings.HasPrefix(s, "Index Only Scan ") || !strings.HasSuffix(s, " using btree index by_age, card:1000\n") {
+		t.Errorf("unexpected plan %q", s)
+	}
+	for _, columns := range []map[string]bool{nil, cols("id"), cols("name", "id")} {
+		if node := filterChain(hf); useIndexScan(node, columns) != node {
+			t.Errorf("columns %v: expected no index scan", columns)
+		}
+	}
+
+	// INCLUDE columns are stored but cannot be searched
+	byName, err := NewBTreeFile(filepath.Join(t.TempDir(), "cover.idx"), &TupleDesc{[]FieldType{{"name", "", StringType}, {"age", "", IntType}}}, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cover := &tableIndex{name: "cover", fields: []int{0}, include: []int{1}, file: byName}
+	hf = &HeapFile{}
+	hf.addIndex(cover)
+	plan = useIndexScan(filterChain(hf, &Filter{op: OpEq, left: age, right: &ConstExpr{IntField{3}, IntType}}), cols("name", "age"))
+	f, ok := plan.Op.(*Filter)
+	if !ok {
+		t.Fatalf("expected the filter on an INCLUDE column to stay, got %+v", plan.Op)
+	}
+	if scan, ok := f.child.(*OperatorCard).Op.(*IndexOnlyScan); !ok || scan.index != cover || scan.lo != nil {
+		t.Errorf("expected a full scan of cover, got %+v", f.child)
+	}
+	key, err := cover.keyOf(&Tuple{Fields: []DBValue{StringField{"joe"}, IntField{3}}, Rid: heapRid{1, 2}})
+	if err != nil || len(key.Fields) != 2 || key.Fields[1] != (IntField{3}) || key.Rid != (heapRid{1, 2}) {
+		t.Errorf("expected the entry to hold the INCLUDE column, got %+v", key)
+	}
+}
+
+func TestColumnsRead(t *testing.T) {
+	field := func(table, name string) *LogicalSelectNode {
+		s := NewFieldSelectNode(table, name, "")
+		return &s
+	}
+	count := NewAggrSelectNode("count", field("", "*"), "n")
+	sum := NewFuncSelectNode("+", []*LogicalSelectNode{field("t", "a"), field("u", "b")}, "")
+	plan := &LogicalPlan{
+		tables:        []*LogicalTableNode{{tableName: "t"}, {tableName: "u"}},
+		selects:       []*LogicalSelectNode{&sum, &count},
+		aggs:          []*LogicalSelectNode{&count},
+		filters:       []*LogicalFilterNode{{fieldExpr: *field("t", "c"), constExpr: NewConstSelectNode("1", ""), predOp: OpEq}},
+		joins:         []*LogicalJoinNode{{left: field("t", "id"), right: field("u", "id"), predOp: OpEq}},
+		groupByFields: []*GroupBy{{field("u", "d")}},
+		orderByFields: []*OrderByNode{{field("", "n"), true}},
+	}
+	columns := plan.columnsRead(nil)
+	if fmt.Sprint(columns) != "map[t:map[a:true c:true id:true] u:map[b:true d:true id:true]]" {
+		t.Errorf("unexpected columns %v", columns)
+	}
+	star := NewStarSelectNode("")
+	plan.selects = append(plan.selects, &star)
+	if columns := plan.columnsRead(nil); columns != nil {
+		t.Errorf("expected SELECT * to read every column, got %v", columns)
+	}
+	plan.selects = plan.selects[:2]
+	plan.orderByFields = []*OrderByNode{{field("", "m"), true}}
+	if columns := plan.columnsRead(nil); columns != nil {
+		t.Errorf("expected an unresolved column to read every column, got %v", columns)
+	}
+}
+
+func TestIndexIncludeEntries(t *testing.T) {
+	hf := &HeapFile{}
+	byName, err := NewBTreeFile(filepath.Join(t.TempDir(), "cover.idx"), &TupleDesc{[]FieldType{{"name", "", StringType}, {"age", "", IntType}}}, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cover := &tableIndex{name: "cover", fields: []int{0}, include: []int{1}, file: byName, unique: true}
+	table := &Table{name: "t", desc: TupleDesc{[]FieldType{{"name", "", StringType}, {"age", "", IntType}}},
+		file: hf, columns: []columnDef{{nullable: true}, {nullable: true}}, indexes: []*tableIndex{cover}}
+	expected := "t(name string, age int)\nunique index cover on t(name) include (age)\n"
+	if s := table.String(); s != expected {
+		t.Fatalf("expected %q, got %q", expected, s)
+	}
+	m := catalogIndexEntry.FindStringSubmatch("unique index cover on t(name) include (age) using btree")
+	if m == nil || m[4] != "name" || m[5] != "age" || m[6] != "btree" {
+		t.Errorf("unexpected match %q", m)
+	}
+
+	creates := map[string][]string{
+		"CREATE INDEX c ON t (name) INCLUDE (age, id)":      {"name", "age, id"},
+		"create index c on t using btree (a) include(b);":   {"a", "b"},
+		"create index c on t(a, b) using btree include (c)": {"a, b", "c"},
+		"create index c on t(a)":                            {"a", ""},
+	}
+	for query, expected := range creates {
+		m := createIndexStatement.FindStringSubmatch(query)
+		if m == nil || m[6] != expected[0] || m[8] != expected[1] {
+			t.Errorf("%s: unexpected match %q", query, m)
+		}
+	}
+	if createIndexStatement.MatchString("create index c on t(a) include b") {
+		t.Errorf("expected INCLUDE columns to be parenthesized")
+	}
+}
@@ -0,0 +1,342 @@
+package godb
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+An index scan returns the tuples of a heap file whose key in one of its
+indexes is in a range, by scanning the entries of the index in that range
+and fetching the tuples they point to (see [IndexScan]).
+
+The planner replaces the filters of a table scan with an index scan when some
+of them constrain a prefix of the key columns of an index of the table (see
+[useIndexScan]): equality predicates on its first columns, optionally
+followed by range predicates (<, <=, > or >=) on the next one. Hash indexes
+can only be used with equality predicates on all their columns. A predicate is
+usable if it compares a column of the table with a non-NULL constant of the
+same type, since the index orders keys by the values of its columns. Of the
+indexes a table has, the planner uses the one whose prefix has the most
+equality predicates, and then a range predicate, preferring an index that
+covers the columns the query reads (see [IndexOnlyScan]). The filters the index
+scan does not use stay above it.
+*/
+
+// A predicate of a filter that an index scan evaluates by scanning a range of
+// its index.
+type indexPredicate struct {
+	field *FieldExpr
+	op    BoolOp
+	value *ConstExpr
+}
+
+// A range of the keys of an index, or of the cluster key of a heap file (see
+// [ClusteredScan]), between lo and hi.
+type keyRange struct {
+	preds  []indexPredicate // the predicates lo and hi were built from
+	lo, hi *BTreeBound
+}
+
+// A scan of the tuples of a heap file whose keys in one of its indexes are
+// in a range.
+type IndexScan struct {
+	file  *HeapFile
+	index *tableIndex
+	keyRange
+}
+
+// Return the TupleDesc of the heap file.
+func (s *IndexScan) Descriptor() *TupleDesc {
+	return s.file.Descriptor()
+}
+
+// Return an iterator over the tuples of the heap file whose entries are in the
+// range of the scan, in the order of their keys. Tuples are read like
+// [HeapFile.Iterator] reads them (see [HeapFile.fetchTuple]), taking a new
+// snapshot first if the isolation level of tid requires it.
+func (s *IndexScan) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	s.file.bufPool.refreshSnapshot(tid)
+	entries, err := s.index.scan(s.lo, s.hi, tid)
+	if err != nil {
+		return nil, err
+	}
+	return func() (*Tuple, error) {
+		for {
+			e, err := entries()
+			if err != nil || e == nil {
+				return nil, err
+			}
+			t, err := s.file.fetchTuple(e.Rid.(heapRid), tid)
+			if err != nil || t != nil {
+				return t, err
+			}
+		}
+	}, nil
+}
+
+// Return a description of the range, such as "t.a = 1, t.b > 2".
+func (s *keyRange) predicateString() string {
+	preds := make([]string, len(s.preds))
+	for i, p := range s.preds {
+		preds[i] = fmt.Sprintf("%s %s %s", exprToStr(p.field), opToStr(p.op), exprToStr(p.value))
+	}
+	return strings.Join(preds, ", ")
+}
+
+// Return the tuple of the file with the specified record id, read on behalf of
+// tid as [HeapFile.Iterator] reads tuples (see [HeapFile.readTuple]). Returns
+// nil if the file has no such tuple, or if it is not visible to tid. If the
+// BufferPool uses row locking, the tuple is locked before its page is read,
+// since the index entry that led to it may be an uncommitted change of the
+// transaction that holds the lock.
+func (f *HeapFile) fetchTuple(rid heapRid, tid TransactionID) (*Tuple, error) {
+	defer f.bufPool.endRead(tid, f.bufPool.startRead(tid))
+	if f.bufPool.rowLocking && f.bufPool.versions == nil {
+		if err := f.bufPool.lockTuple(f, rid, tid, ReadPerm, LockWait); err != nil {
+			return nil, err
+		}
+	}
+	pg, err := f.bufPool.GetPage(f, rid.pageNo, tid, ReadPerm)
+	if err != nil {
+		return nil, err
+	}
+	hp := pg.(*heapPage)
+	iter := hp.tupleIter()
+	for t, err := iter(); t != nil || err != nil; t, err = iter() {
+		if err != nil {
+			return nil, err
+		}
+		if t.Rid == rid {
+			return f.readTuple(hp, t, tid)
+		}
+	}
+	return nil, nil
+}
+
+// Return t, a tuple of hp as returned by [heapPage.tupleIter], as
+// [HeapFile.Iterator] returns it to tid: the tuple is locked for reading if
+// the BufferPool uses row locking, its strings stored out of line are fetched,
+// and its TupleDesc is that of the file. Returns nil if the BufferPool uses
+// MVCC and the tuple is not visible to the snapshot of tid. The short locks
+// taken at ReadCommitted are released before returning (see
+// [BufferPool.startRead]).
+func (f *HeapFile) readTuple(hp *heapPage, t *Tuple, tid TransactionID) (*Tuple, error) {
+	defer f.bufPool.endRead(tid, f.bufPool.startRead(tid))
+	if vm := f.bufPool.versions; vm != nil {
+		if s := vm.snapshot(tid); s != nil && !s.isVisible(hp.versionOf(t.Rid)) {
+			return nil, nil
+		}
+	}
+	if err := f.bufPool.lockTuple(f, t.Rid, tid, ReadPerm, LockWait); err != nil {
+		return nil, err
+	}
+	t, err := f.detoast(t, tid)
+	if err != nil {
+		return nil, err
+	}
+	t.Desc = *f.Descriptor()
+	return t, nil
+}
+
+// Return the predicate of the filter if the index can evaluate it on its key
+// column of type ft: the filter compares a column named like ft with a
+// non-NULL constant of the same type.
+func indexablePredicate(f *Filter, ft FieldType) (indexPredicate, bool) {
+	field, ok := f.left.(*FieldExpr)
+	if !ok || field.selectField.Fname != ft.Fname || field.selectField.Ftype != ft.Ftype {
+		return indexPredicate{}, false
+	}
+	value, ok := f.right.(*ConstExpr)
+	if !ok || isNull(value.val) || typeOfValue(value.val) != ft.Ftype {
+		return indexPredicate{}, false
+	}
+	switch f.op {
+	case OpEq, OpLt, OpLe, OpGt, OpGe:
+		return indexPredicate{field, f.op, value}, true
+	}
+	return indexPredicate{}, false
+}
+
+// Return a scan of the index over the tuples of file that satisfy the longest
+// prefix of its key columns the filters constrain (see [IndexScan]), the
+// filters the scan evaluates, and its score (see [planKeyRange]). Returns a nil
+// scan if the filters do not constrain the first key column, or, if the index
+// does not support ranges, all of them with equality predicates.
+func planIndexScan(file *HeapFile, idx *tableIndex, filters []*Filter) (*IndexScan, []bool, int) {
+	r, used, score := planKeyRange(idx.file.Descriptor().Fields[:len(idx.fields)], idx.supportsRanges(), filters)
+	if score == 0 {
+		return nil, nil, 0
+	}
+	return &IndexScan{file, idx, r}, used, score
+}
+
+// Return the range of keys with the columns of key that satisfy the longest
+// prefix of them the filters constrain, with equality predicates on its first
+// columns, optionally followed by range predicates on the next one if ranged
+// is set, the filters the range evaluates, and its score: two points per
+// equality predicate, and one for a range predicate. If ranged is not set,
+// the filters must constrain every column with an equality predicate. Returns
+// a score of 0 if they do not constrain the first column.
+func planKeyRange(key []FieldType, ranged bool, filters []*Filter) (keyRange, []bool, int) {
+	used := make([]bool, len(filters))
+	var r keyRange
+	var prefix []DBValue
+	score, bounded := 0, false
+	// find the first unused filter on ft with one of ops
+	find := func(ft FieldType, ops ...BoolOp) (indexPredicate, bool) {
+		for i, f := range filters {
+			if used[i] {
+				continue
+			}
+			p, ok := indexablePredicate(f, ft)
+			for _, op := range ops {
+				if ok && p.op == op {
+					used[i] = true
+					return p, true
+				}
+			}
+		}
+		return indexPredicate{}, false
+	}
+	for _, ft := range key {
+		if p, ok := find(ft, OpEq); ok {
+			r.preds = append(r.preds, p)
+			prefix = append(prefix, p.value.val)
+			score += 2
+			continue
+		}
+		if !ranged {
+			return keyRange{}, nil, 0
+		}
+		if p, ok := find(ft, OpGt, OpGe); ok {
+			r.preds = append(r.preds, p)
+			r.lo = &BTreeBound{append(prefix[:len(prefix):len(prefix)], p.value.val), p.op == OpGe}
+			bounded = true
+		}
+		if p, ok := find(ft, OpLt, OpLe); ok {
+			r.preds = append(r.preds, p)
+			r.hi = &BTreeBound{append(prefix[:len(prefix):len(prefix)], p.value.val), p.op == OpLe}
+			bounded = true
+		}
+		break
+	}
+	if bounded {
+		score++
+	}
+	if score == 0 {
+		return keyRange{}, nil, 0
+	}
+	if len(prefix) > 0 {
+		if r.lo == nil {
+			r.lo = &BTreeBound{prefix, true}
+		}
+		if r.hi == nil {
+			r.hi = &BTreeBound{prefix, true}
+		}
+	}
+	return r, used, score
+}
+
+// Replace the filters over a scan of a heap file in node, the plan of a
+// table, with a scan of one of its indexes, if one can evaluate some of them
+// (see [IndexScan]), and return the new plan. columns are the columns of the
+// table the query reads (see [LogicalPlan.columnsRead]), or nil if they are
+// unknown: if the index stores all of them, it is read with an index-only
+// scan, and if no index can evaluate the filters, the whole of the narrowest
+// index that stores them replaces the scan of the file (see [IndexOnlyScan]).
+// The filters the index scan does not evaluate are applied to its output, in
+// the same order. Cardinalities are scaled by the selectivities of the
+// filters, except that a lookup of a key of a unique index returns at most one
+// tuple. If the file is clustered, a range of its cluster key the filters
+// constrain is read with a [ClusteredScan] rather than with an index scan that
+// evaluates no more of them. Returns node if no index is usable.
+func useIndexScan(node *OperatorCard, columns map[string]bool) *OperatorCard {
+	var filters []*Filter
+	var cards []int // the cardinality of each filter
+	scan := node
+	for {
+		f, ok := scan.Op.(*Filter)
+		if !ok {
+			break
+		}
+		child, ok := f.child.(*OperatorCard)
+		if !ok {
+			return node
+		}
+		filters = append(filters, f)
+		cards = append(cards, scan.Cardinality)
+		scan = child
+	}
+	file, ok := scan.Op.(*HeapFile)
+	if !ok {
+		return node
+	}
+	var best *IndexScan
+	var clustered *ClusteredScan
+	var bestUsed []bool
+	bestScore, bestCovers := 0, false
+	if file.cluster != nil {
+		// a range of the cluster key is read from contiguous pages, so it is
+		// preferred to an index scan of a range that is as selective
+		if r, used, score := planKeyRange(file.cluster.desc.Fields, true, filters); score > 0 {
+			clustered, bestUsed, bestScore = &ClusteredScan{file, r}, used, score
+		}
+	}
+	width := func(idx *tableIndex) int { return len(idx.file.Descriptor().Fields) }
+	for _, idx := range file.indexes {
+		covers := idx.covers(columns)
+		s, used, score := planIndexScan(file, idx, filters)
+		if s == nil {
+			if !covers {
+				continue
+			}
+			// scan all of the entries of the index
+			s, used = &IndexScan{file: file, index: idx}, make([]bool, len(filters))
+		}
+		if best == nil && clustered == nil || score > bestScore || score == bestScore && covers && (!bestCovers || width(idx) < width(best.index)) {
+			best, clustered, bestUsed, bestScore, bestCovers = s, nil, used, score, covers
+		}
+	}
+	if best == nil && clustered == nil {
+		return node
+	}
+
+	// rebuild the chain bottom up, filters being listed top down
+	card := float64(scan.Cardinality)
+	sels := make([]float64, len(filters))
+	for i := len(filters) - 1; i >= 0; i-- {
+		sels[i] = 1.0
+		if card > 0 {
+			sels[i] = float64(cards[i]) / card
+		}
+		card = float64(cards[i])
+	}
+	card = float64(scan.Cardinality)
+	for i, used := range bestUsed {
+		if used {
+			card *= sels[i]
+		}
+	}
+	if best != nil && best.index.unique && bestScore == 2*len(best.index.fields) {
+		// an equality on every column of a unique key finds at most one tuple
+		card = min(card, 1)
+	}
+	var op Operator = best
+	switch {
+	case clustered != nil:
+		op = clustered
+	case bestCovers:
+		op = &IndexOnlyScan{*best}
+	}
+	result := NewOperatorCard(op, int(card))
+	for i := len(filters) - 1; i >= 0; i-- {
+		if bestUsed[i] {
+			continue
+		}
+		f := filters[i]
+		card *= sels[i]
+		result = NewOperatorCard(&Filter{f.op, f.left, f.right, result}, int(card))
+	}
+	return result
+}
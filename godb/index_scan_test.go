@@ -0,0 +1,131 @@
+package godb
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func makeIndexedFile(t *testing.T) (*HeapFile, *tableIndex, *tableIndex) {
+	t.Helper()
+	byName, err := NewBTreeFile(filepath.Join(t.TempDir(), "by_name.idx"), &TupleDesc{[]FieldType{{"name", "", StringType}, {"age", "", IntType}}}, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	byAge, err := NewBTreeFile(filepath.Join(t.TempDir(), "by_age.idx"), &TupleDesc{[]FieldType{{"age", "", IntType}}}, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	hf := &HeapFile{}
+	nameIdx := &tableIndex{name: "by_name", fields: []int{0, 1}, file: byName}
+	ageIdx := &tableIndex{name: "by_age", fields: []int{1}, file: byAge, unique: true}
+	hf.addIndex(ageIdx)
+	hf.addIndex(nameIdx)
+	return hf, nameIdx, ageIdx
+}
+
+// Build the plan of a table scan of file with the filters, the first one being
+// applied first, each halving the cardinality.
+func filterChain(file DBFile, filters ...*Filter) *OperatorCard {
+	node := NewOperatorCard(file, 1000)
+	for _, f := range filters {
+		f.child = node
+		node = NewOperatorCard(f, node.Cardinality/2)
+	}
+	return node
+}
+
+func TestIndexScanPlan(t *testing.T) {
+	hf, nameIdx, ageIdx := makeIndexedFile(t)
+	name := &FieldExpr{FieldType{"name", "t", StringType}}
+	age := &FieldExpr{FieldType{"age", "t", IntType}}
+
+	plan := useIndexScan(filterChain(hf,
+		&Filter{op: OpGt, left: age, right: &ConstExpr{IntField{20}, IntType}},
+		&Filter{op: OpEq, left: name, right: &ConstExpr{StringField{"joe"}, StringType}},
+		&Filter{op: OpNeq, left: age, right: &ConstExpr{IntField{30}, IntType}},
+	), nil)
+	f, ok := plan.Op.(*Filter)
+	if !ok || f.op != OpNeq || plan.Cardinality != 125 {
+		t.Fatalf("expected the unused filter to stay on top with its cardinality, got %+v", plan)
+	}
+	child := f.child.(*OperatorCard)
+	scan, ok := child.Op.(*IndexScan)
+	if !ok || scan.index != nameIdx || child.Cardinality != 250 {
+		t.Fatalf("expected an index scan of by_name, got %+v", child)
+	}
+	if s := scan.predicateString(); s != "t.name = 'joe', t.age > 20" {
+		t.Errorf("unexpected predicates %s", s)
+	}
+	if scan.lo.Inclusive || len(scan.lo.Key) != 2 || scan.lo.Key[1] != (IntField{20}) {
+		t.Errorf("unexpected lower bound %+v", scan.lo)
+	}
+	if !scan.hi.Inclusive || len(scan.hi.Key) != 1 || scan.hi.Key[0] != (StringField{"joe"}) {
+		t.Errorf("unexpected upper bound %+v", scan.hi)
+	}
+
+	plan = useIndexScan(filterChain(hf, &Filter{op: OpLe, left: age, right: &ConstExpr{IntField{5}, IntType}}), nil)
+	if scan, ok := plan.Op.(*IndexScan); !ok || scan.index != ageIdx || scan.lo != nil || !scan.hi.Inclusive {
+		t.Errorf("expected a range scan of by_age, got %+v", plan.Op)
+	}
+
+	unusable := []*Filter{
+		{op: OpEq, left: age, right: &ConstExpr{NullField{}, UnknownType}},
+		{op: OpEq, left: age, right: &ConstExpr{StringField{"5"}, StringType}},
+		{op: OpNeq, left: name, right: &ConstExpr{StringField{"joe"}, StringType}},
+		{op: OpEq, left: &FieldExpr{FieldType{"id", "t", IntType}}, right: &ConstExpr{IntField{1}, IntType}},
+		{op: OpEq, left: &CastExpr{age, castTarget{ftype: StringType}}, right: &ConstExpr{StringField{"5"}, StringType}},
+	}
+	for _, f := range unusable {
+		node := filterChain(hf, f)
+		if plan := useIndexScan(node, nil); plan != node {
+			t.Errorf("%s %s %s: expected no index scan", exprToStr(f.left), opToStr(f.op), exprToStr(f.right))
+		}
+	}
+}
+
+func TestIndexCatalogEntries(t *testing.T) {
+	hf, nameIdx, ageIdx := makeIndexedFile(t)
+	table := &Table{name: "t", desc: TupleDesc{[]FieldType{{"name", "", StringType}, {"age", "", IntType}}},
+		file: hf, columns: []columnDef{{nullable: true}, {nullable: true}}, indexes: []*tableIndex{ageIdx, nameIdx}}
+	expected := "t(name string, age int)\nunique index by_age on t(age)\nindex by_name on t(name, age)\n"
+	if s := table.String(); s != expected {
+		t.Fatalf("expected %q, got %q", expected, s)
+	}
+	m := catalogIndexEntry.FindStringSubmatch("index by_name on t(name, age)")
+	if m == nil || m[1] != "" || m[2] != "by_name" || m[3] != "t" || m[4] != "name, age" {
+		t.Errorf("unexpected match %q", m)
+	}
+	if m := catalogIndexEntry.FindStringSubmatch("unique index by_age on t(age)"); m == nil || m[1] == "" {
+		t.Errorf("expected a unique index entry, got %q", m)
+	}
+	if m := catalogIndexEntry.FindStringSubmatch("index h on t(age) using hash"); m == nil || m[4] != "age" || m[6] != "hash" {
+		t.Errorf("expected a hash index entry, got %q", m)
+	}
+	if catalogIndexEntry.MatchString("index(a int)") {
+		t.Errorf("expected a table named index not to be an index entry")
+	}
+
+	creates := map[string][]string{
+		"CREATE INDEX by_name ON t (name, age)":      {"", "by_name", "t", "name, age", ""},
+		"create unique index i on t(a);":             {"unique ", "i", "t", "a", ""},
+		"create index h using hash on t(a)":          {"", "h", "t", "a", "hash"},
+		"CREATE INDEX h ON t USING HASH (a, b)":      {"", "h", "t", "a, b", "HASH"},
+		"create index h on t (a) using btree;":       {"", "h", "t", "a", "btree"},
+		"create unique index h on t(a) using hash ;": {"unique ", "h", "t", "a", "hash"},
+	}
+	for query, expected := range creates {
+		m := createIndexStatement.FindStringSubmatch(query)
+		if m == nil || m[1] != expected[0] || m[2] != expected[1] || m[4] != expected[2] || m[6] != expected[3] || m[3]+m[5]+m[7] != expected[4] {
+			t.Errorf("%s: unexpected match %q", query, m)
+		}
+	}
+	if m := dropIndexStatement.FindStringSubmatch("drop index i on t"); m == nil || m[1] != "i" || m[2] != "t" {
+		t.Errorf("unexpected match %q", m)
+	}
+	if m := dropIndexStatement.FindStringSubmatch("DROP INDEX i;"); m == nil || m[2] != "" {
+		t.Errorf("unexpected match %q", m)
+	}
+	if createIndexStatement.MatchString("create table t (a int)") || dropIndexStatement.MatchString("drop table t") {
+		t.Errorf("expected table statements not to be index statements")
+	}
+}
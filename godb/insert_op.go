@@ -23,7 +23,9 @@ func (i *InsertOp) Descriptor() *TupleDesc {
 // iterator into the DBFile passed to the constuctor and then returns a
 // one-field tuple with a "count" field indicating the number of tuples that
 // were inserted.  Tuples should be inserted using the [DBFile.insertTuple]
-// method.
+// method, which locks each inserted tuple.
+//
+// Before reading the child, lock the DBFile for writing with [lockDBFile].
 func (iop *InsertOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 	// TODO: some code goes here
 	return nil, fmt.Errorf("InsertOp.Iterator not implemented")
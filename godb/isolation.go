@@ -0,0 +1,349 @@
+package godb
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+The isolation level of a transaction decides which anomalies caused by
+concurrent transactions it may observe, by deciding how long the BufferPool
+holds the read locks it takes on behalf of the transaction (see
+[BufferPool.lockPage]). Write locks are always held until the transaction ends,
+so dirty writes are never possible.
+
+  - ReadUncommitted takes no read locks, so the transaction may read pages
+    being modified by running transactions (dirty reads).
+  - ReadCommitted takes short read locks that are released as soon as the page
+    has been read (see [BufferPool.startRead]). Only committed data is read,
+    but reading the same page twice may return different contents
+    (non-repeatable reads).
+  - RepeatableRead holds read locks until the transaction ends, so pages that
+    have been read cannot change. New pages may still be appended to a file that
+    has been scanned, so rescanning it may return new tuples (phantoms).
+  - Serializable also locks the end of every file it scans (see
+    [BufferPool.lockEndOfFile]), which transactions appending pages to the file
+    must lock for writing, preventing phantoms.
+
+With MVCC (see [VersionManager]) readers take no locks at the lower levels;
+instead the level decides which snapshot they read from. ReadUncommitted and
+ReadCommitted take a new snapshot at the start of every scan, RepeatableRead
+uses the snapshot taken when the transaction began (snapshot isolation), and
+Serializable takes read locks as described above and reads the latest committed
+versions of the pages it has locked.
+*/
+
+type IsolationLevel int
+
+const (
+	ReadUncommitted IsolationLevel = iota
+	ReadCommitted   IsolationLevel = iota
+	RepeatableRead  IsolationLevel = iota
+	Serializable    IsolationLevel = iota
+)
+
+// The isolation level of transactions for which no level has been set. This is
+// the level GoDB has always provided: strict two phase locking of pages.
+const DefaultIsolationLevel = RepeatableRead
+
+func (l IsolationLevel) String() string {
+	switch l {
+	case ReadUncommitted:
+		return "READ UNCOMMITTED"
+	case ReadCommitted:
+		return "READ COMMITTED"
+	case RepeatableRead:
+		return "REPEATABLE READ"
+	case Serializable:
+		return "SERIALIZABLE"
+	}
+	return "UNKNOWN"
+}
+
+// Parse the name of an isolation level, as written in a SET TRANSACTION
+// ISOLATION LEVEL statement (e.g., "read committed"; case and spacing are
+// ignored).
+func ParseIsolationLevel(s string) (IsolationLevel, error) {
+	name := strings.Join(strings.Fields(strings.ToUpper(s)), " ")
+	for l := ReadUncommitted; l <= Serializable; l++ {
+		if l.String() == name {
+			return l, nil
+		}
+	}
+	return DefaultIsolationLevel, GoDBError{ParseError, fmt.Sprintf("unknown isolation level %s", s)}
+}
+
+// The settings of a transaction that can be changed with SET statements.
+type txnSettings struct {
+	isolation   IsolationLevel
+	lockTimeout time.Duration // 0 waits forever (see [LockWaitPolicy])
+}
+
+// The settings of the transactions of a BufferPool. The zero value is ready to
+// use, and gives every transaction the default settings.
+type txnSettingsTable struct {
+	mu       sync.Mutex
+	defaults *txnSettings // nil until the defaults are changed
+	txns     map[TransactionID]*txnSettings
+}
+
+// Return the settings of tid: the settings changed for tid, if any, and
+// otherwise the defaults.
+func (st *txnSettingsTable) get(tid TransactionID) txnSettings {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if s := st.txns[tid]; s != nil {
+		return *s
+	}
+	if st.defaults != nil {
+		return *st.defaults
+	}
+	return txnSettings{isolation: DefaultIsolationLevel}
+}
+
+// Change the settings of tid, or the defaults if tid is InvalidTID, with
+// update. The settings of transactions that have already been changed are not
+// affected by changes to the defaults.
+func (st *txnSettingsTable) update(tid TransactionID, update func(s *txnSettings)) {
+	current := st.get(tid)
+	update(&current)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if tid == InvalidTID {
+		st.defaults = &current
+		return
+	}
+	if st.txns == nil {
+		st.txns = make(map[TransactionID]*txnSettings)
+	}
+	st.txns[tid] = &current
+}
+
+// Forget the settings of tid, which has ended.
+func (st *txnSettingsTable) forget(tid TransactionID) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.txns, tid)
+}
+
+// Set the isolation level of tid, which should not have read any pages yet.
+func (bp *BufferPool) SetIsolationLevel(tid TransactionID, level IsolationLevel) {
+	bp.settings.update(tid, func(s *txnSettings) { s.isolation = level })
+}
+
+// Set the isolation level of transactions whose level has not been set with
+// [BufferPool.SetIsolationLevel].
+func (bp *BufferPool) SetDefaultIsolationLevel(level IsolationLevel) {
+	bp.settings.update(InvalidTID, func(s *txnSettings) { s.isolation = level })
+}
+
+// Return the isolation level of tid.
+func (bp *BufferPool) IsolationLevel(tid TransactionID) IsolationLevel {
+	return bp.settings.get(tid).isolation
+}
+
+// Return the lock manager of the BufferPool, creating it if needed.
+func (bp *BufferPool) lockManager() *LockManager {
+	bp.locksOnce.Do(func() {
+		if bp.locks == nil {
+			bp.locks = NewLockManager()
+		}
+	})
+	return bp.locks
+}
+
+// Lock the page with the specified key (see [DBFile.pageKey]) with the
+// specified permission on behalf of tid, blocking until the lock is available,
+// as [BufferPool.GetPage] does. If the BufferPool uses row locking, only an
+// intention lock is taken on the page, so that transactions writing different
+// tuples of the page do not conflict (see [BufferPool.lockTuple]). Read locks
+// are held as required by tid's isolation level, and wait specifies what to do
+// if the lock is held by another transaction (see [BufferPool.lockKey]).
+func (bp *BufferPool) lockPage(tid TransactionID, key any, perm RWPerm, wait LockWaitPolicy) error {
+	mode := SharedLock
+	if perm == WritePerm {
+		mode = ExclusiveLock
+	}
+	if bp.rowLocking {
+		mode = intentionFor(perm)
+	}
+	return bp.lockKey(tid, key, mode, wait)
+}
+
+// Lock the specified key in the specified mode on behalf of tid. If the lock is
+// held by another transaction, wait until it is available or tid's lock timeout
+// expires, or with LockNoWait and LockSkipLocked, fail right away with a
+// LockNotAvailableError (see [LockWaitPolicy]). Read locks (IS and S) are
+// taken as required by tid's isolation level: not at all at ReadUncommitted (or
+// at ReadCommitted and RepeatableRead if the BufferPool uses MVCC), until the
+// read that takes them ends at ReadCommitted (see [BufferPool.startRead]), and
+// until tid ends otherwise. Other locks are always held until tid ends.
+func (bp *BufferPool) lockKey(tid TransactionID, key any, mode LockMode, wait LockWaitPolicy) error {
+	lm := bp.lockManager()
+	timeout := bp.lockWaitTimeout(tid, wait)
+	if !mode.readOnly() {
+		if err := lm.acquireWithin(tid, key, mode, timeout); err != nil {
+			return err
+		}
+		bp.shortLocks.keep(tid, key)
+		return nil
+	}
+	level := bp.IsolationLevel(tid)
+	if level == ReadUncommitted || (bp.versions != nil && level != Serializable) {
+		return nil
+	}
+	_, held := lm.holds(tid, key)
+	if err := lm.acquireWithin(tid, key, mode, timeout); err != nil {
+		return err
+	}
+	if level == ReadCommitted && !held {
+		// a short lock: it only makes sure no uncommitted changes are read
+		bp.shortLocks.add(tid, key)
+	}
+	if bp.versions != nil {
+		// the page cannot change until tid ends, so read its latest version
+		bp.versions.refresh(tid)
+	}
+	return nil
+}
+
+// The lock key protecting the end of a file, i.e., the pages that do not exist
+// yet.
+type endOfFileKey struct {
+	file DBFile
+}
+
+// Lock the end of the file on behalf of tid. A Serializable transaction that
+// scans a file must lock its end with ReadPerm once it has read its last page,
+// and any transaction must lock it with WritePerm before appending a page to
+// it; together these prevent new tuples from appearing in a file that a
+// Serializable transaction has scanned. Does nothing for ReadPerm at lower
+// isolation levels.
+func (bp *BufferPool) lockEndOfFile(file DBFile, tid TransactionID, perm RWPerm) error {
+	if perm == ReadPerm {
+		if bp.IsolationLevel(tid) != Serializable {
+			return nil
+		}
+		return bp.lockKey(tid, endOfFileKey{file}, SharedLock, LockWait)
+	}
+	return bp.lockKey(tid, endOfFileKey{file}, ExclusiveLock, LockWait)
+}
+
+// Release the locks held by tid and forget its settings, savepoints and row
+// changes. Called when tid commits or aborts.
+func (bp *BufferPool) releaseLocks(tid TransactionID) {
+	bp.changes.forget(tid)
+	bp.lockManager().releaseAll(tid)
+	bp.rowLocks.forget(tid)
+	bp.shortLocks.forget(tid)
+	bp.settings.forget(tid)
+	bp.savepoints.forget(tid)
+}
+
+// The short read locks of each transaction at ReadCommitted, in the order they
+// were taken (see [BufferPool.startRead]). The zero value is ready to use.
+type shortLockTable struct {
+	mu    sync.Mutex
+	keys  map[TransactionID][]any        // in the order they were taken
+	short map[TransactionID]map[any]bool // the keys that are still short
+}
+
+// Record that tid holds a short lock on key.
+func (st *shortLockTable) add(tid TransactionID, key any) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.keys == nil {
+		st.keys = make(map[TransactionID][]any)
+		st.short = make(map[TransactionID]map[any]bool)
+	}
+	if st.short[tid] == nil {
+		st.short[tid] = make(map[any]bool)
+	}
+	st.keys[tid] = append(st.keys[tid], key)
+	st.short[tid][key] = true
+}
+
+// Record that the lock tid holds on key, if any, is held until tid ends, e.g.
+// because tid locked it for writing.
+func (st *shortLockTable) keep(tid TransactionID, key any) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.short[tid], key)
+}
+
+// Return true if the lock tid holds on key is short.
+func (st *shortLockTable) isShort(tid TransactionID, key any) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.short[tid][key]
+}
+
+// Return the number of short locks tid has taken and not released.
+func (st *shortLockTable) mark(tid TransactionID) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return len(st.keys[tid])
+}
+
+// Forget the short locks tid took after the specified mark, returning the keys
+// of those that are still short.
+func (st *shortLockTable) takeSince(tid TransactionID, mark int) []any {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	keys := st.keys[tid]
+	if mark >= len(keys) {
+		return nil
+	}
+	var short []any
+	for _, key := range keys[mark:] {
+		if st.short[tid][key] {
+			short = append(short, key)
+			delete(st.short[tid], key)
+		}
+	}
+	st.keys[tid] = keys[:mark]
+	return short
+}
+
+// Forget the short locks of tid, which has ended.
+func (st *shortLockTable) forget(tid TransactionID) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.keys, tid)
+	delete(st.short, tid)
+}
+
+// Start a read on behalf of tid, returning a mark to pass to
+// [BufferPool.endRead] once the read is done, i.e., once the tuples the read
+// needs have been copied out of the pages it retrieved. At ReadCommitted, the
+// read locks taken in between are released when the read ends, so a writer
+// cannot change a page while it is being read; they are held until tid ends if
+// the read is never ended. Reads may be nested, but must end in the reverse
+// order they started, so a read must not be left open across calls to an
+// iterator.
+func (bp *BufferPool) startRead(tid TransactionID) int {
+	return bp.shortLocks.mark(tid)
+}
+
+// End the read of tid started with the specified mark (see
+// [BufferPool.startRead]), releasing the short read locks taken since.
+func (bp *BufferPool) endRead(tid TransactionID, mark int) {
+	lm := bp.lockManager()
+	for _, key := range bp.shortLocks.takeSince(tid, mark) {
+		lm.release(tid, key)
+	}
+}
+
+// Take a new snapshot for tid if its isolation level requires one at the start
+// of each scan (see [IsolationLevel]); [HeapFile.Iterator] calls this before
+// reading the first page. Does nothing if the BufferPool does not use MVCC.
+func (bp *BufferPool) refreshSnapshot(tid TransactionID) {
+	if bp.versions == nil {
+		return
+	}
+	if level := bp.IsolationLevel(tid); level == ReadUncommitted || level == ReadCommitted {
+		bp.versions.refresh(tid)
+	}
+}
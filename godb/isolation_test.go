@@ -0,0 +1,264 @@
+package godb
+
+import (
+	"testing"
+)
+
+// Lock a page in the background, returning a channel that receives the result
+// once the lock has been taken.
+func lockPageAsync(bp *BufferPool, tid TransactionID, key any, perm RWPerm) chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- bp.lockPage(tid, key, perm, LockWait)
+	}()
+	return done
+}
+
+// Lock the end of a file in the background.
+func lockEndOfFileAsync(bp *BufferPool, file DBFile, tid TransactionID, perm RWPerm) chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- bp.lockEndOfFile(file, tid, perm)
+	}()
+	return done
+}
+
+// The anomalies each isolation level permits (true) and forbids (false).
+var isolationAnomalies = []struct {
+	level         IsolationLevel
+	dirtyRead     bool
+	nonRepeatable bool
+	phantom       bool
+}{
+	{ReadUncommitted, true, true, true},
+	{ReadCommitted, false, true, true},
+	{RepeatableRead, false, false, true},
+	{Serializable, false, false, false},
+}
+
+// A dirty read happens if a reader can read a page while a running writer has
+// it locked for writing.
+func TestIsolationDirtyRead(t *testing.T) {
+	for _, tt := range isolationAnomalies {
+		bp := &BufferPool{}
+		writer, reader := NewTID(), NewTID()
+		bp.SetIsolationLevel(reader, tt.level)
+		if err := bp.lockPage(writer, "page", WritePerm, LockWait); err != nil {
+			t.Fatalf(err.Error())
+		}
+		read := lockPageAsync(bp, reader, "page", ReadPerm)
+		if granted(t, read) != tt.dirtyRead {
+			t.Errorf("%v: expected dirty read permitted = %v", tt.level, tt.dirtyRead)
+		}
+		bp.releaseLocks(writer)
+		if !tt.dirtyRead && !granted(t, read) {
+			t.Errorf("%v: the read should proceed once the writer commits", tt.level)
+		}
+		bp.releaseLocks(reader)
+	}
+}
+
+// A non-repeatable read happens if a writer can change a page that a running
+// reader has read.
+func TestIsolationNonRepeatableRead(t *testing.T) {
+	for _, tt := range isolationAnomalies {
+		bp := &BufferPool{}
+		writer, reader := NewTID(), NewTID()
+		bp.SetIsolationLevel(reader, tt.level)
+		read := bp.startRead(reader)
+		if err := bp.lockPage(reader, "page", ReadPerm, LockWait); err != nil {
+			t.Fatalf(err.Error())
+		}
+		bp.endRead(reader, read)
+		write := lockPageAsync(bp, writer, "page", WritePerm)
+		if granted(t, write) != tt.nonRepeatable {
+			t.Errorf("%v: expected non-repeatable read permitted = %v", tt.level, tt.nonRepeatable)
+		}
+		bp.releaseLocks(reader)
+		if !tt.nonRepeatable && !granted(t, write) {
+			t.Errorf("%v: the write should proceed once the reader commits", tt.level)
+		}
+		bp.releaseLocks(writer)
+	}
+}
+
+// At ReadCommitted, a read lock is held until the read that took it ends, so
+// that a writer cannot change the page while it is being copied out.
+func TestIsolationReadCommittedShortLocks(t *testing.T) {
+	bp := &BufferPool{}
+	writer, reader := NewTID(), NewTID()
+	bp.SetIsolationLevel(reader, ReadCommitted)
+	lm := bp.lockManager()
+	outer := bp.startRead(reader)
+	if err := bp.lockPage(reader, "p1", ReadPerm, LockWait); err != nil {
+		t.Fatalf(err.Error())
+	}
+	inner := bp.startRead(reader)
+	for _, key := range []string{"p1", "p2"} {
+		if err := bp.lockPage(reader, key, ReadPerm, LockWait); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	write := lockPageAsync(bp, writer, "p1", WritePerm)
+	if granted(t, write) {
+		t.Fatalf("a page should not be written while it is being read")
+	}
+	bp.endRead(reader, inner)
+	if _, ok := lm.holds(reader, "p2"); ok {
+		t.Errorf("expected the lock of the nested read to be released, have %s", lm)
+	}
+	if granted(t, write) {
+		t.Fatalf("a nested read should not release the locks of the outer read")
+	}
+	bp.endRead(reader, outer)
+	if !granted(t, write) {
+		t.Fatalf("the write should proceed once the read ends")
+	}
+	bp.releaseLocks(writer)
+
+	// locks taken for writing, and those of reads never ended, are held
+	// until the transaction ends
+	read := bp.startRead(reader)
+	for _, key := range []string{"p1", "p2"} {
+		if err := bp.lockPage(reader, key, ReadPerm, LockWait); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if err := bp.lockPage(reader, "p1", WritePerm, LockWait); err != nil {
+		t.Fatalf(err.Error())
+	}
+	bp.endRead(reader, read)
+	if _, ok := lm.holds(reader, "p1"); !ok {
+		t.Errorf("expected the write lock to be kept, have %s", lm)
+	}
+	if err := bp.lockPage(reader, "p3", ReadPerm, LockWait); err != nil {
+		t.Fatalf(err.Error())
+	}
+	bp.releaseLocks(reader)
+	if len(lm.locks) != 0 || len(bp.shortLocks.keys) != 0 {
+		t.Errorf("expected no locks to be left, have %s", lm)
+	}
+}
+
+// A phantom happens if a writer can append a page to a file that a running
+// reader has scanned.
+func TestIsolationPhantom(t *testing.T) {
+	for _, tt := range isolationAnomalies {
+		bp := &BufferPool{}
+		file := &HeapFile{bufPool: bp}
+		writer, reader := NewTID(), NewTID()
+		bp.SetIsolationLevel(reader, tt.level)
+		if err := bp.lockEndOfFile(file, reader, ReadPerm); err != nil {
+			t.Fatalf(err.Error())
+		}
+		appendPage := lockEndOfFileAsync(bp, file, writer, WritePerm)
+		if granted(t, appendPage) != tt.phantom {
+			t.Errorf("%v: expected phantom permitted = %v", tt.level, tt.phantom)
+		}
+		bp.releaseLocks(reader)
+		if !tt.phantom && !granted(t, appendPage) {
+			t.Errorf("%v: the append should proceed once the reader commits", tt.level)
+		}
+		bp.releaseLocks(writer)
+	}
+}
+
+// With MVCC, the isolation level decides which snapshot a scan reads from
+// rather than which locks it takes.
+func TestIsolationMVCCSnapshots(t *testing.T) {
+	for _, tt := range isolationAnomalies {
+		bp := &BufferPool{versions: NewVersionManager()}
+		reader, writer := NewTID(), NewTID()
+		bp.SetIsolationLevel(reader, tt.level)
+		s, err := bp.versions.begin(reader)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if _, err := bp.versions.begin(writer); err != nil {
+			t.Fatalf(err.Error())
+		}
+		v := tupleVersion{writer, InvalidTID}
+		if err := bp.lockPage(writer, "page", WritePerm, LockWait); err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		// only Serializable readers wait for writers
+		read := lockPageAsync(bp, reader, "page", ReadPerm)
+		if granted(t, read) != (tt.level != Serializable) {
+			t.Errorf("%v: expected the read to block only at SERIALIZABLE", tt.level)
+		}
+		if tt.level != Serializable && s.isVisible(v) {
+			t.Errorf("%v: uncommitted versions should never be visible", tt.level)
+		}
+		bp.versions.commit(writer)
+		bp.releaseLocks(writer)
+		if tt.level == Serializable && !granted(t, read) {
+			t.Fatalf("%v: the read should proceed once the writer commits", tt.level)
+		}
+
+		// a new scan sees the committed version unless the snapshot is
+		// repeatable
+		bp.refreshSnapshot(reader)
+		if s.isVisible(v) != tt.nonRepeatable && tt.level != Serializable {
+			t.Errorf("%v: expected committed version visible to the next scan = %v", tt.level, tt.nonRepeatable)
+		}
+		if tt.level == Serializable && !s.isVisible(v) {
+			t.Errorf("%v: a locked page should be read at its latest committed version", tt.level)
+		}
+		bp.versions.commit(reader)
+		bp.releaseLocks(reader)
+	}
+}
+
+func TestIsolationDefaults(t *testing.T) {
+	bp := &BufferPool{}
+	t1, t2 := NewTID(), NewTID()
+	if bp.IsolationLevel(t1) != DefaultIsolationLevel {
+		t.Errorf("expected %v, got %v", DefaultIsolationLevel, bp.IsolationLevel(t1))
+	}
+	bp.SetIsolationLevel(t1, Serializable)
+	bp.SetDefaultIsolationLevel(ReadCommitted)
+	if bp.IsolationLevel(t1) != Serializable || bp.IsolationLevel(t2) != ReadCommitted {
+		t.Errorf("expected the default to apply only to t2, got %v and %v", bp.IsolationLevel(t1), bp.IsolationLevel(t2))
+	}
+	bp.releaseLocks(t1)
+	if bp.IsolationLevel(t1) != ReadCommitted {
+		t.Errorf("expected the level of an ended transaction to be forgotten")
+	}
+}
+
+func TestIsolationParse(t *testing.T) {
+	bp := &BufferPool{}
+	c := &Catalog{bufferPool: bp}
+	queries := map[string]IsolationLevel{
+		"set transaction isolation level read uncommitted":        ReadUncommitted,
+		"SET TRANSACTION ISOLATION LEVEL READ COMMITTED":          ReadCommitted,
+		"set session transaction isolation level repeatable read": RepeatableRead,
+		"set transaction isolation level serializable":            Serializable,
+	}
+	for query, level := range queries {
+		qtype, op, err := Parse(c, query)
+		if err != nil || qtype != SetQueryType {
+			t.Fatalf("%q: expected SetQueryType, got %v (%v)", query, qtype, err)
+		}
+		tid := NewTID()
+		if _, err := op.Iterator(tid); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if bp.IsolationLevel(tid) != level {
+			t.Errorf("%q: expected %v, got %v", query, level, bp.IsolationLevel(tid))
+		}
+		if err := op.(*SetOp).SetDefaults(); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if bp.IsolationLevel(NewTID()) != level {
+			t.Errorf("%q: expected the default to be %v", query, level)
+		}
+	}
+
+	for _, query := range []string{"set transaction isolation level snapshot", "set foo = 1"} {
+		if _, _, err := Parse(c, query); err == nil {
+			t.Errorf("%q: expected a parse error", query)
+		}
+	}
+}
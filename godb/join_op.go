@@ -36,7 +36,9 @@ func (hj *EqualityJoin) Descriptor() *TupleDesc {
 // of the join. The join should be the result of joining joinOp.left and
 // joinOp.right, applying the joinOp.leftField and joinOp.rightField expressions
 // to the tuples of the left and right iterators respectively, and joining them
-// using an equality predicate.
+// using an equality predicate. A NULL is not equal to anything, not even NULL
+// (see [evalPred]), so tuples whose join value is NULL never join; keep this in
+// mind if you use the values as keys of a hash table.
 //
 // HINT: When implementing the simple nested loop join, you should keep in mind
 // that you only iterate through the left iterator once (outer loop) but iterate
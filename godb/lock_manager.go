@@ -0,0 +1,386 @@
+package godb
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+LockManager implements the locks the BufferPool uses to isolate transactions.
+A lock is identified by a key, which may be any comparable value; the
+BufferPool uses the [DBFile.pageKey] of a page to lock it.
+
+Locks form a hierarchy: tables contain pages, and pages contain rows. Besides
+shared (S) and exclusive (X) locks, a transaction can take intention locks on
+a table or page, announcing that it is locking objects inside it: intention
+shared (IS) before taking S locks, intention exclusive (IX) before taking X
+locks, and shared intention exclusive (SIX), which is an S lock on the whole
+table combined with an IX lock. Two transactions can hold locks on the same key
+if their modes are compatible:
+
+	      IS  IX  S   SIX X
+	IS    y   y   y   y   n
+	IX    y   y   n   n   n
+	S     y   n   y   n   n
+	SIX   y   n   n   n   n
+	X     n   n   n   n   n
+
+Locks are granted in first come, first served order: a request that conflicts
+with a lock that is held, or with a request that is already waiting, waits
+until the conflicting locks are released. The exception is a lock upgrade (a
+transaction holding a lock asks for it in a stronger mode, e.g., S to X, or IX
+and S to SIX), which is granted as soon as it is compatible with the locks of
+the other holders.
+
+Locks are normally held until [LockManager.releaseAll] is called when the
+transaction commits or aborts (strict two phase locking), but depending on the
+isolation level (see [IsolationLevel]), read locks may be released early.
+*/
+
+type LockMode int
+
+const (
+	IntentionSharedLock          LockMode = iota // IS
+	IntentionExclusiveLock       LockMode = iota // IX
+	SharedLock                   LockMode = iota // S
+	SharedIntentionExclusiveLock LockMode = iota // SIX
+	ExclusiveLock                LockMode = iota // X
+)
+
+func (m LockMode) String() string {
+	switch m {
+	case IntentionSharedLock:
+		return "IS"
+	case IntentionExclusiveLock:
+		return "IX"
+	case SharedLock:
+		return "S"
+	case SharedIntentionExclusiveLock:
+		return "SIX"
+	case ExclusiveLock:
+		return "X"
+	}
+	return "UNKNOWN"
+}
+
+// Compatibility of lock modes, indexed by LockMode, as in the table above.
+var lockCompatible = [5][5]bool{
+	{true, true, true, true, false},
+	{true, true, false, false, false},
+	{true, false, true, false, false},
+	{true, false, false, false, false},
+	{false, false, false, false, false},
+}
+
+// Return true if two different transactions can hold locks in modes m and m2
+// on the same key.
+func (m LockMode) compatible(m2 LockMode) bool {
+	return lockCompatible[m][m2]
+}
+
+// Return the weakest mode that allows everything modes m and m2 allow; a
+// transaction holding a lock in mode m that asks for it in mode m2 ends up
+// holding it in this mode.
+func (m LockMode) join(m2 LockMode) LockMode {
+	if m == m2 {
+		return m
+	}
+	if m > m2 {
+		m, m2 = m2, m
+	}
+	switch {
+	case m2 == ExclusiveLock || m2 == SharedIntentionExclusiveLock:
+		return m2
+	case m == IntentionSharedLock:
+		return m2
+	default: // IX and S
+		return SharedIntentionExclusiveLock
+	}
+}
+
+// Return true if holding a lock in mode m allows everything holding one in
+// mode m2 does.
+func (m LockMode) covers(m2 LockMode) bool {
+	return m.join(m2) == m
+}
+
+// Return true if locks in mode m only allow reading.
+func (m LockMode) readOnly() bool {
+	return m == IntentionSharedLock || m == SharedLock
+}
+
+// A request waiting for a lock. Granting (or refusing) the request sends on
+// ready.
+type lockWaiter struct {
+	tid   TransactionID
+	key   any
+	mode  LockMode
+	ready chan error
+}
+
+// The holders of, and the requests waiting for, a single lock.
+type lockState struct {
+	holders map[TransactionID]LockMode
+	queue   []*lockWaiter
+}
+
+type LockManager struct {
+	mu      sync.Mutex
+	locks   map[any]*lockState
+	held    map[TransactionID]map[any]bool // keys locked by each transaction
+	waiting map[TransactionID][]*lockWaiter
+	work    map[TransactionID]int // locks granted to each transaction so far
+	wounded map[TransactionID]error
+	mode    DeadlockMode
+	policy  VictimPolicy
+}
+
+// Create a new LockManager in which no locks are held.
+func NewLockManager() *LockManager {
+	return &LockManager{locks: make(map[any]*lockState), held: make(map[TransactionID]map[any]bool),
+		waiting: make(map[TransactionID][]*lockWaiter), work: make(map[TransactionID]int),
+		wounded: make(map[TransactionID]error)}
+}
+
+// Return true if tid could be granted the lock on st in the specified mode
+// given the locks held by other transactions. Must be called with lm.mu held.
+func (st *lockState) grantable(tid TransactionID, mode LockMode) bool {
+	for holder, held := range st.holders {
+		if holder != tid && !held.compatible(mode) {
+			return false
+		}
+	}
+	return true
+}
+
+// Record that tid holds the lock with the specified key in the specified mode.
+// Must be called with lm.mu held.
+func (lm *LockManager) grantLocked(st *lockState, key any, tid TransactionID, mode LockMode) {
+	if held, ok := st.holders[tid]; ok {
+		mode = held.join(mode)
+	}
+	st.holders[tid] = mode
+	lm.work[tid]++
+	if lm.held[tid] == nil {
+		lm.held[tid] = make(map[any]bool)
+	}
+	lm.held[tid][key] = true
+}
+
+// Grant waiting requests, in order, until one cannot be granted. Must be
+// called with lm.mu held.
+func (lm *LockManager) grantWaitersLocked(key any, st *lockState) {
+	for len(st.queue) > 0 {
+		w := st.queue[0]
+		if !st.grantable(w.tid, w.mode) {
+			return
+		}
+		st.queue = st.queue[1:]
+		lm.removeWaiterLocked(w)
+		lm.grantLocked(st, key, w.tid, w.mode)
+		w.ready <- nil
+	}
+}
+
+// Acquire the lock with the specified key in the specified mode on behalf of
+// tid, blocking until it can be granted. Does nothing if tid already holds the
+// lock in a mode that covers the requested one.
+//
+// If waiting for the lock would cause a deadlock, a victim is chosen among the
+// deadlocked transactions (see [VictimPolicy]): if it is tid, acquire returns a
+// DeadlockError without waiting; otherwise the request the victim is waiting
+// for returns a DeadlockError. The victim is expected to abort. With deadlock
+// prevention (see [DeadlockMode]), the request returns a DeadlockError if tid
+// must die or has been wounded.
+func (lm *LockManager) acquire(tid TransactionID, key any, mode LockMode) error {
+	return lm.acquireWithin(tid, key, mode, waitForever)
+}
+
+// A timeout for [LockManager.acquireWithin] that waits as long as it takes.
+const waitForever time.Duration = -1
+
+// Acquire the lock with the specified key in the specified mode on behalf of
+// tid as [LockManager.acquire] does, but give up and return a
+// LockNotAvailableError if the lock has not been granted within timeout. A
+// timeout of 0 does not wait at all, and waitForever waits as long as it
+// takes.
+func (lm *LockManager) acquireWithin(tid TransactionID, key any, mode LockMode, timeout time.Duration) error {
+	lm.mu.Lock()
+	if err := lm.wounded[tid]; err != nil {
+		lm.mu.Unlock()
+		return err
+	}
+	st := lm.locks[key]
+	if st == nil {
+		st = &lockState{holders: make(map[TransactionID]LockMode)}
+		lm.locks[key] = st
+	}
+	held, upgrade := st.holders[tid]
+	if upgrade && held.covers(mode) {
+		lm.mu.Unlock()
+		return nil
+	}
+	if upgrade {
+		mode = held.join(mode)
+	}
+	if st.grantable(tid, mode) && (upgrade || len(st.queue) == 0) {
+		lm.grantLocked(st, key, tid, mode)
+		lm.mu.Unlock()
+		return nil
+	}
+
+	if timeout == 0 {
+		lm.mu.Unlock()
+		return lm.notAvailable(tid, key, timeout)
+	}
+
+	w := &lockWaiter{tid: tid, key: key, mode: mode, ready: make(chan error, 1)}
+	if upgrade {
+		// upgrades go first, as the upgrading transaction already holds the lock
+		st.queue = append([]*lockWaiter{w}, st.queue...)
+	} else {
+		st.queue = append(st.queue, w)
+	}
+	lm.waiting[tid] = append(lm.waiting[tid], w)
+	if err := lm.handleWaitLocked(w); err != nil {
+		lm.cancelWaiterLocked(w, err)
+	}
+	lm.mu.Unlock()
+	if timeout == waitForever {
+		return <-w.ready
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-w.ready:
+		return err
+	case <-timer.C:
+	}
+	lm.mu.Lock()
+	select {
+	case err := <-w.ready:
+		// granted or refused while the timer fired
+		lm.mu.Unlock()
+		return err
+	default:
+	}
+	lm.cancelWaiterLocked(w, nil)
+	lm.mu.Unlock()
+	<-w.ready
+	return lm.notAvailable(tid, key, timeout)
+}
+
+// Return the error of a request of tid for the lock with the specified key
+// that was not granted within timeout.
+func (lm *LockManager) notAvailable(tid TransactionID, key any, timeout time.Duration) error {
+	if timeout == 0 {
+		return GoDBError{LockNotAvailableError, fmt.Sprintf("transaction %d could not lock %v without waiting", tid, key)}
+	}
+	return GoDBError{LockNotAvailableError, fmt.Sprintf("transaction %d could not lock %v within %v", tid, key, timeout)}
+}
+
+// Return the mode in which tid holds the lock with the specified key, and
+// whether it holds it at all.
+func (lm *LockManager) holds(tid TransactionID, key any) (LockMode, bool) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	st := lm.locks[key]
+	if st == nil {
+		return IntentionSharedLock, false
+	}
+	mode, ok := st.holders[tid]
+	return mode, ok
+}
+
+// Release the lock tid holds on the specified key, granting waiting requests
+// that it was blocking. Must be called with lm.mu held.
+func (lm *LockManager) releaseLocked(tid TransactionID, key any) {
+	st := lm.locks[key]
+	if st == nil {
+		return
+	}
+	delete(st.holders, tid)
+	if keys := lm.held[tid]; keys != nil {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(lm.held, tid)
+		}
+	}
+	lm.grantWaitersLocked(key, st)
+	if len(st.holders) == 0 && len(st.queue) == 0 {
+		delete(lm.locks, key)
+	}
+}
+
+// Release the lock tid holds on the specified key, if any. Under strict two
+// phase locking, this should only be used for locks that do not need to be
+// held until the end of the transaction (see [IsolationLevel]).
+func (lm *LockManager) release(tid TransactionID, key any) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	lm.releaseLocked(tid, key)
+}
+
+// Stop waiting for a lock: remove the request from its queue, making it return
+// err, and grant the requests that were waiting behind it if possible. Must be
+// called with lm.mu held.
+func (lm *LockManager) cancelWaiterLocked(w *lockWaiter, err error) {
+	st := lm.locks[w.key]
+	for i, w2 := range st.queue {
+		if w2 == w {
+			st.queue = append(st.queue[:i], st.queue[i+1:]...)
+			break
+		}
+	}
+	lm.removeWaiterLocked(w)
+	w.ready <- err
+	lm.grantWaitersLocked(w.key, st)
+	if len(st.holders) == 0 && len(st.queue) == 0 {
+		delete(lm.locks, w.key)
+	}
+}
+
+// Forget that the transaction of w is waiting for w. Must be called with lm.mu
+// held.
+func (lm *LockManager) removeWaiterLocked(w *lockWaiter) {
+	waiters := lm.waiting[w.tid]
+	for i, w2 := range waiters {
+		if w2 == w {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(lm.waiting, w.tid)
+	} else {
+		lm.waiting[w.tid] = waiters
+	}
+}
+
+// Release every lock held by tid. Requests tid is still waiting for (e.g.,
+// made by another goroutine) return an IllegalTransactionError.
+func (lm *LockManager) releaseAll(tid TransactionID) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	for _, w := range append([]*lockWaiter{}, lm.waiting[tid]...) {
+		lm.cancelWaiterLocked(w, GoDBError{IllegalTransactionError, fmt.Sprintf("transaction %d ended while waiting for a lock", tid)})
+	}
+	for key := range lm.held[tid] {
+		lm.releaseLocked(tid, key)
+	}
+	delete(lm.work, tid)
+	delete(lm.wounded, tid)
+}
+
+// Return a description of the locks held, for debugging.
+func (lm *LockManager) String() string {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	s := ""
+	for key, st := range lm.locks {
+		s += fmt.Sprintf("%v: held by %v, %d waiting\n", key, st.holders, len(st.queue))
+	}
+	return s
+}
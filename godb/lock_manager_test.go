@@ -0,0 +1,93 @@
+package godb
+
+import (
+	"testing"
+	"time"
+)
+
+// Request a lock in the background, returning a channel that receives the
+// result of the request once it is granted.
+func acquireAsync(lm *LockManager, tid TransactionID, key any, mode LockMode) chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- lm.acquire(tid, key, mode)
+	}()
+	return done
+}
+
+// Return true if the request has been granted within a short time.
+func granted(t *testing.T, done chan error) bool {
+	t.Helper()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		return true
+	case <-time.After(50 * time.Millisecond):
+		return false
+	}
+}
+
+func TestLockManagerSharedExclusive(t *testing.T) {
+	lm := NewLockManager()
+	t1, t2, t3 := NewTID(), NewTID(), NewTID()
+	if !granted(t, acquireAsync(lm, t1, "a", SharedLock)) || !granted(t, acquireAsync(lm, t2, "a", SharedLock)) {
+		t.Fatalf("shared locks should be compatible")
+	}
+	x := acquireAsync(lm, t3, "a", ExclusiveLock)
+	if granted(t, x) {
+		t.Fatalf("an exclusive lock should wait for shared locks")
+	}
+	lm.releaseAll(t1)
+	if granted(t, x) {
+		t.Fatalf("an exclusive lock should wait for every shared lock")
+	}
+	// first come, first served: t1 waits behind t3
+	s := acquireAsync(lm, t1, "a", SharedLock)
+	lm.releaseAll(t2)
+	if !granted(t, x) {
+		t.Fatalf("the exclusive lock should be granted once the shared locks are released")
+	}
+	if granted(t, s) {
+		t.Fatalf("a shared lock should wait for an exclusive lock")
+	}
+	lm.releaseAll(t3)
+	if !granted(t, s) {
+		t.Fatalf("the shared lock should be granted once the exclusive lock is released")
+	}
+	lm.releaseAll(t1)
+	if len(lm.locks) != 0 || len(lm.held) != 0 {
+		t.Errorf("expected no locks to be left, have %s", lm)
+	}
+}
+
+func TestLockManagerUpgrade(t *testing.T) {
+	lm := NewLockManager()
+	t1, t2 := NewTID(), NewTID()
+	if !granted(t, acquireAsync(lm, t1, "a", SharedLock)) {
+		t.Fatalf("the shared lock should be granted")
+	}
+	if !granted(t, acquireAsync(lm, t1, "a", ExclusiveLock)) {
+		t.Fatalf("a lock should be upgraded when its holder is the only one")
+	}
+	if !granted(t, acquireAsync(lm, t1, "a", SharedLock)) {
+		t.Fatalf("an exclusive lock covers a shared lock")
+	}
+	if mode, ok := lm.holds(t1, "a"); !ok || mode != ExclusiveLock {
+		t.Errorf("expected t1 to hold an exclusive lock, got %v", mode)
+	}
+	lm.releaseAll(t1)
+
+	lm.acquire(t1, "a", SharedLock)
+	lm.acquire(t2, "a", SharedLock)
+	upgrade := acquireAsync(lm, t1, "a", ExclusiveLock)
+	if granted(t, upgrade) {
+		t.Fatalf("an upgrade should wait for other holders")
+	}
+	lm.release(t2, "a")
+	if !granted(t, upgrade) {
+		t.Fatalf("the upgrade should be granted once t1 is the only holder")
+	}
+	lm.releaseAll(t1)
+}
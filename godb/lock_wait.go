@@ -0,0 +1,168 @@
+package godb
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+By default a transaction waits for a lock for as long as it takes (unless the
+wait would cause a deadlock, see [LockManager]). A transaction can bound the
+wait with SET lock_timeout = t, where t is a number of milliseconds or a
+duration such as '1s'; a lock request that is not granted within the timeout
+fails with a LockNotAvailableError. A timeout of 0 waits forever.
+
+Scans of SELECT ... FOR UPDATE statements lock the tuples they return for
+writing, as if they were about to be updated, and may specify what to do when
+a tuple is locked by another transaction (see [LockWaitPolicy]): NOWAIT fails
+right away with a LockNotAvailableError, and SKIP LOCKED skips the tuple, which
+lets many workers claim different rows of a queue table concurrently. SKIP
+LOCKED requires row locking (see [BufferPoolOptions]): a BufferPool that locks
+pages could only skip whole pages, hiding the tuples of a page that are not
+locked, so such scans fail with an IllegalOperationError instead.
+*/
+
+// What a lock request does when the lock is held by another transaction.
+type LockWaitPolicy int
+
+const (
+	LockWait       LockWaitPolicy = iota // wait, up to the transaction's lock timeout
+	LockNoWait     LockWaitPolicy = iota // fail with a LockNotAvailableError
+	LockSkipLocked LockWaitPolicy = iota // skip the locked tuple or page (scans only)
+)
+
+func (p LockWaitPolicy) String() string {
+	switch p {
+	case LockWait:
+		return ""
+	case LockNoWait:
+		return "NOWAIT"
+	case LockSkipLocked:
+		return "SKIP LOCKED"
+	}
+	return "UNKNOWN"
+}
+
+// How a scan locks the tuples it returns (see [HeapFile.iteratorWithLocking]).
+type scanLocking struct {
+	forUpdate bool // lock tuples for writing rather than reading
+	wait      LockWaitPolicy
+}
+
+// The locking of ordinary scans, as done by [HeapFile.Iterator].
+var defaultScanLocking = scanLocking{false, LockWait}
+
+// Return the permission with which the scan reads pages and locks tuples.
+func (l scanLocking) perm() RWPerm {
+	if l.forUpdate {
+		return WritePerm
+	}
+	return ReadPerm
+}
+
+func (l scanLocking) String() string {
+	if !l.forUpdate {
+		return ""
+	}
+	if l.wait != LockWait {
+		return "FOR UPDATE " + l.wait.String()
+	}
+	return "FOR UPDATE"
+}
+
+// Return true if err is a LockNotAvailableError, i.e., a lock request that did
+// not wait, or timed out.
+func isLockNotAvailable(err error) bool {
+	gerr, ok := err.(GoDBError)
+	return ok && gerr.code == LockNotAvailableError
+}
+
+// A scan of a HeapFile for a SELECT ... FOR UPDATE statement, which locks the
+// tuples it returns (see [HeapFile.iteratorWithLocking]).
+type lockingScanOp struct {
+	file    *HeapFile
+	locking scanLocking
+}
+
+func (s *lockingScanOp) Descriptor() *TupleDesc {
+	return s.file.Descriptor()
+}
+
+func (s *lockingScanOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	if s.locking.wait == LockSkipLocked && !s.file.bufPool.rowLocking {
+		return nil, GoDBError{IllegalOperationError, "SKIP LOCKED requires row locking"}
+	}
+	return s.file.iteratorWithLocking(tid, s.locking)
+}
+
+var lockWaitClause = regexp.MustCompile(`(?i)\s+(nowait|skip\s+locked)\s*;?\s*$`)
+
+// Remove a trailing NOWAIT or SKIP LOCKED clause, which the sqlparser grammar
+// does not support, from query, returning the policy it specifies (LockWait if
+// there is none).
+func extractLockWaitPolicy(query string) (string, LockWaitPolicy) {
+	m := lockWaitClause.FindStringSubmatchIndex(query)
+	if m == nil {
+		return query, LockWait
+	}
+	policy := LockNoWait
+	if strings.HasPrefix(strings.ToLower(query[m[2]:m[3]]), "skip") {
+		policy = LockSkipLocked
+	}
+	return query[:m[0]], policy
+}
+
+// Return the locking of the scans of a SELECT statement with the specified
+// lock clause (as parsed by sqlparser, e.g. " for update") and wait policy,
+// or nil if the statement does not lock.
+func parseLockClause(lock string, wait LockWaitPolicy) (*scanLocking, error) {
+	switch strings.TrimSpace(strings.ToLower(lock)) {
+	case "":
+		if wait != LockWait {
+			return nil, GoDBError{ParseError, fmt.Sprintf("%s requires FOR UPDATE", wait)}
+		}
+		return nil, nil
+	case "for update":
+		return &scanLocking{true, wait}, nil
+	}
+	return nil, GoDBError{ParseError, fmt.Sprintf("unsupported lock clause %s", lock)}
+}
+
+// Parse the value of a SET lock_timeout statement: a number of milliseconds,
+// or a string holding a number of milliseconds or a duration (see
+// [time.ParseDuration]).
+func parseLockTimeout(val string) (time.Duration, error) {
+	if ms, err := strconv.Atoi(val); err == nil && ms >= 0 {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	if d, err := time.ParseDuration(val); err == nil && d >= 0 {
+		return d, nil
+	}
+	return 0, GoDBError{ParseError, fmt.Sprintf("invalid lock timeout %s", val)}
+}
+
+// Set the lock timeout of tid (see [LockWaitPolicy]); 0 waits forever.
+func (bp *BufferPool) SetLockTimeout(tid TransactionID, timeout time.Duration) {
+	bp.settings.update(tid, func(s *txnSettings) { s.lockTimeout = timeout })
+}
+
+// Set the lock timeout of transactions whose timeout has not been set with
+// [BufferPool.SetLockTimeout].
+func (bp *BufferPool) SetDefaultLockTimeout(timeout time.Duration) {
+	bp.settings.update(InvalidTID, func(s *txnSettings) { s.lockTimeout = timeout })
+}
+
+// Return how long a lock request of tid with the specified policy may wait, as
+// passed to [LockManager.acquireWithin].
+func (bp *BufferPool) lockWaitTimeout(tid TransactionID, wait LockWaitPolicy) time.Duration {
+	if wait != LockWait {
+		return 0
+	}
+	if timeout := bp.settings.get(tid).lockTimeout; timeout > 0 {
+		return timeout
+	}
+	return waitForever
+}
@@ -0,0 +1,225 @@
+package godb
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLockWaitTimeout(t *testing.T) {
+	lm := NewLockManager()
+	t1, t2 := NewTID(), NewTID()
+	lm.acquire(t1, "a", ExclusiveLock)
+
+	start := time.Now()
+	err := lm.acquireWithin(t2, "a", SharedLock, 50*time.Millisecond)
+	if !isLockNotAvailable(err) {
+		t.Fatalf("expected a LockNotAvailableError, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected the request to wait for the timeout, returned after %v", elapsed)
+	}
+	if err := lm.acquireWithin(t2, "a", SharedLock, 0); !isLockNotAvailable(err) {
+		t.Fatalf("expected a LockNotAvailableError without waiting, got %v", err)
+	}
+	if len(lm.locks["a"].queue) != 0 || len(lm.waiting) != 0 {
+		t.Errorf("expected requests that timed out to stop waiting, have %s", lm)
+	}
+
+	// a lock released within the timeout is granted
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		lm.releaseAll(t1)
+	}()
+	if err := lm.acquireWithin(t2, "a", SharedLock, time.Second); err != nil {
+		t.Fatalf("expected the lock to be granted within the timeout, got %v", err)
+	}
+	lm.releaseAll(t2)
+}
+
+func TestLockWaitTransactionTimeout(t *testing.T) {
+	bp := &BufferPool{}
+	t1, t2, t3 := NewTID(), NewTID(), NewTID()
+	bp.lockPage(t1, "p", WritePerm, LockWait)
+	bp.SetDefaultLockTimeout(20 * time.Millisecond)
+	if err := bp.lockPage(t2, "p", ReadPerm, LockWait); !isLockNotAvailable(err) {
+		t.Errorf("expected the default lock timeout to apply, got %v", err)
+	}
+	bp.SetLockTimeout(t3, 0)
+	wait := lockPageAsync(bp, t3, "p", ReadPerm)
+	if granted(t, wait) {
+		t.Fatalf("a lock timeout of 0 should wait forever")
+	}
+	if err := bp.lockPage(t3, "q", ReadPerm, LockNoWait); err != nil {
+		t.Errorf("NOWAIT should not fail when the lock is free, got %v", err)
+	}
+	bp.releaseLocks(t1)
+	if !granted(t, wait) {
+		t.Fatalf("the request should be granted once the lock is released")
+	}
+	bp.releaseLocks(t3)
+}
+
+// Workers claiming rows of a queue table with SKIP LOCKED each get different
+// rows, without waiting for each other.
+func TestLockWaitSkipLocked(t *testing.T) {
+	bp := &BufferPool{rowLocking: true}
+	file := &HeapFile{bufPool: bp}
+	const rows, workers = 20, 4
+
+	var mu sync.Mutex
+	claimedBy := make(map[int]TransactionID)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		tid := NewTID()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rid := 0; rid < rows; rid++ {
+				err := bp.lockTuple(file, rid, tid, WritePerm, LockSkipLocked)
+				if isLockNotAvailable(err) {
+					continue
+				} else if err != nil {
+					t.Errorf(err.Error())
+					return
+				}
+				mu.Lock()
+				if other, ok := claimedBy[rid]; ok {
+					t.Errorf("row %d claimed by both %d and %d", rid, other, tid)
+				}
+				claimedBy[rid] = tid
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(claimedBy) != rows {
+		t.Errorf("expected every row to be claimed, %d were", len(claimedBy))
+	}
+}
+
+// Two workers share one page of a queue table: each takes the page lock for
+// writing, claims rows with SKIP LOCKED and updates the rows it claimed, and
+// neither ever waits for the other, since the page lock is only an intention
+// lock and their rows are disjoint.
+func TestLockWaitSkipLockedOnePage(t *testing.T) {
+	bp := &BufferPool{rowLocking: true}
+	file := &HeapFile{bufPool: bp}
+	const rows = 10
+	workers := []TransactionID{NewTID(), NewTID()}
+
+	var pageLocked, wg sync.WaitGroup
+	pageLocked.Add(len(workers))
+	claimed := make([][]int, len(workers))
+	for w, tid := range workers {
+		wg.Add(1)
+		go func(w int, tid TransactionID) {
+			defer wg.Done()
+			// NOWAIT fails rather than blocks, so any wait shows up as an error
+			err := bp.lockFilePage(file, 0, tid, WritePerm, LockNoWait)
+			pageLocked.Done()
+			if err != nil {
+				t.Errorf("worker %d could not lock the page: %v", w, err)
+				return
+			}
+			pageLocked.Wait()
+			for slot := w; slot < rows+w; slot++ {
+				rid := heapRid{0, slot % rows}
+				err := bp.lockTuple(file, rid, tid, WritePerm, LockSkipLocked)
+				if isLockNotAvailable(err) {
+					continue
+				} else if err != nil {
+					t.Errorf(err.Error())
+					return
+				}
+				claimed[w] = append(claimed[w], rid.slot)
+				bp.changes.add(tid, &rowChange{kind: slotChange, file: file, pageNo: 0, rid: rid, before: []byte("ready"), after: []byte("done")})
+				if err := bp.lockFilePage(file, 0, tid, WritePerm, LockNoWait); err != nil {
+					t.Errorf("worker %d could not lock the page again: %v", w, err)
+					return
+				}
+			}
+		}(w, tid)
+	}
+	wg.Wait()
+
+	owner := make(map[int]int)
+	for w, slots := range claimed {
+		for _, slot := range slots {
+			if other, ok := owner[slot]; ok {
+				t.Errorf("row %d claimed by workers %d and %d", slot, other, w)
+			}
+			owner[slot] = w
+		}
+		if n := len(bp.changes.slotChanges(workers[w], file, 0)); n != len(slots) {
+			t.Errorf("expected worker %d to have updated its %d rows, has %d changes", w, len(slots), n)
+		}
+	}
+	if len(owner) != rows {
+		t.Errorf("expected every row to be claimed, %d were", len(owner))
+	}
+	for _, tid := range workers {
+		bp.releaseLocks(tid)
+	}
+	if bp.changes.changedByOthers(file, 0, InvalidTID) {
+		t.Errorf("expected the changes of the workers to be forgotten once they end")
+	}
+}
+
+func TestLockWaitParse(t *testing.T) {
+	queries := map[string]LockWaitPolicy{
+		"select * from t for update":              LockWait,
+		"select * from t for update nowait":       LockNoWait,
+		"select * from t for update NOWAIT;":      LockNoWait,
+		"select * from t for update skip locked":  LockSkipLocked,
+		"select * from t FOR UPDATE SKIP  LOCKED": LockSkipLocked,
+	}
+	for query, policy := range queries {
+		rest, wait := extractLockWaitPolicy(query)
+		if wait != policy {
+			t.Errorf("%q: expected %v, got %v", query, policy, wait)
+		}
+		locking, err := parseLockClause(" for update", wait)
+		if err != nil || !locking.forUpdate || locking.wait != policy {
+			t.Errorf("%q: unexpected locking %v (%v) for %q", query, locking, err, rest)
+		}
+	}
+	if _, err := parseLockClause("", LockNoWait); err == nil {
+		t.Errorf("expected NOWAIT without FOR UPDATE to be rejected")
+	}
+	if _, _, err := Parse(nil, "delete from t where a = 1 skip locked"); err == nil {
+		t.Errorf("expected SKIP LOCKED outside of SELECT to be rejected")
+	}
+
+	bp := &BufferPool{}
+	c := &Catalog{bufferPool: bp}
+	timeouts := map[string]time.Duration{
+		"set lock_timeout = 100":   100 * time.Millisecond,
+		"set lock_timeout = '2s'":  2 * time.Second,
+		"set lock_timeout = '250'": 250 * time.Millisecond,
+		"SET lock_timeout = 0":     0,
+	}
+	for query, timeout := range timeouts {
+		qtype, op, err := Parse(c, query)
+		if err != nil || qtype != SetQueryType {
+			t.Fatalf("%q: expected SetQueryType, got %v (%v)", query, qtype, err)
+		}
+		tid := NewTID()
+		op.Iterator(tid)
+		if got := bp.settings.get(tid).lockTimeout; got != timeout {
+			t.Errorf("%q: expected %v, got %v", query, timeout, got)
+		}
+	}
+	if _, _, err := Parse(c, "set lock_timeout = 'soon'"); err == nil {
+		t.Errorf("expected an invalid lock timeout to be rejected")
+	}
+}
+
+// Without row locking a SKIP LOCKED scan could only skip whole pages, so it is
+// rejected rather than silently hiding tuples that are not locked.
+func TestLockWaitSkipLockedRequiresRowLocking(t *testing.T) {
+	scan := &lockingScanOp{&HeapFile{bufPool: &BufferPool{}}, scanLocking{true, LockSkipLocked}}
+	if _, err := scan.Iterator(NewTID()); err == nil || err.(GoDBError).code != IllegalOperationError {
+		t.Errorf("expected SKIP LOCKED without row locking to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,704 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+/*
+LogFile implements the write-ahead log (WAL) used by GoDB to recover from
+crashes. The log is a sequence of records, each identified by a log sequence
+number (LSN). The LSN of a record is its logical byte offset in the log, so
+LSNs increase monotonically and a record can be located directly from its LSN.
+
+On disk, the log starts with a header (a 64 bit magic number followed by the
+64 bit LSN of the first record in the file). Each record is then written as a
+32 bit payload length, a 32 bit CRC of the payload, and the payload itself.
+The CRC allows [NewLogFile] to detect a record that was only partially written
+when the system crashed; such a torn tail is discarded.
+
+Appended records are buffered in memory and only become durable when the log is
+forced (see [LogFile.Force] and [LogFile.forceTo]). The BufferPool must follow
+the WAL protocol:
+
+  - before a dirty page is written to its backing file, an update record
+    holding the page's before image and its current contents as the after
+    image must have been logged, and the log must be forced up to (and
+    including) that record;
+  - a transaction is committed once its commit record is durable, which
+    [LogFile.logCommit] guarantees before returning.
+
+Because every update is logged with its before image, the BufferPool may
+write pages dirtied by uncommitted transactions (STEAL): if such a transaction
+aborts, [LogFile.logAbort] restores the before images, and if the system
+crashes first, recovery does. Because every update is logged with its after
+image, the BufferPool does not need to write a transaction's pages when it
+commits (NO FORCE): recovery redoes any committed update that had not reached
+the disk.
+
+Update records store full page images, so recovery never needs to understand
+the format of a page: it simply writes images back at offset pageNo *
+[PageSize] of the backing file named in the record (see [LogFile.Recover]).
+Insert and delete records store the serialized tuple affected by an
+operation; they describe the logical change but are not needed to restore
+page images. Checkpoint records bound how much of the log recovery has to read
+(see [LogFile.Checkpoint]).
+*/
+
+// A log sequence number; the logical offset of a record in the log.
+type LSN int64
+
+// The LSN used to terminate prevLSN chains and to mark pages that have never
+// been logged.
+const InvalidLSN LSN = -1
+
+// The name of the log file that [NewCatalogFromFile] opens in the root path of
+// a database.
+const LogFileName string = "godb.log"
+
+type LogRecordType uint8
+
+const (
+	BeginRecord  LogRecordType = iota // a transaction started
+	CommitRecord LogRecordType = iota // a transaction committed
+	AbortRecord  LogRecordType = iota // a transaction started to roll back
+	EndRecord    LogRecordType = iota // a transaction's commit or rollback finished
+	UpdateRecord LogRecordType = iota // a page was overwritten; holds before/after images
+	InsertRecord LogRecordType = iota // a tuple was inserted
+	DeleteRecord LogRecordType = iota // a tuple was deleted
+	CLRRecord    LogRecordType = iota // compensation for an undone update
+
+	BeginCheckpointRecord LogRecordType = iota // a fuzzy checkpoint started
+	EndCheckpointRecord   LogRecordType = iota // holds the active transaction and dirty page tables
+)
+
+func (t LogRecordType) String() string {
+	switch t {
+	case BeginRecord:
+		return "BEGIN"
+	case CommitRecord:
+		return "COMMIT"
+	case AbortRecord:
+		return "ABORT"
+	case EndRecord:
+		return "END"
+	case UpdateRecord:
+		return "UPDATE"
+	case InsertRecord:
+		return "INSERT"
+	case DeleteRecord:
+		return "DELETE"
+	case CLRRecord:
+		return "CLR"
+	case BeginCheckpointRecord:
+		return "BEGIN_CHECKPOINT"
+	case EndCheckpointRecord:
+		return "END_CHECKPOINT"
+	}
+	return "UNKNOWN"
+}
+
+// Return true if records of this type are written on behalf of a transaction
+// and are part of its prevLSN chain.
+func (t LogRecordType) transactional() bool {
+	return t != BeginCheckpointRecord && t != EndCheckpointRecord
+}
+
+// LogRecord is the in-memory representation of a single log record. Which
+// fields are meaningful depends on the record type.
+type LogRecord struct {
+	recType LogRecordType
+	lsn     LSN
+	prevLSN LSN // previous record written by the same transaction
+	tid     TransactionID
+
+	// UpdateRecord, CLRRecord, InsertRecord and DeleteRecord
+	file string // backing file of the page or tuple
+
+	// UpdateRecord and CLRRecord
+	pageNo int
+	before []byte // nil if the page did not exist before the update
+	after  []byte
+
+	// CLRRecord: the next record of the transaction that still needs undoing
+	undoNextLSN LSN
+
+	// InsertRecord and DeleteRecord
+	tuple []byte
+
+	// EndCheckpointRecord (see [LogFile.Checkpoint])
+	checkpointLSN LSN           // the matching BeginCheckpointRecord
+	nextTID       TransactionID // the next id NewTID would have returned
+	activeTxns    map[TransactionID]checkpointTxn
+	dirtyPages    map[heapHash]LSN
+}
+
+func (r *LogRecord) String() string {
+	switch r.recType {
+	case UpdateRecord, CLRRecord:
+		return fmt.Sprintf("%d: %v tid=%d prev=%d %s#%d", r.lsn, r.recType, r.tid, r.prevLSN, r.file, r.pageNo)
+	case InsertRecord, DeleteRecord:
+		return fmt.Sprintf("%d: %v tid=%d prev=%d %s", r.lsn, r.recType, r.tid, r.prevLSN, r.file)
+	case BeginCheckpointRecord:
+		return fmt.Sprintf("%d: %v", r.lsn, r.recType)
+	case EndCheckpointRecord:
+		return fmt.Sprintf("%d: %v begin=%d active=%d dirty=%d", r.lsn, r.recType, r.checkpointLSN, len(r.activeTxns), len(r.dirtyPages))
+	default:
+		return fmt.Sprintf("%d: %v tid=%d prev=%d", r.lsn, r.recType, r.tid, r.prevLSN)
+	}
+}
+
+const (
+	logMagic       uint64 = 0x474f44424c4f4731 // "GODBLOG1"
+	logHeaderSize  int64  = 16
+	logRecOverhead int64  = 8 // length and checksum preceding each payload
+
+	// Payloads larger than this are assumed to be garbage left by a torn write.
+	maxLogPayload uint32 = 64 << 20
+)
+
+type LogFile struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+
+	startLSN   LSN          // LSN of the first record stored in file
+	nextLSN    LSN          // LSN the next appended record will receive
+	flushedLSN LSN          // every record with an LSN below this is durable
+	tail       bytes.Buffer // records appended but not yet forced
+
+	// first and last records written by each transaction that has not yet ended
+	firstLSN map[TransactionID]LSN
+	lastLSN  map[TransactionID]LSN
+
+	// the last checkpoint, as stored in the master record (see
+	// [LogFile.Checkpoint]); InvalidLSN if there has not been one
+	checkpointLSN LSN
+	truncateLSN   LSN
+
+	// set by [LogFile.Recover] if it found transactions that had not ended
+	unfinished bool
+}
+
+// Open the log stored at path, creating it if it does not exist. Any partially
+// written record at the end of the log (e.g., because the system crashed while
+// forcing the log) is truncated away.
+func NewLogFile(path string) (*LogFile, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	lf := &LogFile{path: path, file: f, firstLSN: make(map[TransactionID]LSN), lastLSN: make(map[TransactionID]LSN),
+		checkpointLSN: InvalidLSN, truncateLSN: InvalidLSN}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.Size() < logHeaderSize {
+		// new (or never initialized) log
+		if err := lf.writeHeader(0); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return lf, nil
+	}
+
+	var hdr struct {
+		Magic    uint64
+		StartLSN int64
+	}
+	if err := binary.Read(io.NewSectionReader(f, 0, logHeaderSize), binary.LittleEndian, &hdr); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if hdr.Magic != logMagic {
+		f.Close()
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("%s is not a GoDB log file", path)}
+	}
+	lf.startLSN = LSN(hdr.StartLSN)
+
+	// find the end of the last complete record
+	end := lf.startLSN
+	for {
+		rec, err := lf.readFromFile(end)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if rec == nil {
+			break
+		}
+		end += LSN(logRecOverhead) + LSN(rec.payloadLen)
+		lf.trackTransaction(&rec.LogRecord)
+	}
+	if err := f.Truncate(lf.fileOffset(end)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	lf.nextLSN = end
+	lf.flushedLSN = end
+	if err := lf.readMasterRecord(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return lf, nil
+}
+
+func encodeLogHeader(startLSN LSN) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, logMagic)
+	binary.Write(&b, binary.LittleEndian, int64(startLSN))
+	return b.Bytes()
+}
+
+func (lf *LogFile) writeHeader(startLSN LSN) error {
+	if _, err := lf.file.WriteAt(encodeLogHeader(startLSN), 0); err != nil {
+		return err
+	}
+	if err := lf.file.Truncate(logHeaderSize); err != nil {
+		return err
+	}
+	lf.startLSN = startLSN
+	lf.nextLSN = startLSN
+	lf.flushedLSN = startLSN
+	return lf.file.Sync()
+}
+
+func (lf *LogFile) fileOffset(lsn LSN) int64 {
+	return logHeaderSize + int64(lsn-lf.startLSN)
+}
+
+// Close the log, forcing any buffered records first.
+func (lf *LogFile) Close() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if err := lf.forceLocked(); err != nil {
+		return err
+	}
+	return lf.file.Close()
+}
+
+// Return the path of the file backing this log.
+func (lf *LogFile) Path() string {
+	return lf.path
+}
+
+// Return the LSN of the last record written on behalf of tid, or InvalidLSN if
+// tid has not written any records (or has ended).
+func (lf *LogFile) lastLSNOf(tid TransactionID) LSN {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	lsn, ok := lf.lastLSN[tid]
+	if !ok {
+		return InvalidLSN
+	}
+	return lsn
+}
+
+// Append a record to the in-memory tail of the log, assigning its LSN and
+// prevLSN. Must be called with lf.mu held.
+func (lf *LogFile) appendLocked(rec *LogRecord) (LSN, error) {
+	prev, ok := lf.lastLSN[rec.tid]
+	if !ok || !rec.recType.transactional() {
+		prev = InvalidLSN
+	}
+	rec.prevLSN = prev
+	rec.lsn = lf.nextLSN
+
+	payload, err := rec.encode()
+	if err != nil {
+		return InvalidLSN, err
+	}
+	binary.Write(&lf.tail, binary.LittleEndian, uint32(len(payload)))
+	binary.Write(&lf.tail, binary.LittleEndian, crc32.ChecksumIEEE(payload))
+	lf.tail.Write(payload)
+	lf.nextLSN += LSN(logRecOverhead) + LSN(len(payload))
+	lf.trackTransaction(rec)
+	return rec.lsn, nil
+}
+
+// Update firstLSN and lastLSN for a record that was just appended or read.
+func (lf *LogFile) trackTransaction(rec *LogRecord) {
+	switch {
+	case !rec.recType.transactional():
+	case rec.recType == EndRecord:
+		delete(lf.firstLSN, rec.tid)
+		delete(lf.lastLSN, rec.tid)
+	default:
+		if _, ok := lf.firstLSN[rec.tid]; !ok {
+			lf.firstLSN[rec.tid] = rec.lsn
+		}
+		lf.lastLSN[rec.tid] = rec.lsn
+	}
+}
+
+func (lf *LogFile) append(rec *LogRecord) (LSN, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	return lf.appendLocked(rec)
+}
+
+// Log the start of transaction tid.
+func (lf *LogFile) logBegin(tid TransactionID) (LSN, error) {
+	return lf.append(&LogRecord{recType: BeginRecord, tid: tid})
+}
+
+// Log that tid is about to overwrite page pageNo of the file named fileName.
+// before is the page's before image (nil if the page does not exist on disk
+// yet) and after is the image that will be written. Rolling back the update
+// restores the before image, so under NO FORCE it must be the last committed
+// contents of the page, which are not necessarily the contents on disk. The
+// returned LSN should be stamped on the page; the log must be forced up to it
+// before the page itself is written (see [LogFile.forceTo]).
+func (lf *LogFile) logUpdate(tid TransactionID, fileName string, pageNo int, before []byte, after []byte) (LSN, error) {
+	return lf.append(&LogRecord{recType: UpdateRecord, tid: tid, file: fileName, pageNo: pageNo,
+		before: cloneImage(before), after: cloneImage(after)})
+}
+
+// Log that tid inserted the serialized tuple tup into the file named fileName.
+func (lf *LogFile) logInsert(tid TransactionID, fileName string, tup []byte) (LSN, error) {
+	return lf.append(&LogRecord{recType: InsertRecord, tid: tid, file: fileName, tuple: cloneImage(tup)})
+}
+
+// Log that tid deleted the serialized tuple tup from the file named fileName.
+func (lf *LogFile) logDelete(tid TransactionID, fileName string, tup []byte) (LSN, error) {
+	return lf.append(&LogRecord{recType: DeleteRecord, tid: tid, file: fileName, tuple: cloneImage(tup)})
+}
+
+// Log the commit of tid and force the log. tid is committed once this method
+// returns without error. All of tid's updates must have been logged before
+// calling this method.
+func (lf *LogFile) logCommit(tid TransactionID) error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if _, err := lf.appendLocked(&LogRecord{recType: CommitRecord, tid: tid}); err != nil {
+		return err
+	}
+	if err := lf.forceLocked(); err != nil {
+		return err
+	}
+	_, err := lf.appendLocked(&LogRecord{recType: EndRecord, tid: tid})
+	return err
+}
+
+// Log the abort of tid and roll back its logged updates (see
+// [LogFile.rollback]), returning the pages that were restored on disk. Under
+// STEAL, some of tid's dirty pages may have been written before it aborted;
+// the caller must discard any cached copies of the returned pages so they are
+// read again from disk. Updates that were never logged only exist in the
+// caller's cache and must be discarded by the caller.
+//
+// The log does not need to be forced, because recovery rolls back any
+// transaction that has no commit record.
+func (lf *LogFile) logAbort(tid TransactionID) ([]heapHash, error) {
+	if _, err := lf.append(&LogRecord{recType: AbortRecord, tid: tid}); err != nil {
+		return nil, err
+	}
+	pages, err := lf.rollback(tid, InvalidLSN)
+	if err != nil {
+		return nil, err
+	}
+	_, err = lf.append(&LogRecord{recType: EndRecord, tid: tid})
+	return pages, err
+}
+
+// Force all buffered log records to disk.
+func (lf *LogFile) Force() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	return lf.forceLocked()
+}
+
+// Force the log to disk up to and including the record with the specified
+// LSN. Does nothing if that record is already durable.
+func (lf *LogFile) forceTo(lsn LSN) error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lsn < lf.flushedLSN {
+		return nil
+	}
+	return lf.forceLocked()
+}
+
+func (lf *LogFile) forceLocked() error {
+	if lf.tail.Len() == 0 {
+		return nil
+	}
+	if _, err := lf.file.WriteAt(lf.tail.Bytes(), lf.fileOffset(lf.flushedLSN)); err != nil {
+		return err
+	}
+	if err := lf.file.Sync(); err != nil {
+		return err
+	}
+	lf.tail.Reset()
+	lf.flushedLSN = lf.nextLSN
+	return nil
+}
+
+// Return an iterator over the records of the log, in LSN order, starting with
+// the record at the specified LSN. The log is forced before iterating. The
+// iterator returns nil, nil once all records have been read.
+func (lf *LogFile) iteratorFrom(lsn LSN) (func() (*LogRecord, error), error) {
+	if err := lf.Force(); err != nil {
+		return nil, err
+	}
+	lf.mu.Lock()
+	if lsn < lf.startLSN {
+		lsn = lf.startLSN
+	}
+	lf.mu.Unlock()
+	next := lsn
+	return func() (*LogRecord, error) {
+		lf.mu.Lock()
+		defer lf.mu.Unlock()
+		if next >= lf.flushedLSN {
+			return nil, nil
+		}
+		if next < lf.startLSN {
+			return nil, GoDBError{MalformedDataError, fmt.Sprintf("log record at LSN %d was truncated", next)}
+		}
+		rec, err := lf.readFromFile(next)
+		if err != nil {
+			return nil, err
+		}
+		if rec == nil {
+			return nil, GoDBError{MalformedDataError, fmt.Sprintf("log record at LSN %d is corrupt", next)}
+		}
+		next += LSN(logRecOverhead) + LSN(rec.payloadLen)
+		return &rec.LogRecord, nil
+	}, nil
+}
+
+// Return an iterator over all of the records in the log, in LSN order.
+func (lf *LogFile) Iterator() (func() (*LogRecord, error), error) {
+	return lf.iteratorFrom(InvalidLSN)
+}
+
+// Read the record with the specified LSN, forcing the log first if the record
+// is still buffered.
+func (lf *LogFile) readRecord(lsn LSN) (*LogRecord, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lsn >= lf.flushedLSN {
+		if err := lf.forceLocked(); err != nil {
+			return nil, err
+		}
+	}
+	if lsn < lf.startLSN || lsn >= lf.flushedLSN {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("no log record with LSN %d", lsn)}
+	}
+	rec, err := lf.readFromFile(lsn)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("log record at LSN %d is corrupt", lsn)}
+	}
+	return &rec.LogRecord, nil
+}
+
+type storedLogRecord struct {
+	LogRecord
+	payloadLen int
+}
+
+// Read the record at the specified LSN from the backing file. Returns nil, nil
+// if there is no complete, intact record at that LSN.
+func (lf *LogFile) readFromFile(lsn LSN) (*storedLogRecord, error) {
+	var hdr [logRecOverhead]byte
+	n, err := lf.file.ReadAt(hdr[:], lf.fileOffset(lsn))
+	if n < len(hdr) {
+		if err == io.EOF || err == nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	length := binary.LittleEndian.Uint32(hdr[0:4])
+	sum := binary.LittleEndian.Uint32(hdr[4:8])
+	if length > maxLogPayload {
+		return nil, nil
+	}
+	payload := make([]byte, length)
+	n, err = lf.file.ReadAt(payload, lf.fileOffset(lsn)+logRecOverhead)
+	if n < len(payload) {
+		if err == io.EOF || err == nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(payload) != sum {
+		return nil, nil
+	}
+	rec, err := decodeLogRecord(payload)
+	if err != nil {
+		return nil, nil
+	}
+	rec.lsn = lsn
+	return &storedLogRecord{*rec, int(length)}, nil
+}
+
+func cloneImage(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+func writeLogBytes(b *bytes.Buffer, data []byte) {
+	if data == nil {
+		binary.Write(b, binary.LittleEndian, int32(-1))
+		return
+	}
+	binary.Write(b, binary.LittleEndian, int32(len(data)))
+	b.Write(data)
+}
+
+func readLogBytes(b *bytes.Buffer) ([]byte, error) {
+	var n int32
+	if err := binary.Read(b, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, nil
+	}
+	if int(n) > b.Len() {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return append([]byte{}, b.Next(int(n))...), nil
+}
+
+// Serialize the type specific contents of the record (everything but its LSN,
+// which is implied by its position in the log).
+func (r *LogRecord) encode() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte(byte(r.recType))
+	binary.Write(&b, binary.LittleEndian, int64(r.tid))
+	binary.Write(&b, binary.LittleEndian, int64(r.prevLSN))
+	switch r.recType {
+	case UpdateRecord, CLRRecord:
+		writeLogBytes(&b, []byte(r.file))
+		binary.Write(&b, binary.LittleEndian, int64(r.pageNo))
+		writeLogBytes(&b, r.before)
+		writeLogBytes(&b, r.after)
+		if r.recType == CLRRecord {
+			binary.Write(&b, binary.LittleEndian, int64(r.undoNextLSN))
+		}
+	case InsertRecord, DeleteRecord:
+		writeLogBytes(&b, []byte(r.file))
+		writeLogBytes(&b, r.tuple)
+	case EndCheckpointRecord:
+		binary.Write(&b, binary.LittleEndian, [2]int64{int64(r.checkpointLSN), int64(r.nextTID)})
+		binary.Write(&b, binary.LittleEndian, int32(len(r.activeTxns)))
+		for tid, txn := range r.activeTxns {
+			binary.Write(&b, binary.LittleEndian, [3]int64{int64(tid), int64(txn.firstLSN), int64(txn.lastLSN)})
+		}
+		binary.Write(&b, binary.LittleEndian, int32(len(r.dirtyPages)))
+		for page, recLSN := range r.dirtyPages {
+			writeLogBytes(&b, []byte(page.FileName))
+			binary.Write(&b, binary.LittleEndian, [2]int64{int64(page.PageNo), int64(recLSN)})
+		}
+	case BeginRecord, CommitRecord, AbortRecord, EndRecord, BeginCheckpointRecord:
+	default:
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("unknown log record type %d", r.recType)}
+	}
+	return b.Bytes(), nil
+}
+
+func decodeLogRecord(payload []byte) (*LogRecord, error) {
+	b := bytes.NewBuffer(payload)
+	t, err := b.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	var hdr struct {
+		Tid     int64
+		PrevLSN int64
+	}
+	if err := binary.Read(b, binary.LittleEndian, &hdr); err != nil {
+		return nil, err
+	}
+	r := &LogRecord{recType: LogRecordType(t), tid: TransactionID(hdr.Tid), prevLSN: LSN(hdr.PrevLSN)}
+	switch r.recType {
+	case UpdateRecord, CLRRecord:
+		file, err := readLogBytes(b)
+		if err != nil {
+			return nil, err
+		}
+		r.file = string(file)
+		var pageNo int64
+		if err := binary.Read(b, binary.LittleEndian, &pageNo); err != nil {
+			return nil, err
+		}
+		r.pageNo = int(pageNo)
+		if r.before, err = readLogBytes(b); err != nil {
+			return nil, err
+		}
+		if r.after, err = readLogBytes(b); err != nil {
+			return nil, err
+		}
+		if r.recType == CLRRecord {
+			var undoNext int64
+			if err := binary.Read(b, binary.LittleEndian, &undoNext); err != nil {
+				return nil, err
+			}
+			r.undoNextLSN = LSN(undoNext)
+		}
+	case InsertRecord, DeleteRecord:
+		file, err := readLogBytes(b)
+		if err != nil {
+			return nil, err
+		}
+		r.file = string(file)
+		if r.tuple, err = readLogBytes(b); err != nil {
+			return nil, err
+		}
+	case EndCheckpointRecord:
+		var ckpt [2]int64
+		if err := binary.Read(b, binary.LittleEndian, &ckpt); err != nil {
+			return nil, err
+		}
+		r.checkpointLSN = LSN(ckpt[0])
+		r.nextTID = TransactionID(ckpt[1])
+		var n int32
+		if err := binary.Read(b, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+		r.activeTxns = make(map[TransactionID]checkpointTxn)
+		for i := int32(0); i < n; i++ {
+			var txn [3]int64
+			if err := binary.Read(b, binary.LittleEndian, &txn); err != nil {
+				return nil, err
+			}
+			r.activeTxns[TransactionID(txn[0])] = checkpointTxn{LSN(txn[1]), LSN(txn[2])}
+		}
+		if err := binary.Read(b, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+		r.dirtyPages = make(map[heapHash]LSN)
+		for i := int32(0); i < n; i++ {
+			file, err := readLogBytes(b)
+			if err != nil {
+				return nil, err
+			}
+			var page [2]int64
+			if err := binary.Read(b, binary.LittleEndian, &page); err != nil {
+				return nil, err
+			}
+			r.dirtyPages[heapHash{FileName: string(file), PageNo: int(page[0])}] = LSN(page[1])
+		}
+	case BeginRecord, CommitRecord, AbortRecord, EndRecord, BeginCheckpointRecord:
+	default:
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("unknown log record type %d", t)}
+	}
+	if b.Len() != 0 {
+		return nil, GoDBError{MalformedDataError, "trailing bytes in log record"}
+	}
+	return r, nil
+}
@@ -0,0 +1,184 @@
+package godb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func makeTestLogFile(t *testing.T) (*LogFile, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), LogFileName)
+	lf, err := NewLogFile(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return lf, path
+}
+
+func testPageImage(b byte) []byte {
+	return bytes.Repeat([]byte{b}, PageSize)
+}
+
+// Read all of the records in the log.
+func readLogRecords(t *testing.T, lf *LogFile) []*LogRecord {
+	t.Helper()
+	iter, err := lf.Iterator()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var recs []*LogRecord
+	for rec, err := iter(); rec != nil || err != nil; rec, err = iter() {
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+// Simulate a crash: records that were not forced are lost.
+func crashLogFile(lf *LogFile) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	lf.tail.Reset()
+	lf.file.Close()
+}
+
+func TestLogFileReadBack(t *testing.T) {
+	lf, path := makeTestLogFile(t)
+	tid := NewTID()
+
+	lf.logBegin(tid)
+	lf.logUpdate(tid, "t.dat", 3, nil, testPageImage(1))
+	lf.logUpdate(tid, "t.dat", 3, testPageImage(1), testPageImage(2))
+	lf.logInsert(tid, "t.dat", []byte("inserted"))
+	lf.logDelete(tid, "t.dat", []byte("deleted"))
+	if err := lf.logCommit(tid); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := lf.Close(); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	lf, err := NewLogFile(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer lf.Close()
+	recs := readLogRecords(t, lf)
+	expected := []LogRecordType{BeginRecord, UpdateRecord, UpdateRecord, InsertRecord, DeleteRecord, CommitRecord, EndRecord}
+	if len(recs) != len(expected) {
+		t.Fatalf("expected %d records, got %d", len(expected), len(recs))
+	}
+	prev := InvalidLSN
+	for i, rec := range recs {
+		if rec.recType != expected[i] {
+			t.Errorf("record %d: expected %v, got %v", i, expected[i], rec.recType)
+		}
+		if rec.tid != tid {
+			t.Errorf("record %d: expected tid %d, got %d", i, tid, rec.tid)
+		}
+		if rec.prevLSN != prev {
+			t.Errorf("record %d: expected prevLSN %d, got %d", i, prev, rec.prevLSN)
+		}
+		prev = rec.lsn
+	}
+	if recs[1].before != nil || !bytes.Equal(recs[1].after, testPageImage(1)) || recs[1].pageNo != 3 || recs[1].file != "t.dat" {
+		t.Errorf("first update record not read back correctly: %v", recs[1])
+	}
+	if !bytes.Equal(recs[2].before, testPageImage(1)) || !bytes.Equal(recs[2].after, testPageImage(2)) {
+		t.Errorf("second update record not read back correctly: %v", recs[2])
+	}
+	if string(recs[3].tuple) != "inserted" || string(recs[4].tuple) != "deleted" {
+		t.Errorf("insert/delete records not read back correctly")
+	}
+
+	rec, err := lf.readRecord(recs[2].lsn)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if rec.recType != UpdateRecord || rec.prevLSN != recs[1].lsn {
+		t.Errorf("readRecord returned the wrong record: %v", rec)
+	}
+}
+
+func TestLogFileUnforcedRecordsLost(t *testing.T) {
+	lf, path := makeTestLogFile(t)
+	tid1 := NewTID()
+	tid2 := NewTID()
+	lf.logBegin(tid1)
+	if err := lf.logCommit(tid1); err != nil {
+		t.Fatalf(err.Error())
+	}
+	lf.logBegin(tid2)
+	lf.logUpdate(tid2, "t.dat", 0, nil, testPageImage(1))
+	crashLogFile(lf)
+
+	lf, err := NewLogFile(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer lf.Close()
+	// the end record of tid1 and the records of tid2 were never forced
+	recs := readLogRecords(t, lf)
+	if len(recs) != 2 || recs[0].recType != BeginRecord || recs[1].recType != CommitRecord {
+		t.Errorf("expected only tid1's begin and commit records to survive, got %v", recs)
+	}
+}
+
+func TestLogFileTornTail(t *testing.T) {
+	lf, path := makeTestLogFile(t)
+	tid := NewTID()
+	lf.logBegin(tid)
+	for i := 0; i < 3; i++ {
+		lf.logUpdate(tid, "t.dat", i, testPageImage(byte(i)), testPageImage(byte(i+1)))
+	}
+	if err := lf.logCommit(tid); err != nil {
+		t.Fatalf(err.Error())
+	}
+	recs := readLogRecords(t, lf)
+	lf.Close()
+
+	full, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// ends[i] is the file size once record i is completely written
+	var ends []int64
+	for i := 1; i < len(recs); i++ {
+		ends = append(ends, logHeaderSize+int64(recs[i].lsn))
+	}
+
+	tornPath := filepath.Join(t.TempDir(), "torn.log")
+	for size := logHeaderSize; size < int64(len(full)); size += 97 {
+		if err := os.WriteFile(tornPath, full[:size], 0644); err != nil {
+			t.Fatalf(err.Error())
+		}
+		torn, err := NewLogFile(tornPath)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		complete := 0
+		for _, end := range ends {
+			if end <= size {
+				complete++
+			}
+		}
+		if got := len(readLogRecords(t, torn)); got != complete {
+			t.Errorf("size %d: expected %d complete records, got %d", size, complete, got)
+		}
+
+		// the torn record must have been truncated away, so appending works
+		tid2 := NewTID()
+		torn.logBegin(tid2)
+		if err := torn.logCommit(tid2); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if got := len(readLogRecords(t, torn)); got != complete+3 {
+			t.Errorf("size %d: expected %d records after appending, got %d", size, complete+3, got)
+		}
+		torn.Close()
+	}
+}
@@ -0,0 +1,392 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+/*
+GoDB optionally supports multi-version concurrency control (MVCC), enabled by
+creating the BufferPool with [NewBufferPoolWithOptions] and MVCC set. With
+MVCC, readers see a consistent snapshot of the database without taking read
+locks, so long running SELECTs neither block nor are blocked by writers.
+
+In an MVCC database every tuple stored in a heap page carries a version header
+(see [tupleVersion]) holding the id of the transaction that created it (xmin)
+and the id of the transaction that deleted it (xmax, InvalidTID while it has
+not been deleted). Deleting a tuple only sets its xmax; the tuple stays on its
+page until it is dead, i.e., no running or future transaction can see it, at
+which point [HeapFile.Vacuum] removes it.
+
+Which versions a transaction sees is decided by its [Snapshot], taken when the
+transaction begins (or, depending on the transaction's [IsolationLevel], again
+during the transaction). The [VersionManager] numbers commits with a commit sequence
+number (CSN); a snapshot sees the changes of its own transaction and of every
+transaction that committed before it was taken, and nothing else.
+
+Transactions still take write locks, so two transactions never modify the same
+page at the same time. A transaction that tries to delete a version that was
+deleted by a transaction its snapshot does not see gets a
+SerializationFailureError and should abort (first updater wins).
+*/
+
+// A transaction id that is never assigned by [NewTID]; used as the xmax of
+// versions that have not been deleted.
+const InvalidTID TransactionID = -1
+
+// The version header stored with each tuple of an MVCC heap page.
+type tupleVersion struct {
+	xmin TransactionID // transaction that created the version
+	xmax TransactionID // transaction that deleted the version, or InvalidTID
+}
+
+// Size in bytes of a serialized tupleVersion.
+const tupleVersionSize int = 16
+
+// Write the version header to b, in little endian order.
+func (v tupleVersion) writeTo(b *bytes.Buffer) error {
+	return binary.Write(b, binary.LittleEndian, [2]int64{int64(v.xmin), int64(v.xmax)})
+}
+
+// Read a version header written by [tupleVersion.writeTo] from b.
+func readTupleVersion(b *bytes.Buffer) (tupleVersion, error) {
+	var v [2]int64
+	if err := binary.Read(b, binary.LittleEndian, &v); err != nil {
+		return tupleVersion{}, err
+	}
+	return tupleVersion{TransactionID(v[0]), TransactionID(v[1])}, nil
+}
+
+// A commit sequence number. CSNs are assigned in commit order, starting from 1;
+// transactions that committed before the VersionManager was created (e.g., in
+// an earlier run of the database) are treated as having CSN 0.
+type commitSeq int64
+
+// The set of versions visible to a transaction.
+type Snapshot struct {
+	tid   TransactionID
+	csn   commitSeq // the CSN of the last commit visible to the snapshot
+	began int64     // the value of VersionManager.seq when tid began
+	vm    *VersionManager
+}
+
+// An aborted transaction the VersionManager still tracks.
+type abortedTxn struct {
+	seq   int64           // the value of VersionManager.seq when it aborted
+	files map[DBFile]bool // files that may still hold versions it created or deleted
+}
+
+// A run of [HeapFile.Vacuum] by a transaction that has not ended yet: the
+// file it vacuumed, and the transactions that had aborted when it started.
+type vacuumPass struct {
+	file    DBFile
+	aborted []TransactionID
+}
+
+// VersionManager tracks the running, committed and aborted transactions of an
+// MVCC database and hands out snapshots.
+type VersionManager struct {
+	mu      sync.Mutex
+	lastCSN commitSeq
+	seq     int64 // counts begins and aborts, to tell which came first
+
+	running   map[TransactionID]*Snapshot
+	committed map[TransactionID]commitSeq // transactions some snapshot may not see yet
+	aborted   map[TransactionID]*abortedTxn
+	vacuums   map[TransactionID][]vacuumPass // vacuums done by running transactions
+}
+
+// Create a new VersionManager with no running transactions.
+func NewVersionManager() *VersionManager {
+	return &VersionManager{running: make(map[TransactionID]*Snapshot), committed: make(map[TransactionID]commitSeq),
+		aborted: make(map[TransactionID]*abortedTxn), vacuums: make(map[TransactionID][]vacuumPass)}
+}
+
+// Register tid as running and take its snapshot. Returns an error if tid is
+// already running or is known to have ended.
+func (vm *VersionManager) begin(tid TransactionID) (*Snapshot, error) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	_, committed := vm.committed[tid]
+	if vm.running[tid] != nil || committed || vm.aborted[tid] != nil {
+		return nil, GoDBError{IllegalTransactionError, fmt.Sprintf("transaction %d has already begun", tid)}
+	}
+	vm.seq++
+	s := &Snapshot{tid: tid, csn: vm.lastCSN, began: vm.seq, vm: vm}
+	vm.running[tid] = s
+	return s, nil
+}
+
+// Return the snapshot of tid, or nil if tid is not running.
+func (vm *VersionManager) snapshot(tid TransactionID) *Snapshot {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	return vm.running[tid]
+}
+
+// Replace the snapshot of tid with one that sees every transaction that has
+// committed so far. Used by isolation levels weaker than snapshot isolation
+// (see [IsolationLevel]). Does nothing if tid is not running.
+func (vm *VersionManager) refresh(tid TransactionID) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	if s := vm.running[tid]; s != nil {
+		s.csn = vm.lastCSN
+	}
+}
+
+// Mark tid as committed, making its changes visible to snapshots taken from
+// now on. The files tid vacuumed (see [VersionManager.vacuumed]) no longer
+// hold versions of the transactions that had aborted when it vacuumed them.
+func (vm *VersionManager) commit(tid TransactionID) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	if vm.running[tid] == nil {
+		return
+	}
+	delete(vm.running, tid)
+	vm.lastCSN++
+	vm.committed[tid] = vm.lastCSN
+	for _, pass := range vm.vacuums[tid] {
+		for _, aborted := range pass.aborted {
+			if t := vm.aborted[aborted]; t != nil {
+				delete(t.files, pass.file)
+			}
+		}
+	}
+	delete(vm.vacuums, tid)
+	vm.pruneLocked()
+}
+
+// Mark tid as aborted. Versions created by tid are never visible, and versions
+// it deleted remain visible. files are the files whose pages tid dirtied, the
+// only ones that may hold versions it created or deleted; tid is tracked until
+// each of them has been vacuumed.
+func (vm *VersionManager) abort(tid TransactionID, files ...DBFile) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	if vm.running[tid] == nil {
+		return
+	}
+	delete(vm.running, tid)
+	delete(vm.vacuums, tid)
+	vm.seq++
+	t := &abortedTxn{seq: vm.seq, files: make(map[DBFile]bool)}
+	for _, f := range files {
+		t.files[f] = true
+	}
+	vm.aborted[tid] = t
+	vm.pruneLocked()
+}
+
+// Return the transactions that have aborted and whose versions f may still
+// hold.
+func (vm *VersionManager) abortedIn(f DBFile) []TransactionID {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	var tids []TransactionID
+	for tid, t := range vm.aborted {
+		if t.files[f] {
+			tids = append(tids, tid)
+		}
+	}
+	return tids
+}
+
+// Record that tid vacuumed f, removing the versions of the aborted
+// transactions returned by [VersionManager.abortedIn] before it started. This
+// only takes effect if tid commits.
+func (vm *VersionManager) vacuumed(tid TransactionID, f DBFile, aborted []TransactionID) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	if vm.running[tid] != nil && len(aborted) > 0 {
+		vm.vacuums[tid] = append(vm.vacuums[tid], vacuumPass{f, aborted})
+	}
+}
+
+// Return the CSN of the oldest running snapshot; every transaction that
+// committed with a CSN no larger than this is visible to all running and
+// future snapshots. Must be called with vm.mu held.
+func (vm *VersionManager) horizonLocked() commitSeq {
+	horizon := vm.lastCSN
+	for _, s := range vm.running {
+		if s.csn < horizon {
+			horizon = s.csn
+		}
+	}
+	return horizon
+}
+
+// Forget transactions that every running and future snapshot sees; they are
+// then treated like transactions that committed before the VersionManager was
+// created. Also forget aborted transactions whose versions have been vacuumed
+// from every file, once every snapshot that may still be reading a page
+// holding them has ended. Must be called with vm.mu held.
+func (vm *VersionManager) pruneLocked() {
+	horizon := vm.horizonLocked()
+	for tid, csn := range vm.committed {
+		if csn <= horizon {
+			delete(vm.committed, tid)
+		}
+	}
+	oldest := vm.seq + 1
+	for _, s := range vm.running {
+		oldest = min(oldest, s.began)
+	}
+	for tid, t := range vm.aborted {
+		if len(t.files) == 0 && t.seq < oldest {
+			delete(vm.aborted, tid)
+		}
+	}
+}
+
+// Return true if tid committed no later than the commit with the specified
+// CSN.
+func (vm *VersionManager) committedBy(tid TransactionID, csn commitSeq) bool {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	if vm.running[tid] != nil || vm.aborted[tid] != nil {
+		return false
+	}
+	commitCSN, ok := vm.committed[tid]
+	return !ok || commitCSN <= csn
+}
+
+// Return true if the changes made by tid are visible to the snapshot.
+func (s *Snapshot) sees(tid TransactionID) bool {
+	return tid == s.tid || s.vm.committedBy(tid, s.csn)
+}
+
+// Return true if the version is visible to the snapshot: it was created by a
+// transaction the snapshot sees, and not deleted by one.
+func (s *Snapshot) isVisible(v tupleVersion) bool {
+	if !s.sees(v.xmin) {
+		return false
+	}
+	return v.xmax == InvalidTID || !s.sees(v.xmax)
+}
+
+// Check that the snapshot's transaction may delete the version, which must be
+// visible to the snapshot. Returns a SerializationFailureError if the version
+// was deleted by a transaction that is running or committed after the snapshot
+// was taken.
+func (s *Snapshot) checkDelete(v tupleVersion) error {
+	if v.xmax == InvalidTID || v.xmax == s.tid {
+		return nil
+	}
+	s.vm.mu.Lock()
+	aborted := s.vm.aborted[v.xmax] != nil
+	s.vm.mu.Unlock()
+	if aborted {
+		return nil
+	}
+	return GoDBError{SerializationFailureError,
+		fmt.Sprintf("tuple was concurrently deleted by transaction %d", v.xmax)}
+}
+
+// Return true if no running or future transaction can see the version: it was
+// created by a transaction that aborted, or deleted by a transaction that
+// every running snapshot sees.
+func (vm *VersionManager) isDead(v tupleVersion) bool {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	if vm.aborted[v.xmin] != nil {
+		return true
+	}
+	if v.xmax == InvalidTID || vm.running[v.xmax] != nil || vm.aborted[v.xmax] != nil {
+		return false
+	}
+	csn, ok := vm.committed[v.xmax]
+	return !ok || csn <= vm.horizonLocked()
+}
+
+// Return true if the version is or may become part of the committed state of
+// the database, as seen by tid: it was not created by a transaction that
+// aborted, and not deleted by tid or by a transaction that committed.
+func (vm *VersionManager) mayBeLive(v tupleVersion, tid TransactionID) bool {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	if vm.aborted[v.xmin] != nil {
+		return false
+	}
+	if v.xmax == InvalidTID || vm.aborted[v.xmax] != nil {
+		return true
+	}
+	return v.xmax != tid && vm.running[v.xmax] != nil
+}
+
+// Garbage collect the file: remove every tuple version that is dead (see
+// [VersionManager.isDead]) from its page and its indexes, freeing the overflow
+// chains of its strings stored out of line, and return the number of versions
+// removed. Versions deleted by a transaction that aborted are marked as not
+// deleted, so that once tid commits the file no longer refers to the
+// transactions that had aborted when it started. Pages, and the tuples it
+// changes if the BufferPool uses row locking, are locked for writing on behalf
+// of tid, and the room of the pages is recorded in the free space map of the
+// file. Does nothing if the BufferPool of the file does not use MVCC.
+func (f *HeapFile) Vacuum(tid TransactionID) (int, error) {
+	vm := f.bufPool.versions
+	if vm == nil {
+		return 0, nil
+	}
+	aborted := vm.abortedIn(f)
+	undeleted := make(map[TransactionID]bool)
+	for _, t := range aborted {
+		undeleted[t] = true
+	}
+	removed := 0
+	for pageNo := 0; pageNo < f.NumPages(); pageNo++ {
+		pg, err := f.bufPool.GetPage(f, pageNo, tid, WritePerm)
+		if err != nil {
+			return removed, err
+		}
+		hp := pg.(*heapPage)
+		var dead []recordID
+		iter := hp.tupleIter()
+		for t, err := iter(); t != nil || err != nil; t, err = iter() {
+			if err != nil {
+				return removed, err
+			}
+			v := hp.versionOf(t.Rid)
+			if !vm.isDead(v) && !undeleted[v.xmax] {
+				continue
+			}
+			if err := f.bufPool.lockTuple(f, t.Rid, tid, WritePerm, LockWait); err != nil {
+				return removed, err
+			}
+			// the tuple may have been deleted while it was being locked
+			v = hp.versionOf(t.Rid)
+			if vm.isDead(v) {
+				if err := f.deleteIndexEntries(t, tid); err != nil {
+					return removed, err
+				}
+				if err := f.freeToastedValues(t, tid); err != nil {
+					return removed, err
+				}
+				dead = append(dead, t.Rid)
+			} else if undeleted[v.xmax] {
+				rid := t.Rid
+				_, err := f.changeSlot(hp, rid, tid, func() (recordID, error) {
+					return rid, hp.setVersion(rid, tupleVersion{v.xmin, InvalidTID})
+				})
+				if err != nil {
+					return removed, err
+				}
+			}
+		}
+		for _, rid := range dead {
+			_, err := f.changeSlot(hp, rid, tid, func() (recordID, error) {
+				return rid, hp.deleteTuple(rid)
+			})
+			if err != nil {
+				return removed, err
+			}
+		}
+		removed += len(dead)
+		f.noteFreeSpace(pageNo, hp)
+	}
+	vm.vacuumed(tid, f, aborted)
+	return removed, nil
+}
@@ -0,0 +1,205 @@
+package godb
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func beginSnapshot(t *testing.T, vm *VersionManager) (TransactionID, *Snapshot) {
+	t.Helper()
+	tid := NewTID()
+	s, err := vm.begin(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return tid, s
+}
+
+func TestMVCCSnapshotVisibility(t *testing.T) {
+	vm := NewVersionManager()
+	old := tupleVersion{NewTID(), InvalidTID} // created before vm existed
+
+	t1, s1 := beginSnapshot(t, vm)
+	v1 := tupleVersion{t1, InvalidTID}
+	t2, s2 := beginSnapshot(t, vm)
+	if !s1.isVisible(v1) {
+		t.Errorf("a transaction should see its own insert")
+	}
+	if s2.isVisible(v1) {
+		t.Errorf("a running transaction's insert should not be visible")
+	}
+	vm.commit(t1)
+	if s2.isVisible(v1) {
+		t.Errorf("an insert committed after the snapshot was taken should not be visible")
+	}
+	t3, s3 := beginSnapshot(t, vm)
+	if !s3.isVisible(v1) || !s3.isVisible(old) || !s2.isVisible(old) {
+		t.Errorf("committed inserts should be visible to later snapshots")
+	}
+
+	// t3 deletes v1
+	if err := s3.checkDelete(v1); err != nil {
+		t.Fatalf(err.Error())
+	}
+	v1.xmax = t3
+	if s3.isVisible(v1) {
+		t.Errorf("a transaction should not see a version it deleted")
+	}
+	_, s4 := beginSnapshot(t, vm)
+	vm.commit(t3)
+	if !s4.isVisible(v1) {
+		t.Errorf("a delete committed after the snapshot was taken should not be visible")
+	}
+	_, s5 := beginSnapshot(t, vm)
+	if s5.isVisible(v1) {
+		t.Errorf("a committed delete should be visible to later snapshots")
+	}
+
+	// s4 still sees v1, but cannot delete it again
+	err := s4.checkDelete(v1)
+	var gerr GoDBError
+	if !errors.As(err, &gerr) || gerr.code != SerializationFailureError {
+		t.Errorf("expected a SerializationFailureError deleting a concurrently deleted version, got %v", err)
+	}
+	vm.commit(t2)
+}
+
+func TestMVCCAbort(t *testing.T) {
+	vm := NewVersionManager()
+	t1, _ := beginSnapshot(t, vm)
+	inserted := tupleVersion{t1, InvalidTID}
+	deleted := tupleVersion{NewTID(), t1}
+	vm.abort(t1, &HeapFile{})
+
+	_, s := beginSnapshot(t, vm)
+	if s.isVisible(inserted) {
+		t.Errorf("versions created by an aborted transaction should not be visible")
+	}
+	if !s.isVisible(deleted) {
+		t.Errorf("versions deleted by an aborted transaction should be visible")
+	}
+	if err := s.checkDelete(deleted); err != nil {
+		t.Errorf("versions deleted by an aborted transaction may be deleted again, got %v", err)
+	}
+	if !vm.isDead(inserted) || vm.isDead(deleted) {
+		t.Errorf("only the version created by the aborted transaction should be dead")
+	}
+}
+
+func TestMVCCDeadVersions(t *testing.T) {
+	vm := NewVersionManager()
+	reader, _ := beginSnapshot(t, vm)
+	deleter, _ := beginSnapshot(t, vm)
+	v := tupleVersion{NewTID(), deleter}
+	if vm.isDead(v) {
+		t.Errorf("a version deleted by a running transaction is not dead")
+	}
+	vm.commit(deleter)
+	if vm.isDead(v) {
+		t.Errorf("a version visible to a running snapshot is not dead")
+	}
+	later, _ := beginSnapshot(t, vm)
+	vm.commit(reader)
+	if !vm.isDead(v) {
+		t.Errorf("a version deleted before every running snapshot was taken is dead")
+	}
+	if vm.isDead(tupleVersion{later, InvalidTID}) {
+		t.Errorf("a version that has not been deleted is not dead")
+	}
+	vm.commit(later)
+	if len(vm.committed) != 0 {
+		t.Errorf("expected committed transactions to be forgotten once every snapshot sees them, have %d", len(vm.committed))
+	}
+	if !vm.isDead(v) {
+		t.Errorf("a version stays dead once its deleter has been forgotten")
+	}
+}
+
+func TestMVCCBeginTwice(t *testing.T) {
+	vm := NewVersionManager()
+	tid, _ := beginSnapshot(t, vm)
+	if _, err := vm.begin(tid); err == nil {
+		t.Errorf("expected an error beginning a running transaction")
+	}
+	vm.abort(tid, &HeapFile{})
+	if _, err := vm.begin(tid); err == nil {
+		t.Errorf("expected an error beginning an aborted transaction")
+	}
+}
+
+func TestMVCCPruneAborted(t *testing.T) {
+	vm := NewVersionManager()
+	f1, f2 := &HeapFile{}, &HeapFile{}
+	reader, _ := beginSnapshot(t, vm)
+	writer, _ := beginSnapshot(t, vm)
+	inserted := tupleVersion{writer, InvalidTID}
+	vm.abort(writer, f1, f2)
+	idle, _ := beginSnapshot(t, vm)
+	vm.abort(idle)
+
+	// a vacuum only counts once it commits
+	vacuum, _ := beginSnapshot(t, vm)
+	vm.vacuumed(vacuum, f1, vm.abortedIn(f1))
+	vm.abort(vacuum)
+	if aborted := vm.abortedIn(f1); len(aborted) != 1 || aborted[0] != writer {
+		t.Fatalf("expected %d to still be in the file, got %v", writer, aborted)
+	}
+	for _, f := range []*HeapFile{f1, f2} {
+		vacuum, _ := beginSnapshot(t, vm)
+		vm.vacuumed(vacuum, f, vm.abortedIn(f))
+		vm.commit(vacuum)
+	}
+	if len(vm.abortedIn(f1)) != 0 || len(vm.abortedIn(f2)) != 0 {
+		t.Errorf("expected vacuumed files not to hold versions of aborted transactions")
+	}
+
+	// reader may still be reading a page it got before the abort
+	if !vm.isDead(inserted) {
+		t.Errorf("expected the aborted transaction to be remembered while an older snapshot runs")
+	}
+	vm.commit(reader)
+	if len(vm.aborted) != 0 {
+		t.Errorf("expected aborted transactions to be forgotten once vacuumed, have %d", len(vm.aborted))
+	}
+}
+
+func TestMVCCTupleVersionSerialization(t *testing.T) {
+	v := tupleVersion{12, InvalidTID}
+	var b bytes.Buffer
+	if err := v.writeTo(&b); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if b.Len() != tupleVersionSize {
+		t.Errorf("expected %d bytes, got %d", tupleVersionSize, b.Len())
+	}
+	v2, err := readTupleVersion(&b)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if v2 != v {
+		t.Errorf("expected %v, got %v", v, v2)
+	}
+}
+
+// Transaction ids are stored in version headers, so they must not be reused
+// after a restart.
+func TestMVCCRecoveryAdvancesTIDs(t *testing.T) {
+	lf, path := makeTestLogFile(t)
+	tid := peekNextTID() + 1000
+	lf.logBegin(tid)
+	lf.logCommit(tid)
+	lf.Close()
+
+	lf, err := NewLogFile(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer lf.Close()
+	if err := lf.Recover(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if next := NewTID(); next <= tid {
+		t.Errorf("expected NewTID to return an id above %d after recovery, got %d", tid, next)
+	}
+}
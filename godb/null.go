@@ -0,0 +1,108 @@
+package godb
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+A NULL is represented by a [NullField], whatever the type of its column. SQL
+uses three-valued logic: a comparison with NULL is neither true nor false but
+unknown (see [evalPredicate]), and a predicate only selects a tuple if it is
+true, so comparisons with NULL never select tuples, and NULL never joins with
+anything, not even NULL. Use IS NULL and IS NOT NULL ([OpIsNull] and
+[OpIsNotNull]) to test for NULLs.
+
+Columns are nullable unless declared NOT NULL, both in CREATE TABLE statements
+and in catalog files, e.g. "t(id int not null, name string)". Nullable columns
+require a null bitmap, which only the variable-length tuple encoding has (see
+[Tuple.writeVarlenTo]), so files with nullable columns are stored in slotted
+pages like files with variable-length columns (see [HeapFile.isVarlen]).
+
+Aggregates other than COUNT(*) ignore NULLs, and return NULL if there are no
+other values (COUNT returns 0). ORDER BY sorts NULLs after every other value,
+i.e., last in ascending order and first in descending order (see
+[compareNulls]). Functions return NULL if any of their arguments is NULL.
+*/
+
+// NULL field value
+type NullField struct{}
+
+// Comparisons with NULL are unknown, so the only predicate NULL satisfies is
+// IS NULL.
+func (n NullField) EvalPred(v DBValue, op BoolOp) bool {
+	return op == OpIsNull
+}
+
+func (n NullField) String() string {
+	return "NULL"
+}
+
+// Return true if v is NULL.
+func isNull(v DBValue) bool {
+	_, ok := v.(NullField)
+	return ok
+}
+
+// The truth values of SQL's three-valued logic.
+type truthValue int
+
+const (
+	truthFalse   truthValue = iota
+	truthTrue    truthValue = iota
+	truthUnknown truthValue = iota
+)
+
+// Evaluate the predicate v1 op v2 with three-valued logic: IS NULL and IS NOT
+// NULL are true or false, other predicates are unknown if either value is
+// NULL.
+func evalPredicate(v1 DBValue, v2 DBValue, op BoolOp) truthValue {
+	if op != OpIsNull && op != OpIsNotNull && (isNull(v1) || isNull(v2)) {
+		return truthUnknown
+	}
+	if v1.EvalPred(v2, op) {
+		return truthTrue
+	}
+	return truthFalse
+}
+
+// Return true if the predicate v1 op v2 is true, as opposed to false or
+// unknown; this is the test operators such as [Filter] apply.
+func evalPred(v1 DBValue, v2 DBValue, op BoolOp) bool {
+	return evalPredicate(v1, v2, op) == truthTrue
+}
+
+// Compare two values for sorting if either is NULL: NULL is greater than any
+// other value, and equal to NULL. Returns false if neither value is NULL.
+func compareNulls(v1 DBValue, v2 DBValue) (orderByState, bool) {
+	switch n1, n2 := isNull(v1), isNull(v2); {
+	case n1 && n2:
+		return OrderedEqual, true
+	case n1:
+		return OrderedGreaterThan, true
+	case n2:
+		return OrderedLessThan, true
+	}
+	return OrderedEqual, false
+}
+
+// Return true if field, a value of a column of type t in a CSV file, is NULL:
+// either \N, or empty if t is not StringType.
+func isNullCSVValue(field string, t DBType) bool {
+	trimmed := strings.TrimSpace(field)
+	return trimmed == `\N` || (trimmed == "" && t != StringType)
+}
+
+// Return an error if t has a NULL in a column that is not nullable.
+func (f *HeapFile) checkNotNull(t *Tuple) error {
+	for i, field := range t.Fields {
+		if isNull(field) && !f.isNullable(i) {
+			name := ""
+			if i < len(t.Desc.Fields) {
+				name = t.Desc.Fields[i].Fname
+			}
+			return GoDBError{TypeMismatchError, fmt.Sprintf("NULL in column %s, which is NOT NULL", name)}
+		}
+	}
+	return nil
+}
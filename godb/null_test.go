@@ -0,0 +1,183 @@
+package godb
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+func TestNullPredicates(t *testing.T) {
+	null := NullField{}
+	values := []DBValue{IntField{1}, StringField{"a"}, null}
+	for _, v := range values {
+		for _, op := range []BoolOp{OpEq, OpNeq, OpLt, OpGe, OpLike} {
+			if got := evalPredicate(v, null, op); got != truthUnknown {
+				t.Errorf("%v %s NULL: expected unknown, got %v", v, opToStr(op), got)
+			}
+			if got := evalPredicate(null, v, op); got != truthUnknown {
+				t.Errorf("NULL %s %v: expected unknown, got %v", opToStr(op), v, got)
+			}
+			if evalPred(v, null, op) || v.EvalPred(null, op) {
+				t.Errorf("%v %s NULL should not be true", v, opToStr(op))
+			}
+		}
+		if got := evalPred(v, null, OpIsNull); got != isNull(v) {
+			t.Errorf("%v IS NULL: expected %t", v, isNull(v))
+		}
+		if got := evalPred(v, null, OpIsNotNull); got == isNull(v) {
+			t.Errorf("%v IS NOT NULL: expected %t", v, !isNull(v))
+		}
+	}
+	if evalPredicate(IntField{1}, IntField{2}, OpEq) != truthFalse || evalPredicate(IntField{1}, IntField{1}, OpEq) != truthTrue {
+		t.Errorf("expected comparisons without NULLs to be true or false")
+	}
+}
+
+func TestNullTupleSerialization(t *testing.T) {
+	var fields []FieldType
+	var values []DBValue
+	for i := 0; i < 10; i++ {
+		if i%2 == 0 {
+			fields = append(fields, FieldType{"i", "", IntType})
+			values = append(values, IntField{int64(i)})
+		} else {
+			fields = append(fields, FieldType{"s", "", StringType})
+			values = append(values, StringField{"s"})
+		}
+		if i%3 == 0 {
+			values[i] = NullField{}
+		}
+	}
+	td := TupleDesc{fields}
+	tup := Tuple{Desc: td, Fields: values}
+	var b bytes.Buffer
+	if err := tup.writeVarlenTo(&b); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if expected := 2 + 3*8 + 3*2; b.Len() != expected {
+		t.Errorf("expected %d bytes with NULLs only in the bitmap, got %d", expected, b.Len())
+	}
+	t2, err := readVarlenTupleFrom(&b, &td)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for i := range values {
+		if t2.Fields[i] != values[i] {
+			t.Errorf("field %d: expected %v, got %v", i, values[i], t2.Fields[i])
+		}
+	}
+	if _, err := readVarlenTupleFrom(bytes.NewBuffer(nil), &td); err == nil {
+		t.Errorf("expected a missing null bitmap to be rejected")
+	}
+
+	other := Tuple{Desc: td, Fields: append([]DBValue(nil), values...)}
+	other.Fields[0] = IntField{0}
+	if tup.tupleKey() == other.tupleKey() {
+		t.Errorf("expected NULL and 0 to have different keys")
+	}
+}
+
+func TestNullOrdering(t *testing.T) {
+	cases := []struct {
+		v1, v2 DBValue
+		order  orderByState
+		ok     bool
+	}{
+		{NullField{}, IntField{1}, OrderedGreaterThan, true},
+		{StringField{"z"}, NullField{}, OrderedLessThan, true},
+		{NullField{}, NullField{}, OrderedEqual, true},
+		{IntField{1}, IntField{2}, OrderedEqual, false},
+	}
+	for _, c := range cases {
+		if order, ok := compareNulls(c.v1, c.v2); order != c.order || ok != c.ok {
+			t.Errorf("compareNulls(%v, %v): expected %v %t, got %v %t", c.v1, c.v2, c.order, c.ok, order, ok)
+		}
+	}
+}
+
+func TestNullColumns(t *testing.T) {
+	td := TupleDesc{[]FieldType{{"id", "", IntType}, {"age", "", IntType}}}
+	hf := &HeapFile{}
+	hf.setColumns([]columnDef{{}, {nullable: true}})
+	if !hf.isVarlen() {
+		t.Errorf("a file with nullable columns should use the variable-length encoding")
+	}
+	if err := hf.checkNotNull(&Tuple{Desc: td, Fields: []DBValue{IntField{1}, NullField{}}}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := hf.checkNotNull(&Tuple{Desc: td, Fields: []DBValue{NullField{}, IntField{1}}}); err == nil {
+		t.Errorf("expected a NULL in a NOT NULL column to be rejected")
+	}
+
+	nulls := map[string]bool{"": true, " ": true, `\N`: true, "0": false, "NULL": false}
+	for value, null := range nulls {
+		if isNullCSVValue(value, IntType) != null {
+			t.Errorf("%q in an int column: expected null to be %t", value, null)
+		}
+	}
+	if isNullCSVValue("", StringType) || !isNullCSVValue(`\N`, StringType) {
+		t.Errorf("expected only \\N to be NULL in a string column")
+	}
+}
+
+func TestNullExprs(t *testing.T) {
+	null := ConstExpr{NullField{}, UnknownType}
+	var one, nullExpr Expr = &ConstExpr{IntField{1}, IntType}, &null
+	sum := FuncExpr{"+", []*Expr{&one, &nullExpr}}
+	if v, err := sum.EvalExpr(nil); err != nil || !isNull(v) {
+		t.Errorf("expected 1 + NULL to be NULL, got %v (%v)", v, err)
+	}
+
+	count := &CountAggState{}
+	count.Init("count", &null)
+	countStar := &CountAggState{}
+	countStar.Init("count", nil)
+	for i := 0; i < 3; i++ {
+		count.AddTuple(&Tuple{})
+		countStar.AddTuple(&Tuple{})
+	}
+	if n := count.Finalize().Fields[0]; n != (IntField{0}) {
+		t.Errorf("expected COUNT(expr) to skip NULLs, got %v", n)
+	}
+	if n := countStar.Finalize().Fields[0]; n != (IntField{3}) {
+		t.Errorf("expected COUNT(*) to count NULLs, got %v", n)
+	}
+}
+
+func TestNullParse(t *testing.T) {
+	predicates := map[string]BoolOp{
+		"select * from t where a is null":     OpIsNull,
+		"select * from t where a is not null": OpIsNotNull,
+	}
+	for query, op := range predicates {
+		stmt, err := sqlparser.Parse(query)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		filters, _, err := parseWhere(nil, nil, nil, stmt.(*sqlparser.Select).Where.Expr)
+		if err != nil || len(filters) != 1 {
+			t.Fatalf("%q: expected a filter (%v)", query, err)
+		}
+		if f := filters[0]; f.predOp != op || f.fieldExpr.field != "a" || !isNull(f.constExpr.constVal) {
+			t.Errorf("%q: unexpected filter %+v", query, f)
+		}
+	}
+
+	stmt, err := sqlparser.Parse("insert into t values (1, null)")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	row := stmt.(*sqlparser.Insert).Rows.(sqlparser.Values)[0]
+	node, err := parseExpr(nil, row[1], "")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expr, _, err := node.generateExpr(nil, nil, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if v, _ := expr.EvalExpr(nil); !isNull(v) {
+		t.Errorf("expected a NULL constant, got %v", v)
+	}
+}
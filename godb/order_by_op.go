@@ -14,7 +14,9 @@ type OrderBy struct {
 // values for use in the Iterator() method. Here, orderByFields is a list of
 // expressions that can be extracted from the child operator's tuples, and the
 // ascending bitmap indicates whether the ith field in the orderByFields list
-// should be in ascending (true) or descending (false) order.
+// should be in ascending (true) or descending (false) order. NULLs sort after
+// every other value, i.e., last in ascending order and first in descending
+// order (see [Tuple.compareField]).
 func NewOrderBy(orderByFields []Expr, child Operator, ascending []bool) (*OrderBy, error) {
 	// TODO: some code goes here
 	return nil, fmt.Errorf("NewOrderBy not implemented.") //replace me
@@ -0,0 +1,283 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+Values too large to be stored in a heap page are stored out of line, in a chain
+of overflow pages, in the style of PostgreSQL's TOAST. Only variable-length
+files (see [HeapFile.isVarlen]) store values out of line.
+
+When a tuple is inserted, [HeapFile.toast] stores its largest strings out of
+line, largest first, until its record is at most toastThreshold bytes. Each
+such string is split into chunks of at most overflowChunkSize bytes, and each
+chunk is stored in an overflow page of the file (see [slottedPage]), as a
+single record made of the number of the page holding the next chunk, as a 32
+bit little endian integer (noOverflowPage for the last chunk), followed by the
+chunk. The tuple stores a [toastPointer] to the first page of the chain
+instead of the string.
+
+Overflow pages are appended to the file and read and written through the
+BufferPool like any other page of the file, so they are locked, logged and
+recovered like the pages holding tuples, but they hold no tuples:
+[heapPage.tupleIter] returns no tuples for them and [heapPage.insertTuple]
+never inserts into them. Chains are fetched lazily, when a tuple is returned
+by a scan of the file (see [HeapFile.detoast]), and freed when the tuple is
+removed from its page (see [HeapFile.freeToastedValues]), which turns their
+pages back into empty pages that later inserts can use.
+*/
+
+const (
+	toastThreshold    int = PageSize / 4 // largest record stored without moving values out of line
+	toastMinValueSize int = 32           // smallest string stored out of line
+	overflowChunkSize int = maxSlottedRecordSize - 4
+	noOverflowPage    int = -1
+)
+
+// A string stored out of line, in the chain of overflow pages starting at
+// firstPage. Tuples read from heap pages hold toastPointer fields until
+// [HeapFile.detoast] replaces them with the strings they point to.
+type toastPointer struct {
+	firstPage int
+	length    int // length of the string in bytes
+}
+
+// Pointers are never compared; tuples are detoasted before they are returned
+// to operators.
+func (p toastPointer) EvalPred(v DBValue, op BoolOp) bool {
+	return false
+}
+
+// Return the size of the record of t, as written by [Tuple.writeVarlenTo].
+func varlenSize(t *Tuple) (int, error) {
+	var b bytes.Buffer
+	if err := t.writeVarlenTo(&b); err != nil {
+		return 0, err
+	}
+	return b.Len(), nil
+}
+
+// Return a copy of t in which strings are stored out of line, on behalf of
+// tid, until its record is at most toastThreshold bytes, or t itself if it is
+// small enough. Strings of at most toastMinValueSize bytes are never stored
+// out of line. Called by [HeapFile.insertTuple] for variable-length files.
+func (f *HeapFile) toast(t *Tuple, tid TransactionID) (*Tuple, error) {
+	size, err := varlenSize(t)
+	if err != nil || size <= toastThreshold {
+		return t, err
+	}
+	var order []int
+	for i, field := range t.Fields {
+		if s, ok := field.(StringField); ok && len(s.Value) > toastMinValueSize {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return len(t.Fields[order[a]].(StringField).Value) > len(t.Fields[order[b]].(StringField).Value)
+	})
+	toasted := &Tuple{Desc: t.Desc, Fields: append([]DBValue(nil), t.Fields...), Rid: t.Rid}
+	for _, i := range order {
+		if size <= toastThreshold {
+			break
+		}
+		s := t.Fields[i].(StringField).Value
+		first, err := f.writeOverflowChain(s, tid)
+		if err != nil {
+			return nil, err
+		}
+		toasted.Fields[i] = toastPointer{firstPage: first, length: len(s)}
+		if size, err = varlenSize(toasted); err != nil {
+			return nil, err
+		}
+	}
+	return toasted, nil
+}
+
+// Return t with the strings it stores out of line fetched on behalf of tid,
+// or t itself if it has none. Called by [HeapFile.Iterator] before returning a
+// tuple of a variable-length file.
+func (f *HeapFile) detoast(t *Tuple, tid TransactionID) (*Tuple, error) {
+	var fields []DBValue
+	for i, field := range t.Fields {
+		p, ok := field.(toastPointer)
+		if !ok {
+			continue
+		}
+		if fields == nil {
+			fields = append([]DBValue(nil), t.Fields...)
+		}
+		s, err := f.readOverflowChain(p, tid)
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = StringField{s}
+	}
+	if fields == nil {
+		return t, nil
+	}
+	return &Tuple{Desc: t.Desc, Fields: fields, Rid: t.Rid}, nil
+}
+
+// Free the overflow chains of the strings t stores out of line, on behalf of
+// tid. t must be a tuple as read from its page, before [HeapFile.detoast].
+// Called when a tuple is removed from its page.
+func (f *HeapFile) freeToastedValues(t *Tuple, tid TransactionID) error {
+	for _, field := range t.Fields {
+		if p, ok := field.(toastPointer); ok {
+			if err := f.freeOverflowChain(p, tid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Append an empty page to the file on behalf of tid, returning its number.
+func (f *HeapFile) appendEmptyPage(tid TransactionID) (int, error) {
+	if err := f.bufPool.lockEndOfFile(f, tid, WritePerm); err != nil {
+		return 0, err
+	}
+	pageNo := f.NumPages()
+	hp, err := newHeapPage(f.Descriptor(), pageNo, f)
+	if err != nil {
+		return 0, err
+	}
+	if err := f.flushPage(hp); err != nil {
+		return 0, err
+	}
+	return pageNo, nil
+}
+
+// Return the overflow page pageNo of the file, read on behalf of tid with the
+// specified permission.
+func (f *HeapFile) getOverflowPage(pageNo int, tid TransactionID, perm RWPerm) (*heapPage, error) {
+	pg, err := f.bufPool.GetPage(f, pageNo, tid, perm)
+	if err != nil {
+		return nil, err
+	}
+	hp, ok := pg.(*heapPage)
+	if !ok || hp.slots == nil || !hp.slots.overflow {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("page %d of %s is not an overflow page", pageNo, f.BackingFile())}
+	}
+	return hp, nil
+}
+
+// Return the next page and the chunk of an overflow page.
+func overflowChunk(hp *heapPage) (int, []byte, error) {
+	rec, err := hp.slots.get(0)
+	if err != nil || len(rec) < 4 {
+		return 0, nil, GoDBError{MalformedDataError, "overflow page without a chunk"}
+	}
+	return int(int32(binary.LittleEndian.Uint32(rec))), rec[4:], nil
+}
+
+// Make hp an overflow page holding chunk, followed by the page next.
+func setOverflowChunk(hp *heapPage, next int, chunk []byte) error {
+	rec := make([]byte, 4+len(chunk))
+	binary.LittleEndian.PutUint32(rec, uint32(int32(next)))
+	copy(rec[4:], chunk)
+	slots := newOverflowPage()
+	if _, err := slots.insert(rec); err != nil {
+		return err
+	}
+	hp.slots = slots
+	hp.versions = nil
+	return nil
+}
+
+// Store s in a new chain of overflow pages on behalf of tid, returning the
+// number of its first page.
+func (f *HeapFile) writeOverflowChain(s string, tid TransactionID) (int, error) {
+	if s == "" {
+		return noOverflowPage, nil
+	}
+	n := (len(s) + overflowChunkSize - 1) / overflowChunkSize
+	pages := make([]int, n)
+	for i := range pages {
+		pageNo, err := f.appendEmptyPage(tid)
+		if err != nil {
+			return 0, err
+		}
+		pages[i] = pageNo
+		// the page is not for tuples, even before it holds its chunk
+		f.freeSpace.record(pageNo, 0)
+	}
+	for i, pageNo := range pages {
+		hp, err := f.bufPool.GetPage(f, pageNo, tid, WritePerm)
+		if err != nil {
+			return 0, err
+		}
+		next := noOverflowPage
+		if i+1 < n {
+			next = pages[i+1]
+		}
+		chunk := s[i*overflowChunkSize : min((i+1)*overflowChunkSize, len(s))]
+		err = f.changePage(hp.(*heapPage), pageNo, tid, func() error {
+			return setOverflowChunk(hp.(*heapPage), next, []byte(chunk))
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return pages[0], nil
+}
+
+// Return the string p points to, read on behalf of tid.
+func (f *HeapFile) readOverflowChain(p toastPointer, tid TransactionID) (string, error) {
+	defer f.bufPool.endRead(tid, f.bufPool.startRead(tid))
+	var b strings.Builder
+	b.Grow(p.length)
+	for pageNo := p.firstPage; pageNo != noOverflowPage; {
+		if b.Len() >= p.length {
+			return "", GoDBError{MalformedDataError, fmt.Sprintf("overflow chain at page %d is longer than %d bytes", p.firstPage, p.length)}
+		}
+		hp, err := f.getOverflowPage(pageNo, tid, ReadPerm)
+		if err != nil {
+			return "", err
+		}
+		next, chunk, err := overflowChunk(hp)
+		if err != nil {
+			return "", err
+		}
+		b.Write(chunk)
+		pageNo = next
+	}
+	if b.Len() != p.length {
+		return "", GoDBError{MalformedDataError, fmt.Sprintf("overflow chain at page %d holds %d bytes, expected %d", p.firstPage, b.Len(), p.length)}
+	}
+	return b.String(), nil
+}
+
+// Free the chain of overflow pages p points to on behalf of tid, turning its
+// pages into empty pages that inserts can use.
+func (f *HeapFile) freeOverflowChain(p toastPointer, tid TransactionID) error {
+	for pageNo, freed := p.firstPage, 0; pageNo != noOverflowPage; freed += overflowChunkSize {
+		if freed >= p.length {
+			return GoDBError{MalformedDataError, fmt.Sprintf("overflow chain at page %d is longer than %d bytes", p.firstPage, p.length)}
+		}
+		hp, err := f.getOverflowPage(pageNo, tid, WritePerm)
+		if err != nil {
+			return err
+		}
+		next, _, err := overflowChunk(hp)
+		if err != nil {
+			return err
+		}
+		err = f.changePage(hp, pageNo, tid, func() error {
+			hp.slots = newSlottedPageOfSize(f.pageSize())
+			hp.versions = nil
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		f.noteFreeSpace(pageNo, hp)
+		pageNo = next
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package godb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestToastPointerSerialization(t *testing.T) {
+	td := TupleDesc{[]FieldType{{"id", "", IntType}, {"body", "", StringType}, {"title", "", StringType}}}
+	tup := Tuple{Desc: td, Fields: []DBValue{IntField{7}, toastPointer{firstPage: 300, length: 100000}, StringField{"doc"}}}
+	var b bytes.Buffer
+	if err := tup.writeVarlenTo(&b); err != nil {
+		t.Fatalf(err.Error())
+	}
+	data := append([]byte{}, b.Bytes()...)
+	t2, err := readVarlenTupleFrom(&b, &td)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for i := range tup.Fields {
+		if t2.Fields[i] != tup.Fields[i] {
+			t.Errorf("field %d: expected %v, got %v", i, tup.Fields[i], t2.Fields[i])
+		}
+	}
+	if _, err := readVarlenTupleFrom(bytes.NewBuffer(data[:1+8+3]), &td); err == nil {
+		t.Errorf("expected a truncated pointer to be rejected")
+	}
+}
+
+func TestOverflowPage(t *testing.T) {
+	chunk := []byte(strings.Repeat("j", overflowChunkSize))
+	hp := &heapPage{}
+	if err := setOverflowChunk(hp, 12, chunk); err != nil {
+		t.Fatalf("a full chunk should fit in an overflow page: %v", err)
+	}
+	if err := setOverflowChunk(&heapPage{}, 12, append(chunk, 'j')); err == nil {
+		t.Errorf("expected a chunk larger than overflowChunkSize to be rejected")
+	}
+
+	var b bytes.Buffer
+	if err := hp.slots.writeTo(&b); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !isSlottedPage(b.Bytes()) {
+		t.Fatalf("expected an overflow page to be a slotted page")
+	}
+	slots, err := readSlottedPage(b.Bytes())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !slots.overflow {
+		t.Errorf("expected the page to be read back as an overflow page")
+	}
+	next, got, err := overflowChunk(&heapPage{slots: slots})
+	if err != nil || next != 12 || !bytes.Equal(got, chunk) {
+		t.Errorf("expected the chunk followed by page 12, got page %d (%v)", next, err)
+	}
+
+	if err := setOverflowChunk(hp, noOverflowPage, []byte("end")); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if next, got, _ := overflowChunk(hp); next != noOverflowPage || string(got) != "end" {
+		t.Errorf("expected the last chunk of a chain, got page %d and %q", next, got)
+	}
+}
+
+func TestToastSmallTuple(t *testing.T) {
+	td := TupleDesc{[]FieldType{{"id", "", IntType}, {"body", "", StringType}}}
+	tup := &Tuple{Desc: td, Fields: []DBValue{IntField{1}, StringField{strings.Repeat("s", toastThreshold-16)}}}
+	f := &HeapFile{}
+	toasted, err := f.toast(tup, NewTID())
+	if err != nil || toasted != tup {
+		t.Errorf("expected a tuple below the threshold to be stored inline (%v)", err)
+	}
+	detoasted, err := f.detoast(tup, NewTID())
+	if err != nil || detoasted != tup {
+		t.Errorf("expected a tuple without pointers to be returned as is (%v)", err)
+	}
+}
@@ -3,8 +3,12 @@ package godb
 import (
 	"fmt"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 	"unsafe"
 
 	"github.com/xwb1989/sqlparser"
@@ -40,6 +44,8 @@ type LogicalSelectNode struct {
 	value       string
 	args        []*LogicalSelectNode //for functions other than aggregates
 	cachedField *FieldType
+	constVal    DBValue //for constants whose type is not inferred from value, e.g. NULL or TRUE
+	castTo      *castTarget //for CAST expressions, which are functions named "cast" with one argument
 }
 
 func NewFieldSelectNode(table string, field string, alias string) LogicalSelectNode {
@@ -59,6 +65,16 @@ func NewConstSelectNode(value string, alias string) LogicalSelectNode {
 	return lsn
 }
 
+func NewTypedConstSelectNode(value DBValue, alias string) LogicalSelectNode {
+	lsn := NewConstSelectNode(fmt.Sprintf("%v", value), alias)
+	lsn.constVal = value
+	return lsn
+}
+
+func NewNullSelectNode(alias string) LogicalSelectNode {
+	return NewTypedConstSelectNode(NullField{}, alias)
+}
+
 func NewStarSelectNode(table string) LogicalSelectNode {
 	lsn := LogicalSelectNode{}
 	lsn.exprType = ExprStar
@@ -119,6 +135,10 @@ func (op BoolOp) String() string {
 		return "<"
 	case OpLike:
 		return " LIKE "
+	case OpIsNull:
+		return " IS "
+	case OpIsNotNull:
+		return " IS NOT "
 	default:
 		return "??"
 	}
@@ -248,6 +268,7 @@ type LogicalPlan struct {
 	limit         *LogicalSelectNode
 	distinct      bool
 	alias         string
+	lock          *scanLocking // locking of table scans, for SELECT ... FOR UPDATE
 }
 
 func (p *LogicalPlan) getSubplanFields(c *Catalog) []*FieldType {
@@ -298,6 +319,17 @@ func parseWhere(c *Catalog, subqueries []*LogicalPlan, ts []*LogicalTableNode, e
 			return []*LogicalFilterNode{{*left, *right, op}}, nil, nil
 		}
 
+	case *sqlparser.IsExpr:
+		op, ok := BoolOpMap[expr.Operator]
+		if !ok || (op != OpIsNull && op != OpIsNotNull) {
+			return nil, nil, GoDBError{ParseError, fmt.Sprintf("unsupported predicate %s", expr.Operator)}
+		}
+		left, err := parseExpr(c, expr.Expr, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		return []*LogicalFilterNode{{*left, NewNullSelectNode(""), op}}, nil, nil
+
 	default:
 		return nil, nil, GoDBError{ParseError, "where expression with non value or column on RHS (disjunctions and nested where expressions are not supported)"}
 	}
@@ -433,6 +465,18 @@ func parseExpr(c *Catalog, expr sqlparser.Expr, alias string) (*LogicalSelectNod
 		return &outer, nil
 	case *sqlparser.ParenExpr:
 		return parseExpr(c, expr.Expr, alias)
+	case *sqlparser.ConvertExpr:
+		arg, err := parseExpr(c, expr.Expr, "")
+		if err != nil {
+			return nil, err
+		}
+		to, err := parseCastType(expr.Type)
+		if err != nil {
+			return nil, err
+		}
+		cast := NewFuncSelectNode("cast", []*LogicalSelectNode{arg}, alias)
+		cast.castTo = &to
+		return &cast, nil
 	case *sqlparser.ColName:
 		field := NewFieldSelectNode(strings.ToLower(sqlparser.String(expr.Qualifier)), strings.ToLower(sqlparser.String(expr.Name)), alias)
 		if len(field.table) > 1 && (field.table[0] == '\'' || field.table[0] == '`') {
@@ -449,8 +493,39 @@ func parseExpr(c *Catalog, expr sqlparser.Expr, alias string) (*LogicalSelectNod
 			str = str[1 : len(str)-1]
 			//str = str[-1]
 		}
+		if expr.Type == sqlparser.FloatVal {
+			// as in MySQL, literals with an exponent are floats, and
+			// other literals with a decimal point are exact decimals
+			// unless they have too many digits
+			if !strings.ContainsAny(str, "eE") {
+				if d, err := parseDecimal(str); err == nil {
+					field := NewTypedConstSelectNode(d, alias)
+					return &field, nil
+				}
+			}
+			f, err := strconv.ParseFloat(str, 64)
+			if err != nil {
+				return nil, GoDBError{ParseError, fmt.Sprintf("invalid float %s", str)}
+			}
+			field := NewTypedConstSelectNode(FloatField{f}, alias)
+			return &field, nil
+		}
+		if expr.Type == sqlparser.StrVal {
+			// quoted literals are strings, even if they look like
+			// numbers, and are converted when compared with other
+			// types (see [coerceExprs])
+			field := NewTypedConstSelectNode(StringField{str}, alias)
+			field.value = str
+			return &field, nil
+		}
 		field := NewConstSelectNode(str, alias)
 		return &field, nil
+	case sqlparser.BoolVal:
+		field := NewTypedConstSelectNode(BoolField{bool(expr)}, alias)
+		return &field, nil
+	case *sqlparser.NullVal:
+		field := NewNullSelectNode(alias)
+		return &field, nil
 	default:
 		return nil, GoDBError{ParseError, fmt.Sprintf("unsupported expression type %s in select list", reflect.TypeOf(expr))}
 	}
@@ -564,7 +639,7 @@ func parseStatement(c *Catalog, s *sqlparser.Select) (*LogicalPlan, error) {
 		}
 	}
 
-	p := LogicalPlan{filters, joins, selects, aggs, tables, subplans, groupBys, orderBys, limExpr, s.Distinct != "", ""}
+	p := LogicalPlan{filters, joins, selects, aggs, tables, subplans, groupBys, orderBys, limExpr, s.Distinct != "", "", nil}
 
 	return &p, nil
 }
@@ -682,6 +757,13 @@ func (s *LogicalSelectNode) generateExpr(c *Catalog, inputDesc *TupleDesc, table
 		e := FieldExpr{field}
 		return &e, fieldName, nil
 	case ExprConst:
+		if s.constVal != nil {
+			fieldName := s.value
+			if s.alias != "" {
+				fieldName = s.alias
+			}
+			return &ConstExpr{s.constVal, typeOfValue(s.constVal)}, fieldName, nil
+		}
 		var fval DBValue
 		constType := StringType
 		intFval, e := strconv.Atoi(s.value)
@@ -710,6 +792,9 @@ func (s *LogicalSelectNode) generateExpr(c *Catalog, inputDesc *TupleDesc, table
 			}
 			exprs[i] = &newExpr
 		}
+		if s.castTo != nil {
+			return &CastExpr{*exprs[0], *s.castTo}, fieldName, nil
+		}
 
 		fe := FuncExpr{*s.funcOp, exprs}
 		return &fe, fieldName, nil
@@ -729,7 +814,12 @@ func exprToStr(e Expr) string {
 		}
 		return fmt.Sprintf("%s%s", tbl, ex.selectField.Fname)
 	case *ConstExpr:
-		return fmt.Sprintf("%v", ex.val)
+		if s, ok := ex.val.(StringField); ok {
+			return fmt.Sprintf("'%s'", s.Value)
+		}
+		return formatValue(ex.val)
+	case *CastExpr:
+		return fmt.Sprintf("cast(%s as %v)", exprToStr(ex.expr), ex.to)
 	case *FuncExpr:
 		argStr := ""
 		for _, arg := range ex.args {
@@ -776,6 +866,11 @@ func OutputPhysicalPlan(printf func(format string, a ...any), o Operator, indent
 		indent = indent + "\t"
 		OutputPhysicalPlan(printf, *op.left, indent)
 		OutputPhysicalPlan(printf, *op.right, indent)
+	case *IndexJoin:
+		printf("%sIndex Join, %s, card:%d\n", indent, op, oc.Cardinality)
+		indent = indent + "\t"
+		OutputPhysicalPlan(printf, op.outer, indent)
+		printf("%sIndex Lookup %s\n", indent, op.inner.BackingFile())
 	case *Project:
 		selectStr := ""
 		for _, ex := range op.selectFields {
@@ -793,6 +888,40 @@ func OutputPhysicalPlan(printf func(format string, a ...any), o Operator, indent
 	case *HeapFile:
 		printf("%sHeap Scan %s, card:%d\n", indent, op.BackingFile(), oc.Cardinality)
 
+	case *lockingScanOp:
+		printf("%sHeap Scan %s %s, card:%d\n", indent, op.file.BackingFile(), op.locking, oc.Cardinality)
+
+	case *ClusteredScan:
+		preds := ""
+		if len(op.preds) > 0 {
+			preds = " (" + op.predicateString() + ")"
+		}
+		printf("%sClustered Scan %s%s, card:%d\n", indent, op.file.BackingFile(), preds, oc.Cardinality)
+
+	case *ColumnarScan:
+		names := make([]string, len(op.columns))
+		for i, field := range op.Descriptor().Fields {
+			names[i] = field.Fname
+		}
+		preds := ""
+		if len(op.preds) > 0 {
+			preds = " (" + op.predicateString() + ")"
+		}
+		printf("%sColumnar Scan %s [%s]%s, card:%d\n", indent, op.file.BackingFile(), strings.Join(names, ", "), preds, oc.Cardinality)
+
+	case *ColumnarFile:
+		printf("%sColumnar Scan %s, card:%d\n", indent, op.BackingFile(), oc.Cardinality)
+
+	case *IndexOnlyScan:
+		preds := ""
+		if len(op.preds) > 0 {
+			preds = " (" + op.predicateString() + ")"
+		}
+		printf("%sIndex Only Scan %s using %s index %s%s, card:%d\n", indent, op.file.BackingFile(), op.index.method(), op.index.name, preds, oc.Cardinality)
+
+	case *IndexScan:
+		printf("%sIndex Scan %s using %s index %s (%s), card:%d\n", indent, op.file.BackingFile(), op.index.method(), op.index.name, op.predicateString(), oc.Cardinality)
+
 	case *OrderBy:
 		orderStr := ""
 		if len(op.orderBy) > 0 {
@@ -918,7 +1047,11 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
 		if stats != nil {
 			card = stats.EstimateCardinality(1.0)
 		}
-		tableMap[name] = &PlanNode{NewOperatorCard(*t.file, card), td}
+		var scan Operator = *t.file
+		if hf, ok := scan.(*HeapFile); ok && plan.lock != nil {
+			scan = &lockingScanOp{hf, *plan.lock}
+		}
+		tableMap[name] = &PlanNode{NewOperatorCard(scan, card), td}
 		sel[name] = 1.0
 	}
 
@@ -940,6 +1073,12 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
 		if err != nil {
 			return nil, err
 		}
+		if f.predOp != OpIsNull && f.predOp != OpIsNotNull {
+			leftExpr, rightExpr, err = coerceExprs(leftExpr, rightExpr)
+			if err != nil {
+				return nil, err
+			}
+		}
 
 		op := node.op
 		desc := *op.Descriptor()
@@ -952,7 +1091,8 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
 
 		filterSel := 1.0
 		constExpr, ok := rightExpr.(*ConstExpr)
-		if ok && table_stats != nil {
+		_, isField := leftExpr.(*FieldExpr) // statistics are of the values of fields, not of casts
+		if ok && isField && table_stats != nil {
 			filterSel, err = table_stats.EstimateSelectivity(field, f.predOp, constExpr.val)
 		}
 		if err != nil {
@@ -968,6 +1108,28 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
 		tableMap[table] = &PlanNode{NewOperatorCard(newOp, int(float64(op.Cardinality)*filterSel)), &desc}
 	}
 
+	// use an index to evaluate the filters of a table, or to read the columns
+	// the query needs, if one can, and read only those columns of columnar
+	// tables
+	columnsRead := plan.columnsRead(c)
+	for _, t := range plan.tables {
+		name := t.tableName
+		if t.alias != "" {
+			name = t.alias
+		}
+		var columns map[string]bool
+		if columnsRead != nil {
+			// unqualified columns are resolved to the name of their table
+			columns = make(map[string]bool)
+			for _, table := range []string{name, t.tableName} {
+				for col := range columnsRead[table] {
+					columns[col] = true
+				}
+			}
+		}
+		tableMap[name].op = useColumnarScan(useIndexScan(tableMap[name].op, columns), columns)
+	}
+
 	selects := make(map[TableAndField]*LogicalSelectNode)
 	join_order := make([]*JoinNode, len(plan.joins))
 	for i, j := range plan.joins {
@@ -1054,13 +1216,22 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
 		if err != nil {
 			return nil, err
 		}
-
-		newOp, err := NewJoin(op1, leftExpr, op2, rightExpr, JoinBufferSize)
+		leftExpr, rightExpr, err = coerceExprs(leftExpr, rightExpr)
 		if err != nil {
 			return nil, err
 		}
 
-		newNode := &PlanNode{NewOperatorCard(newOp, EstimateJoinCardinality(node1.op.Cardinality, node2.op.Cardinality)), newOp.Descriptor()}
+		var newNode *PlanNode
+		card := EstimateJoinCardinality(node1.op.Cardinality, node2.op.Cardinality)
+		if indexJoin := planIndexJoins(op1, leftExpr, op2, rightExpr, card); indexJoin != nil {
+			newNode = &PlanNode{indexJoin, indexJoin.Descriptor()}
+		} else {
+			newOp, err := NewJoin(op1, leftExpr, op2, rightExpr, JoinBufferSize)
+			if err != nil {
+				return nil, err
+			}
+			newNode = &PlanNode{NewOperatorCard(newOp, card), newOp.Descriptor()}
+		}
 		for key, node := range tableMap {
 			if node.op == op1 {
 				tableMap[key] = newNode
@@ -1135,6 +1306,9 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
 				if err != nil {
 					return nil, err
 				}
+				if s.args[0].exprType == ExprField && s.args[0].field == "*" {
+					aggExpr = nil // COUNT(*) counts NULLs too
+				}
 
 				switch *s.funcOp {
 				case "max":
@@ -1224,11 +1398,16 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
 			ascs = append(ascs, oby.ascending)
 
 		}
-		orderOp, err := NewOrderBy(exprs, topOp, ascs)
-		if err != nil {
-			return nil, err
+		if ordered, ok := orderedByClusterKey(topOp, exprs, ascs); ok {
+			// a clustered table is read in the order asked for
+			topOp = ordered
+		} else {
+			orderOp, err := NewOrderBy(exprs, topOp, ascs)
+			if err != nil {
+				return nil, err
+			}
+			topOp = NewOperatorCard(orderOp, topOp.Cardinality)
 		}
-		topOp = NewOperatorCard(orderOp, topOp.Cardinality)
 	}
 
 	if plan.limit != nil {
@@ -1272,6 +1451,10 @@ func parseInsert(c *Catalog, insStmt *sqlparser.Insert) (Operator, error) {
 				}
 				tupAr = append(tupAr, exprOp)
 			}
+			tupAr, err := coerceRow(tupAr, file.Descriptor())
+			if err != nil {
+				return nil, err
+			}
 			exprAr = append(exprAr, tupAr)
 		}
 		iterOp := NewValueOp(exprAr)
@@ -1341,6 +1524,12 @@ func parseDelete(c *Catalog, delStmt *sqlparser.Delete) (Operator, error) {
 		if err != nil {
 			return nil, err
 		}
+		if f.predOp != OpIsNull && f.predOp != OpIsNotNull {
+			leftExpr, rightExpr, err = coerceExprs(leftExpr, rightExpr)
+			if err != nil {
+				return nil, err
+			}
+		}
 
 		//op := node.op
 		//dbField, _ := fieldNameToField(f.table, f.field, &PlanNode{op, &desc})
@@ -1364,10 +1553,193 @@ const (
 	AbortXactionType     QueryType = iota
 	CreateTableQueryType QueryType = iota
 	DropTableQueryType   QueryType = iota
+	CheckpointQueryType  QueryType = iota
+	SetQueryType         QueryType = iota
+	SavepointQueryType   QueryType = iota
+	CreateIndexQueryType QueryType = iota
+	DropIndexQueryType   QueryType = iota
 	UnknownQueryType     QueryType = iota
 )
 
-func processDDL(c *Catalog, ddl *sqlparser.DDL) (QueryType, error) {
+var (
+	createTableStatement = regexp.MustCompile(`(?i)^\s*create\s+table\b`)
+	clusterByClause      = regexp.MustCompile(`(?i)\)\s*cluster\s+by\s*\(([^()]*)\)\s*;?\s*$`)
+	tableOptionsClause   = regexp.MustCompile(`(?i)\)\s*with\s*\(([^()]*)\)\s*;?\s*$`)
+)
+
+// Remove the WITH (option = value, ...) clause, which the sqlparser grammar
+// does not support, from the end of a CREATE TABLE statement, returning the
+// storage options it lists (see [parseTableOptions]), which have their default
+// values if there is none. It follows the CLUSTER BY clause, if there is one.
+func extractTableOptions(query string) (string, tableOptions, error) {
+	opts := tableOptions{format: heapFormat}
+	if !createTableStatement.MatchString(query) {
+		return query, opts, nil
+	}
+	m := tableOptionsClause.FindStringSubmatchIndex(query)
+	if m == nil {
+		return query, opts, nil
+	}
+	opts, err := parseTableOptions(query[m[2]:m[3]])
+	return query[:m[0]+1], opts, err
+}
+
+// Remove the CLUSTER BY (col, ...) clause, which the sqlparser grammar does
+// not support, from the end of a CREATE TABLE statement, returning the
+// columns it lists (nil if there is none).
+func extractClusterBy(query string) (string, []string) {
+	if !createTableStatement.MatchString(query) {
+		return query, nil
+	}
+	m := clusterByClause.FindStringSubmatchIndex(query)
+	if m == nil {
+		return query, nil
+	}
+	columns := strings.Split(query[m[2]:m[3]], ",")
+	for i, col := range columns {
+		columns[i] = strings.TrimSpace(col)
+	}
+	return query[:m[0]+1], columns
+}
+
+// A token of a query: a word (a keyword, an identifier or a number), quoted
+// text (a string or a quoted identifier), or any other single character. It
+// spans the bytes from start to end of the query.
+type queryToken struct {
+	start, end int
+	text       string
+	quoted     bool
+}
+
+// Return true if tok is the unquoted word or character s, ignoring case.
+func (tok queryToken) is(s string) bool {
+	return !tok.quoted && strings.EqualFold(tok.text, s)
+}
+
+// Return true if tok is an unquoted word.
+func (tok queryToken) isWord() bool {
+	return !tok.quoted && isWordByte(tok.text[0])
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || c >= utf8.RuneSelf || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+// Split query into tokens, skipping whitespace. Quoted text, in which a
+// backslash escapes the next character, is a single token, so the rewrites of
+// the constructs the sqlparser grammar does not support never change it.
+func tokenizeQuery(query string) []queryToken {
+	var tokens []queryToken
+	for i := 0; i < len(query); {
+		start, c := i, query[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+			continue
+		case c == '\'' || c == '"' || c == '`':
+			for i++; i < len(query) && query[i] != c; i++ {
+				if query[i] == '\\' {
+					i++
+				}
+			}
+			i = min(i+1, len(query))
+			tokens = append(tokens, queryToken{start, i, query[start:i], true})
+			continue
+		case isWordByte(c):
+			for i < len(query) && isWordByte(query[i]) {
+				i++
+			}
+		default:
+			i++
+		}
+		tokens = append(tokens, queryToken{start, i, query[start:i], false})
+	}
+	return tokens
+}
+
+// Return the index of the token closing the parenthesis tokens[open], or -1
+// if it is not closed.
+func closingParen(tokens []queryToken, open int) int {
+	depth := 0
+	for i := open; i < len(tokens); i++ {
+		switch {
+		case tokens[i].is("("):
+			depth++
+		case tokens[i].is(")"):
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// A replacement of the bytes from start to end of a query.
+type queryEdit struct {
+	start, end int
+	text       string
+}
+
+// Apply edits, which do not overlap, to query.
+func applyQueryEdits(query string, edits []queryEdit) string {
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	var b strings.Builder
+	last := 0
+	for _, e := range edits {
+		b.WriteString(query[last:e.start])
+		b.WriteString(e.text)
+		last = e.end
+	}
+	b.WriteString(query[last:])
+	return b.String()
+}
+
+// The sqlparser grammar does not support BOOLEAN (or BOOL) columns, so rewrite
+// the types of such columns to TINYINT(1), MySQL's representation of BOOLEAN,
+// in the column list of a CREATE TABLE statement; [parseColumnType] parses
+// TINYINT(1) as BoolType. Quoted text, such as a column named `bool` or a
+// default value, is left alone.
+func rewriteBooleanColumns(query string) string {
+	tokens := tokenizeQuery(query)
+	if len(tokens) < 2 || !tokens[0].is("create") || !tokens[1].is("table") {
+		return query
+	}
+	open := 2
+	for open < len(tokens) && !tokens[open].is("(") {
+		open++
+	}
+	close := closingParen(tokens, open)
+	if close < 0 {
+		return query
+	}
+	// the type of a column is the second token of its definition
+	var edits []queryEdit
+	depth, n := 0, 0
+	for _, tok := range tokens[open+1 : close] {
+		switch {
+		case depth == 0 && tok.is(","):
+			n = 0
+			continue
+		case depth == 0 && n == 1 && (tok.is("bool") || tok.is("boolean")):
+			edits = append(edits, queryEdit{tok.start, tok.end, "tinyint(1)"})
+		case tok.is("("):
+			depth++
+		case tok.is(")"):
+			depth--
+		}
+		if depth == 0 {
+			n++
+		}
+	}
+	return applyQueryEdits(query, edits)
+}
+
+// Execute a CREATE TABLE or DROP TABLE statement. The table created is stored
+// with the specified options (see [Catalog.addTableWithOptions]). If cluster
+// is not nil, it is clustered by those columns (see [HeapFile.setCluster]),
+// which only heap tables can be.
+func processDDL(c *Catalog, ddl *sqlparser.DDL, cluster []string, opts tableOptions) (QueryType, error) {
 	switch ddl.Action {
 	case "create":
 		fields := make([]FieldType, len(ddl.TableSpec.Columns))
@@ -1376,29 +1748,45 @@ func processDDL(c *Catalog, ddl *sqlparser.DDL) (QueryType, error) {
 		if t != nil {
 			return UnknownQueryType, GoDBError{ParseError, fmt.Sprintf("table %s already exists", tabName)}
 		}
+		columns := make([]columnDef, len(ddl.TableSpec.Columns))
 		for i, col := range ddl.TableSpec.Columns {
-			var colType DBType
 			colName := sqlparser.String(col.Name)
-			switch col.Type.Type {
-			case "int":
-				colType = IntType
-			case "string":
-				fallthrough
-			case "text":
-				fallthrough
-			case "varchar":
-				colType = StringType
-			default:
-				return UnknownQueryType, GoDBError{ParseError, fmt.Sprintf("unsupported column type %s", col.Type.Type)}
-
+			length := ""
+			if col.Type.Length != nil {
+				length = string(col.Type.Length.Val)
+			}
+			if col.Type.Scale != nil {
+				length += "," + string(col.Type.Scale.Val)
+			}
+			colType, column, err := parseColumnType(col.Type.Type, length)
+			if err != nil {
+				return UnknownQueryType, GoDBError{ParseError, fmt.Sprintf("unsupported column type %s", sqlparser.String(&col.Type))}
 			}
 			fields[i] = FieldType{colName, "", colType}
+			column.nullable = !bool(col.Type.NotNull)
+			columns[i] = column
+		}
+		var key *clusterKey
+		if cluster != nil {
+			if opts.format != heapFormat {
+				return UnknownQueryType, GoDBError{ParseError, fmt.Sprintf("%s table %s cannot be clustered", opts.format, tabName)}
+			}
+			var err error
+			if key, err = newClusterKey(&TupleDesc{fields}, cluster); err != nil {
+				return UnknownQueryType, err
+			}
 		}
 
-		_, err := c.addTable(tabName, TupleDesc{fields})
+		_, err := c.addTableWithOptions(tabName, TupleDesc{fields}, columns, opts)
 		if err != nil {
 			return UnknownQueryType, err
 		}
+		if key != nil {
+			t, _ := c.GetTableInfo(tabName)
+			if err := t.setCluster(key); err != nil {
+				return UnknownQueryType, err
+			}
+		}
 		return CreateTableQueryType, nil
 
 	case "drop":
@@ -1413,11 +1801,140 @@ func processDDL(c *Catalog, ddl *sqlparser.DDL) (QueryType, error) {
 	}
 }
 
+var (
+	createIndexStatement = regexp.MustCompile(`(?i)^\s*create\s+(unique\s+)?index\s+(\w+)\s+(?:using\s+(\w+)\s+)?on\s+(\w+)\s*(?:using\s+(\w+)\s*)?\(([^()]*)\)\s*(?:using\s+(\w+)\s*)?(?:include\s*\(([^()]*)\)\s*)?;?\s*$`)
+	dropIndexStatement   = regexp.MustCompile(`(?i)^\s*drop\s+index\s+(\w+)(?:\s+on\s+(\w+))?\s*;?\s*$`)
+)
+
+// Execute a CREATE [UNIQUE] INDEX name ON table(col, ...) [INCLUDE (col,
+// ...)] or DROP INDEX name [ON table] statement, which the sqlparser grammar
+// parses without their details (see [Catalog.createIndex] and
+// [Catalog.dropIndex]). The access method of an index is given by a USING
+// method clause, as in MySQL (before ON or after the columns) or PostgreSQL
+// (before the columns). Returns UnknownQueryType if query is not one of them.
+func processIndexDDL(c *Catalog, query string) (QueryType, error) {
+	if m := createIndexStatement.FindStringSubmatch(query); m != nil {
+		parseColumns := func(list string) ([]string, error) {
+			columns := strings.Split(strings.ToLower(list), ",")
+			for i, col := range columns {
+				columns[i] = strings.TrimSpace(col)
+				if columns[i] == "" {
+					return nil, GoDBError{ParseError, fmt.Sprintf("invalid index columns (%s)", list)}
+				}
+			}
+			return columns, nil
+		}
+		columns, err := parseColumns(m[6])
+		if err != nil {
+			return UnknownQueryType, err
+		}
+		var include []string
+		if m[8] != "" {
+			if include, err = parseColumns(m[8]); err != nil {
+				return UnknownQueryType, err
+			}
+		}
+		method := ""
+		for _, using := range []string{m[3], m[5], m[7]} {
+			if using != "" && method != "" {
+				return UnknownQueryType, GoDBError{ParseError, "an index can only have one USING clause"}
+			}
+			method += strings.ToLower(using)
+		}
+		if err := c.createIndex(strings.ToLower(m[2]), strings.ToLower(m[4]), columns, include, m[1] != "", method); err != nil {
+			return UnknownQueryType, err
+		}
+		return CreateIndexQueryType, nil
+	}
+	if m := dropIndexStatement.FindStringSubmatch(query); m != nil {
+		if err := c.dropIndex(strings.ToLower(m[1]), strings.ToLower(m[2])); err != nil {
+			return UnknownQueryType, err
+		}
+		return DropIndexQueryType, nil
+	}
+	return UnknownQueryType, nil
+}
+
+// Parse a SET statement into a [SetOp]. The settings supported are the
+// isolation level, set with SET [SESSION] TRANSACTION ISOLATION LEVEL level,
+// and the lock timeout, set with SET lock_timeout = t (see [LockWaitPolicy]).
+func parseSet(c *Catalog, set *sqlparser.Set) (*SetOp, error) {
+	var bp *BufferPool
+	if c != nil {
+		bp = c.bufferPool
+	}
+	var updates []func(s *txnSettings)
+	var descs []string
+	for _, expr := range set.Exprs {
+		name := expr.Name.Lowered()
+		switch name {
+		case "tx_isolation", "transaction_isolation":
+			val, ok := expr.Expr.(*sqlparser.SQLVal)
+			if !ok || val.Type != sqlparser.StrVal {
+				return nil, GoDBError{ParseError, fmt.Sprintf("invalid isolation level %s", sqlparser.String(expr.Expr))}
+			}
+			level, err := ParseIsolationLevel(string(val.Val))
+			if err != nil {
+				return nil, err
+			}
+			updates = append(updates, func(s *txnSettings) { s.isolation = level })
+			descs = append(descs, fmt.Sprintf("TRANSACTION ISOLATION LEVEL %s", level))
+		case "lock_timeout":
+			val, ok := expr.Expr.(*sqlparser.SQLVal)
+			if !ok || (val.Type != sqlparser.IntVal && val.Type != sqlparser.StrVal) {
+				return nil, GoDBError{ParseError, fmt.Sprintf("invalid lock timeout %s", sqlparser.String(expr.Expr))}
+			}
+			timeout, err := parseLockTimeout(string(val.Val))
+			if err != nil {
+				return nil, err
+			}
+			updates = append(updates, func(s *txnSettings) { s.lockTimeout = timeout })
+			descs = append(descs, fmt.Sprintf("lock_timeout = %v", timeout))
+		default:
+			return nil, GoDBError{ParseError, fmt.Sprintf("unsupported setting %s", name)}
+		}
+	}
+	return newSetOp(bp, strings.Join(descs, ", "), func(s *txnSettings) {
+		for _, update := range updates {
+			update(s)
+		}
+	}), nil
+}
+
+// Parse statements that are not supported by the sqlparser grammar: CHECKPOINT
+// and the savepoint statements (see [SavepointOp]). Returns UnknownQueryType
+// if query is not one of them.
+func parseUtilityStatement(c *Catalog, query string) (QueryType, Operator) {
+	switch strings.ToLower(strings.TrimSpace(query)) {
+	case "checkpoint":
+		return CheckpointQueryType, nil
+	}
+	if op := parseSavepoint(c, query); op != nil {
+		return SavepointQueryType, op
+	}
+	return UnknownQueryType, nil
+}
+
 func Parse(c *Catalog, query string) (QueryType, Operator, error) {
+	if qtype, op := parseUtilityStatement(c, query); qtype != UnknownQueryType {
+		return qtype, op, nil
+	}
+	if qtype, err := processIndexDDL(c, query); qtype != UnknownQueryType || err != nil {
+		return qtype, nil, err
+	}
+	query, opts, err := extractTableOptions(query)
+	if err != nil {
+		return UnknownQueryType, nil, err
+	}
+	query, cluster := extractClusterBy(query)
+	query, wait := extractLockWaitPolicy(rewriteCastTypes(rewriteBooleanColumns(query)))
 	stmt, err := sqlparser.Parse(query)
 	if err != nil {
 		return UnknownQueryType, nil, err
 	}
+	if _, ok := stmt.(*sqlparser.Select); !ok && wait != LockWait {
+		return UnknownQueryType, nil, GoDBError{ParseError, fmt.Sprintf("%s is only supported in SELECT ... FOR UPDATE", wait)}
+	}
 	switch stmt := stmt.(type) {
 	case *sqlparser.Select:
 		plan, err := parseStatement(c, stmt)
@@ -1425,6 +1942,10 @@ func Parse(c *Catalog, query string) (QueryType, Operator, error) {
 			//fmt.Printf("Err: %s\n", err.Error())
 			return UnknownQueryType, nil, err
 		}
+		plan.lock, err = parseLockClause(stmt.Lock, wait)
+		if err != nil {
+			return UnknownQueryType, nil, err
+		}
 		op, err := makePhysicalPlan(c, plan)
 		if err != nil {
 			//fmt.Printf("Err: %s\n", err.Error())
@@ -1449,8 +1970,14 @@ func Parse(c *Catalog, query string) (QueryType, Operator, error) {
 		return CommitXactionType, nil, nil
 	case *sqlparser.Rollback:
 		return AbortXactionType, nil, nil
+	case *sqlparser.Set:
+		op, err := parseSet(c, stmt)
+		if err != nil {
+			return UnknownQueryType, nil, err
+		}
+		return SetQueryType, op, nil
 	case *sqlparser.DDL:
-		qtype, err := processDDL(c, stmt)
+		qtype, err := processDDL(c, stmt, cluster, opts)
 		if err != nil {
 			return UnknownQueryType, nil, err
 		} else {
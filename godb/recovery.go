@@ -0,0 +1,287 @@
+package godb
+
+import (
+	"os"
+)
+
+// Per-transaction state built during the analysis pass of recovery.
+type recoveryTxn struct {
+	lastLSN   LSN
+	committed bool
+	aborting  bool
+}
+
+// Recover the database after a crash. Must be called before any pages of the
+// files referenced by the log are read into a BufferPool (see
+// [NewCatalogFromFile]).
+//
+// Recovery follows ARIES:
+//
+//  1. Analysis scans the log from the last checkpoint (see [LogFile.Checkpoint])
+//     to find transactions that had not ended at the time of the crash, and
+//     whether or not they committed.
+//  2. Redo repeats history by writing the after image of every update and
+//     compensation record back to its page, in LSN order. Records written
+//     before the checkpoint are only redone if their page was in the
+//     checkpoint's dirty page table. Because records hold full page images,
+//     this is idempotent: it is safe to redo a record whose page already
+//     reached the disk.
+//  3. Undo rolls back every transaction that did not commit, processing their
+//     updates in reverse LSN order. Each undone update restores the before
+//     image of its page and is logged with a compensation log record (CLR), so
+//     if the system crashes during recovery the work is not undone twice.
+//
+// When Recover returns, every committed transaction has been made durable and
+// every other transaction has been rolled back and logged as ended. Recover also
+// makes sure [NewTID] does not reuse the ids of transactions in the log, and
+// records whether it found transactions that had not ended, whose changes to
+// B+ trees, which are not logged, may be on disk (see [BTreeFile]).
+func (lf *LogFile) Recover() error {
+	a, err := lf.analyze()
+	if err != nil {
+		return err
+	}
+	advanceTIDs(a.nextTID)
+	lf.unfinished = len(a.txns) > 0
+	if a.redoLSN != InvalidLSN {
+		if err := lf.redo(a); err != nil {
+			return err
+		}
+	}
+	if err := lf.undo(a.txns); err != nil {
+		return err
+	}
+	return lf.Force()
+}
+
+// The result of the analysis pass of recovery.
+type recoveryAnalysis struct {
+	txns          map[TransactionID]*recoveryTxn // transactions that had not ended
+	checkpointLSN LSN                            // InvalidLSN if analysis started at the start of the log
+	dirtyPages    map[heapHash]LSN               // the checkpoint's dirty page table
+	redoLSN       LSN                            // InvalidLSN if nothing needs to be redone
+	nextTID       TransactionID                  // larger than any transaction id in the log
+}
+
+// Analysis pass: find the transactions that had not ended when the system
+// crashed and the LSN to start redo from.
+func (lf *LogFile) analyze() (*recoveryAnalysis, error) {
+	a := &recoveryAnalysis{txns: make(map[TransactionID]*recoveryTxn), checkpointLSN: lf.lastCheckpoint(),
+		dirtyPages: make(map[heapHash]LSN), redoLSN: InvalidLSN}
+	ended := make(map[TransactionID]bool)
+
+	iter, err := lf.iteratorFrom(a.checkpointLSN)
+	if err != nil {
+		return nil, err
+	}
+	for rec, err := iter(); rec != nil || err != nil; rec, err = iter() {
+		if err != nil {
+			return nil, err
+		}
+		switch rec.recType {
+		case BeginCheckpointRecord:
+			continue
+		case EndCheckpointRecord:
+			if rec.checkpointLSN == a.checkpointLSN {
+				a.mergeCheckpoint(rec, ended)
+			}
+			continue
+		}
+		if rec.tid >= a.nextTID {
+			a.nextTID = rec.tid + 1
+		}
+		if rec.recType == EndRecord {
+			delete(a.txns, rec.tid)
+			ended[rec.tid] = true
+			continue
+		}
+		txn, ok := a.txns[rec.tid]
+		if !ok {
+			txn = &recoveryTxn{}
+			a.txns[rec.tid] = txn
+		}
+		txn.lastLSN = rec.lsn
+		switch rec.recType {
+		case CommitRecord:
+			txn.committed = true
+		case AbortRecord:
+			txn.aborting = true
+		case UpdateRecord, CLRRecord:
+			if a.redoLSN == InvalidLSN || rec.lsn < a.redoLSN {
+				a.redoLSN = rec.lsn
+			}
+		}
+	}
+	return a, nil
+}
+
+// Add the tables of the checkpoint's end record to the analysis. ended holds
+// the transactions whose end records were read since the checkpoint began.
+func (a *recoveryAnalysis) mergeCheckpoint(rec *LogRecord, ended map[TransactionID]bool) {
+	if rec.nextTID > a.nextTID {
+		a.nextTID = rec.nextTID
+	}
+	for tid, ckpt := range rec.activeTxns {
+		if ended[tid] {
+			continue
+		}
+		txn, ok := a.txns[tid]
+		if !ok {
+			txn = &recoveryTxn{lastLSN: ckpt.lastLSN}
+			a.txns[tid] = txn
+		}
+		if ckpt.lastLSN > txn.lastLSN {
+			txn.lastLSN = ckpt.lastLSN
+		}
+	}
+	for page, recLSN := range rec.dirtyPages {
+		a.dirtyPages[page] = recLSN
+		if a.redoLSN == InvalidLSN || recLSN < a.redoLSN {
+			a.redoLSN = recLSN
+		}
+	}
+}
+
+// Redo pass: write the after image of every update and CLR from a.redoLSN on,
+// skipping records written before the checkpoint whose pages were not dirty
+// when it was taken.
+func (lf *LogFile) redo(a *recoveryAnalysis) error {
+	iter, err := lf.iteratorFrom(a.redoLSN)
+	if err != nil {
+		return err
+	}
+	for rec, err := iter(); rec != nil || err != nil; rec, err = iter() {
+		if err != nil {
+			return err
+		}
+		if rec.recType != UpdateRecord && rec.recType != CLRRecord {
+			continue
+		}
+		if rec.lsn < a.checkpointLSN {
+			recLSN, ok := a.dirtyPages[heapHash{FileName: rec.file, PageNo: rec.pageNo}]
+			if !ok || rec.lsn < recLSN {
+				continue
+			}
+		}
+		if err := writePageImage(rec.file, rec.pageNo, rec.after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Undo pass: roll back every transaction in txns that did not commit, and log
+// the end of every transaction in txns.
+func (lf *LogFile) undo(txns map[TransactionID]*recoveryTxn) error {
+	toUndo := make(map[TransactionID]LSN)
+	for tid, txn := range txns {
+		if txn.committed {
+			// the commit record is durable, so only the end record is missing
+			if _, err := lf.append(&LogRecord{recType: EndRecord, tid: tid}); err != nil {
+				return err
+			}
+			continue
+		}
+		if !txn.aborting {
+			if _, err := lf.append(&LogRecord{recType: AbortRecord, tid: tid}); err != nil {
+				return err
+			}
+		}
+		toUndo[tid] = txn.lastLSN
+	}
+
+	for len(toUndo) > 0 {
+		// always undo the record with the largest LSN next
+		tid := TransactionID(-1)
+		lsn := InvalidLSN
+		for t, l := range toUndo {
+			if l > lsn {
+				tid, lsn = t, l
+			}
+		}
+
+		_, next, err := lf.undoRecord(lsn)
+		if err != nil {
+			return err
+		}
+		if next == InvalidLSN {
+			delete(toUndo, tid)
+			if _, err := lf.append(&LogRecord{recType: EndRecord, tid: tid}); err != nil {
+				return err
+			}
+		} else {
+			toUndo[tid] = next
+		}
+	}
+	return nil
+}
+
+// Roll back the updates tid logged after stopLSN, most recent first, returning
+// the pages that were restored. Each undone update is logged with a CLR, so
+// updates that were already rolled back (e.g., by an earlier rollback to a
+// savepoint) are skipped. Pass InvalidLSN as stopLSN to roll back all of
+// tid's updates.
+func (lf *LogFile) rollback(tid TransactionID, stopLSN LSN) ([]heapHash, error) {
+	var pages []heapHash
+	for lsn := lf.lastLSNOf(tid); lsn > stopLSN; {
+		rec, next, err := lf.undoRecord(lsn)
+		if err != nil {
+			return nil, err
+		}
+		if rec.recType == UpdateRecord {
+			pages = append(pages, heapHash{FileName: rec.file, PageNo: rec.pageNo})
+		}
+		lsn = next
+	}
+	return pages, nil
+}
+
+// Undo the record with the specified LSN, returning the record and the LSN of
+// the next record of the same transaction that needs to be undone.
+func (lf *LogFile) undoRecord(lsn LSN) (*LogRecord, LSN, error) {
+	rec, err := lf.readRecord(lsn)
+	if err != nil {
+		return nil, InvalidLSN, err
+	}
+	switch rec.recType {
+	case UpdateRecord:
+		clrLSN, err := lf.append(&LogRecord{recType: CLRRecord, tid: rec.tid, file: rec.file, pageNo: rec.pageNo,
+			before: rec.after, after: rec.before, undoNextLSN: rec.prevLSN})
+		if err != nil {
+			return nil, InvalidLSN, err
+		}
+		if err := lf.forceTo(clrLSN); err != nil {
+			return nil, InvalidLSN, err
+		}
+		if err := writePageImage(rec.file, rec.pageNo, rec.before); err != nil {
+			return nil, InvalidLSN, err
+		}
+		return rec, rec.prevLSN, nil
+	case CLRRecord:
+		// everything between the CLR and undoNextLSN has already been undone
+		return rec, rec.undoNextLSN, nil
+	default:
+		return rec, rec.prevLSN, nil
+	}
+}
+
+// Write the page image to page pageNo of the specified file, padding it to
+// [PageSize] bytes. A nil image denotes a page that did not exist before an
+// update; it is restored as a page of zeros. The room of the page is marked as
+// unknown in the free space map of the file, if it has one.
+func writePageImage(fileName string, pageNo int, image []byte) error {
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	buf := make([]byte, PageSize)
+	copy(buf, image)
+	if _, err := f.WriteAt(buf, int64(pageNo)*int64(PageSize)); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return forgetPersistedFreeSpace(fileName, pageNo)
+}
@@ -0,0 +1,438 @@
+package godb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// crashHarness plays the role of the BufferPool in recovery tests: it caches
+// pages and writes them and log records following the same WAL protocol that
+// [BufferPool.CommitTransaction], [BufferPool.AbortTransaction] and
+// [BufferPool.GetPage] use, and can be made to crash after any number of steps
+// (log appends, log forces, page writes and aborts). Records that were not
+// forced at the time of the crash are lost.
+type crashHarness struct {
+	t        *testing.T
+	dataFile string
+	logPath  string
+	lf       *LogFile
+	steal    bool // STEAL/NO FORCE if true, FORCE/NO STEAL otherwise
+
+	steps      int
+	crashAfter int // crash once this many steps have run; -1 to never crash
+	crashed    bool
+
+	cache     map[int]*testPage
+	written   map[TransactionID]map[int][]byte // latest contents of the pages each transaction updated
+	committed map[int][]byte                   // expected page contents after recovery
+}
+
+// A cached page, with the state a heapPage keeps for the WAL.
+type testPage struct {
+	data      []byte
+	before    []byte
+	lsn       LSN
+	dirty     bool
+	dirtiedBy TransactionID // -1 if not dirtied by a running transaction
+}
+
+func newCrashHarness(t *testing.T, steal bool, crashAfter int, initialPages int) *crashHarness {
+	dir := t.TempDir()
+	h := &crashHarness{t: t, dataFile: filepath.Join(dir, "data.dat"), logPath: filepath.Join(dir, LogFileName),
+		steal: steal, crashAfter: crashAfter, cache: make(map[int]*testPage), written: make(map[TransactionID]map[int][]byte),
+		committed: make(map[int][]byte)}
+	for i := 0; i < initialPages; i++ {
+		if err := writePageImage(h.dataFile, i, testPageImage(byte(100+i))); err != nil {
+			t.Fatalf(err.Error())
+		}
+		h.committed[i] = testPageImage(byte(100 + i))
+	}
+	lf, err := NewLogFile(h.logPath)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	h.lf = lf
+	return h
+}
+
+// Return true if the next step should run, crashing the harness if it has
+// run out of steps.
+func (h *crashHarness) step() bool {
+	if h.crashed {
+		return false
+	}
+	if h.steps == h.crashAfter {
+		h.crashed = true
+		crashLogFile(h.lf)
+		return false
+	}
+	h.steps++
+	return true
+}
+
+func (h *crashHarness) check(err error) {
+	if err != nil {
+		h.t.Fatalf(err.Error())
+	}
+}
+
+func (h *crashHarness) begin(tid TransactionID) {
+	if h.step() {
+		_, err := h.lf.logBegin(tid)
+		h.check(err)
+	}
+}
+
+func (h *crashHarness) getPage(pageNo int) *testPage {
+	p, ok := h.cache[pageNo]
+	if !ok {
+		data := h.readDiskPage(pageNo)
+		p = &testPage{data: data, before: data, lsn: InvalidLSN, dirtiedBy: -1}
+		h.cache[pageNo] = p
+	}
+	return p
+}
+
+// Modify a page in memory on behalf of tid.
+func (h *crashHarness) update(tid TransactionID, pageNo int, contents byte) {
+	if h.crashed {
+		return
+	}
+	p := h.getPage(pageNo)
+	p.data = testPageImage(contents)
+	p.dirty = true
+	p.dirtiedBy = tid
+	if h.written[tid] == nil {
+		h.written[tid] = make(map[int][]byte)
+	}
+	h.written[tid][pageNo] = p.data
+}
+
+// Return the cached pages dirtied by tid, in page order.
+func (h *crashHarness) pagesOf(tid TransactionID) []int {
+	var pages []int
+	for pageNo := 0; pageNo < 16; pageNo++ {
+		if p, ok := h.cache[pageNo]; ok && p.dirtiedBy == tid {
+			pages = append(pages, pageNo)
+		}
+	}
+	return pages
+}
+
+// Log the current contents of a cached page on behalf of tid.
+func (h *crashHarness) logPage(tid TransactionID, pageNo int) {
+	p := h.cache[pageNo]
+	lsn, err := h.lf.logUpdate(tid, h.dataFile, pageNo, p.before, p.data)
+	h.check(err)
+	p.lsn = lsn
+}
+
+// Commit tid. Under FORCE, its pages are written before the commit is logged.
+func (h *crashHarness) commit(tid TransactionID) {
+	pages := h.pagesOf(tid)
+	for _, pageNo := range pages {
+		if !h.step() {
+			return
+		}
+		h.logPage(tid, pageNo)
+	}
+	if !h.steal {
+		if !h.step() {
+			return
+		}
+		h.check(h.lf.Force())
+		for _, pageNo := range pages {
+			if !h.step() {
+				return
+			}
+			h.check(writePageImage(h.dataFile, pageNo, h.cache[pageNo].data))
+		}
+	}
+	if !h.step() {
+		return
+	}
+	h.check(h.lf.logCommit(tid))
+	for _, pageNo := range pages {
+		p := h.cache[pageNo]
+		p.before = p.data
+		p.dirtiedBy = -1
+		p.dirty = h.steal
+	}
+	for pageNo, data := range h.written[tid] {
+		h.committed[pageNo] = data
+	}
+}
+
+// Evict a page from the cache, writing it first if it is dirty. If the page
+// was dirtied by a running transaction, this is a steal.
+func (h *crashHarness) evict(pageNo int) {
+	p := h.cache[pageNo]
+	if p == nil || h.crashed {
+		return
+	}
+	if p.dirty {
+		if p.dirtiedBy != -1 {
+			if !h.step() {
+				return
+			}
+			h.logPage(p.dirtiedBy, pageNo)
+		}
+		if !h.step() {
+			return
+		}
+		h.check(h.lf.forceTo(p.lsn))
+		if !h.step() {
+			return
+		}
+		h.check(writePageImage(h.dataFile, pageNo, p.data))
+	}
+	delete(h.cache, pageNo)
+}
+
+// Abort tid, restoring its cached pages from their before images and rolling
+// back the pages it stole.
+func (h *crashHarness) abort(tid TransactionID) {
+	if !h.step() {
+		return
+	}
+	for _, pageNo := range h.pagesOf(tid) {
+		p := h.cache[pageNo]
+		if p.before == nil {
+			delete(h.cache, pageNo)
+			continue
+		}
+		p.data = p.before
+		p.dirtiedBy = -1
+	}
+	restored, err := h.lf.logAbort(tid)
+	h.check(err)
+	for _, key := range restored {
+		if key.FileName != h.dataFile {
+			h.t.Fatalf("abort restored a page of unexpected file %s", key.FileName)
+		}
+		delete(h.cache, key.PageNo)
+	}
+}
+
+// Read a page from the data file, or nil if it does not exist.
+func (h *crashHarness) readDiskPage(pageNo int) []byte {
+	f, err := os.Open(h.dataFile)
+	h.check(err)
+	defer f.Close()
+	buf := make([]byte, PageSize)
+	n, err := f.ReadAt(buf, int64(pageNo)*int64(PageSize))
+	if n == 0 && err == io.EOF {
+		return nil
+	}
+	return buf
+}
+
+// Recover from the crash (or the end of the workload) and check that the data
+// file contains exactly the committed pages.
+func (h *crashHarness) recoverAndVerify(desc string) {
+	if !h.crashed {
+		crashLogFile(h.lf)
+	}
+	lf, err := NewLogFile(h.logPath)
+	h.check(err)
+	defer lf.Close()
+	if err := lf.Recover(); err != nil {
+		h.t.Fatalf("%s: recovery failed: %v", desc, err)
+	}
+	h.verifyPages(desc)
+
+	// every transaction should have ended
+	a, err := lf.analyze()
+	h.check(err)
+	if len(a.txns) != 0 {
+		h.t.Errorf("%s: %d transactions still active after recovery", desc, len(a.txns))
+	}
+}
+
+func (h *crashHarness) verifyPages(desc string) {
+	for pageNo := 0; pageNo < 16; pageNo++ {
+		expected, ok := h.committed[pageNo]
+		if !ok {
+			expected = make([]byte, PageSize)
+		}
+		actual := h.readDiskPage(pageNo)
+		if actual == nil {
+			actual = make([]byte, PageSize)
+		}
+		if !bytes.Equal(expected, actual) {
+			h.t.Errorf("%s: page %d: expected contents %d, found %d", desc, pageNo, expected[0], actual[0])
+		}
+	}
+}
+
+// A workload of five transactions: two commit, two abort, and one is still
+// running when the workload ends. New pages are created by both committed and
+// aborted transactions. The workload evicts pages, which steals pages of
+// running transactions under STEAL and is not allowed under NO STEAL.
+func runRecoveryWorkload(h *crashHarness) {
+	t1, t2, t3, t4, t5 := NewTID(), NewTID(), NewTID(), NewTID(), NewTID()
+	h.begin(t1)
+	h.update(t1, 0, 1)
+	h.update(t1, 1, 1)
+	h.commit(t1)
+
+	h.begin(t2)
+	h.update(t2, 1, 2)
+	h.update(t2, 2, 2)
+	if h.steal {
+		h.evict(1)
+	}
+	h.update(t2, 3, 2)
+	if h.steal {
+		h.evict(3)
+	}
+	h.begin(t3)
+	h.update(t3, 0, 3)
+	h.update(t3, 4, 3)
+	if h.steal {
+		h.evict(4)
+	}
+	h.commit(t2)
+	h.abort(t3)
+
+	h.begin(t4)
+	h.update(t4, 1, 4)
+	h.update(t4, 5, 4)
+	if h.steal {
+		h.evict(1)
+		h.evict(5)
+	}
+	h.evict(0)
+	h.abort(t4)
+
+	h.begin(t5)
+	h.update(t5, 2, 5)
+	if h.steal {
+		h.evict(2)
+	}
+	h.update(t5, 6, 5)
+	if h.steal {
+		h.evict(6)
+	}
+}
+
+func testRecoveryCrashes(t *testing.T, steal bool) {
+	h := newCrashHarness(t, steal, -1, 3)
+	runRecoveryWorkload(h)
+	totalSteps := h.steps
+	h.recoverAndVerify("no crash")
+
+	for crashAfter := 0; crashAfter <= totalSteps; crashAfter++ {
+		h := newCrashHarness(t, steal, crashAfter, 3)
+		runRecoveryWorkload(h)
+		h.recoverAndVerify(fmt.Sprintf("crash after %d steps", crashAfter))
+	}
+}
+
+func TestRecoveryCrashForceNoSteal(t *testing.T) {
+	testRecoveryCrashes(t, false)
+}
+
+func TestRecoveryCrashStealNoForce(t *testing.T) {
+	testRecoveryCrashes(t, true)
+}
+
+// Without a crash, aborts under STEAL must restore both the cached pages and
+// the pages that were stolen, and committed pages must eventually reach disk
+// when they are evicted.
+func TestRecoveryAbortStolenPages(t *testing.T) {
+	h := newCrashHarness(t, true, -1, 3)
+	runRecoveryWorkload(h)
+	for pageNo := range h.cache {
+		if h.cache[pageNo].dirtiedBy == -1 {
+			h.evict(pageNo)
+		}
+	}
+	// the only pages that differ from the committed state are the ones t5
+	// stole
+	for pageNo := 0; pageNo < 16; pageNo++ {
+		expected, ok := h.committed[pageNo]
+		if !ok {
+			expected = make([]byte, PageSize)
+		}
+		actual := h.readDiskPage(pageNo)
+		if actual == nil {
+			actual = make([]byte, PageSize)
+		}
+		if stolen := pageNo == 2 || pageNo == 6; stolen == bytes.Equal(expected, actual) {
+			t.Errorf("page %d: unexpected contents %d (committed contents %d)", pageNo, actual[0], expected[0])
+		}
+	}
+}
+
+// Recovery must be idempotent: crashing immediately after recovering (or
+// during recovery, once some compensation records have been written) and
+// recovering again must produce the same result.
+func TestRecoveryRepeated(t *testing.T) {
+	for _, crashAfter := range []int{5, 9, 13, 17} {
+		h := newCrashHarness(t, true, crashAfter, 3)
+		runRecoveryWorkload(h)
+		// write a loser's page to disk without committing it, as if it had
+		// been flushed, so that undo has work to do
+		tid := NewTID()
+		lf, err := NewLogFile(h.logPath)
+		h.check(err)
+		lf.logBegin(tid)
+		_, err = lf.logUpdate(tid, h.dataFile, 1, h.committed[1], testPageImage(77))
+		h.check(err)
+		h.check(lf.Force())
+		h.check(writePageImage(h.dataFile, 1, testPageImage(77)))
+		crashLogFile(lf)
+
+		for i := 0; i < 3; i++ {
+			lf, err := NewLogFile(h.logPath)
+			h.check(err)
+			h.check(lf.Recover())
+			h.verifyPages(fmt.Sprintf("crash after %d steps, recovery %d", crashAfter, i))
+			crashLogFile(lf)
+		}
+	}
+}
+
+// The log written while undoing a transaction must allow a later recovery to
+// skip the work that was already undone.
+func TestRecoveryCompensationRecords(t *testing.T) {
+	h := newCrashHarness(t, true, -1, 2)
+	tid := NewTID()
+	h.begin(tid)
+	for pageNo := 0; pageNo < 2; pageNo++ {
+		_, err := h.lf.logUpdate(tid, h.dataFile, pageNo, h.readDiskPage(pageNo), testPageImage(50))
+		h.check(err)
+	}
+	h.check(h.lf.Force())
+	h.check(writePageImage(h.dataFile, 0, testPageImage(50)))
+	h.check(writePageImage(h.dataFile, 1, testPageImage(50)))
+	crashLogFile(h.lf)
+
+	lf, err := NewLogFile(h.logPath)
+	h.check(err)
+	h.check(lf.Recover())
+	recs := readLogRecords(t, lf)
+	lf.Close()
+	h.verifyPages("after recovery")
+
+	var types []LogRecordType
+	for _, rec := range recs {
+		types = append(types, rec.recType)
+	}
+	expected := []LogRecordType{BeginRecord, UpdateRecord, UpdateRecord, AbortRecord, CLRRecord, CLRRecord, EndRecord}
+	if fmt.Sprint(types) != fmt.Sprint(expected) {
+		t.Fatalf("expected records %v, got %v", expected, types)
+	}
+	// CLRs undo the updates in reverse order, and point past what they undo
+	if recs[4].pageNo != 1 || recs[4].undoNextLSN != recs[1].lsn {
+		t.Errorf("first CLR should undo page 1 and point to the first update, got %v", recs[4])
+	}
+	if recs[5].pageNo != 0 || recs[5].undoNextLSN != recs[0].lsn {
+		t.Errorf("second CLR should undo page 0 and point to the begin record, got %v", recs[5])
+	}
+}
@@ -0,0 +1,213 @@
+package godb
+
+import "sync"
+
+/*
+By default the BufferPool locks whole pages, so two transactions that modify
+different tuples on the same page conflict. A BufferPool created with
+RowLocking set (see [BufferPoolOptions]) instead locks individual tuples,
+using the lock hierarchy described in [LockManager]:
+
+  - a transaction reading (writing) a page takes an IS (IX) lock on its table
+    and on the page (see [BufferPool.lockFilePage]);
+  - a transaction reading (writing) a tuple takes an S (X) lock on the tuple,
+    keyed by its table and [Tuple.Rid] (see [BufferPool.lockTuple]).
+
+Several transactions may then have uncommitted changes on the same page, so
+their changes are undone and committed tuple by tuple rather than as whole
+page images (see [rowChangeTable]).
+
+Since a transaction that scans a large table would end up holding a lock on
+every tuple, once a transaction holds more row locks in a table than the
+escalation threshold, the row locks are replaced by a single S or X lock on
+the table (lock escalation). Tuples in the table are then covered by the table
+lock and no longer locked individually.
+*/
+
+// The number of row locks a transaction can hold in a table before they are
+// escalated to a table lock, unless set with
+// [BufferPoolOptions.LockEscalationThreshold].
+const DefaultLockEscalationThreshold = 1000
+
+// The lock key of a table.
+type tableLockKey struct {
+	file DBFile
+}
+
+// The lock key of a tuple. The Rid of a tuple must be comparable so that it
+// can be used as a lock key.
+type rowLockKey struct {
+	file DBFile
+	rid  recordID
+}
+
+// Return the intention lock mode required to lock objects inside a table or
+// page with the specified permission.
+func intentionFor(perm RWPerm) LockMode {
+	if perm == WritePerm {
+		return IntentionExclusiveLock
+	}
+	return IntentionSharedLock
+}
+
+// The row locks held by each transaction in each table, used to decide when to
+// escalate them. The zero value is ready to use.
+type rowLockTable struct {
+	mu   sync.Mutex
+	rows map[TransactionID]map[DBFile]map[recordID]LockMode
+}
+
+// Record that tid holds a lock on the row of file with the specified rid in the
+// specified mode, returning the number of rows of file tid holds locks on.
+func (rt *rowLockTable) add(tid TransactionID, file DBFile, rid recordID, mode LockMode) int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.rows == nil {
+		rt.rows = make(map[TransactionID]map[DBFile]map[recordID]LockMode)
+	}
+	tables := rt.rows[tid]
+	if tables == nil {
+		tables = make(map[DBFile]map[recordID]LockMode)
+		rt.rows[tid] = tables
+	}
+	rows := tables[file]
+	if rows == nil {
+		rows = make(map[recordID]LockMode)
+		tables[file] = rows
+	}
+	rows[rid] = mode
+	return len(rows)
+}
+
+// Return a copy of the row locks tid holds in file.
+func (rt *rowLockTable) rowsOf(tid TransactionID, file DBFile) map[recordID]LockMode {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rows := make(map[recordID]LockMode)
+	for rid, mode := range rt.rows[tid][file] {
+		rows[rid] = mode
+	}
+	return rows
+}
+
+// Forget that tid holds a lock on the row of file with the specified rid.
+func (rt *rowLockTable) remove(tid TransactionID, file DBFile, rid recordID) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	delete(rt.rows[tid][file], rid)
+}
+
+// Forget the row locks of tid, which has ended.
+func (rt *rowLockTable) forget(tid TransactionID) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	delete(rt.rows, tid)
+}
+
+// Take the locks [BufferPool.GetPage] needs before returning page pageNo of
+// file to tid: with row locking, an intention lock on the table of the page,
+// and then the page lock (see [BufferPool.lockPage]). wait specifies what to do
+// if a lock is held by another transaction (see [BufferPool.lockKey]).
+func (bp *BufferPool) lockFilePage(file DBFile, pageNo int, tid TransactionID, perm RWPerm, wait LockWaitPolicy) error {
+	if bp.rowLocking {
+		if err := bp.lockTable(file, tid, perm, wait); err != nil {
+			return err
+		}
+	}
+	return bp.lockPage(tid, file.pageKey(pageNo), perm, wait)
+}
+
+// Lock the table of file with the intention lock required to read or write
+// tuples in it, on behalf of tid.
+func (bp *BufferPool) lockTable(file DBFile, tid TransactionID, perm RWPerm, wait LockWaitPolicy) error {
+	return bp.lockKey(tid, tableLockKey{file}, intentionFor(perm), wait)
+}
+
+// Lock the tuple of file with the specified rid for reading or writing on
+// behalf of tid; wait specifies what to do if it is locked by another
+// transaction (see [BufferPool.lockKey]). The tuple's page must have been
+// retrieved with [BufferPool.GetPage], with WritePerm if tid changes the tuple.
+// Does nothing unless the BufferPool uses row locking, or if tid holds a table
+// lock that covers the tuple. Escalates the row locks of tid in the table if
+// there are more than the escalation threshold, following wait as well; if the
+// table lock is not granted, tid keeps its row locks.
+func (bp *BufferPool) lockTuple(file DBFile, rid recordID, tid TransactionID, perm RWPerm, wait LockWaitPolicy) error {
+	if !bp.rowLocking {
+		return nil
+	}
+	mode := SharedLock
+	if perm == WritePerm {
+		mode = ExclusiveLock
+	}
+	lm := bp.lockManager()
+	tableKey := tableLockKey{file}
+	if held, ok := lm.holds(tid, tableKey); ok && held.covers(mode) {
+		return nil
+	}
+	if err := bp.lockKey(tid, tableKey, intentionFor(perm), wait); err != nil {
+		return err
+	}
+	rowKey := rowLockKey{file, rid}
+	if err := bp.lockKey(tid, rowKey, mode, wait); err != nil {
+		return err
+	}
+	held, ok := lm.holds(tid, rowKey)
+	if !ok || bp.shortLocks.isShort(tid, rowKey) {
+		// the isolation level did not require a lock to be held until tid ends
+		return nil
+	}
+	if bp.rowLocks.add(tid, file, rid, held) > bp.escalationThreshold() {
+		// the tuple itself is locked, so a failed escalation is not an error;
+		// it is tried again with the next row lock
+		bp.escalate(file, tid, wait)
+	}
+	return nil
+}
+
+// Return the number of row locks a transaction can hold in a table before they
+// are escalated.
+func (bp *BufferPool) escalationThreshold() int {
+	if bp.lockEscalationThreshold <= 0 {
+		return DefaultLockEscalationThreshold
+	}
+	return bp.lockEscalationThreshold
+}
+
+// Replace the row locks tid holds in file with a lock on the table: an S lock
+// (SIX if tid also intends to write) if tid only holds S row locks, and an X
+// lock otherwise. wait specifies what to do if the table is locked by another
+// transaction (see [BufferPool.lockKey]); if the table lock is not granted, the
+// row locks are kept.
+func (bp *BufferPool) escalate(file DBFile, tid TransactionID, wait LockWaitPolicy) error {
+	rows := bp.rowLocks.rowsOf(tid, file)
+	mode := SharedLock
+	for _, m := range rows {
+		if m == ExclusiveLock {
+			mode = ExclusiveLock
+		}
+	}
+	tableKey := tableLockKey{file}
+	if err := bp.lockKey(tid, tableKey, mode, wait); err != nil {
+		return err
+	}
+	lm := bp.lockManager()
+	held, _ := lm.holds(tid, tableKey)
+	for rid, m := range rows {
+		if held.covers(m) {
+			lm.release(tid, rowLockKey{file, rid})
+			bp.rowLocks.remove(tid, file, rid)
+		}
+	}
+	return nil
+}
+
+// Lock the table of file with an intention lock on behalf of tid, if file is a
+// HeapFile. Used by operators that modify a DBFile, which lock the table before
+// reading their child so that they do not have to upgrade their table lock
+// while holding row locks.
+func lockDBFile(file DBFile, tid TransactionID, perm RWPerm) error {
+	if hf, ok := file.(*HeapFile); ok && hf.bufPool != nil && hf.bufPool.rowLocking {
+		return hf.bufPool.lockTable(hf, tid, perm, LockWait)
+	}
+	return nil
+}
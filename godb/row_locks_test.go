@@ -0,0 +1,201 @@
+package godb
+
+import (
+	"testing"
+	"time"
+)
+
+// Lock a tuple in the background, returning a channel that receives the result
+// once the lock has been taken.
+func lockTupleAsync(bp *BufferPool, file DBFile, rid recordID, tid TransactionID, perm RWPerm) chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- bp.lockTuple(file, rid, tid, perm, LockWait)
+	}()
+	return done
+}
+
+func TestLockModeLattice(t *testing.T) {
+	modes := []LockMode{IntentionSharedLock, IntentionExclusiveLock, SharedLock, SharedIntentionExclusiveLock, ExclusiveLock}
+	for _, m := range modes {
+		for _, m2 := range modes {
+			if m.compatible(m2) != m2.compatible(m) {
+				t.Errorf("compatibility of %v and %v is not symmetric", m, m2)
+			}
+			j := m.join(m2)
+			if j != m2.join(m) || !j.covers(m) || !j.covers(m2) {
+				t.Errorf("%v join %v = %v does not cover both", m, m2, j)
+			}
+			// a mode that covers another conflicts with everything it does
+			for _, m3 := range modes {
+				if m.covers(m2) && !m2.compatible(m3) && m.compatible(m3) {
+					t.Errorf("%v covers %v but is compatible with %v", m, m2, m3)
+				}
+			}
+		}
+	}
+	if IntentionExclusiveLock.join(SharedLock) != SharedIntentionExclusiveLock {
+		t.Errorf("expected IX join S to be SIX")
+	}
+	if !IntentionSharedLock.compatible(SharedIntentionExclusiveLock) || IntentionExclusiveLock.compatible(SharedLock) {
+		t.Errorf("unexpected compatibility of intention locks")
+	}
+}
+
+// Transactions writing different tuples of the same page should not conflict
+// with row locking, but should with page locking.
+func TestRowLockingNoFalseConflicts(t *testing.T) {
+	bp := &BufferPool{rowLocking: true}
+	file := &HeapFile{bufPool: bp}
+	t1, t2 := NewTID(), NewTID()
+	for _, tid := range []TransactionID{t1, t2} {
+		if err := bp.lockFilePage(file, 0, tid, WritePerm, LockWait); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if !granted(t, lockTupleAsync(bp, file, 1, t1, WritePerm)) || !granted(t, lockTupleAsync(bp, file, 2, t2, WritePerm)) {
+		t.Fatalf("writes to different tuples should not conflict")
+	}
+	conflict := lockTupleAsync(bp, file, 1, t2, ReadPerm)
+	if granted(t, conflict) {
+		t.Fatalf("reading a tuple written by a running transaction should block")
+	}
+	bp.releaseLocks(t1)
+	if !granted(t, conflict) {
+		t.Fatalf("the read should proceed once the writer commits")
+	}
+	bp.releaseLocks(t2)
+
+	pageBP := &BufferPool{}
+	file = &HeapFile{bufPool: pageBP}
+	if err := pageBP.lockFilePage(file, 0, t1, WritePerm, LockWait); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := pageBP.lockTuple(file, 1, t1, WritePerm, LockWait); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(pageBP.lockManager().held[t1]) != 1 {
+		t.Errorf("expected only a page lock without row locking, have %s", pageBP.lockManager())
+	}
+	page := make(chan error, 1)
+	go func() { page <- pageBP.lockFilePage(file, 0, t2, WritePerm, LockWait) }()
+	if granted(t, page) {
+		t.Errorf("writes to the same page should conflict without row locking")
+	}
+	pageBP.releaseLocks(t1)
+	granted(t, page)
+	pageBP.releaseLocks(t2)
+}
+
+func TestRowLockEscalation(t *testing.T) {
+	bp := &BufferPool{rowLocking: true, lockEscalationThreshold: 3}
+	file := &HeapFile{bufPool: bp}
+	reader, writer := NewTID(), NewTID()
+	lm := bp.lockManager()
+	for rid := 0; rid < 4; rid++ {
+		if err := bp.lockTuple(file, rid, reader, ReadPerm, LockWait); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if mode, ok := lm.holds(reader, tableLockKey{file}); !ok || mode != SharedLock {
+		t.Fatalf("expected the row locks to be escalated to an S table lock, have %s", lm)
+	}
+	if len(lm.held[reader]) != 1 {
+		t.Errorf("expected the row locks to be released after escalation, have %s", lm)
+	}
+	if err := bp.lockTuple(file, 10, reader, ReadPerm, LockWait); err != nil || len(lm.held[reader]) != 1 {
+		t.Errorf("expected reads to be covered by the table lock, have %s", lm)
+	}
+
+	// the table lock blocks writers, but not readers
+	write := lockTupleAsync(bp, file, 20, writer, WritePerm)
+	if granted(t, write) {
+		t.Fatalf("writing a tuple of a table locked by a reader should block")
+	}
+	bp.releaseLocks(reader)
+	if !granted(t, write) {
+		t.Fatalf("the write should proceed once the reader commits")
+	}
+
+	// escalating a writer's row locks takes an X table lock
+	for rid := 21; rid < 24; rid++ {
+		if err := bp.lockTuple(file, rid, writer, WritePerm, LockWait); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if mode, _ := lm.holds(writer, tableLockKey{file}); mode != ExclusiveLock {
+		t.Errorf("expected the row locks to be escalated to an X table lock, have %s", lm)
+	}
+	bp.releaseLocks(writer)
+	if len(lm.locks) != 0 || len(bp.rowLocks.rows) != 0 {
+		t.Errorf("expected no locks to be left, have %s", lm)
+	}
+}
+
+// A transaction that intends to write a table and reads many of its tuples
+// escalates to SIX: other transactions may still read, but not write.
+func TestRowLockEscalationSIX(t *testing.T) {
+	bp := &BufferPool{rowLocking: true, lockEscalationThreshold: 3}
+	file := &HeapFile{bufPool: bp}
+	tid, other := NewTID(), NewTID()
+	lm := bp.lockManager()
+	if err := bp.lockFilePage(file, 0, tid, WritePerm, LockWait); err != nil {
+		t.Fatalf(err.Error())
+	}
+	for rid := 0; rid < 4; rid++ {
+		if err := bp.lockTuple(file, rid, tid, ReadPerm, LockWait); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if mode, _ := lm.holds(tid, tableLockKey{file}); mode != SharedIntentionExclusiveLock {
+		t.Fatalf("expected escalation to SIX, have %s", lm)
+	}
+	if !granted(t, lockTupleAsync(bp, file, 0, other, ReadPerm)) {
+		t.Errorf("SIX should allow other transactions to read")
+	}
+	write := lockTupleAsync(bp, file, 5, other, WritePerm)
+	if granted(t, write) {
+		t.Errorf("SIX should not allow other transactions to write")
+	}
+	bp.releaseLocks(tid)
+	granted(t, write)
+	bp.releaseLocks(other)
+}
+
+// Escalation follows the wait policy of the lock request that triggers it, and
+// keeps the row locks if the table lock is not granted.
+func TestRowLockEscalationNoWait(t *testing.T) {
+	bp := &BufferPool{rowLocking: true, lockEscalationThreshold: 3}
+	file := &HeapFile{bufPool: bp}
+	reader, writer := NewTID(), NewTID()
+	lm := bp.lockManager()
+	if err := bp.lockTuple(file, 10, writer, WritePerm, LockWait); err != nil {
+		t.Fatalf(err.Error())
+	}
+	for rid := 0; rid < 3; rid++ {
+		if err := bp.lockTuple(file, rid, reader, ReadPerm, LockNoWait); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if err := bp.lockTuple(file, 3, reader, ReadPerm, LockNoWait); err != nil {
+		t.Errorf("expected the tuple to be locked without waiting for the escalation, got %v", err)
+	}
+	if err := bp.lockTuple(file, 4, reader, ReadPerm, LockSkipLocked); err != nil {
+		t.Errorf("expected a tuple that is not locked not to be skipped, got %v", err)
+	}
+	bp.SetLockTimeout(reader, 10*time.Millisecond)
+	if err := bp.lockTuple(file, 5, reader, ReadPerm, LockWait); err != nil {
+		t.Errorf("expected the tuple to be locked once the escalation times out, got %v", err)
+	}
+	if mode, _ := lm.holds(reader, tableLockKey{file}); mode != IntentionSharedLock || len(bp.rowLocks.rowsOf(reader, file)) != 6 {
+		t.Errorf("expected the row locks to be kept, have %s", lm)
+	}
+	bp.releaseLocks(writer)
+	if err := bp.lockTuple(file, 6, reader, ReadPerm, LockNoWait); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if mode, _ := lm.holds(reader, tableLockKey{file}); mode != SharedLock || len(lm.held[reader]) != 1 {
+		t.Errorf("expected the row locks to be escalated once the writer ends, have %s", lm)
+	}
+	bp.releaseLocks(reader)
+}
@@ -0,0 +1,287 @@
+package godb
+
+import (
+	"bytes"
+	"sync"
+)
+
+/*
+With row locking (see [BufferPool.lockTuple]), writers only take IX locks on
+the pages they modify, so several running transactions may have changes on
+the same heap page. Whole page images cannot undo such changes: restoring a
+page would also undo the changes of the other transactions. Instead, the
+BufferPool records every change a transaction makes to a heap page in its row
+change table, and undoes them one at a time, newest first, when the
+transaction aborts or rolls back to a savepoint (see [BufferPool.undoChanges]):
+
+  - a slot change records the record a slot of a page held before and after
+    the change (see [heapPage.recordAt]), and is undone by storing the old
+    record back into the slot (see [heapPage.setRecord]). Since the tuple in
+    the slot is locked for writing by the transaction, no other transaction
+    can change the slot before the change is undone;
+  - a page change records the image of a page that the transaction changed as
+    a whole, i.e. an overflow page (see [HeapFile.toast]), and is undone by
+    restoring the image. No other transaction uses an overflow page while the
+    transaction that writes or frees it is running.
+
+Other transactions must not take the slots, or the room, that undoing the
+changes of a running transaction needs (see [rowChangeTable.reserved]).
+
+The log and the pages on disk only ever hold committed changes: when a
+transaction commits, the BufferPool logs or writes the before image of each
+page it changed with its slot changes applied (see [BufferPool.commitPage]),
+and pages holding changes of running transactions are not evicted.
+
+The nodes of B+ trees are shared by running transactions whether or not the
+BufferPool uses row locking (see [BTreeFile]), so the inserts and deletes of
+their entries are always recorded, as entry changes, and undone by deleting
+or inserting the entry again, wherever it has moved since.
+*/
+// The kinds of changes recorded in a [rowChangeTable].
+type changeKind int
+
+const (
+	slotChange  changeKind = iota // the record in a slot of a heap page changed
+	pageChange  changeKind = iota // an overflow page changed as a whole
+	entryInsert changeKind = iota // an entry was inserted into a B+ tree
+	entryDelete changeKind = iota // an entry was deleted from a B+ tree
+)
+
+// A change a transaction made to a page of a heap file, or to the entries of
+// a B+ tree.
+type rowChange struct {
+	kind   changeKind
+	file   *HeapFile
+	pageNo int
+	rid    recordID // the slot changed, for a slot change
+	before []byte   // the record the slot held (nil if free), or the page image
+	after  []byte   // the record the slot holds after the change (nil if free)
+
+	index *BTreeFile // the B+ tree of an entry change, whose file is nil
+	entry btreeEntry
+}
+
+// The changes of each running transaction to heap pages and B+ trees, in the
+// order they were made. The zero value is ready to use.
+type rowChangeTable struct {
+	mu      sync.Mutex
+	changes map[TransactionID][]*rowChange
+}
+
+// Record that tid made the change c.
+func (ct *rowChangeTable) add(tid TransactionID, c *rowChange) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	if ct.changes == nil {
+		ct.changes = make(map[TransactionID][]*rowChange)
+	}
+	ct.changes[tid] = append(ct.changes[tid], c)
+}
+
+// Return the number of changes tid has made and not undone.
+func (ct *rowChangeTable) mark(tid TransactionID) int {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return len(ct.changes[tid])
+}
+
+// Forget the changes tid made after the specified mark, returning them oldest
+// first.
+func (ct *rowChangeTable) takeSince(tid TransactionID, mark int) []*rowChange {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	changes := ct.changes[tid]
+	if mark >= len(changes) {
+		return nil
+	}
+	taken := append([]*rowChange(nil), changes[mark:]...)
+	ct.changes[tid] = changes[:mark]
+	return taken
+}
+
+// Return the slot changes tid made to page pageNo of file, oldest first.
+func (ct *rowChangeTable) slotChanges(tid TransactionID, file *HeapFile, pageNo int) []*rowChange {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	var changes []*rowChange
+	for _, c := range ct.changes[tid] {
+		if c.kind == slotChange && c.file == file && c.pageNo == pageNo {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// Return true if a running transaction other than tid has changed page pageNo
+// of file.
+func (ct *rowChangeTable) changedByOthers(file *HeapFile, pageNo int, tid TransactionID) bool {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	for other, changes := range ct.changes {
+		if other == tid {
+			continue
+		}
+		for _, c := range changes {
+			if c.file == file && c.pageNo == pageNo {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Return what running transactions other than tid need to undo their changes
+// to page pageNo of file: the slots they changed, with the first record each
+// slot held before they changed it (nil if it was free), and whether they
+// changed the page as a whole, in which case nothing may be inserted into it.
+// An insert into the page on behalf of tid must not use these slots, and must
+// leave room for their records to be stored back.
+func (ct *rowChangeTable) reserved(file *HeapFile, pageNo int, tid TransactionID) (slots map[recordID][]byte, whole bool) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	slots = make(map[recordID][]byte)
+	for other, changes := range ct.changes {
+		if other == tid {
+			continue
+		}
+		for _, c := range changes {
+			if c.file != file || c.pageNo != pageNo {
+				continue
+			}
+			if c.kind == pageChange {
+				whole = true
+			} else if _, ok := slots[c.rid]; !ok {
+				slots[c.rid] = c.before
+			}
+		}
+	}
+	return slots, whole
+}
+
+// Forget the changes of tid, which has ended.
+func (ct *rowChangeTable) forget(tid TransactionID) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	delete(ct.changes, tid)
+}
+
+// Apply change, which changes the slot rid of hp, a page of the file, on behalf
+// of tid, or inserts a record into a free slot if rid is nil, and return the
+// slot it changed. If the BufferPool uses row locking, the change is recorded
+// so that it can be undone (see [rowChangeTable]). hp is marked as dirtied by
+// tid.
+func (f *HeapFile) changeSlot(hp *heapPage, rid recordID, tid TransactionID, change func() (recordID, error)) (recordID, error) {
+	var before []byte
+	if rid != nil && f.bufPool.rowLocking {
+		before = hp.recordAt(rid)
+	}
+	changed, err := change()
+	if err != nil {
+		return nil, err
+	}
+	hp.setDirty(tid, true)
+	if f.bufPool.rowLocking {
+		c := &rowChange{kind: slotChange, file: f, pageNo: changed.(heapRid).pageNo, rid: changed, before: before, after: hp.recordAt(changed)}
+		f.bufPool.changes.add(tid, c)
+	}
+	return changed, nil
+}
+
+// Apply change, which changes hp, overflow page pageNo of the file, as a whole
+// on behalf of tid. If the BufferPool uses row locking, the image of the page
+// before the change is recorded so that it can be undone (see
+// [rowChangeTable]). hp is marked as dirtied by tid.
+func (f *HeapFile) changePage(hp *heapPage, pageNo int, tid TransactionID, change func() error) error {
+	var before []byte
+	if f.bufPool.rowLocking {
+		buf, err := hp.toBuffer()
+		if err != nil {
+			return err
+		}
+		before = buf.Bytes()
+	}
+	if err := change(); err != nil {
+		return err
+	}
+	hp.setDirty(tid, true)
+	if f.bufPool.rowLocking {
+		f.bufPool.changes.add(tid, &rowChange{kind: pageChange, file: f, pageNo: pageNo, before: before})
+	}
+	return nil
+}
+
+// Return a new page pageNo of the file holding image, or an empty page if
+// image is nil.
+func (f *HeapFile) pageFromImage(pageNo int, image []byte) (*heapPage, error) {
+	hp, err := newHeapPage(f.Descriptor(), pageNo, f)
+	if err != nil || image == nil {
+		return hp, err
+	}
+	return hp, hp.initFromBuffer(bytes.NewBuffer(image))
+}
+
+// Undo the changes tid made after the specified mark of its row change table
+// (see [rowChangeTable.mark]), newest first, and forget them. The pages are
+// retrieved on behalf of tid, which already holds their locks. Heap pages stay
+// dirty, and their room is marked as unknown in the free space maps of their
+// files; their before images and LSNs are unchanged, since they describe the
+// committed state of the pages. Entry changes are undone without locking the
+// leaves again or recording new changes.
+func (bp *BufferPool) undoChanges(tid TransactionID, mark int) error {
+	changes := bp.changes.takeSince(tid, mark)
+	for i := len(changes) - 1; i >= 0; i-- {
+		c := changes[i]
+		switch c.kind {
+		case entryInsert:
+			if err := c.index.deleteEntry(c.entry, tid, false); err != nil {
+				return err
+			}
+			continue
+		case entryDelete:
+			if err := c.index.insertEntry(c.entry, tid, false); err != nil {
+				return err
+			}
+			continue
+		}
+		pg, err := bp.GetPage(c.file, c.pageNo, tid, WritePerm)
+		if err != nil {
+			return err
+		}
+		hp := pg.(*heapPage)
+		if c.kind == slotChange {
+			err = hp.setRecord(c.rid, c.before)
+		} else {
+			beforeImage, lsn := hp.beforeImage, hp.lsn
+			err = hp.initFromBuffer(bytes.NewBuffer(c.before))
+			hp.beforeImage, hp.lsn = beforeImage, lsn
+		}
+		if err != nil {
+			return err
+		}
+		hp.setDirty(tid, true)
+		c.file.forgetFreeSpace(c.pageNo)
+	}
+	return nil
+}
+
+// Return the page [BufferPool.CommitTransaction] logs or writes for hp, page
+// pageNo of file, when tid commits: hp itself, unless other running
+// transactions have changes on it, in which case a copy of its before image
+// (see [heapPage]) with the slot changes of tid applied. Either way the page
+// returned holds exactly the committed changes, and its contents become the
+// new before image of hp.
+func (bp *BufferPool) commitPage(file *HeapFile, pageNo int, hp *heapPage, tid TransactionID) (*heapPage, error) {
+	if !bp.rowLocking || !bp.changes.changedByOthers(file, pageNo, tid) {
+		return hp, nil
+	}
+	committed, err := file.pageFromImage(pageNo, hp.beforeImage)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range bp.changes.slotChanges(tid, file, pageNo) {
+		if err := committed.setRecord(c.rid, c.after); err != nil {
+			return nil, err
+		}
+	}
+	return committed, nil
+}
@@ -0,0 +1,105 @@
+package godb
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Two transactions changing different slots of the same page: each keeps its
+// own changes, rolling one back to a mark only takes its later changes, and
+// the slots of each are reserved for the other until it ends.
+func TestRowChangeTable(t *testing.T) {
+	var ct rowChangeTable
+	file, other := &HeapFile{}, &HeapFile{}
+	t1, t2 := NewTID(), NewTID()
+	slot := func(tid TransactionID, f *HeapFile, s int, before, after string) {
+		c := &rowChange{kind: slotChange, file: f, pageNo: 0, rid: heapRid{0, s}}
+		if before != "" {
+			c.before = []byte(before)
+		}
+		if after != "" {
+			c.after = []byte(after)
+		}
+		ct.add(tid, c)
+	}
+
+	slot(t1, file, 0, "", "a")
+	mark := ct.mark(t1)
+	slot(t1, file, 1, "b", "")
+	slot(t1, file, 1, "", "c")
+	slot(t2, file, 2, "d", "e")
+	slot(t2, other, 3, "f", "")
+	if mark != 1 || ct.mark(t2) != 2 {
+		t.Fatalf("expected marks 1 and 2, got %d and %d", mark, ct.mark(t2))
+	}
+	if n := len(ct.slotChanges(t1, file, 0)); n != 3 {
+		t.Errorf("expected 3 changes of t1 on the page, got %d", n)
+	}
+	if !ct.changedByOthers(file, 0, t1) || !ct.changedByOthers(file, 0, t2) || ct.changedByOthers(other, 0, t2) {
+		t.Errorf("unexpected transactions changing the pages")
+	}
+
+	slots, whole := ct.reserved(file, 0, t2)
+	if whole || len(slots) != 2 {
+		t.Fatalf("expected the two slots of t1 to be reserved for t2, got %v", slots)
+	}
+	if slots[heapRid{0, 0}] != nil || !bytes.Equal(slots[heapRid{0, 1}], []byte("b")) {
+		t.Errorf("expected the records the slots held before t1 changed them, got %q", slots)
+	}
+	if slots, _ := ct.reserved(file, 0, t1); len(slots) != 1 || slots[heapRid{0, 2}] == nil {
+		t.Errorf("expected the slot of t2 to be reserved for t1, got %v", slots)
+	}
+
+	taken := ct.takeSince(t1, mark)
+	if len(taken) != 2 || string(taken[0].before) != "b" || string(taken[1].after) != "c" {
+		t.Fatalf("expected the two changes after the mark, oldest first")
+	}
+	if ct.mark(t1) != mark || len(ct.takeSince(t1, mark)) != 0 {
+		t.Errorf("expected the changes after the mark to be forgotten")
+	}
+	if slots, _ := ct.reserved(file, 0, t2); len(slots) != 1 {
+		t.Errorf("expected only the first slot of t1 to stay reserved, got %v", slots)
+	}
+
+	ct.add(t1, &rowChange{kind: pageChange, file: other, pageNo: 1, before: []byte("page")})
+	if _, whole := ct.reserved(other, 1, t2); !whole {
+		t.Errorf("expected a page changed as a whole to be reserved")
+	}
+	if n := len(ct.slotChanges(t1, other, 1)); n != 0 {
+		t.Errorf("expected a page change not to be a slot change, got %d", n)
+	}
+
+	ct.forget(t1)
+	if slots, whole := ct.reserved(file, 0, t2); whole || len(slots) != 0 {
+		t.Errorf("expected nothing to be reserved once t1 ends, got %v", slots)
+	}
+	if ct.changedByOthers(file, 0, t2) || ct.mark(t2) != 2 {
+		t.Errorf("expected only the changes of t1 to be forgotten")
+	}
+}
+
+// Changes to the entries of a B+ tree are recorded in the order they were
+// made, but do not reserve anything on heap pages.
+func TestRowChangeTableEntries(t *testing.T) {
+	var ct rowChangeTable
+	file, index := &HeapFile{}, &BTreeFile{}
+	t1, t2 := NewTID(), NewTID()
+	ct.add(t1, &rowChange{kind: entryInsert, index: index, entry: btreeEntry{rid: heapRid{0, 0}}})
+	mark := ct.mark(t1)
+	ct.add(t1, &rowChange{kind: slotChange, file: file, pageNo: 0, rid: heapRid{0, 0}, after: []byte("a")})
+	ct.add(t1, &rowChange{kind: entryDelete, index: index, entry: btreeEntry{rid: heapRid{0, 1}}})
+
+	if slots, whole := ct.reserved(file, 0, t2); whole || len(slots) != 1 {
+		t.Errorf("expected only the slot change to be reserved, got %v", slots)
+	}
+	if n := len(ct.slotChanges(t1, file, 0)); n != 1 {
+		t.Errorf("expected 1 slot change, got %d", n)
+	}
+	taken := ct.takeSince(t1, mark)
+	if len(taken) != 2 || taken[1].kind != entryDelete || taken[1].index != index {
+		t.Fatalf("expected the slot change and the entry delete after the mark")
+	}
+	if ct.mark(t1) != 1 {
+		t.Errorf("expected the entry insert to be kept")
+	}
+}
@@ -0,0 +1,233 @@
+package godb
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+/*
+A savepoint marks a point in a running transaction that the transaction can
+later roll back to, undoing the changes it made after the savepoint but
+keeping the changes it made before, as well as all of its locks:
+
+	SAVEPOINT name
+	ROLLBACK TO [SAVEPOINT] name
+	RELEASE [SAVEPOINT] name
+
+Rolling back to a savepoint destroys the savepoints established after it, but
+keeps the savepoint itself, so a transaction can roll back to it again.
+Releasing a savepoint destroys it and the savepoints established after it,
+keeping the changes made since. Establishing a savepoint with the name of an
+existing savepoint replaces it. Savepoints are destroyed when the transaction
+commits or aborts.
+
+If the BufferPool has a log file, a savepoint is the LSN of the last record
+the transaction logged when the savepoint was established; the pages the
+transaction dirtied are logged at that point, so that rolling back to the
+savepoint rolls back the log records written after it (see
+[LogFile.rollback]). Otherwise, the BufferPool never evicts dirty pages, so a
+savepoint is a copy of the pages the transaction dirtied, which rolling back
+restores. Other transactions may have changes on the B+ tree nodes the
+transaction dirtied, and, if the BufferPool uses row locking, on its heap
+pages, so a savepoint also marks the changes the transaction has made to
+them, and rolling back undoes the changes made after the mark rather than
+restoring the pages (see [rowChangeTable]).
+*/
+
+type savepoint struct {
+	name    string
+	lsn     LSN                 // last record tid logged, if the BufferPool has a log file
+	pages   map[heapHash][]byte // images of the pages tid dirtied, otherwise
+	changes int                 // mark of the row changes of tid
+}
+
+// The savepoints of each running transaction, oldest first. The zero value is
+// ready to use.
+type savepointTable struct {
+	mu         sync.Mutex
+	savepoints map[TransactionID][]*savepoint
+}
+
+// Return the position of the savepoint of tid with the specified name, or -1
+// if there is none. Must be called with st.mu held.
+func (st *savepointTable) findLocked(tid TransactionID, name string) int {
+	for i, sp := range st.savepoints[tid] {
+		if sp.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Add sp as the latest savepoint of tid, replacing any savepoint with the same
+// name.
+func (st *savepointTable) add(tid TransactionID, sp *savepoint) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.savepoints == nil {
+		st.savepoints = make(map[TransactionID][]*savepoint)
+	}
+	sps := st.savepoints[tid]
+	if i := st.findLocked(tid, sp.name); i >= 0 {
+		sps = append(sps[:i:i], sps[i+1:]...)
+	}
+	st.savepoints[tid] = append(sps, sp)
+}
+
+// Destroy the savepoints of tid established after the savepoint with the
+// specified name and return it; if release is set, destroy the savepoint too.
+// Returns an error if tid has no such savepoint.
+func (st *savepointTable) truncate(tid TransactionID, name string, release bool) (*savepoint, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	i := st.findLocked(tid, name)
+	if i < 0 {
+		return nil, GoDBError{IllegalOperationError, fmt.Sprintf("savepoint %s does not exist", name)}
+	}
+	sps := st.savepoints[tid]
+	sp := sps[i]
+	if release {
+		st.savepoints[tid] = sps[:i]
+	} else {
+		st.savepoints[tid] = sps[:i+1]
+	}
+	return sp, nil
+}
+
+// Forget the savepoints of tid, which has ended.
+func (st *savepointTable) forget(tid TransactionID) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.savepoints, tid)
+}
+
+// Establish a savepoint with the specified name in the transaction tid.
+func (bp *BufferPool) Savepoint(tid TransactionID, name string) error {
+	pages, err := bp.savepointPages(tid)
+	if err != nil {
+		return err
+	}
+	sp := &savepoint{name: name, lsn: InvalidLSN, pages: pages, changes: bp.changes.mark(tid)}
+	if bp.logFile != nil {
+		sp.lsn = bp.logFile.lastLSNOf(tid)
+	}
+	bp.savepoints.add(tid, sp)
+	return nil
+}
+
+// Undo the changes tid made after the savepoint with the specified name was
+// established, keeping its locks, and destroy the savepoints established
+// after it.
+func (bp *BufferPool) RollbackToSavepoint(tid TransactionID, name string) error {
+	sp, err := bp.savepoints.truncate(tid, name, false)
+	if err != nil {
+		return err
+	}
+	if err := bp.undoChanges(tid, sp.changes); err != nil {
+		return err
+	}
+	if bp.logFile == nil {
+		bp.restoreDirtyPages(tid, sp.pages)
+		return nil
+	}
+	bp.restoreDirtyPages(tid, nil)
+	pages, err := bp.logFile.rollback(tid, sp.lsn)
+	if err != nil {
+		return err
+	}
+	bp.discardPages(pages)
+	return nil
+}
+
+// Destroy the savepoint of tid with the specified name, and the savepoints
+// established after it, keeping the changes made since.
+func (bp *BufferPool) ReleaseSavepoint(tid TransactionID, name string) error {
+	_, err := bp.savepoints.truncate(tid, name, true)
+	return err
+}
+
+// The savepoint statements.
+type SavepointAction int
+
+const (
+	SavepointEstablish SavepointAction = iota // SAVEPOINT name
+	SavepointRollback  SavepointAction = iota // ROLLBACK TO [SAVEPOINT] name
+	SavepointRelease   SavepointAction = iota // RELEASE [SAVEPOINT] name
+)
+
+func (a SavepointAction) String() string {
+	switch a {
+	case SavepointEstablish:
+		return "SAVEPOINT"
+	case SavepointRollback:
+		return "ROLLBACK TO SAVEPOINT"
+	case SavepointRelease:
+		return "RELEASE SAVEPOINT"
+	}
+	return "UNKNOWN"
+}
+
+// An operator that executes a savepoint statement.
+type SavepointOp struct {
+	bp     *BufferPool
+	action SavepointAction
+	name   string
+}
+
+var savepointStatement = regexp.MustCompile(`(?i)^\s*(savepoint|rollback\s+to(?:\s+savepoint)?|release(?:\s+savepoint)?)\s+(\w+)\s*;?\s*$`)
+
+// Parse a savepoint statement, which the sqlparser grammar does not support.
+// Returns nil if query is not one.
+func parseSavepoint(c *Catalog, query string) *SavepointOp {
+	m := savepointStatement.FindStringSubmatch(query)
+	if m == nil {
+		return nil
+	}
+	var bp *BufferPool
+	if c != nil {
+		bp = c.bufferPool
+	}
+	action := SavepointEstablish
+	switch strings.ToLower(m[1][:3]) {
+	case "rol":
+		action = SavepointRollback
+	case "rel":
+		action = SavepointRelease
+	}
+	return &SavepointOp{bp, action, strings.ToLower(m[2])}
+}
+
+// Savepoint statements return no tuples.
+func (s *SavepointOp) Descriptor() *TupleDesc {
+	return &TupleDesc{}
+}
+
+// Execute the statement in the transaction tid, returning an iterator that
+// returns no tuples.
+func (s *SavepointOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	if s.bp == nil {
+		return nil, GoDBError{IllegalOperationError, "savepoint statement has no buffer pool"}
+	}
+	var err error
+	switch s.action {
+	case SavepointEstablish:
+		err = s.bp.Savepoint(tid, s.name)
+	case SavepointRollback:
+		err = s.bp.RollbackToSavepoint(tid, s.name)
+	case SavepointRelease:
+		err = s.bp.ReleaseSavepoint(tid, s.name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() (*Tuple, error) {
+		return nil, nil
+	}, nil
+}
+
+// Return the statement, e.g. "ROLLBACK TO SAVEPOINT a".
+func (s *SavepointOp) String() string {
+	return fmt.Sprintf("%s %s", s.action, s.name)
+}
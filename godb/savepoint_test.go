@@ -0,0 +1,165 @@
+package godb
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Establish a savepoint of tid as [BufferPool.Savepoint] does with a log file,
+// returning its LSN.
+func (h *crashHarness) savepoint(tid TransactionID) LSN {
+	for _, pageNo := range h.pagesOf(tid) {
+		h.logPage(tid, pageNo)
+		h.cache[pageNo].before = h.cache[pageNo].data
+	}
+	return h.lf.lastLSNOf(tid)
+}
+
+// Roll tid back to the savepoint with the specified LSN, as
+// [BufferPool.RollbackToSavepoint] does with a log file.
+func (h *crashHarness) rollbackTo(tid TransactionID, lsn LSN) {
+	for _, pageNo := range h.pagesOf(tid) {
+		p := h.cache[pageNo]
+		if p.before == nil {
+			delete(h.cache, pageNo)
+			continue
+		}
+		p.data = p.before
+		p.dirtiedBy = -1
+	}
+	restored, err := h.lf.rollback(tid, lsn)
+	h.check(err)
+	for _, key := range restored {
+		delete(h.cache, key.PageNo)
+	}
+}
+
+// Check the contents of the pages as the transactions see them, i.e., read
+// through the cache.
+func (h *crashHarness) expectPages(desc string, expected map[int]byte) {
+	for pageNo, contents := range expected {
+		if data := h.getPage(pageNo).data; !bytes.Equal(data, testPageImage(contents)) {
+			h.t.Errorf("%s: page %d should be %d, is %d", desc, pageNo, contents, data[0])
+		}
+	}
+}
+
+// Rolling back to a savepoint under STEAL must undo the changes made after the
+// savepoint, whether the pages were stolen or are still cached, and keep the
+// changes made before it.
+func TestSavepointRollbackStolenPages(t *testing.T) {
+	h := newCrashHarness(t, true, -1, 4)
+	tid := NewTID()
+	h.begin(tid)
+	h.update(tid, 0, 10)
+	h.update(tid, 1, 11)
+	h.evict(1) // stolen before the savepoint
+	sp := h.savepoint(tid)
+
+	h.update(tid, 0, 20)
+	h.update(tid, 2, 22)
+	h.evict(2) // stolen after the savepoint
+	h.update(tid, 1, 21)
+	h.update(tid, 3, 23)
+	h.evict(3)
+	h.update(tid, 3, 33) // stolen, read again, and updated again
+	h.rollbackTo(tid, sp)
+	h.expectPages("after rollback to savepoint", map[int]byte{0: 10, 1: 11, 2: 102, 3: 103})
+
+	// the transaction can go on after rolling back, and roll back again
+	h.update(tid, 2, 42)
+	h.evict(2)
+	h.rollbackTo(tid, sp)
+	h.expectPages("after second rollback to savepoint", map[int]byte{0: 10, 1: 11, 2: 102, 3: 103})
+
+	// aborting rolls back the changes made before the savepoint too
+	h.abort(tid)
+	h.expectPages("after abort", map[int]byte{0: 100, 1: 101, 2: 102, 3: 103})
+}
+
+// Recovery of a transaction that rolled back to a savepoint and committed
+// must keep exactly the changes made before the savepoint.
+func TestSavepointRecovery(t *testing.T) {
+	h := newCrashHarness(t, true, -1, 3)
+	tid := NewTID()
+	h.begin(tid)
+	h.update(tid, 0, 10)
+	sp := h.savepoint(tid)
+	h.update(tid, 0, 20)
+	h.update(tid, 1, 21)
+	h.evict(0)
+	h.evict(1)
+	h.rollbackTo(tid, sp)
+	h.written[tid] = map[int][]byte{0: testPageImage(10)}
+	h.commit(tid)
+	h.recoverAndVerify("after commit")
+}
+
+func TestSavepointTable(t *testing.T) {
+	var st savepointTable
+	tid := NewTID()
+	for _, name := range []string{"a", "b", "c", "b"} {
+		st.add(tid, &savepoint{name: name})
+	}
+	names := func() []string {
+		var names []string
+		for _, sp := range st.savepoints[tid] {
+			names = append(names, sp.name)
+		}
+		return names
+	}
+	if got := names(); len(got) != 3 || got[2] != "b" {
+		t.Fatalf("expected a savepoint with an existing name to replace it, have %v", got)
+	}
+	if sp, err := st.truncate(tid, "c", false); err != nil || sp.name != "c" || len(names()) != 2 {
+		t.Errorf("rolling back to c should keep a and c, have %v (%v)", names(), err)
+	}
+	if _, err := st.truncate(tid, "b", false); err == nil {
+		t.Errorf("savepoints established after the savepoint rolled back to should be destroyed")
+	}
+	if _, err := st.truncate(tid, "a", true); err != nil || len(names()) != 0 {
+		t.Errorf("releasing a should destroy every savepoint, have %v (%v)", names(), err)
+	}
+	st.add(tid, &savepoint{name: "a"})
+	st.forget(tid)
+	if len(st.savepoints) != 0 {
+		t.Errorf("expected the savepoints to be forgotten")
+	}
+}
+
+func TestSavepointParse(t *testing.T) {
+	bp := &BufferPool{}
+	c := &Catalog{bufferPool: bp}
+	statements := map[string]string{
+		"savepoint a":                  "SAVEPOINT a",
+		"SAVEPOINT Batch_1;":           "SAVEPOINT batch_1",
+		"rollback to savepoint a":      "ROLLBACK TO SAVEPOINT a",
+		"ROLLBACK TO a":                "ROLLBACK TO SAVEPOINT a",
+		"release savepoint a":          "RELEASE SAVEPOINT a",
+		"release a ;":                  "RELEASE SAVEPOINT a",
+		"  rollback   to  savepoint b": "ROLLBACK TO SAVEPOINT b",
+	}
+	for query, expected := range statements {
+		qtype, op, err := Parse(c, query)
+		if err != nil || qtype != SavepointQueryType {
+			t.Fatalf("%q: expected SavepointQueryType, got %v (%v)", query, qtype, err)
+		}
+		if s := op.(*SavepointOp).String(); s != expected {
+			t.Errorf("%q: expected %q, got %q", query, expected, s)
+		}
+	}
+	for _, query := range []string{"savepoint", "savepoint a b", "rollback to", "release"} {
+		if qtype, _, err := Parse(c, query); err == nil && qtype == SavepointQueryType {
+			t.Errorf("%q: expected an error", query)
+		}
+	}
+	if qtype, _, err := Parse(c, "rollback"); err != nil || qtype != AbortXactionType {
+		t.Errorf("expected ROLLBACK to still abort, got %v (%v)", qtype, err)
+	}
+
+	tid := NewTID()
+	_, op, _ := Parse(c, "release savepoint missing")
+	if _, err := op.Iterator(tid); err == nil {
+		t.Errorf("expected releasing a missing savepoint to fail")
+	}
+}
@@ -0,0 +1,49 @@
+package godb
+
+import "fmt"
+
+// Methods to apply the settings of a SET statement (e.g., SET TRANSACTION
+// ISOLATION LEVEL READ COMMITTED) to the transactions of a BufferPool.
+
+type SetOp struct {
+	bp     *BufferPool
+	update func(s *txnSettings)
+	desc   string // the settings, for display
+}
+
+// Construct an operator that changes the settings of transactions of bp with
+// update.
+func newSetOp(bp *BufferPool, desc string, update func(s *txnSettings)) *SetOp {
+	return &SetOp{bp, update, desc}
+}
+
+// SET statements return no tuples.
+func (s *SetOp) Descriptor() *TupleDesc {
+	return &TupleDesc{}
+}
+
+// Apply the settings to the transaction tid, returning an iterator that
+// returns no tuples.
+func (s *SetOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	if s.bp == nil {
+		return nil, GoDBError{IllegalOperationError, "SET statement has no buffer pool"}
+	}
+	s.bp.settings.update(tid, s.update)
+	return func() (*Tuple, error) {
+		return nil, nil
+	}, nil
+}
+
+// Apply the settings to every transaction whose settings have not been
+// changed, rather than to a single transaction.
+func (s *SetOp) SetDefaults() error {
+	if s.bp == nil {
+		return GoDBError{IllegalOperationError, "SET statement has no buffer pool"}
+	}
+	s.bp.settings.update(InvalidTID, s.update)
+	return nil
+}
+
+func (s *SetOp) String() string {
+	return fmt.Sprintf("SET %s", s.desc)
+}
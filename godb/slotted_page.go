@@ -0,0 +1,241 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+A slotted page stores variable-length records, and is used by the pages of
+heap files that have variable-length columns (see [HeapFile.isVarlen]). The
+page starts with a header made of
+
+  - the 32 bit integer slottedPageMagic, which distinguishes slotted pages
+    from fixed-length heap pages, whose header starts with their number of
+    slots: read as a signed integer, the magic number is negative;
+  - the number of slots, as a 16 bit integer;
+  - the offset of the first byte of record data, as a 16 bit integer.
+
+The header is followed by the slot directory, which holds the offset and
+length of the record of each slot as two 16 bit integers; a free slot has
+offset 0. Records are packed at the end of the page, so the directory and the
+records grow towards each other and the free space of the page is between
+them. All integers are little endian.
+
+A record is identified by its slot number, which does not change when other
+records are inserted or deleted, so slot numbers can be used as record ids.
+Free slots are reused by later inserts; free slots at the end of the
+directory are removed from it.
+
+Overflow pages (see [HeapFile.toast]) are slotted pages that start with
+overflowPageMagic instead of slottedPageMagic; they hold a single record,
+which is a chunk of a value stored out of line rather than a tuple.
+
+Slotted pages are PageSize bytes long, except for the pages of compressed
+files, other than overflow pages, which are compressedPageSize bytes long
+before they are compressed (see [compressPage]).
+*/
+
+const (
+	slottedPageMagic      uint32 = 0xF510F510 // never a valid number of fixed-length slots
+	overflowPageMagic     uint32 = 0xF510F0F0
+	slottedPageHeaderSize int    = 8
+	slotEntrySize         int    = 4
+)
+
+type slottedPage struct {
+	records  [][]byte // the record of each slot, nil if the slot is free
+	used     int      // bytes used by the header, the directory and the records
+	overflow bool     // true for overflow pages
+	size     int      // the size of the page, PageSize or compressedPageSize
+}
+
+// The largest record that fits in an empty slotted page.
+const maxSlottedRecordSize = PageSize - slottedPageHeaderSize - slotEntrySize
+
+// Create an empty slotted page of PageSize bytes.
+func newSlottedPage() *slottedPage {
+	return newSlottedPageOfSize(PageSize)
+}
+
+// Create an empty slotted page of the specified size, which is at most
+// compressedPageSize bytes.
+func newSlottedPageOfSize(size int) *slottedPage {
+	return &slottedPage{used: slottedPageHeaderSize, size: size}
+}
+
+// Create an empty overflow page.
+func newOverflowPage() *slottedPage {
+	return &slottedPage{used: slottedPageHeaderSize, overflow: true, size: PageSize}
+}
+
+// Return the number of slots in the directory, including free ones.
+func (p *slottedPage) numSlots() int {
+	return len(p.records)
+}
+
+// Return the number of slots that hold a record.
+func (p *slottedPage) numRecords() int {
+	n := 0
+	for _, rec := range p.records {
+		if rec != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Return the position of the first free slot, or numSlots() if there is none.
+func (p *slottedPage) freeSlot() int {
+	for i, rec := range p.records {
+		if rec == nil {
+			return i
+		}
+	}
+	return len(p.records)
+}
+
+// Return the number of bytes available to a new record, taking into account
+// the directory entry it may need.
+func (p *slottedPage) freeSpace() int {
+	free := p.size - p.used
+	if p.freeSlot() == len(p.records) {
+		free -= slotEntrySize
+	}
+	if free < 0 {
+		return 0
+	}
+	return free
+}
+
+// Insert a copy of rec into a free slot, returning the slot. Returns a
+// PageFullError if the page does not have enough free space.
+func (p *slottedPage) insert(rec []byte) (int, error) {
+	if len(rec) > p.freeSpace() {
+		return 0, GoDBError{PageFullError, fmt.Sprintf("record of %d bytes does not fit in page with %d free bytes", len(rec), p.freeSpace())}
+	}
+	slot := p.freeSlot()
+	if slot == len(p.records) {
+		p.records = append(p.records, nil)
+		p.used += slotEntrySize
+	}
+	p.records[slot] = append(make([]byte, 0, len(rec)), rec...)
+	p.used += len(rec)
+	return slot, nil
+}
+
+// Return the record in the specified slot, or a TupleNotFoundError if the slot
+// is free or does not exist. The record must not be modified.
+func (p *slottedPage) get(slot int) ([]byte, error) {
+	if slot < 0 || slot >= len(p.records) || p.records[slot] == nil {
+		return nil, GoDBError{TupleNotFoundError, fmt.Sprintf("no record in slot %d", slot)}
+	}
+	return p.records[slot], nil
+}
+
+// Replace the record in the specified slot with a copy of rec. Returns a
+// PageFullError if the page does not have enough free space for the new
+// record, in which case the page is unchanged.
+func (p *slottedPage) update(slot int, rec []byte) error {
+	old, err := p.get(slot)
+	if err != nil {
+		return err
+	}
+	if p.used-len(old)+len(rec) > p.size {
+		return GoDBError{PageFullError, fmt.Sprintf("record of %d bytes does not fit in slot %d", len(rec), slot)}
+	}
+	p.records[slot] = append(make([]byte, 0, len(rec)), rec...)
+	p.used += len(rec) - len(old)
+	return nil
+}
+
+// Free the specified slot, or return a TupleNotFoundError if it is free or
+// does not exist.
+func (p *slottedPage) delete(slot int) error {
+	rec, err := p.get(slot)
+	if err != nil {
+		return err
+	}
+	p.records[slot] = nil
+	p.used -= len(rec)
+	for len(p.records) > 0 && p.records[len(p.records)-1] == nil {
+		p.records = p.records[:len(p.records)-1]
+		p.used -= slotEntrySize
+	}
+	return nil
+}
+
+// Write the page to b, in exactly the size of the page.
+func (p *slottedPage) writeTo(b *bytes.Buffer) error {
+	if p.used > p.size {
+		return GoDBError{PageFullError, fmt.Sprintf("slotted page uses %d bytes", p.used)}
+	}
+	page := make([]byte, p.size)
+	magic := slottedPageMagic
+	if p.overflow {
+		magic = overflowPageMagic
+	}
+	binary.LittleEndian.PutUint32(page[0:], magic)
+	binary.LittleEndian.PutUint16(page[4:], uint16(len(p.records)))
+	end := p.size
+	for i, rec := range p.records {
+		entry := page[slottedPageHeaderSize+i*slotEntrySize:]
+		if rec == nil {
+			continue
+		}
+		end -= len(rec)
+		copy(page[end:], rec)
+		binary.LittleEndian.PutUint16(entry[0:], uint16(end))
+		binary.LittleEndian.PutUint16(entry[2:], uint16(len(rec)))
+	}
+	binary.LittleEndian.PutUint16(page[6:], uint16(end))
+	_, err := b.Write(page)
+	return err
+}
+
+// Return true if buf holds a slotted page (possibly an overflow page) rather
+// than a fixed-length heap page.
+func isSlottedPage(buf []byte) bool {
+	if len(buf) < 4 {
+		return false
+	}
+	magic := binary.LittleEndian.Uint32(buf)
+	return magic == slottedPageMagic || magic == overflowPageMagic
+}
+
+// Read a slotted page of PageSize bytes written by [slottedPage.writeTo] from
+// buf. Returns a MalformedDataError if buf does not hold a valid slotted page.
+func readSlottedPage(buf []byte) (*slottedPage, error) {
+	return readSlottedPageOfSize(buf, PageSize)
+}
+
+// Read a slotted page of the specified size, which is at most
+// compressedPageSize bytes, from buf, as [readSlottedPage] does.
+func readSlottedPageOfSize(buf []byte, size int) (*slottedPage, error) {
+	if len(buf) < size || !isSlottedPage(buf) {
+		return nil, GoDBError{MalformedDataError, "not a slotted page"}
+	}
+	n := int(binary.LittleEndian.Uint16(buf[4:]))
+	dataStart := int(binary.LittleEndian.Uint16(buf[6:]))
+	dirEnd := slottedPageHeaderSize + n*slotEntrySize
+	if dirEnd > dataStart || dataStart > size {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("slotted page with %d slots has data at offset %d", n, dataStart)}
+	}
+	p := &slottedPage{records: make([][]byte, n), used: dirEnd, size: size}
+	p.overflow = binary.LittleEndian.Uint32(buf) == overflowPageMagic
+	for i := 0; i < n; i++ {
+		entry := buf[slottedPageHeaderSize+i*slotEntrySize:]
+		offset := int(binary.LittleEndian.Uint16(entry[0:]))
+		length := int(binary.LittleEndian.Uint16(entry[2:]))
+		if offset == 0 {
+			continue
+		}
+		if offset < dataStart || offset+length > size {
+			return nil, GoDBError{MalformedDataError, fmt.Sprintf("slot %d has record at offset %d of length %d", i, offset, length)}
+		}
+		p.records[i] = append(make([]byte, 0, length), buf[offset:offset+length]...)
+		p.used += length
+	}
+	return p, nil
+}
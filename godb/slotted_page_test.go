@@ -0,0 +1,121 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestSlottedPageInsertDelete(t *testing.T) {
+	p := newSlottedPage()
+	recs := [][]byte{[]byte("a"), []byte(strings.Repeat("b", 100)), {}, []byte("dd")}
+	for i, rec := range recs {
+		slot, err := p.insert(rec)
+		if err != nil || slot != i {
+			t.Fatalf("expected record %d in slot %d, got %d (%v)", i, i, slot, err)
+		}
+	}
+	if err := p.delete(1); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := p.get(1); err == nil {
+		t.Errorf("expected deleted slot to be free")
+	}
+	if rec, err := p.get(3); err != nil || string(rec) != "dd" {
+		t.Errorf("deleting a record should not move the others, slot 3 has %q (%v)", rec, err)
+	}
+	if slot, _ := p.insert([]byte("e")); slot != 1 {
+		t.Errorf("expected the free slot to be reused, got slot %d", slot)
+	}
+	// free slots at the end of the directory are removed
+	p.delete(3)
+	p.delete(2)
+	if p.numSlots() != 2 || p.numRecords() != 2 {
+		t.Errorf("expected 2 slots, have %d with %d records", p.numSlots(), p.numRecords())
+	}
+	if p.used != slottedPageHeaderSize+2*slotEntrySize+2 {
+		t.Errorf("unexpected space used %d", p.used)
+	}
+}
+
+func TestSlottedPageFull(t *testing.T) {
+	p := newSlottedPage()
+	rec := bytes.Repeat([]byte{7}, 96)
+	n := 0
+	for ; ; n++ {
+		if _, err := p.insert(rec); err != nil {
+			if gerr, ok := err.(GoDBError); !ok || gerr.code != PageFullError {
+				t.Fatalf("expected a PageFullError, got %v", err)
+			}
+			break
+		}
+	}
+	if expected := (PageSize - slottedPageHeaderSize) / (len(rec) + slotEntrySize); n != expected {
+		t.Errorf("expected %d records to fit, got %d", expected, n)
+	}
+	if p.freeSpace() >= len(rec) {
+		t.Errorf("page is full but reports %d free bytes", p.freeSpace())
+	}
+	// a record that fits exactly in the remaining space
+	if _, err := p.insert(make([]byte, p.freeSpace())); err != nil {
+		t.Errorf("expected the record to fit, got %v", err)
+	}
+	if err := p.update(0, bytes.Repeat([]byte{8}, 200)); err == nil {
+		t.Errorf("expected growing a record of a full page to fail")
+	}
+	if err := p.update(0, []byte{8}); err != nil {
+		t.Errorf("shrinking a record should succeed, got %v", err)
+	}
+	if _, err := p.insert(make([]byte, PageSize)); err == nil {
+		t.Errorf("expected a record larger than a page to be rejected")
+	}
+}
+
+func TestSlottedPageSerialization(t *testing.T) {
+	p := newSlottedPage()
+	for _, s := range []string{"one", "", "three", "four"} {
+		p.insert([]byte(s))
+	}
+	p.delete(2)
+	var b bytes.Buffer
+	if err := p.writeTo(&b); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if b.Len() != PageSize {
+		t.Fatalf("expected a page of %d bytes, got %d", PageSize, b.Len())
+	}
+	if !isSlottedPage(b.Bytes()) {
+		t.Fatalf("expected the page to be recognized as slotted")
+	}
+	p2, err := readSlottedPage(b.Bytes())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if p2.used != p.used || p2.numSlots() != 4 {
+		t.Errorf("expected %d bytes in 4 slots, got %d in %d", p.used, p2.used, p2.numSlots())
+	}
+	for slot, s := range []string{"one", "", "", "four"} {
+		rec, err := p2.get(slot)
+		if slot == 2 {
+			if err == nil {
+				t.Errorf("expected slot 2 to be free")
+			}
+		} else if err != nil || string(rec) != s {
+			t.Errorf("slot %d: expected %q, got %q (%v)", slot, s, rec, err)
+		}
+	}
+
+	// fixed-length pages, which start with their number of slots, are not
+	// slotted, and corrupt directories are detected
+	legacy := make([]byte, PageSize)
+	binary.LittleEndian.PutUint32(legacy, 102)
+	if isSlottedPage(legacy) || isSlottedPage(make([]byte, PageSize)) {
+		t.Errorf("fixed-length pages should not be recognized as slotted")
+	}
+	corrupt := b.Bytes()
+	binary.LittleEndian.PutUint16(corrupt[4:], 2000)
+	if _, err := readSlottedPage(corrupt); err == nil {
+		t.Errorf("expected a corrupt slotted page to be rejected")
+	}
+}
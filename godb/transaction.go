@@ -15,4 +15,22 @@ func NewTID() TransactionID {
 	return TransactionID(id)
 }
 
+// Make sure NewTID never returns an id lower than next. Transaction ids are
+// stored in the log and in MVCC version headers, so recovery calls this to
+// avoid reusing the ids of an earlier run of the database.
+func advanceTIDs(next TransactionID) {
+	newTidMutex.Lock()
+	defer newTidMutex.Unlock()
+	if int(next) > nextTid {
+		nextTid = int(next)
+	}
+}
+
+// Return the id the next call to NewTID will return.
+func peekNextTID() TransactionID {
+	newTidMutex.Lock()
+	defer newTidMutex.Unlock()
+	return TransactionID(nextTid)
+}
+
 //var tid TransactionID = NewTID()
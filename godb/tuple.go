@@ -6,7 +6,6 @@ package godb
 import (
 	"bytes"
 	"fmt"
-	"strconv"
 	"strings"
 
 )
@@ -15,9 +14,14 @@ import (
 type DBType int
 
 const (
-	IntType     DBType = iota
-	StringType  DBType = iota
-	UnknownType DBType = iota //used internally, during parsing, because sometimes the type is unknown
+	IntType       DBType = iota
+	StringType    DBType = iota
+	FloatType     DBType = iota
+	BoolType      DBType = iota
+	DateType      DBType = iota
+	TimestampType DBType = iota
+	DecimalType   DBType = iota
+	UnknownType   DBType = iota //used internally, during parsing, because sometimes the type is unknown
 )
 
 func (t DBType) String() string {
@@ -26,6 +30,16 @@ func (t DBType) String() string {
 		return "int"
 	case StringType:
 		return "string"
+	case FloatType:
+		return "float"
+	case BoolType:
+		return "bool"
+	case DateType:
+		return "date"
+	case TimestampType:
+		return "timestamp"
+	case DecimalType:
+		return "decimal"
 	}
 	return "unknown"
 }
@@ -124,6 +138,33 @@ type StringField struct {
 	Value string
 }
 
+// Floating-point field value
+type FloatField struct {
+	Value float64
+}
+
+// Boolean field value
+type BoolField struct {
+	Value bool
+}
+
+// Date field value, as the number of days since 1970-01-01
+type DateField struct {
+	Value int64
+}
+
+// Timestamp field value, as the number of microseconds since
+// 1970-01-01 00:00:00 UTC
+type TimestampField struct {
+	Value int64
+}
+
+// Exact decimal field value, Value / 10^Scale (see [DecimalField.rescale])
+type DecimalField struct {
+	Value int64
+	Scale int
+}
+
 // Tuple represents the contents of a tuple read from a database
 // It includes the tuple descriptor, and the value of the fields
 type Tuple struct {
@@ -132,6 +173,8 @@ type Tuple struct {
 	Rid    recordID //used to track the page and position this page was read from
 }
 
+// A record id must be comparable, as it is used as a key by the lock manager
+// (see [BufferPool.lockTuple]).
 type recordID interface {
 }
 
@@ -147,8 +190,18 @@ type recordID interface {
 // example if StringLength is set to 5, the string 'mit' should be written as
 // 'm', 'i', 't', 0, 0
 //
+// Floats are written as float64, booleans as a single byte that is 0 or 1, and
+// dates and timestamps as int64 (see [DateField] and [TimestampField]), and
+// decimals as their int64 Value followed by their Scale as a single byte; each
+// field takes [DBType.fixedSize] bytes.
+//
 // May return an error if the buffer has insufficient capacity to store the
 // tuple.
+//
+// This is the encoding of fixed-length pages; tuples of files with
+// variable-length or nullable columns are stored with [Tuple.writeVarlenTo]
+// instead. It has no room for NULLs: return a TypeMismatchError if a field is
+// a [NullField].
 func (t *Tuple) writeTo(b *bytes.Buffer) error {
 	// TODO: some code goes here
 	return fmt.Errorf("writeTo not implemented") //replace me
@@ -159,7 +212,8 @@ func (t *Tuple) writeTo(b *bytes.Buffer) error {
 //
 // See [binary.Read]. Objects should be deserialized in little endian oder.
 //
-// All strings are stored as StringLength byte objects.
+// All strings are stored as StringLength byte objects. Other types are stored
+// as described in [Tuple.writeTo].
 //
 // Strings with length < StringLength will be padded with zeros, and these
 // trailing zeros should be removed from the strings.  A []byte can be cast
@@ -211,6 +265,9 @@ const (
 //
 // Note that EvalExpr uses the [Tuple.project] method, so you will need
 // to implement projection before testing compareField.
+//
+// If either value is NULL, compare them with [compareNulls], which orders
+// NULLs after every other value.
 func (t *Tuple) compareField(t2 *Tuple, field Expr) (orderByState, error) {
 	// TODO: some code goes here
 	return OrderedEqual, fmt.Errorf("compareField not implemented") // replace me
@@ -227,10 +284,12 @@ func (t *Tuple) project(fields []FieldType) (*Tuple, error) {
 	return nil, fmt.Errorf("project not implemented")  //replace me
 }
 
-// Compute a key for the tuple to be used in a map structure
+// Compute a key for the tuple to be used in a map structure. Uses the
+// variable-length encoding, which, unlike the fixed-length one, can encode
+// NULLs.
 func (t *Tuple) tupleKey() any {
 	var buf bytes.Buffer
-	t.writeTo(&buf)
+	t.writeVarlenTo(&buf)
 	return buf.String()
 }
 
@@ -279,13 +338,7 @@ func (d *TupleDesc) HeaderString(aligned bool) string {
 func (t *Tuple) PrettyPrintString(aligned bool) string {
 	outstr := ""
 	for i, f := range t.Fields {
-		str := ""
-		switch f := f.(type) {
-		case IntField:
-			str = strconv.FormatInt(f.Value, 10)
-		case StringField:
-			str = f.Value
-		}
+		str := formatValue(f)
 		if aligned {
 			outstr = fmt.Sprintf("%s %s", outstr, fmtCol(str, len(t.Fields)))
 		} else {
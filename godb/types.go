@@ -1,6 +1,7 @@
 package godb
 
 import (
+	"cmp"
 	"fmt"
 	"regexp"
 	"strings"
@@ -9,19 +10,24 @@ import (
 type GoDBErrorCode int
 
 const (
-	TupleNotFoundError      GoDBErrorCode = iota
-	PageFullError           GoDBErrorCode = iota
-	IncompatibleTypesError  GoDBErrorCode = iota
-	TypeMismatchError       GoDBErrorCode = iota
-	MalformedDataError      GoDBErrorCode = iota
-	BufferPoolFullError     GoDBErrorCode = iota
-	ParseError              GoDBErrorCode = iota
-	DuplicateTableError     GoDBErrorCode = iota
-	NoSuchTableError        GoDBErrorCode = iota
-	AmbiguousNameError      GoDBErrorCode = iota
-	IllegalOperationError   GoDBErrorCode = iota
-	DeadlockError           GoDBErrorCode = iota
-	IllegalTransactionError GoDBErrorCode = iota
+	TupleNotFoundError        GoDBErrorCode = iota
+	PageFullError             GoDBErrorCode = iota
+	IncompatibleTypesError    GoDBErrorCode = iota
+	TypeMismatchError         GoDBErrorCode = iota
+	MalformedDataError        GoDBErrorCode = iota
+	BufferPoolFullError       GoDBErrorCode = iota
+	ParseError                GoDBErrorCode = iota
+	DuplicateTableError       GoDBErrorCode = iota
+	NoSuchTableError          GoDBErrorCode = iota
+	AmbiguousNameError        GoDBErrorCode = iota
+	IllegalOperationError     GoDBErrorCode = iota
+	DeadlockError             GoDBErrorCode = iota
+	IllegalTransactionError   GoDBErrorCode = iota
+	SerializationFailureError GoDBErrorCode = iota
+	LockNotAvailableError     GoDBErrorCode = iota
+	NumericOverflowError      GoDBErrorCode = iota
+	DivisionByZeroError       GoDBErrorCode = iota
+	UniqueViolationError      GoDBErrorCode = iota
 )
 
 //go:generate stringer -type=GoDBErrorCode
@@ -76,6 +82,9 @@ const (
 	OpEq   BoolOp = iota
 	OpNeq  BoolOp = iota
 	OpLike BoolOp = iota
+	// IS NULL and IS NOT NULL; the value compared with is ignored
+	OpIsNull    BoolOp = iota
+	OpIsNotNull BoolOp = iota
 )
 
 var BoolOpMap = map[string]BoolOp{
@@ -87,9 +96,15 @@ var BoolOpMap = map[string]BoolOp{
 	"<>":   OpNeq,
 	"!=":   OpNeq,
 	"like": OpLike,
+
+	"is null":     OpIsNull,
+	"is not null": OpIsNotNull,
 }
 
 func (i1 IntField) EvalPred(v2 DBValue, op BoolOp) bool {
+	if op == OpIsNull || op == OpIsNotNull {
+		return op == OpIsNotNull
+	}
 	i2, ok := v2.(IntField)
 	if !ok {
 		return false
@@ -114,7 +129,94 @@ func (i1 IntField) EvalPred(v2 DBValue, op BoolOp) bool {
 	}
 }
 
+// Compare x1 and x2 with op, which must not be OpLike.
+func evalOrdered[T cmp.Ordered](x1 T, x2 T, op BoolOp) bool {
+	switch op {
+	case OpEq:
+		return x1 == x2
+	case OpNeq:
+		return x1 != x2
+	case OpGt:
+		return x1 > x2
+	case OpGe:
+		return x1 >= x2
+	case OpLt:
+		return x1 < x2
+	case OpLe:
+		return x1 <= x2
+	default:
+		return false
+	}
+}
+
+func (f1 FloatField) EvalPred(v2 DBValue, op BoolOp) bool {
+	if op == OpIsNull || op == OpIsNotNull {
+		return op == OpIsNotNull
+	}
+	f2, ok := v2.(FloatField)
+	if !ok {
+		return false
+	}
+	return evalOrdered(f1.Value, f2.Value, op)
+}
+
+// false < true
+func (b1 BoolField) EvalPred(v2 DBValue, op BoolOp) bool {
+	if op == OpIsNull || op == OpIsNotNull {
+		return op == OpIsNotNull
+	}
+	b2, ok := v2.(BoolField)
+	if !ok {
+		return false
+	}
+	x1, x2 := 0, 0
+	if b1.Value {
+		x1 = 1
+	}
+	if b2.Value {
+		x2 = 1
+	}
+	return evalOrdered(x1, x2, op)
+}
+
+func (d1 DateField) EvalPred(v2 DBValue, op BoolOp) bool {
+	if op == OpIsNull || op == OpIsNotNull {
+		return op == OpIsNotNull
+	}
+	d2, ok := v2.(DateField)
+	if !ok {
+		return false
+	}
+	return evalOrdered(d1.Value, d2.Value, op)
+}
+
+func (t1 TimestampField) EvalPred(v2 DBValue, op BoolOp) bool {
+	if op == OpIsNull || op == OpIsNotNull {
+		return op == OpIsNotNull
+	}
+	t2, ok := v2.(TimestampField)
+	if !ok {
+		return false
+	}
+	return evalOrdered(t1.Value, t2.Value, op)
+}
+
+// Decimals with different scales compare by value, e.g. 1.50 = 1.5
+func (d1 DecimalField) EvalPred(v2 DBValue, op BoolOp) bool {
+	if op == OpIsNull || op == OpIsNotNull {
+		return op == OpIsNotNull
+	}
+	d2, ok := v2.(DecimalField)
+	if !ok {
+		return false
+	}
+	return evalOrdered(d1.compare(d2), 0, op)
+}
+
 func (i1 StringField) EvalPred(v2 DBValue, op BoolOp) bool {
+	if op == OpIsNull || op == OpIsNotNull {
+		return op == OpIsNotNull
+	}
 	i2, ok := v2.(StringField)
 	if !ok {
 		return false
@@ -0,0 +1,179 @@
+package godb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Besides INT and the string types, columns can be FLOAT (or DOUBLE and REAL),
+BOOLEAN (or BOOL), DATE and TIMESTAMP (or DATETIME), holding [FloatField],
+[BoolField], [DateField] and [TimestampField] values. Dates and timestamps
+have no time zone: they are stored as days and microseconds since the Unix
+epoch, and timestamps with a time zone offset are converted to UTC when they
+are parsed.
+
+Dates are written as 2006-01-02, and timestamps as 2006-01-02 15:04:05,
+followed by up to 6 digits of fractional seconds if they are not zero. Both
+formats are accepted in CSV files, as are RFC 3339 timestamps, e.g.
+2006-01-02T15:04:05Z, and dates in place of timestamps, meaning midnight.
+*/
+
+const (
+	dateLayout      = "2006-01-02"
+	timestampLayout = "2006-01-02 15:04:05"
+	microsPerDay    = int64(24 * time.Hour / time.Microsecond)
+)
+
+// Return the size of a field of the type in the fixed-length tuple encoding
+// (see [Tuple.writeTo]).
+func (t DBType) fixedSize() int {
+	switch t {
+	case StringType:
+		return StringLength
+	case BoolType:
+		return 1
+	case DecimalType:
+		return 9
+	}
+	return 8
+}
+
+// Return the value v as it is printed in query results, e.g. "2006-01-02" for
+// a date.
+func formatValue(v DBValue) string {
+	switch v := v.(type) {
+	case IntField:
+		return strconv.FormatInt(v.Value, 10)
+	case StringField:
+		return v.Value
+	case FloatField:
+		return formatFloat(v.Value)
+	case BoolField:
+		return strconv.FormatBool(v.Value)
+	case DateField:
+		return formatDate(v.Value)
+	case TimestampField:
+		return formatTimestamp(v.Value)
+	case DecimalField:
+		return v.String()
+	case NullField:
+		return v.String()
+	}
+	return ""
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Return the date with the specified number of days since the epoch, e.g.
+// "2006-01-02".
+func formatDate(days int64) string {
+	return time.UnixMicro(days * microsPerDay).UTC().Format(dateLayout)
+}
+
+// Return the timestamp with the specified number of microseconds since the
+// epoch, e.g. "2006-01-02 15:04:05.5".
+func formatTimestamp(micros int64) string {
+	return time.UnixMicro(micros).UTC().Format(timestampLayout + ".999999")
+}
+
+// Parse a date written as 2006-01-02, returning the number of days since the
+// epoch.
+func parseDate(s string) (int64, error) {
+	t, err := time.Parse(dateLayout, strings.TrimSpace(s))
+	if err != nil {
+		return 0, GoDBError{TypeMismatchError, fmt.Sprintf("invalid date %q", s)}
+	}
+	return floorDiv(t.UnixMicro(), microsPerDay), nil
+}
+
+// Parse a timestamp, returning the number of microseconds since the epoch;
+// fractional seconds beyond microseconds are truncated.
+func parseTimestamp(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{timestampLayout, time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05Z07:00", dateLayout} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UnixMicro(), nil
+		}
+	}
+	return 0, GoDBError{TypeMismatchError, fmt.Sprintf("invalid timestamp %q", s)}
+}
+
+// Parse a boolean, written as true/false, t/f, yes/no or 1/0, in any case.
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "true", "t", "yes", "y", "1":
+		return true, nil
+	case "false", "f", "no", "n", "0":
+		return false, nil
+	}
+	return false, GoDBError{TypeMismatchError, fmt.Sprintf("invalid boolean %q", s)}
+}
+
+// Return a / b rounded towards negative infinity, so that dates before the
+// epoch are the day they start in.
+func floorDiv(a int64, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// Return the type of the value v, or UnknownType if v is NULL.
+func typeOfValue(v DBValue) DBType {
+	switch v.(type) {
+	case IntField:
+		return IntType
+	case StringField:
+		return StringType
+	case FloatField:
+		return FloatType
+	case BoolField:
+		return BoolType
+	case DateField:
+		return DateType
+	case TimestampField:
+		return TimestampType
+	case DecimalField:
+		return DecimalType
+	}
+	return UnknownType
+}
+
+// Parse s as a value of the type t. Returns a TypeMismatchError if s is not a
+// valid value of the type.
+func parseFieldValue(s string, t DBType) (DBValue, error) {
+	switch t {
+	case IntType:
+		v, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return nil, GoDBError{TypeMismatchError, fmt.Sprintf("invalid int %q", s)}
+		}
+		return IntField{v}, nil
+	case StringType:
+		return StringField{s}, nil
+	case FloatType:
+		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, GoDBError{TypeMismatchError, fmt.Sprintf("invalid float %q", s)}
+		}
+		return FloatField{v}, nil
+	case BoolType:
+		v, err := parseBool(s)
+		return BoolField{v}, err
+	case DateType:
+		v, err := parseDate(s)
+		return DateField{v}, err
+	case TimestampType:
+		v, err := parseTimestamp(s)
+		return TimestampField{v}, err
+	case DecimalType:
+		return parseDecimal(s)
+	}
+	return nil, GoDBError{TypeMismatchError, fmt.Sprintf("cannot parse a value of type %v", t)}
+}
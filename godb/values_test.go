@@ -0,0 +1,168 @@
+package godb
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+func TestDateTimeValues(t *testing.T) {
+	dates := map[string]int64{"1970-01-01": 0, "1970-01-02": 1, "1969-12-31": -1, "2000-03-01": 11017}
+	for s, days := range dates {
+		if got, err := parseDate(s); err != nil || got != days {
+			t.Errorf("%s: expected day %d, got %d (%v)", s, days, got, err)
+		}
+		if got := formatDate(days); got != s {
+			t.Errorf("day %d: expected %s, got %s", days, s, got)
+		}
+	}
+	if _, err := parseDate("2000-02-30"); err == nil {
+		t.Errorf("expected an invalid date to be rejected")
+	}
+
+	timestamps := map[string]int64{
+		"1970-01-01 00:00:01":           1000000,
+		"1970-01-01 00:00:01.25":        1250000,
+		"1970-01-01T01:00:00+01:00":     0,
+		"1970-01-01T00:00:00.000001Z":   1,
+		"1970-01-02":                    microsPerDay,
+		"1969-12-31 23:59:59.999999":    -1,
+		" 1970-01-01 00:01:00.5000009 ": 60500000,
+	}
+	for s, micros := range timestamps {
+		if got, err := parseTimestamp(s); err != nil || got != micros {
+			t.Errorf("%q: expected %d, got %d (%v)", s, micros, got, err)
+		}
+	}
+	if s := formatTimestamp(1250000); s != "1970-01-01 00:00:01.25" {
+		t.Errorf("unexpected timestamp %s", s)
+	}
+	if s := formatTimestamp(-microsPerDay); s != "1969-12-31 00:00:00" {
+		t.Errorf("unexpected timestamp %s", s)
+	}
+	if _, err := parseTimestamp("yesterday"); err == nil {
+		t.Errorf("expected an invalid timestamp to be rejected")
+	}
+
+	for _, s := range []string{"true", "T", "yes", "1"} {
+		if v, err := parseBool(s); err != nil || !v {
+			t.Errorf("%q: expected true (%v)", s, err)
+		}
+	}
+	if _, err := parseBool("maybe"); err == nil {
+		t.Errorf("expected an invalid boolean to be rejected")
+	}
+}
+
+func TestTypedPredicates(t *testing.T) {
+	if !(FloatField{1.5}).EvalPred(FloatField{2}, OpLt) || (FloatField{math.NaN()}).EvalPred(FloatField{math.NaN()}, OpEq) {
+		t.Errorf("unexpected float comparison")
+	}
+	if !(BoolField{false}).EvalPred(BoolField{true}, OpLt) || !(BoolField{true}).EvalPred(BoolField{true}, OpEq) {
+		t.Errorf("unexpected boolean comparison")
+	}
+	if !(DateField{-1}).EvalPred(DateField{0}, OpLe) || !(TimestampField{5}).EvalPred(TimestampField{4}, OpGt) {
+		t.Errorf("unexpected date or timestamp comparison")
+	}
+	if (DateField{0}).EvalPred(TimestampField{0}, OpEq) || (FloatField{1}).EvalPred(IntField{1}, OpEq) {
+		t.Errorf("expected values of different types not to compare")
+	}
+}
+
+func TestTypedTupleSerialization(t *testing.T) {
+	td := TupleDesc{[]FieldType{{"f", "", FloatType}, {"b", "", BoolType}, {"d", "", DateType}, {"ts", "", TimestampType}}}
+	tup := Tuple{Desc: td, Fields: []DBValue{FloatField{-2.5}, BoolField{true}, DateField{-3}, TimestampField{1 << 40}}}
+	var b bytes.Buffer
+	if err := tup.writeVarlenTo(&b); err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected := 1
+	for _, f := range td.Fields {
+		expected += f.Ftype.fixedSize()
+	}
+	if b.Len() != expected {
+		t.Errorf("expected %d bytes, got %d", expected, b.Len())
+	}
+	t2, err := readVarlenTupleFrom(&b, &td)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for i := range tup.Fields {
+		if t2.Fields[i] != tup.Fields[i] {
+			t.Errorf("field %d: expected %v, got %v", i, tup.Fields[i], t2.Fields[i])
+		}
+	}
+	if s := tup.PrettyPrintString(false); s != "-2.5,true,1969-12-29,1970-01-13 17:25:11.627776" {
+		t.Errorf("unexpected output %s", s)
+	}
+}
+
+func TestTypedColumns(t *testing.T) {
+	types := map[string]DBType{
+		"float":      FloatType,
+		"DOUBLE":     FloatType,
+		"bool":       BoolType,
+		"boolean":    BoolType,
+		"tinyint(1)": BoolType,
+		"tinyint(4)": IntType,
+		"date":       DateType,
+		"timestamp":  TimestampType,
+		"datetime":   TimestampType,
+	}
+	for name, expected := range types {
+		ftype, _, err := parseColumnType(name, "")
+		if err != nil || ftype != expected {
+			t.Errorf("%s: expected %v, got %v (%v)", name, expected, ftype, err)
+		}
+		if reparsed, _, _ := parseColumnType(columnTypeName(ftype, columnDef{}), ""); reparsed != ftype {
+			t.Errorf("%s: type name %s does not parse", name, ftype)
+		}
+	}
+
+	query := rewriteBooleanColumns("CREATE TABLE bool (id int, done BOOLEAN not null, ok bool)")
+	stmt, err := sqlparser.Parse(query)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	for _, col := range stmt.(*sqlparser.DDL).TableSpec.Columns[1:] {
+		length := ""
+		if col.Type.Length != nil {
+			length = string(col.Type.Length.Val)
+		}
+		if ftype, _, _ := parseColumnType(col.Type.Type, length); ftype != BoolType {
+			t.Errorf("%s: expected a boolean column, got %v", col.Name, ftype)
+		}
+	}
+	rewrites := map[string]string{
+		"select bool from t": "select bool from t",
+		"insert into t values ('create table t (a bool)')":              "insert into t values ('create table t (a bool)')",
+		"create table t (`bool` bool default 'x bool', n int)":          "create table t (`bool` tinyint(1) default 'x bool', n int)",
+		"create table t (a decimal(5, 2), b int) with (bool = boolean)": "create table t (a decimal(5, 2), b int) with (bool = boolean)",
+	}
+	for query, expected := range rewrites {
+		if q := rewriteBooleanColumns(query); q != expected {
+			t.Errorf("%q: expected %q, got %q", query, expected, q)
+		}
+	}
+
+	stmt, err = sqlparser.Parse("select * from t where a = 2.5 and b = true")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	and := stmt.(*sqlparser.Select).Where.Expr.(*sqlparser.AndExpr)
+	for cmp, expected := range map[sqlparser.Expr]DBValue{and.Left: DecimalField{25, 1}, and.Right: BoolField{true}} {
+		node, err := parseExpr(nil, cmp.(*sqlparser.ComparisonExpr).Right, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		expr, _, err := node.generateExpr(nil, nil, nil)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if v, _ := expr.EvalExpr(nil); v != expected || expr.GetExprType().Ftype != typeOfValue(expected) {
+			t.Errorf("expected constant %v, got %v", expected, v)
+		}
+	}
+}
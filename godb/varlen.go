@@ -0,0 +1,202 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+String columns come in three flavors, which all hold [StringField] values of
+type StringType:
+
+  - STRING columns are fixed length: their values are padded to StringLength
+    bytes when stored, and truncated to StringLength bytes when loaded from a
+    CSV file;
+  - VARCHAR(n) columns hold strings of up to n bytes, and reject longer ones;
+  - TEXT columns (and VARCHAR columns without a length) hold strings of any
+    length.
+
+A table with VARCHAR or TEXT columns is variable length: its tuples are
+stored without padding using the encoding of [Tuple.writeVarlenTo], in
+slotted pages (see [slottedPage]). Tables with only INT and STRING columns
+keep the fixed-length page format, and fixed-length pages remain readable in
+variable-length tables, since the format of each page is recorded in its
+header.
+*/
+
+// The maximum length of the values of a string column.
+type stringLimit int
+
+const (
+	fixedLengthString stringLimit = 0  // STRING: padded to StringLength bytes
+	unboundedString   stringLimit = -1 // TEXT, or VARCHAR without a length
+)
+
+// Return true if values with the limit are stored with variable length.
+func (l stringLimit) varlen() bool {
+	return l != fixedLengthString
+}
+
+// Return true if a string of n bytes exceeds the limit.
+func (l stringLimit) exceededBy(n int) bool {
+	return l > 0 && n > int(l)
+}
+
+// Return an error if a string field of t is too long for its column.
+func (f *HeapFile) checkStringLengths(t *Tuple) error {
+	for i, field := range t.Fields {
+		s, ok := field.(StringField)
+		if !ok {
+			continue
+		}
+		if l := f.stringLimit(i); l.exceededBy(len(s.Value)) {
+			name := ""
+			if i < len(t.Desc.Fields) {
+				name = t.Desc.Fields[i].Fname
+			}
+			return GoDBError{TypeMismatchError, fmt.Sprintf("value of %d bytes too long for column %s %s", len(s.Value), name, columnTypeName(StringType, columnDef{limit: l}))}
+		}
+	}
+	return nil
+}
+
+// Serialize the tuple into b with the variable-length encoding used by slotted
+// pages: a null bitmap of one bit per field, rounded up to whole bytes, in
+// which bit i%8 of byte i/8 is set if field i is NULL, followed by the fields
+// that are not NULL. Integers, floats, dates and timestamps are written as 8
+// byte little endian values, booleans as one byte, decimals as their 8 byte
+// value followed by their scale as one byte, and strings as twice their length
+// (see [binary.PutUvarint]) followed by their bytes, without padding. Strings
+// stored out of line (see [toastPointer]) are written as twice their length
+// plus one, followed by the number of the first page of their overflow chain.
+func (t *Tuple) writeVarlenTo(b *bytes.Buffer) error {
+	var lenBuf [binary.MaxVarintLen64]byte
+	nulls := make([]byte, (len(t.Fields)+7)/8)
+	for i, field := range t.Fields {
+		if isNull(field) {
+			nulls[i/8] |= 1 << (i % 8)
+		}
+	}
+	b.Write(nulls)
+	for _, field := range t.Fields {
+		switch f := field.(type) {
+		case NullField:
+		case IntField:
+			if err := binary.Write(b, binary.LittleEndian, f.Value); err != nil {
+				return err
+			}
+		case FloatField:
+			if err := binary.Write(b, binary.LittleEndian, f.Value); err != nil {
+				return err
+			}
+		case BoolField:
+			if err := binary.Write(b, binary.LittleEndian, f.Value); err != nil {
+				return err
+			}
+		case DateField:
+			if err := binary.Write(b, binary.LittleEndian, f.Value); err != nil {
+				return err
+			}
+		case TimestampField:
+			if err := binary.Write(b, binary.LittleEndian, f.Value); err != nil {
+				return err
+			}
+		case DecimalField:
+			if err := binary.Write(b, binary.LittleEndian, f.Value); err != nil {
+				return err
+			}
+			b.WriteByte(byte(f.Scale))
+		case StringField:
+			n := binary.PutUvarint(lenBuf[:], uint64(len(f.Value))<<1)
+			b.Write(lenBuf[:n])
+			b.WriteString(f.Value)
+		case toastPointer:
+			n := binary.PutUvarint(lenBuf[:], uint64(f.length)<<1|1)
+			b.Write(lenBuf[:n])
+			n = binary.PutUvarint(lenBuf[:], uint64(f.firstPage))
+			b.Write(lenBuf[:n])
+		default:
+			return GoDBError{TypeMismatchError, fmt.Sprintf("cannot serialize field of type %T", field)}
+		}
+	}
+	return nil
+}
+
+// Read a tuple with the specified TupleDesc written by [Tuple.writeVarlenTo]
+// from b. Strings stored out of line are not fetched: they are returned as
+// [toastPointer] values (see [HeapFile.detoast]). Returns a MalformedDataError
+// if b does not hold a whole tuple.
+func readVarlenTupleFrom(b *bytes.Buffer, desc *TupleDesc) (*Tuple, error) {
+	fields := make([]DBValue, len(desc.Fields))
+	nulls := b.Next((len(desc.Fields) + 7) / 8)
+	if len(nulls) < (len(desc.Fields)+7)/8 {
+		return nil, GoDBError{MalformedDataError, "truncated null bitmap"}
+	}
+	for i, ft := range desc.Fields {
+		if nulls[i/8]&(1<<(i%8)) != 0 {
+			fields[i] = NullField{}
+			continue
+		}
+		switch ft.Ftype {
+		case IntType:
+			var v int64
+			if err := binary.Read(b, binary.LittleEndian, &v); err != nil {
+				return nil, GoDBError{MalformedDataError, fmt.Sprintf("reading field %s: %v", ft.Fname, err)}
+			}
+			fields[i] = IntField{v}
+		case FloatType:
+			var v float64
+			if err := binary.Read(b, binary.LittleEndian, &v); err != nil {
+				return nil, GoDBError{MalformedDataError, fmt.Sprintf("reading field %s: %v", ft.Fname, err)}
+			}
+			fields[i] = FloatField{v}
+		case BoolType:
+			var v bool
+			if err := binary.Read(b, binary.LittleEndian, &v); err != nil {
+				return nil, GoDBError{MalformedDataError, fmt.Sprintf("reading field %s: %v", ft.Fname, err)}
+			}
+			fields[i] = BoolField{v}
+		case DateType, TimestampType:
+			var v int64
+			if err := binary.Read(b, binary.LittleEndian, &v); err != nil {
+				return nil, GoDBError{MalformedDataError, fmt.Sprintf("reading field %s: %v", ft.Fname, err)}
+			}
+			if ft.Ftype == DateType {
+				fields[i] = DateField{v}
+			} else {
+				fields[i] = TimestampField{v}
+			}
+		case DecimalType:
+			var v int64
+			if err := binary.Read(b, binary.LittleEndian, &v); err != nil {
+				return nil, GoDBError{MalformedDataError, fmt.Sprintf("reading field %s: %v", ft.Fname, err)}
+			}
+			scale, err := b.ReadByte()
+			if err != nil {
+				return nil, GoDBError{MalformedDataError, fmt.Sprintf("reading field %s: truncated decimal", ft.Fname)}
+			}
+			fields[i] = DecimalField{v, int(scale)}
+		case StringType:
+			n, err := binary.ReadUvarint(b)
+			if err != nil {
+				return nil, GoDBError{MalformedDataError, fmt.Sprintf("reading field %s: truncated string", ft.Fname)}
+			}
+			if n&1 == 1 {
+				first, err := binary.ReadUvarint(b)
+				if err != nil {
+					return nil, GoDBError{MalformedDataError, fmt.Sprintf("reading field %s: truncated overflow pointer", ft.Fname)}
+				}
+				fields[i] = toastPointer{firstPage: int(first), length: int(n >> 1)}
+				continue
+			}
+			if n>>1 > uint64(b.Len()) {
+				return nil, GoDBError{MalformedDataError, fmt.Sprintf("reading field %s: truncated string", ft.Fname)}
+			}
+			fields[i] = StringField{string(b.Next(int(n >> 1)))}
+		default:
+			return nil, GoDBError{MalformedDataError, fmt.Sprintf("cannot deserialize field %s of type %v", ft.Fname, ft.Ftype)}
+		}
+	}
+	return &Tuple{Desc: *desc, Fields: fields}, nil
+}
@@ -0,0 +1,80 @@
+package godb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestVarlenTupleSerialization(t *testing.T) {
+	td := TupleDesc{[]FieldType{{"name", "", StringType}, {"age", "", IntType}, {"bio", "", StringType}}}
+	long := strings.Repeat("x", 1000)
+	tup := Tuple{Desc: td, Fields: []DBValue{StringField{"sam"}, IntField{-25}, StringField{long}}}
+	var b bytes.Buffer
+	if err := tup.writeVarlenTo(&b); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if expected := 1 + 1 + 3 + 8 + 2 + len(long); b.Len() != expected {
+		t.Errorf("expected %d bytes without padding, got %d", expected, b.Len())
+	}
+	data := append([]byte{}, b.Bytes()...)
+	t2, err := readVarlenTupleFrom(&b, &td)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if t2.Fields[0] != tup.Fields[0] || t2.Fields[1] != tup.Fields[1] || t2.Fields[2] != tup.Fields[2] {
+		t.Errorf("expected %v, got %v", tup.Fields, t2.Fields)
+	}
+	if _, err := readVarlenTupleFrom(bytes.NewBuffer(data[:len(data)-1]), &td); err == nil {
+		t.Errorf("expected a truncated tuple to be rejected")
+	}
+}
+
+func TestVarlenColumnTypes(t *testing.T) {
+	types := map[string]stringLimit{
+		"string":      fixedLengthString,
+		"text":        unboundedString,
+		"varchar":     unboundedString,
+		"VARCHAR(10)": 10,
+	}
+	for name, limit := range types {
+		ftype, c, err := parseColumnType(name, "")
+		if err != nil || ftype != StringType || c.limit != limit {
+			t.Errorf("%s: expected a string with limit %d, got %v %d (%v)", name, limit, ftype, c.limit, err)
+		}
+		if reparsed, _, _ := parseColumnType(columnTypeName(ftype, c), ""); reparsed != ftype {
+			t.Errorf("%s: type name %s does not parse", name, columnTypeName(ftype, c))
+		}
+	}
+	if _, c, _ := parseColumnType("varchar", "20"); c.limit != 20 {
+		t.Errorf("expected a separate length to be used, got %d", c.limit)
+	}
+	for _, name := range []string{"varchar(0)", "varchar(x)", "blob"} {
+		if _, _, err := parseColumnType(name, ""); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	td := TupleDesc{[]FieldType{{"id", "", IntType}, {"name", "", StringType}, {"bio", "", StringType}}}
+	table := &Table{name: "people", desc: td, columns: []columnDef{{}, {limit: 5, nullable: true}, {limit: unboundedString, nullable: true}}}
+	if s := table.String(); s != "people(id int not null, name varchar(5), bio text)\n" {
+		t.Errorf("unexpected catalog entry %q", s)
+	}
+
+	hf := &HeapFile{}
+	if hf.isVarlen() {
+		t.Errorf("a file without limits should be fixed length")
+	}
+	hf.setColumns(table.columns)
+	if !hf.isVarlen() {
+		t.Errorf("a file with varchar columns should be variable length")
+	}
+	tup := Tuple{Desc: td, Fields: []DBValue{IntField{1}, StringField{"sammy"}, StringField{strings.Repeat("y", 5000)}}}
+	if err := hf.checkStringLengths(&tup); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	tup.Fields[1] = StringField{"samuel"}
+	if err := hf.checkStringLengths(&tup); err == nil {
+		t.Errorf("expected a string too long for varchar(5) to be rejected")
+	}
+}
@@ -24,13 +24,23 @@ Available shell commands:
 	\a : Toggle aligned vs csv output
     \o : Toggle query optimization
 	\l table path/to/file [sep] [hasHeader]: Append csv file to end of table.  Default to sep = ',', hasHeader = 'true'
-	\z : Compute statistics for the database`
+	\z : Compute statistics for the database
+	\checkpoint : Checkpoint the log and truncate it (same as the CHECKPOINT statement)`
 
 func printCatalog(c *godb.Catalog) {
 	s := c.CatalogString()
 	fmt.Printf("\033[34m%s\n\033[0m", s)
 }
 
+func checkpoint(bp *godb.BufferPool) {
+	err := bp.Checkpoint()
+	if err != nil {
+		fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+		return
+	}
+	fmt.Printf("\033[32;1mCHECKPOINT\033[0m\n\n")
+}
+
 func main() {
 	alarm := make(chan int, 1)
 
@@ -61,6 +71,9 @@ func main() {
 		fmt.Printf("failed load catalog, %s", err.Error())
 		return
 	}
+	stopCheckpointer := bp.StartCheckpointer(time.Minute)
+	defer stopCheckpointer()
+
 	rl, err := readline.New("> ")
 	if err != nil {
 		panic(err)
@@ -97,6 +110,11 @@ func main() {
 		if len(text) == 0 {
 			continue
 		}
+		if text == "\\checkpoint" {
+			checkpoint(bp)
+			query = ""
+			continue
+		}
 		if text[0] == '\\' {
 			switch text[1] {
 			case 'd':
@@ -267,7 +285,10 @@ func main() {
 				}
 			}
 			if autocommit {
-				bp.CommitTransaction(tid)
+				if err := bp.CommitTransaction(tid); err != nil {
+					fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+					bp.AbortTransaction(tid)
+				}
 			}
 		outer:
 			fmt.Printf("\033[32;1m(%d results)\033[0m\n", nresults)
@@ -301,8 +322,13 @@ func main() {
 				fmt.Printf("\033[31;1m%s\033[0m\n", "Cannot commit transaction unless in transaction")
 				continue
 			}
-			bp.CommitTransaction(tid)
 			autocommit = true
+			if err := bp.CommitTransaction(tid); err != nil {
+				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+				bp.AbortTransaction(tid)
+				fmt.Printf("\033[32;1mABORT\033[0m\n\n")
+				continue
+			}
 			fmt.Printf("\033[32;1mCOMMIT\033[0m\n\n")
 		case godb.CreateTableQueryType:
 			fmt.Printf("\033[32;1mCREATE\033[0m\n\n")
@@ -316,6 +342,44 @@ func main() {
 			if err != nil {
 				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
 			}
+		case godb.CreateIndexQueryType:
+			fmt.Printf("\033[32;1mCREATE INDEX\033[0m\n\n")
+			err := c.SaveToFile(catName, catPath)
+			if err != nil {
+				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+			}
+		case godb.DropIndexQueryType:
+			fmt.Printf("\033[32;1mDROP INDEX\033[0m\n\n")
+			err := c.SaveToFile(catName, catPath)
+			if err != nil {
+				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+			}
+		case godb.CheckpointQueryType:
+			checkpoint(bp)
+		case godb.SetQueryType:
+			// outside of a transaction, settings apply to the transactions that follow
+			set := plan.(*godb.SetOp)
+			if autocommit {
+				err = set.SetDefaults()
+			} else {
+				_, err = set.Iterator(tid)
+			}
+			if err != nil {
+				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+				continue
+			}
+			fmt.Printf("\033[32;1mSET\033[0m\n\n")
+		case godb.SavepointQueryType:
+			if autocommit {
+				fmt.Printf("\033[31;1m%s\033[0m\n", "Savepoints can only be used in transactions")
+				continue
+			}
+			sp := plan.(*godb.SavepointOp)
+			if _, err := sp.Iterator(tid); err != nil {
+				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+				continue
+			}
+			fmt.Printf("\033[32;1m%s\033[0m\n\n", sp)
 		}
 	}
 }